@@ -25,24 +25,50 @@ const shutdownTimeout = 30 * time.Second
 func main() {
 	cfg := config.Load()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, os.Args[2:]))
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Connect to PostgreSQL
-	log.Printf("Connecting to PostgreSQL at %s:%d...", cfg.PGHost, cfg.PGPort)
-	store, err := storage.New(ctx, storage.Config{
-		Host:          cfg.PGHost,
-		Port:          cfg.PGPort,
-		User:          cfg.PGUser,
-		Password:      cfg.PGPassword,
-		Database:      cfg.PGDatabase,
-		SSLMode:       cfg.PGSSLMode,
-		SQLTraceLevel: cfg.SQLTraceLevel,
-	})
+	if cfg.PGDSN != "" {
+		log.Println("Connecting to PostgreSQL with PG_DSN...")
+	} else {
+		log.Printf("Connecting to PostgreSQL at %s:%d...", cfg.PGHost, cfg.PGPort)
+	}
+	storageCfg, err := storageConfig(cfg)
+	if err != nil {
+		log.Fatalf("Invalid PG_MIGRATE: %v", err)
+	}
+	store, err := storage.New(ctx, storageCfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
 	}
-	log.Println("Connected to PostgreSQL")
+	if cfg.PGSchema != "" {
+		log.Printf("Connected to PostgreSQL (schema %s)", cfg.PGSchema)
+	} else {
+		log.Println("Connected to PostgreSQL")
+	}
+
+	if cfg.PGBouncer {
+		log.Println("PgBouncer transaction pooling mode: queries use the simple protocol")
+	}
+	if cfg.PGReplicaDSN != "" {
+		log.Printf("Read-only commands use the read replica while its lag is within %v", cfg.PGReplicaMaxLag)
+	}
+	if !cfg.PGFunctions {
+		log.Println("PL/pgSQL command functions disabled: writes run their queries one by one")
+	}
+	if len(storageCfg.VolatileKeyPatterns) > 0 {
+		log.Printf("Asynchronous commit enabled for volatile keys: %v", storageCfg.VolatileKeyPatterns)
+	}
+
+	if err := store.SetNotifyKeyspaceEvents(cfg.NotifyKeyspaceEvents); err != nil {
+		log.Fatalf("Invalid NOTIFY_KEYSPACE_EVENTS: %v", err)
+	}
+
 
 	// Wrap with cache if enabled
 	var backend storage.Backend = store
@@ -73,8 +99,9 @@ func main() {
 
 		// Set up distributed cache invalidation (optional, for multi-pod deployments)
 		if cfg.CacheDistributedInvalidation {
-			cacheInvalidator = cache.NewInvalidator(store.Pool(), store.ConnString(), cachedStore.GetCache())
+			cacheInvalidator = cache.NewInvalidator(store.Pool(), store.ConnConfig(), cachedStore.GetCache())
 			cacheInvalidator.SetDebug(cfg.Debug)
+			cacheInvalidator.SetNamespace(store.Namespace())
 			if err := cacheInvalidator.Start(ctx); err != nil {
 				log.Fatalf("Failed to start cache invalidator: %v", err)
 			}
@@ -95,20 +122,22 @@ func main() {
 	// Create handler
 	h := handler.New(backend, cfg.RedisPassword)
 
-	// Initialize list notifier for BRPOP/BLPOP
-	listNotifier := listnotify.New(store.Pool(), store.ConnString())
+	// Initialize list notifier for BRPOP/BLPOP and XREAD/XREADGROUP BLOCK
+	listNotifier := listnotify.New(store.Pool(), store.ConnConfig())
 	listNotifier.SetDebug(cfg.Debug)
+	listNotifier.SetNamespace(store.Namespace())
 	if err := listNotifier.Start(ctx); err != nil {
 		log.Fatalf("Failed to start list notifier: %v", err)
 	}
 	h.SetListNotifier(listNotifier)
-	log.Println("List notification support enabled (BRPOP/BLPOP)")
+	log.Println("List notification support enabled (BRPOP/BLPOP, XREAD/XREADGROUP BLOCK)")
 
 	// Create and start server
 	srv := server.NewWithOptions(cfg.RedisAddr, h, cfg.Debug, cfg.TraceLevel)
 
 	// Initialize pub/sub hub
-	hub := pubsub.NewHub(store.Pool(), store.ConnString())
+	hub := pubsub.NewHub(store.Pool(), store.ConnConfig())
+	hub.SetNamespace(store.Namespace())
 	if err := hub.Start(ctx); err != nil {
 		log.Fatalf("Failed to start pub/sub hub: %v", err)
 	}
@@ -187,6 +216,43 @@ func main() {
 	}
 }
 
+// storageConfig returns the PostgreSQL configuration for the storage layer
+func storageConfig(cfg *config.Config) (storage.Config, error) {
+	migrate, err := storage.ParseMigrateMode(cfg.PGMigrate)
+	if err != nil {
+		return storage.Config{}, err
+	}
+	return storage.Config{
+		Host:          cfg.PGHost,
+		Port:          cfg.PGPort,
+		User:          cfg.PGUser,
+		Password:      cfg.PGPassword,
+		Database:      cfg.PGDatabase,
+		SSLMode:       cfg.PGSSLMode,
+		SQLTraceLevel: cfg.SQLTraceLevel,
+		Migrate:       migrate,
+		Schema:        cfg.PGSchema,
+
+		DSN:              cfg.PGDSN,
+		MaxConns:         int32(cfg.PGMaxConns),
+		MinConns:         int32(cfg.PGMinConns),
+		MaxConnLifetime:  cfg.PGMaxConnLifetime,
+		MaxConnIdleTime:  cfg.PGMaxConnIdleTime,
+		ConnectTimeout:   cfg.PGConnectTimeout,
+		StatementTimeout: cfg.PGStatementTimeout,
+		LockTimeout:      cfg.PGLockTimeout,
+		TCPKeepAlive:     cfg.PGTCPKeepAlive,
+		ApplicationName:  cfg.PGApplicationName,
+
+		VolatileKeyPatterns: parsePatterns(cfg.VolatileKeyPatterns),
+		ReplicaDSN:          cfg.PGReplicaDSN,
+		ReplicaMaxLag:       cfg.PGReplicaMaxLag,
+		PgBouncer:           cfg.PGBouncer,
+		ListenDSN:           cfg.PGListenDSN,
+		DisableFunctions:    !cfg.PGFunctions,
+	}, nil
+}
+
 // parsePatterns parses a comma-separated list of patterns
 func parsePatterns(s string) []string {
 	if s == "" {
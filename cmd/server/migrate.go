@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mnorrsken/postkeys/internal/config"
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+const migrateUsage = `Usage: postkeys migrate <command>
+
+Commands:
+  up      apply all pending schema migrations
+  down    revert the most recently applied migration
+  status  list migrations and whether they are applied`
+
+// runMigrate implements the migrate subcommand, which manages the schema out
+// of band, e.g. from a job run before deploying pods with PG_MIGRATE=check.
+// It returns the process exit code.
+func runMigrate(cfg *config.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	switch args[0] {
+	case "up", "down", "status":
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	storageCfg, err := storageConfig(cfg)
+	if err != nil {
+		log.Printf("Invalid PG_MIGRATE: %v", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	m, err := storage.NewMigrator(ctx, storageCfg)
+	if err != nil {
+		log.Printf("Failed to connect to PostgreSQL: %v", err)
+		return 1
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		if err != nil {
+			log.Printf("Migration failed: %v", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			log.Println("Schema is up to date")
+		}
+
+	case "down":
+		if _, err := m.Down(ctx); err != nil {
+			log.Printf("Migration failed: %v", err)
+			return 1
+		}
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			log.Printf("Failed to read migration status: %v", err)
+			return 1
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "MIGRATION\tSTATUS\tAPPLIED AT")
+		for _, st := range statuses {
+			state, appliedAt := "pending", ""
+			switch {
+			case st.Unknown:
+				state = "applied (unknown to this version)"
+			case st.Modified:
+				state = "applied (modified since)"
+			case st.Applied:
+				state = "applied"
+			}
+			if st.Applied {
+				appliedAt = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", st, state, appliedAt)
+		}
+		tw.Flush()
+	}
+	return 0
+}
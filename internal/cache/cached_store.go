@@ -2,6 +2,8 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"strconv"
 	"time"
 
 	"github.com/mnorrsken/postkeys/internal/metrics"
@@ -96,8 +98,18 @@ func (s *CachedStore) recordWrite(key string, ttl time.Duration) {
 	}
 }
 
+// cacheKey returns the cache entry name of key in the database selected in ctx.
+// Keys in database 0 are cached under their own name.
+func cacheKey(ctx context.Context, key string) string {
+	if db := storage.DBFromContext(ctx); db != 0 {
+		return strconv.Itoa(db) + "\x00" + key
+	}
+	return key
+}
+
 // invalidate invalidates a key locally and broadcasts to other instances
 func (s *CachedStore) invalidate(ctx context.Context, key string) {
+	key = cacheKey(ctx, key)
 	s.cache.Invalidate(key)
 	if s.invalidator != nil {
 		s.invalidator.InvalidateKey(ctx, key)
@@ -106,9 +118,13 @@ func (s *CachedStore) invalidate(ctx context.Context, key string) {
 
 // invalidateMulti invalidates multiple keys locally and broadcasts to other instances
 func (s *CachedStore) invalidateMulti(ctx context.Context, keys []string) {
-	s.cache.DeleteMulti(keys)
+	cacheKeys := make([]string, len(keys))
+	for i, key := range keys {
+		cacheKeys[i] = cacheKey(ctx, key)
+	}
+	s.cache.DeleteMulti(cacheKeys)
 	if s.invalidator != nil {
-		s.invalidator.InvalidateKeys(ctx, keys...)
+		s.invalidator.InvalidateKeys(ctx, cacheKeys...)
 	}
 }
 
@@ -126,7 +142,7 @@ func (s *CachedStore) Get(ctx context.Context, key string) (string, bool, error)
 	// Check policy before using cache
 	if s.shouldCache(key) {
 		// Try cache first
-		if value, found := s.cache.Get(key); found {
+		if value, found := s.cache.Get(cacheKey(ctx, key)); found {
 			metrics.CacheHits.Inc()
 			return value, true, nil
 		}
@@ -141,7 +157,7 @@ func (s *CachedStore) Get(ctx context.Context, key string) (string, bool, error)
 
 	// Cache the result if found and policy allows
 	if found && s.shouldCache(key) {
-		s.cache.Set(key, value)
+		s.cache.Set(cacheKey(ctx, key), value)
 	}
 
 	return value, found, nil
@@ -316,6 +332,10 @@ func (s *CachedStore) Keys(ctx context.Context, pattern string) ([]string, error
 	return s.backend.Keys(ctx, pattern)
 }
 
+func (s *CachedStore) Scan(ctx context.Context, cursor uint64, pattern string, count int64, keyType storage.KeyType) (uint64, []string, error) {
+	return s.backend.Scan(ctx, cursor, pattern, count, keyType)
+}
+
 func (s *CachedStore) Type(ctx context.Context, key string) (storage.KeyType, error) {
 	return s.backend.Type(ctx, key)
 }
@@ -329,6 +349,19 @@ func (s *CachedStore) Rename(ctx context.Context, oldKey, newKey string) error {
 	return nil
 }
 
+func (s *CachedStore) Move(ctx context.Context, key string, db int) (bool, error) {
+	ok, err := s.backend.Move(ctx, key, db)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		s.invalidate(ctx, key)
+		s.invalidate(storage.WithDB(ctx, db), key)
+	}
+
+	return ok, nil
+}
+
 // ============== Hash Commands (pass-through, no caching) ==============
 
 func (s *CachedStore) HGet(ctx context.Context, key, field string) (string, bool, error) {
@@ -347,6 +380,10 @@ func (s *CachedStore) HGetAll(ctx context.Context, key string) (map[string]strin
 	return s.backend.HGetAll(ctx, key)
 }
 
+func (s *CachedStore) HScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []storage.HashField, error) {
+	return s.backend.HScan(ctx, key, cursor, pattern, count)
+}
+
 func (s *CachedStore) HMGet(ctx context.Context, key string, fields []string) ([]interface{}, error) {
 	return s.backend.HMGet(ctx, key, fields)
 }
@@ -420,6 +457,10 @@ func (s *CachedStore) SMembers(ctx context.Context, key string) ([]string, error
 	return s.backend.SMembers(ctx, key)
 }
 
+func (s *CachedStore) SScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []string, error) {
+	return s.backend.SScan(ctx, key, cursor, pattern, count)
+}
+
 func (s *CachedStore) SIsMember(ctx context.Context, key, member string) (bool, error) {
 	return s.backend.SIsMember(ctx, key, member)
 }
@@ -541,12 +582,161 @@ func (s *CachedStore) PFMerge(ctx context.Context, destKey string, sourceKeys []
 	return nil
 }
 
+// ============== Stream Commands (pass-through, no caching) ==============
+
+func (s *CachedStore) XAdd(ctx context.Context, key, id string, fields []string, noMkStream bool, trim storage.StreamTrim) (string, bool, error) {
+	return s.backend.XAdd(ctx, key, id, fields, noMkStream, trim)
+}
+
+func (s *CachedStore) XRange(ctx context.Context, key string, start, end storage.StreamID, count int64) ([]storage.StreamEntry, error) {
+	return s.backend.XRange(ctx, key, start, end, count)
+}
+
+func (s *CachedStore) XRevRange(ctx context.Context, key string, end, start storage.StreamID, count int64) ([]storage.StreamEntry, error) {
+	return s.backend.XRevRange(ctx, key, end, start, count)
+}
+
+func (s *CachedStore) XLen(ctx context.Context, key string) (int64, error) {
+	return s.backend.XLen(ctx, key)
+}
+
+func (s *CachedStore) XDel(ctx context.Context, key string, ids []storage.StreamID) (int64, error) {
+	return s.backend.XDel(ctx, key, ids)
+}
+
+func (s *CachedStore) XTrim(ctx context.Context, key string, trim storage.StreamTrim) (int64, error) {
+	return s.backend.XTrim(ctx, key, trim)
+}
+
+// ============== Stream Consumer Group Commands (pass-through, no caching) ==============
+
+func (s *CachedStore) XGroupCreate(ctx context.Context, key, group, id string, mkStream bool, entriesRead *int64) error {
+	return s.backend.XGroupCreate(ctx, key, group, id, mkStream, entriesRead)
+}
+
+func (s *CachedStore) XGroupSetID(ctx context.Context, key, group, id string, entriesRead *int64) error {
+	return s.backend.XGroupSetID(ctx, key, group, id, entriesRead)
+}
+
+func (s *CachedStore) XGroupDestroy(ctx context.Context, key, group string) (bool, error) {
+	return s.backend.XGroupDestroy(ctx, key, group)
+}
+
+func (s *CachedStore) XGroupCreateConsumer(ctx context.Context, key, group, consumer string) (bool, error) {
+	return s.backend.XGroupCreateConsumer(ctx, key, group, consumer)
+}
+
+func (s *CachedStore) XGroupDelConsumer(ctx context.Context, key, group, consumer string) (int64, error) {
+	return s.backend.XGroupDelConsumer(ctx, key, group, consumer)
+}
+
+func (s *CachedStore) XReadGroup(ctx context.Context, group, consumer string, keys, ids []string, count int64, noAck bool) ([]storage.StreamReadResult, error) {
+	return s.backend.XReadGroup(ctx, group, consumer, keys, ids, count, noAck)
+}
+
+func (s *CachedStore) XAck(ctx context.Context, key, group string, ids []storage.StreamID) (int64, error) {
+	return s.backend.XAck(ctx, key, group, ids)
+}
+
+func (s *CachedStore) XPendingSummary(ctx context.Context, key, group string) (storage.StreamPendingSummary, error) {
+	return s.backend.XPendingSummary(ctx, key, group)
+}
+
+func (s *CachedStore) XPending(ctx context.Context, key, group string, start, end storage.StreamID, count int64, consumer string, minIdle int64) ([]storage.StreamPendingEntry, error) {
+	return s.backend.XPending(ctx, key, group, start, end, count, consumer, minIdle)
+}
+
+func (s *CachedStore) XClaim(ctx context.Context, key, group, consumer string, minIdle int64, ids []storage.StreamID, opts storage.StreamClaimOptions) ([]storage.StreamEntry, error) {
+	return s.backend.XClaim(ctx, key, group, consumer, minIdle, ids, opts)
+}
+
+func (s *CachedStore) XAutoClaim(ctx context.Context, key, group, consumer string, minIdle int64, start storage.StreamID, count int64, justID bool) (storage.StreamID, []storage.StreamEntry, []storage.StreamID, error) {
+	return s.backend.XAutoClaim(ctx, key, group, consumer, minIdle, start, count, justID)
+}
+
+func (s *CachedStore) XInfoStream(ctx context.Context, key string) (storage.StreamInfo, error) {
+	return s.backend.XInfoStream(ctx, key)
+}
+
+func (s *CachedStore) XInfoGroups(ctx context.Context, key string) ([]storage.StreamGroupInfo, error) {
+	return s.backend.XInfoGroups(ctx, key)
+}
+
+func (s *CachedStore) XInfoConsumers(ctx context.Context, key, group string) ([]storage.StreamConsumerInfo, error) {
+	return s.backend.XInfoConsumers(ctx, key, group)
+}
+
+// ============== JSON Commands (pass-through, no caching) ==============
+
+func (s *CachedStore) JSONSet(ctx context.Context, key, path, value string, nx, xx bool) (bool, error) {
+	return s.backend.JSONSet(ctx, key, path, value, nx, xx)
+}
+
+func (s *CachedStore) JSONGet(ctx context.Context, key string, paths []string) ([][]storage.JSONMatch, bool, error) {
+	return s.backend.JSONGet(ctx, key, paths)
+}
+
+func (s *CachedStore) JSONDel(ctx context.Context, key, path string) (int64, error) {
+	return s.backend.JSONDel(ctx, key, path)
+}
+
+func (s *CachedStore) JSONNumIncrBy(ctx context.Context, key, path, increment string) ([]storage.JSONMatch, bool, error) {
+	return s.backend.JSONNumIncrBy(ctx, key, path, increment)
+}
+
+func (s *CachedStore) JSONArrAppend(ctx context.Context, key, path string, values []string) ([]storage.JSONMatch, bool, error) {
+	return s.backend.JSONArrAppend(ctx, key, path, values)
+}
+
+func (s *CachedStore) JSONDescribe(ctx context.Context, key, path string, withKeys bool) ([]storage.JSONMatch, bool, error) {
+	return s.backend.JSONDescribe(ctx, key, path, withKeys)
+}
+
+// ============== Search Commands (pass-through, no caching) ==============
+
+func (s *CachedStore) FTCreate(ctx context.Context, index storage.FTIndex) error {
+	return s.backend.FTCreate(ctx, index)
+}
+
+func (s *CachedStore) FTSearch(ctx context.Context, name, query string, opts storage.FTSearchOptions) (int64, []storage.FTDocument, error) {
+	return s.backend.FTSearch(ctx, name, query, opts)
+}
+
+func (s *CachedStore) FTInfo(ctx context.Context, name string) (storage.FTIndex, int64, error) {
+	return s.backend.FTInfo(ctx, name)
+}
+
+func (s *CachedStore) FTDropIndex(ctx context.Context, name string, deleteDocs bool) error {
+	return s.backend.FTDropIndex(ctx, name, deleteDocs)
+}
+
+func (s *CachedStore) FTList(ctx context.Context) ([]string, error) {
+	return s.backend.FTList(ctx)
+}
+
 // ============== Server Commands ==============
 
 func (s *CachedStore) DBSize(ctx context.Context) (int64, error) {
 	return s.backend.DBSize(ctx)
 }
 
+func (s *CachedStore) SwapDB(ctx context.Context, db1, db2 int) error {
+	err := s.backend.SwapDB(ctx, db1, db2)
+	if err != nil {
+		return err
+	}
+	s.flush(ctx)
+	return nil
+}
+
+func (s *CachedStore) Keyspace(ctx context.Context) ([]storage.KeyspaceInfo, error) {
+	return s.backend.Keyspace(ctx)
+}
+
+func (s *CachedStore) KeyVersions(ctx context.Context, keys []string) ([]int64, error) {
+	return s.backend.KeyVersions(ctx, keys)
+}
+
 func (s *CachedStore) FlushDB(ctx context.Context) error {
 	err := s.backend.FlushDB(ctx)
 	if err != nil {
@@ -556,6 +746,23 @@ func (s *CachedStore) FlushDB(ctx context.Context) error {
 	return nil
 }
 
+func (s *CachedStore) FlushAll(ctx context.Context) error {
+	err := s.backend.FlushAll(ctx)
+	if err != nil {
+		return err
+	}
+	s.flush(ctx)
+	return nil
+}
+
+func (s *CachedStore) SetNotifyKeyspaceEvents(flags string) error {
+	return s.backend.SetNotifyKeyspaceEvents(flags)
+}
+
+func (s *CachedStore) NotifyKeyspaceEvents() string {
+	return s.backend.NotifyKeyspaceEvents()
+}
+
 // ============== Transaction Support ==============
 
 // BeginTx starts a transaction on the underlying backend
@@ -564,6 +771,46 @@ func (s *CachedStore) BeginTx(ctx context.Context) (storage.Transaction, error)
 	return s.backend.BeginTx(ctx)
 }
 
+// ============== Pipeline Batches ==============
+
+// NewBatch returns a batch of the backend that caches the values its GETs
+// read, or nil if the backend can't run batches
+func (s *CachedStore) NewBatch(ctx context.Context) *storage.Batch {
+	batcher, ok := s.backend.(storage.Batcher)
+	if !ok {
+		return nil
+	}
+	b := batcher.NewBatch(ctx)
+	if b != nil {
+		b.OnGet(func(key, value string, found bool) {
+			if found && s.shouldCache(key) {
+				s.cache.Set(cacheKey(ctx, key), value)
+			}
+		})
+	}
+	return b
+}
+
+// SendBatch runs b on the backend, then invalidates the keys it wrote
+// (distributed)
+func (s *CachedStore) SendBatch(ctx context.Context, b *storage.Batch) error {
+	batcher, ok := s.backend.(storage.Batcher)
+	if !ok {
+		return errors.New("backend can't run batches")
+	}
+	written := b.Written()
+	for _, key := range written {
+		s.recordWrite(key, 0)
+	}
+	if err := batcher.SendBatch(ctx, b); err != nil {
+		return err
+	}
+	if len(written) > 0 {
+		s.invalidateMulti(ctx, written)
+	}
+	return nil
+}
+
 // ============== Hash Extensions ==============
 
 func (s *CachedStore) HIncrByFloat(ctx context.Context, key, field string, increment float64) (float64, error) {
@@ -680,7 +927,7 @@ func (s *CachedStore) ZCount(ctx context.Context, key string, min, max float64)
 	return s.backend.ZCount(ctx, key, min, max)
 }
 
-func (s *CachedStore) ZScan(ctx context.Context, key string, cursor int64, pattern string, count int64) (int64, []storage.ZMember, error) {
+func (s *CachedStore) ZScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []storage.ZMember, error) {
 	return s.backend.ZScan(ctx, key, cursor, pattern, count)
 }
 
@@ -760,3 +1007,7 @@ func (s *CachedStore) BitPos(ctx context.Context, key string, bit int, start, en
 
 // Ensure CachedStore implements Backend
 var _ storage.Backend = (*CachedStore)(nil)
+
+// Ensure CachedStore implements Batcher, so pipelines are batched with the
+// cache enabled
+var _ storage.Batcher = (*CachedStore)(nil)
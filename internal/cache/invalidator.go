@@ -11,6 +11,7 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mnorrsken/postkeys/internal/pubsub"
 )
 
 // PostgreSQL channel for cache invalidation
@@ -25,7 +26,8 @@ type invalidationPayload struct {
 // Invalidator broadcasts cache invalidations across instances using PostgreSQL LISTEN/NOTIFY
 type Invalidator struct {
 	pool         *pgxpool.Pool
-	connStr      string
+	connConfig   *pgx.ConnConfig
+	channel      string // PostgreSQL channel, namespaced by SetNamespace
 	listenerConn *pgx.Conn
 
 	cache  *Cache
@@ -36,14 +38,15 @@ type Invalidator struct {
 }
 
 // NewInvalidator creates a new cache invalidator
-func NewInvalidator(pool *pgxpool.Pool, connStr string, cache *Cache) *Invalidator {
+func NewInvalidator(pool *pgxpool.Pool, connConfig *pgx.ConnConfig, cache *Cache) *Invalidator {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Invalidator{
-		pool:    pool,
-		connStr: connStr,
-		cache:   cache,
-		ctx:     ctx,
-		cancel:  cancel,
+		pool:       pool,
+		connConfig: connConfig,
+		channel:    pubsub.ChannelName("", cacheInvalidateChannel),
+		cache:      cache,
+		ctx:        ctx,
+		cancel:     cancel,
 	}
 }
 
@@ -52,16 +55,23 @@ func (inv *Invalidator) SetDebug(debug bool) {
 	inv.debug = debug
 }
 
+// SetNamespace scopes the invalidator's PostgreSQL channel to a tenant, so
+// tenants sharing a database never invalidate each other's caches. It must be
+// called before Start.
+func (inv *Invalidator) SetNamespace(namespace string) {
+	inv.channel = pubsub.ChannelName(namespace, cacheInvalidateChannel)
+}
+
 // Start initializes the invalidator and starts listening for notifications
 func (inv *Invalidator) Start(ctx context.Context) error {
-	conn, err := pgx.Connect(ctx, inv.connStr)
+	conn, err := pgx.ConnectConfig(ctx, inv.connConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create listener connection: %w", err)
 	}
 	inv.listenerConn = conn
 
 	// Start listening on the cache invalidation channel
-	_, err = conn.Exec(ctx, fmt.Sprintf("LISTEN %s", cacheInvalidateChannel))
+	_, err = conn.Exec(ctx, fmt.Sprintf("LISTEN %s", pgx.Identifier{inv.channel}.Sanitize()))
 	if err != nil {
 		conn.Close(ctx)
 		return fmt.Errorf("failed to LISTEN: %w", err)
@@ -71,7 +81,7 @@ func (inv *Invalidator) Start(ctx context.Context) error {
 	go inv.listenLoop()
 
 	if inv.debug {
-		log.Printf("[DEBUG] Cache invalidator started, listening on %s", cacheInvalidateChannel)
+		log.Printf("[DEBUG] Cache invalidator started, listening on %s", inv.channel)
 	}
 
 	return nil
@@ -104,7 +114,7 @@ func (inv *Invalidator) InvalidateKeys(ctx context.Context, keys ...string) erro
 		return fmt.Errorf("failed to marshal invalidation payload: %w", err)
 	}
 
-	_, err = inv.pool.Exec(ctx, "SELECT pg_notify($1, $2)", cacheInvalidateChannel, string(jsonBytes))
+	_, err = inv.pool.Exec(ctx, "SELECT pg_notify($1, $2)", inv.channel, string(jsonBytes))
 	if err != nil {
 		return fmt.Errorf("failed to notify cache invalidation: %w", err)
 	}
@@ -124,7 +134,7 @@ func (inv *Invalidator) InvalidateFlush(ctx context.Context) error {
 		return fmt.Errorf("failed to marshal flush payload: %w", err)
 	}
 
-	_, err = inv.pool.Exec(ctx, "SELECT pg_notify($1, $2)", cacheInvalidateChannel, string(jsonBytes))
+	_, err = inv.pool.Exec(ctx, "SELECT pg_notify($1, $2)", inv.channel, string(jsonBytes))
 	if err != nil {
 		return fmt.Errorf("failed to notify cache flush: %w", err)
 	}
@@ -203,14 +213,14 @@ func (inv *Invalidator) reconnect() bool {
 	ctx, cancel := context.WithTimeout(inv.ctx, 10*time.Second)
 	defer cancel()
 
-	conn, err := pgx.Connect(ctx, inv.connStr)
+	conn, err := pgx.ConnectConfig(ctx, inv.connConfig)
 	if err != nil {
 		log.Printf("Cache invalidator reconnect failed: %v", err)
 		return false
 	}
 
 	// Re-subscribe to the channel
-	_, err = conn.Exec(ctx, fmt.Sprintf("LISTEN %s", cacheInvalidateChannel))
+	_, err = conn.Exec(ctx, fmt.Sprintf("LISTEN %s", pgx.Identifier{inv.channel}.Sanitize()))
 	if err != nil {
 		conn.Close(ctx)
 		log.Printf("Cache invalidator LISTEN failed after reconnect: %v", err)
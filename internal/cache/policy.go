@@ -2,10 +2,11 @@
 package cache
 
 import (
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/mnorrsken/postkeys/internal/stringmatch"
 )
 
 // Policy determines whether a key should be cached based on various heuristics.
@@ -180,42 +181,13 @@ func (p *Policy) getWriteFrequency(key string) float64 {
 // matchesPattern checks if a key matches any of the glob patterns
 func (p *Policy) matchesPattern(key string, patterns []string) bool {
 	for _, pattern := range patterns {
-		if matchGlob(pattern, key) {
+		if stringmatch.Match(pattern, key, false) {
 			return true
 		}
 	}
 	return false
 }
 
-// matchGlob performs simple glob matching with * as wildcard
-func matchGlob(pattern, str string) bool {
-	// Handle exact match
-	if pattern == str {
-		return true
-	}
-
-	// Handle simple prefix match (pattern:*)
-	if strings.HasSuffix(pattern, "*") {
-		prefix := strings.TrimSuffix(pattern, "*")
-		return strings.HasPrefix(str, prefix)
-	}
-
-	// Handle simple suffix match (*:pattern)
-	if strings.HasPrefix(pattern, "*") {
-		suffix := strings.TrimPrefix(pattern, "*")
-		return strings.HasSuffix(str, suffix)
-	}
-
-	// Handle middle wildcard (prefix*suffix)
-	if idx := strings.Index(pattern, "*"); idx >= 0 {
-		prefix := pattern[:idx]
-		suffix := pattern[idx+1:]
-		return strings.HasPrefix(str, prefix) && strings.HasSuffix(str, suffix)
-	}
-
-	return false
-}
-
 // cleanupLoop periodically cleans up old write stats
 func (p *Policy) cleanupLoop() {
 	ticker := time.NewTicker(p.cfg.CleanupInterval)
@@ -165,7 +165,7 @@ func TestPolicy_IncludeOverridesExclude(t *testing.T) {
 	}
 }
 
-func TestMatchGlob(t *testing.T) {
+func TestMatchesPattern(t *testing.T) {
 	tests := []struct {
 		pattern string
 		str     string
@@ -181,13 +181,17 @@ func TestMatchGlob(t *testing.T) {
 		{"prefix*suffix", "prefixmiddle", false},
 		{"exact", "exact", true},
 		{"exact", "notexact", false},
+		{"user:[0-9]*", "user:1", true},
+		{"user:[0-9]*", "user:x", false},
 	}
 
+	p := &Policy{}
+
 	for _, tt := range tests {
 		t.Run(tt.pattern+"/"+tt.str, func(t *testing.T) {
-			result := matchGlob(tt.pattern, tt.str)
+			result := p.matchesPattern(tt.str, []string{tt.pattern})
 			if result != tt.match {
-				t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.str, result, tt.match)
+				t.Errorf("matchesPattern(%q, %q) = %v, want %v", tt.str, tt.pattern, result, tt.match)
 			}
 		})
 	}
@@ -26,6 +26,51 @@ type Config struct {
 	PGDatabase string
 	PGSSLMode  string
 
+	// PGDSN is a full connection string, from PG_DSN or DATABASE_URL, used
+	// instead of the settings above
+	PGDSN string
+
+	// Connection pool sizing and connection settings, applied to the LISTEN
+	// connections too; zero keeps the DSN's, pgxpool's or the server's
+	PGMaxConns         int
+	PGMinConns         int
+	PGMaxConnLifetime  time.Duration
+	PGMaxConnIdleTime  time.Duration
+	PGConnectTimeout   time.Duration
+	PGStatementTimeout time.Duration
+	PGLockTimeout      time.Duration
+	PGTCPKeepAlive     time.Duration
+	PGApplicationName  string
+
+	// PGMigrate selects what happens to pending schema migrations at startup:
+	// "auto" applies them, "check" refuses to start until they are applied
+	// with `postkeys migrate up`
+	PGMigrate string
+
+	// PGSchema is the PostgreSQL schema holding postkeys' tables and scoping
+	// its LISTEN/NOTIFY channels, so several deployments can share a database;
+	// empty uses the database's default search_path
+	PGSchema string
+
+	// PGReplicaDSN is the connection string of a streaming replica serving
+	// read-only commands while its lag is within PGReplicaMaxLag
+	PGReplicaDSN    string
+	PGReplicaMaxLag time.Duration
+
+	// PGBouncer adapts the connection pools to PgBouncer in transaction
+	// pooling mode; PGListenDSN is a direct connection string for the LISTEN
+	// connections, which need a session of their own
+	PGBouncer   bool
+	PGListenDSN string
+
+	// PGFunctions runs the busiest write commands as single calls to the
+	// PL/pgSQL functions installed by the schema migrations
+	PGFunctions bool
+
+	// VolatileKeyPatterns is a comma-separated list of key patterns written
+	// with asynchronous commit (e.g. "cache:*,session:*")
+	VolatileKeyPatterns string
+
 	// Cache configuration
 	CacheEnabled                 bool
 	CacheTTL                     time.Duration
@@ -40,6 +85,10 @@ type Config struct {
 	CacheExcludePatterns       string        // Comma-separated patterns to never cache (e.g., "pubsub:*,lock:*")
 	CacheIncludePatterns       string        // Comma-separated patterns to always cache (e.g., "static:*")
 
+	// NotifyKeyspaceEvents is the initial notify-keyspace-events setting
+	// (e.g. "KEA"); empty disables keyspace notifications
+	NotifyKeyspaceEvents string
+
 	// Debug mode
 	Debug bool
 
@@ -64,6 +113,23 @@ func Load() *Config {
 		PGPassword:    getEnv("PG_PASSWORD", "postgres"),
 		PGDatabase:    getEnv("PG_DATABASE", "postkeys"),
 		PGSSLMode:     getEnv("PG_SSLMODE", "disable"),
+		PGDSN:         getEnv("PG_DSN", getEnv("DATABASE_URL", "")),
+		PGMaxConns:         getEnvInt("PG_MAX_CONNS", 0),
+		PGMinConns:         getEnvInt("PG_MIN_CONNS", 0),
+		PGMaxConnLifetime:  getEnvDuration("PG_MAX_CONN_LIFETIME", 0),
+		PGMaxConnIdleTime:  getEnvDuration("PG_MAX_CONN_IDLE_TIME", 0),
+		PGConnectTimeout:   getEnvDuration("PG_CONNECT_TIMEOUT", 0),
+		PGStatementTimeout: getEnvDuration("PG_STATEMENT_TIMEOUT", 0),
+		PGLockTimeout:      getEnvDuration("PG_LOCK_TIMEOUT", 0),
+		PGTCPKeepAlive:     getEnvDuration("PG_TCP_KEEPALIVE", 0),
+		PGApplicationName:  getEnv("PG_APPLICATION_NAME", ""),
+		PGMigrate:     getEnv("PG_MIGRATE", "auto"),
+		PGSchema:      getEnv("PG_SCHEMA", ""),
+		PGReplicaDSN:    getEnv("PG_REPLICA_DSN", ""),
+		PGReplicaMaxLag: getEnvDuration("PG_REPLICA_MAX_LAG", 1*time.Second),
+		PGBouncer:       getEnvBool("PG_PGBOUNCER", false),
+		PGListenDSN:     getEnv("PG_LISTEN_DSN", ""),
+		PGFunctions:     getEnvBool("PG_FUNCTIONS", true),
 		CacheEnabled:                 getEnvBool("CACHE_ENABLED", false),
 		CacheTTL:                     getEnvDuration("CACHE_TTL", 250*time.Millisecond),
 		CacheMaxSize:                 getEnvInt("CACHE_MAX_SIZE", 10000),
@@ -74,6 +140,8 @@ func Load() *Config {
 		CacheWriteTrackingWindow:     getEnvDuration("CACHE_WRITE_TRACKING_WINDOW", 10*time.Second),
 		CacheExcludePatterns:         getEnv("CACHE_EXCLUDE_PATTERNS", ""),
 		CacheIncludePatterns:         getEnv("CACHE_INCLUDE_PATTERNS", ""),
+		NotifyKeyspaceEvents:         getEnv("NOTIFY_KEYSPACE_EVENTS", ""),
+		VolatileKeyPatterns:          getEnv("VOLATILE_KEY_PATTERNS", ""),
 		Debug:                        getEnv("DEBUG", "") == "1",
 		SQLTraceLevel: getEnvInt("SQLTRACE", 0),
 		TraceLevel:    getEnvInt("TRACE", 0),
@@ -0,0 +1,329 @@
+// Package geo implements the 52-bit geohash encoding Redis uses to store
+// geospatial positions as sorted set scores, plus the area and distance
+// helpers needed by GEOSEARCH.
+package geo
+
+import (
+	"math"
+)
+
+const (
+	// StepMax is the number of bits per coordinate (26 + 26 = 52-bit scores)
+	StepMax = 26
+
+	// Web Mercator limits, matching Redis
+	LatMin  = -85.05112878
+	LatMax  = 85.05112878
+	LongMin = -180.0
+	LongMax = 180.0
+
+	// earthRadius is the earth radius in meters used by Redis distance calculations
+	earthRadius = 6372797.560856
+	// mercatorMax is the half-circumference of the earth in Mercator projection
+	mercatorMax = 20037726.37
+)
+
+// geohashAlphabet is the standard base32 geohash alphabet
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Hash is a geohash of a given precision (bits per coordinate)
+type Hash struct {
+	Bits uint64
+	Step uint8
+}
+
+// Area is the bounding box of a geohash cell
+type Area struct {
+	LongMin, LongMax float64
+	LatMin, LatMax   float64
+}
+
+// Shape describes a GEOSEARCH query: a radius, or a box when Width/Height are set
+type Shape struct {
+	Long, Lat     float64
+	Radius        float64 // meters, for BYRADIUS
+	Width, Height float64 // meters, for BYBOX
+	IsBox         bool
+}
+
+// ValidCoordinates reports whether the pair can be stored as a geohash
+func ValidCoordinates(long, lat float64) bool {
+	return long >= LongMin && long <= LongMax && lat >= LatMin && lat <= LatMax
+}
+
+// Encode returns the 52-bit sorted set score for a longitude/latitude pair
+func Encode(long, lat float64) uint64 {
+	return encode(long, lat, LatMin, LatMax, StepMax).Bits
+}
+
+// Decode returns the center of the cell encoded by a 52-bit score
+func Decode(score uint64) (long, lat float64) {
+	area := decode(Hash{Bits: score, Step: StepMax}, LatMin, LatMax)
+	long = math.Max(LongMin, math.Min(LongMax, (area.LongMin+area.LongMax)/2))
+	lat = math.Max(LatMin, math.Min(LatMax, (area.LatMin+area.LatMax)/2))
+	return long, lat
+}
+
+// String returns the 11 character standard geohash for a 52-bit score.
+// Standard geohashes use the [-90, 90] latitude range, so the position is re-encoded.
+func String(score uint64) string {
+	long, lat := Decode(score)
+	bits := encode(long, lat, -90, 90, StepMax).Bits
+
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		// Only 52 bits are available, so the last character is always '0'
+		if i < 10 {
+			idx = int((bits >> (52 - uint((i+1)*5))) & 0x1f)
+		}
+		buf[i] = geohashAlphabet[idx]
+	}
+	return string(buf)
+}
+
+// Distance returns the haversine distance between two points in meters
+func Distance(long1, lat1, long2, lat2 float64) float64 {
+	lat1r, lat2r := degRad(lat1), degRad(lat2)
+	v := math.Sin((degRad(long2) - degRad(long1)) / 2)
+	// Fast path for points on the same meridian
+	if v == 0 {
+		return latDistance(lat1, lat2)
+	}
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2.0 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// Within reports whether a point lies inside the shape and returns its
+// distance in meters from the shape center
+func (s Shape) Within(long, lat float64) (float64, bool) {
+	if !s.IsBox {
+		dist := Distance(s.Long, s.Lat, long, lat)
+		return dist, dist <= s.Radius
+	}
+
+	// Check latitude first, then longitude along the point's parallel
+	if latDistance(lat, s.Lat) > s.Height/2 {
+		return 0, false
+	}
+	if Distance(long, lat, s.Long, lat) > s.Width/2 {
+		return 0, false
+	}
+	return Distance(s.Long, s.Lat, long, lat), true
+}
+
+// ScoreRanges returns the [min, max) score ranges covering the shape: the
+// cell containing the center plus the neighbours needed to cover its bounding box
+func (s Shape) ScoreRanges() [][2]uint64 {
+	minLong, minLat, maxLong, maxLat := s.boundingBox()
+
+	radius := s.Radius
+	if s.IsBox {
+		radius = math.Sqrt((s.Width/2)*(s.Width/2) + (s.Height/2)*(s.Height/2))
+	}
+	step := estimateStepsByRadius(radius, s.Lat)
+
+	center := encode(s.Long, s.Lat, LatMin, LatMax, step)
+	neighbours := center.neighbours()
+	area := decode(center, LatMin, LatMax)
+
+	// The radius estimate can still leave the bounding box uncovered near
+	// cell edges; if so, use one step less precision
+	north := decode(neighbours[dirNorth], LatMin, LatMax)
+	south := decode(neighbours[dirSouth], LatMin, LatMax)
+	east := decode(neighbours[dirEast], LatMin, LatMax)
+	west := decode(neighbours[dirWest], LatMin, LatMax)
+	if step > 1 && (north.LatMax < maxLat || south.LatMin > minLat || east.LongMax < maxLong || west.LongMin > minLong) {
+		step--
+		center = encode(s.Long, s.Lat, LatMin, LatMax, step)
+		neighbours = center.neighbours()
+		area = decode(center, LatMin, LatMax)
+	}
+
+	// Skip neighbours that fall entirely outside the bounding box
+	skip := make([]bool, len(neighbours))
+	if step >= 2 {
+		if area.LatMin < minLat {
+			skip[dirSouth], skip[dirSouthWest], skip[dirSouthEast] = true, true, true
+		}
+		if area.LatMax > maxLat {
+			skip[dirNorth], skip[dirNorthWest], skip[dirNorthEast] = true, true, true
+		}
+		if area.LongMin < minLong {
+			skip[dirWest], skip[dirSouthWest], skip[dirNorthWest] = true, true, true
+		}
+		if area.LongMax > maxLong {
+			skip[dirEast], skip[dirSouthEast], skip[dirNorthEast] = true, true, true
+		}
+	}
+
+	shift := uint(StepMax*2 - int(step)*2)
+	cells := append([]Hash{center}, neighbours...)
+	skip = append([]bool{false}, skip...)
+
+	var ranges [][2]uint64
+	seen := make(map[uint64]bool, len(cells))
+	for i, cell := range cells {
+		// Near the poles and at low precision neighbours can repeat
+		if skip[i] || seen[cell.Bits] {
+			continue
+		}
+		seen[cell.Bits] = true
+		ranges = append(ranges, [2]uint64{cell.Bits << shift, (cell.Bits + 1) << shift})
+	}
+	return ranges
+}
+
+// boundingBox returns the longitude/latitude bounds enclosing the shape
+func (s Shape) boundingBox() (minLong, minLat, maxLong, maxLat float64) {
+	width, height := s.Radius, s.Radius
+	if s.IsBox {
+		width, height = s.Width/2, s.Height/2
+	}
+
+	latDelta := radDeg(height / earthRadius)
+	longDeltaTop := radDeg(width / earthRadius / math.Cos(degRad(s.Lat+latDelta)))
+	longDeltaBottom := radDeg(width / earthRadius / math.Cos(degRad(s.Lat-latDelta)))
+
+	// The widest parallel is the one closest to the equator
+	longDelta := longDeltaTop
+	if s.Lat < 0 {
+		longDelta = longDeltaBottom
+	}
+	return s.Long - longDelta, s.Lat - latDelta, s.Long + longDelta, s.Lat + latDelta
+}
+
+// estimateStepsByRadius picks the geohash precision whose cells are about
+// as large as the search radius
+func estimateStepsByRadius(rangeMeters, lat float64) uint8 {
+	if rangeMeters == 0 {
+		return StepMax
+	}
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	step -= 2
+
+	// Cells are narrower near the poles, so use less precision there
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+
+	if step < 1 {
+		step = 1
+	}
+	if step > StepMax {
+		step = StepMax
+	}
+	return uint8(step)
+}
+
+// Neighbour directions, in the order returned by neighbours
+const (
+	dirNorth = iota
+	dirSouth
+	dirEast
+	dirWest
+	dirNorthEast
+	dirNorthWest
+	dirSouthEast
+	dirSouthWest
+)
+
+// neighbours returns the eight cells surrounding h. Longitude wraps around
+// the antimeridian; latitude wraps too, like Redis, and is filtered by distance.
+func (h Hash) neighbours() []Hash {
+	latIdx, longIdx := deinterleave(h.Bits)
+	mask := uint32(1)<<h.Step - 1
+
+	at := func(dLong, dLat int32) Hash {
+		return Hash{
+			Bits: interleave((latIdx+uint32(dLat))&mask, (longIdx+uint32(dLong))&mask),
+			Step: h.Step,
+		}
+	}
+	return []Hash{
+		dirNorth:     at(0, 1),
+		dirSouth:     at(0, -1),
+		dirEast:      at(1, 0),
+		dirWest:      at(-1, 0),
+		dirNorthEast: at(1, 1),
+		dirNorthWest: at(-1, 1),
+		dirSouthEast: at(1, -1),
+		dirSouthWest: at(-1, -1),
+	}
+}
+
+// encode computes the geohash of a point at the given precision
+func encode(long, lat, latMin, latMax float64, step uint8) Hash {
+	scale := float64(uint64(1) << step)
+	latOffset := (lat - latMin) / (latMax - latMin) * scale
+	longOffset := (long - LongMin) / (LongMax - LongMin) * scale
+
+	// Points exactly on the upper bound belong to the last cell
+	latIdx := uint32(math.Min(latOffset, scale-1))
+	longIdx := uint32(math.Min(longOffset, scale-1))
+	return Hash{Bits: interleave(latIdx, longIdx), Step: step}
+}
+
+// decode returns the area covered by a geohash cell
+func decode(h Hash, latMin, latMax float64) Area {
+	latIdx, longIdx := deinterleave(h.Bits)
+	scale := float64(uint64(1) << h.Step)
+	latStep := (latMax - latMin) / scale
+	longStep := (LongMax - LongMin) / scale
+
+	return Area{
+		LatMin:  latMin + float64(latIdx)*latStep,
+		LatMax:  latMin + float64(latIdx+1)*latStep,
+		LongMin: LongMin + float64(longIdx)*longStep,
+		LongMax: LongMin + float64(longIdx+1)*longStep,
+	}
+}
+
+// interleave spreads x over the even bits and y over the odd bits
+func interleave(x, y uint32) uint64 {
+	return spread(x) | spread(y)<<1
+}
+
+// deinterleave reverses interleave, returning the even and odd bits
+func deinterleave(v uint64) (x, y uint32) {
+	return squash(v), squash(v >> 1)
+}
+
+// spread inserts a zero bit between each bit of v
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// squash collects the even bits of v
+func squash(v uint64) uint32 {
+	x := v & 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x>>4) & 0x00FF00FF00FF00FF
+	x = (x | x>>8) & 0x0000FFFF0000FFFF
+	x = (x | x>>16) & 0x00000000FFFFFFFF
+	return uint32(x)
+}
+
+// latDistance returns the distance in meters between two latitudes on a meridian
+func latDistance(lat1, lat2 float64) float64 {
+	return earthRadius * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+func degRad(deg float64) float64 { return deg * math.Pi / 180.0 }
+
+func radDeg(rad float64) float64 { return rad * 180.0 / math.Pi }
@@ -0,0 +1,88 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+// Reference values from the Redis GEOADD/GEOHASH/GEOPOS/GEODIST documentation
+const (
+	palermoLong, palermoLat = 13.361389, 38.115556
+	cataniaLong, cataniaLat = 15.087269, 37.502669
+)
+
+func TestEncode(t *testing.T) {
+	if got := Encode(palermoLong, palermoLat); got != 3479099956230698 {
+		t.Errorf("Encode(Palermo) = %d, want 3479099956230698", got)
+	}
+	if got := Encode(cataniaLong, cataniaLat); got != 3479447370796909 {
+		t.Errorf("Encode(Catania) = %d, want 3479447370796909", got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	long, lat := Decode(Encode(palermoLong, palermoLat))
+	if math.Abs(long-13.36138933897018433) > 1e-9 || math.Abs(lat-38.11555639549629859) > 1e-9 {
+		t.Errorf("Decode(Palermo) = %v,%v", long, lat)
+	}
+}
+
+func TestString(t *testing.T) {
+	if got := String(Encode(palermoLong, palermoLat)); got != "sqc8b49rny0" {
+		t.Errorf("String(Palermo) = %q, want sqc8b49rny0", got)
+	}
+	if got := String(Encode(cataniaLong, cataniaLat)); got != "sqdtr74hyu0" {
+		t.Errorf("String(Catania) = %q, want sqdtr74hyu0", got)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	pLong, pLat := Decode(Encode(palermoLong, palermoLat))
+	cLong, cLat := Decode(Encode(cataniaLong, cataniaLat))
+	if got := Distance(pLong, pLat, cLong, cLat); math.Abs(got-166274.1516) > 0.001 {
+		t.Errorf("Distance(Palermo, Catania) = %.4f, want 166274.1516", got)
+	}
+}
+
+func TestShapeScoreRanges(t *testing.T) {
+	shapes := []Shape{
+		{Long: 15, Lat: 37, Radius: 200000},
+		{Long: 15, Lat: 37, Width: 400000, Height: 400000, IsBox: true},
+	}
+	points := [][2]float64{{palermoLong, palermoLat}, {cataniaLong, cataniaLat}}
+
+	for _, shape := range shapes {
+		ranges := shape.ScoreRanges()
+		if len(ranges) == 0 || len(ranges) > 9 {
+			t.Fatalf("Unexpected number of ranges: %d", len(ranges))
+		}
+		for _, p := range points {
+			if _, ok := shape.Within(p[0], p[1]); !ok {
+				t.Errorf("Point %v should be within %+v", p, shape)
+			}
+			score := Encode(p[0], p[1])
+			covered := false
+			for _, r := range ranges {
+				if score >= r[0] && score < r[1] {
+					covered = true
+				}
+			}
+			if !covered {
+				t.Errorf("Point %v is not covered by the ranges of %+v", p, shape)
+			}
+		}
+	}
+
+	small := Shape{Long: 15, Lat: 37, Radius: 100000}
+	if _, ok := small.Within(palermoLong, palermoLat); ok {
+		t.Errorf("Palermo should be outside a 100km radius")
+	}
+}
+
+func TestNeighboursWrap(t *testing.T) {
+	h := encode(179.99, 0, LatMin, LatMax, 10)
+	east := decode(h.neighbours()[dirEast], LatMin, LatMax)
+	if east.LongMin != LongMin {
+		t.Errorf("East neighbour of the antimeridian should wrap, got %+v", east)
+	}
+}
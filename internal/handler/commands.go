@@ -0,0 +1,225 @@
+// Package handler implements Redis command handlers.
+// This file contains the command table used to validate commands before they
+// are queued inside MULTI, and to find the keys they access.
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mnorrsken/postkeys/internal/resp"
+)
+
+// commandArity holds the arity of every supported command, counting the
+// command name, like the Redis command table: a positive arity is an exact
+// argument count and a negative one a minimum
+var commandArity = map[string]int{
+	// Connection and server
+	"PING": -1, "ECHO": 2, "QUIT": -1, "AUTH": -2, "HELLO": -1, "COMMAND": -1,
+	"CLUSTER": -2, "CLIENT": -2, "SELECT": 2, "INFO": -1, "DBSIZE": 1,
+	"SWAPDB": 3, "FLUSHDB": -1, "FLUSHALL": -1, "CONFIG": -2,
+	"READONLY": 1, "READWRITE": 1,
+
+	// Transactions
+	"MULTI": 1, "EXEC": 1, "DISCARD": 1, "WATCH": -2, "UNWATCH": 1,
+
+	// Pub/sub
+	"SUBSCRIBE": -2, "UNSUBSCRIBE": -1, "PSUBSCRIBE": -2, "PUNSUBSCRIBE": -1,
+	"PUBLISH": 3,
+
+	// Scripting
+	"EVAL": -3, "EVALSHA": -3, "SCRIPT": -2,
+
+	// Strings and bitmaps
+	"GET": 2, "SET": -3, "SETNX": 3, "SETEX": 4, "MGET": -2, "MSET": -3,
+	"INCR": 2, "DECR": 2, "INCRBY": 3, "DECRBY": 3, "INCRBYFLOAT": 3,
+	"APPEND": 3, "GETRANGE": 4, "SETRANGE": 4, "STRLEN": 2, "GETEX": -2,
+	"GETDEL": 2, "GETSET": 3, "BITFIELD": -2, "SETBIT": 4, "GETBIT": 3,
+	"BITCOUNT": -2, "BITOP": -4, "BITPOS": -3,
+
+	// Keys
+	"DEL": -2, "UNLINK": -2, "EXISTS": -2, "EXPIRE": -3, "PEXPIRE": -3,
+	"EXPIREAT": -3, "PEXPIREAT": -3, "TTL": 2, "PTTL": 2, "PERSIST": 2,
+	"KEYS": 2, "SCAN": -2, "TYPE": 2, "RENAME": 3, "COPY": -3, "MOVE": 3,
+
+	// Hashes
+	"HGET": 3, "HSET": -4, "HDEL": -3, "HGETALL": 2, "HMGET": -3, "HMSET": -4,
+	"HEXISTS": 3, "HKEYS": 2, "HVALS": 2, "HLEN": 2, "HINCRBY": 4,
+	"HINCRBYFLOAT": 4, "HSETNX": 4, "HSCAN": -3,
+
+	// Lists
+	"LPUSH": -3, "RPUSH": -3, "LPOP": -2, "RPOP": -2, "BLPOP": -3, "BRPOP": -3,
+	"LLEN": 2, "LRANGE": 4, "LINDEX": 3, "LREM": 4, "LTRIM": 4,
+	"RPOPLPUSH": 3, "LPOS": -3, "LSET": 4, "LINSERT": 5,
+
+	// Sets
+	"SADD": -3, "SREM": -3, "SMEMBERS": 2, "SISMEMBER": 3, "SCARD": 2,
+	"SMISMEMBER": -3, "SINTER": -2, "SINTERSTORE": -3, "SUNION": -2,
+	"SUNIONSTORE": -3, "SDIFF": -2, "SDIFFSTORE": -3, "SSCAN": -3,
+
+	// Sorted sets
+	"ZADD": -4, "ZRANGE": -4, "ZRANGEBYSCORE": -4, "ZSCORE": 3, "ZREM": -3,
+	"ZREMRANGEBYSCORE": 4, "ZREMRANGEBYRANK": 4, "ZCARD": 2, "ZINCRBY": 4,
+	"ZPOPMIN": -2, "ZPOPMAX": -2, "ZRANK": -3, "ZREVRANK": -3, "ZCOUNT": 4,
+	"ZSCAN": -3, "ZUNIONSTORE": -4, "ZINTERSTORE": -4,
+
+	// Geospatial
+	"GEOADD": -5, "GEOPOS": -2, "GEODIST": -4, "GEOHASH": -2, "GEOSEARCH": -7,
+	"GEOSEARCHSTORE": -8,
+
+	// JSON
+	"JSON.SET": -4, "JSON.GET": -2, "JSON.MGET": -3, "JSON.DEL": -2,
+	"JSON.FORGET": -2, "JSON.NUMINCRBY": 4, "JSON.ARRAPPEND": -4,
+	"JSON.ARRLEN": -2, "JSON.OBJKEYS": -2, "JSON.TYPE": -2, "JSON.STRLEN": -2,
+
+	// Search
+	"FT.CREATE": -2, "FT.SEARCH": -3, "FT.INFO": 2, "FT.DROPINDEX": -2,
+	"FT._LIST": 1,
+
+	// HyperLogLog
+	"PFADD": -2, "PFCOUNT": -2, "PFMERGE": -2,
+
+	// Streams
+	"XADD": -5, "XRANGE": -4, "XREVRANGE": -4, "XLEN": 2, "XDEL": -3,
+	"XTRIM": -4, "XREAD": -4, "XGROUP": -2, "XREADGROUP": -7, "XACK": -4,
+	"XPENDING": -3, "XCLAIM": -6, "XAUTOCLAIM": -6, "XINFO": -2,
+}
+
+// noMultiCommands can't be queued inside MULTI, as they run outside the
+// storage transaction or need connection state EXEC doesn't have
+var noMultiCommands = map[string]bool{
+	"FLUSHDB":   true,
+	"FLUSHALL":  true,
+	"CLIENT":    true,
+	"READONLY":  true,
+	"READWRITE": true,
+}
+
+// checkCommand validates a command's name and argument count against the
+// command table, returning the Redis error reply for a bad command
+func checkCommand(cmd resp.Value) error {
+	if cmd.Type != resp.Array || len(cmd.Array) == 0 {
+		return fmt.Errorf("ERR invalid command format")
+	}
+
+	name := cmd.Array[0].Bulk
+	arity, ok := commandArity[strings.ToUpper(name)]
+	if !ok {
+		var args strings.Builder
+		for _, arg := range cmd.Array[1:] {
+			fmt.Fprintf(&args, "'%s' ", arg.Bulk)
+		}
+		return fmt.Errorf("ERR unknown command '%s', with args beginning with: %s", name, args.String())
+	}
+
+	if (arity > 0 && len(cmd.Array) != arity) || (arity < 0 && len(cmd.Array) < -arity) {
+		return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+	}
+	return nil
+}
+
+// keySpec gives the argument positions of a command's keys, like the first
+// key, last key and step of the Redis command table: positions count the
+// command name as 0, and a negative last key counts back from the end
+type keySpec struct {
+	first, last, step int
+}
+
+// commandKeySpecs holds the key positions of the commands whose keys are at
+// fixed positions. Commands that take a key count or a STREAMS keyword
+// (EVAL, ZUNIONSTORE, XREAD, ...) aren't listed, and are treated as touching
+// unknown keys.
+var commandKeySpecs = map[string]keySpec{
+	// Strings and bitmaps
+	"GET": {1, 1, 1}, "SET": {1, 1, 1}, "SETNX": {1, 1, 1}, "SETEX": {1, 1, 1},
+	"MGET": {1, -1, 1}, "MSET": {1, -1, 2}, "INCR": {1, 1, 1}, "DECR": {1, 1, 1},
+	"INCRBY": {1, 1, 1}, "DECRBY": {1, 1, 1}, "INCRBYFLOAT": {1, 1, 1},
+	"APPEND": {1, 1, 1}, "GETRANGE": {1, 1, 1}, "SETRANGE": {1, 1, 1},
+	"STRLEN": {1, 1, 1}, "GETEX": {1, 1, 1}, "GETDEL": {1, 1, 1},
+	"GETSET": {1, 1, 1}, "BITFIELD": {1, 1, 1}, "SETBIT": {1, 1, 1},
+	"GETBIT": {1, 1, 1}, "BITCOUNT": {1, 1, 1}, "BITOP": {2, -1, 1},
+	"BITPOS": {1, 1, 1},
+
+	// Keys
+	"DEL": {1, -1, 1}, "UNLINK": {1, -1, 1}, "EXISTS": {1, -1, 1},
+	"EXPIRE": {1, 1, 1}, "PEXPIRE": {1, 1, 1}, "EXPIREAT": {1, 1, 1},
+	"PEXPIREAT": {1, 1, 1}, "TTL": {1, 1, 1}, "PTTL": {1, 1, 1},
+	"PERSIST": {1, 1, 1}, "TYPE": {1, 1, 1}, "RENAME": {1, 2, 1},
+	"COPY": {1, 2, 1}, "MOVE": {1, 1, 1},
+
+	// Hashes
+	"HGET": {1, 1, 1}, "HSET": {1, 1, 1}, "HDEL": {1, 1, 1}, "HGETALL": {1, 1, 1},
+	"HMGET": {1, 1, 1}, "HMSET": {1, 1, 1}, "HEXISTS": {1, 1, 1}, "HKEYS": {1, 1, 1},
+	"HVALS": {1, 1, 1}, "HLEN": {1, 1, 1}, "HINCRBY": {1, 1, 1},
+	"HINCRBYFLOAT": {1, 1, 1}, "HSETNX": {1, 1, 1}, "HSCAN": {1, 1, 1},
+
+	// Lists
+	"LPUSH": {1, 1, 1}, "RPUSH": {1, 1, 1}, "LPOP": {1, 1, 1}, "RPOP": {1, 1, 1},
+	"BLPOP": {1, -2, 1}, "BRPOP": {1, -2, 1}, "LLEN": {1, 1, 1},
+	"LRANGE": {1, 1, 1}, "LINDEX": {1, 1, 1}, "LREM": {1, 1, 1},
+	"LTRIM": {1, 1, 1}, "RPOPLPUSH": {1, 2, 1}, "LPOS": {1, 1, 1},
+	"LSET": {1, 1, 1}, "LINSERT": {1, 1, 1},
+
+	// Sets
+	"SADD": {1, 1, 1}, "SREM": {1, 1, 1}, "SMEMBERS": {1, 1, 1},
+	"SISMEMBER": {1, 1, 1}, "SCARD": {1, 1, 1}, "SMISMEMBER": {1, 1, 1},
+	"SINTER": {1, -1, 1}, "SINTERSTORE": {1, -1, 1}, "SUNION": {1, -1, 1},
+	"SUNIONSTORE": {1, -1, 1}, "SDIFF": {1, -1, 1}, "SDIFFSTORE": {1, -1, 1},
+	"SSCAN": {1, 1, 1},
+
+	// Sorted sets
+	"ZADD": {1, 1, 1}, "ZRANGE": {1, 1, 1}, "ZRANGEBYSCORE": {1, 1, 1},
+	"ZSCORE": {1, 1, 1}, "ZREM": {1, 1, 1}, "ZREMRANGEBYSCORE": {1, 1, 1},
+	"ZREMRANGEBYRANK": {1, 1, 1}, "ZCARD": {1, 1, 1}, "ZINCRBY": {1, 1, 1},
+	"ZPOPMIN": {1, 1, 1}, "ZPOPMAX": {1, 1, 1}, "ZRANK": {1, 1, 1},
+	"ZREVRANK": {1, 1, 1}, "ZCOUNT": {1, 1, 1}, "ZSCAN": {1, 1, 1},
+
+	// Geospatial
+	"GEOADD": {1, 1, 1}, "GEOPOS": {1, 1, 1}, "GEODIST": {1, 1, 1},
+	"GEOHASH": {1, 1, 1}, "GEOSEARCH": {1, 1, 1}, "GEOSEARCHSTORE": {1, 2, 1},
+
+	// JSON
+	"JSON.SET": {1, 1, 1}, "JSON.GET": {1, 1, 1}, "JSON.MGET": {1, -2, 1},
+	"JSON.DEL": {1, 1, 1}, "JSON.FORGET": {1, 1, 1}, "JSON.NUMINCRBY": {1, 1, 1},
+	"JSON.ARRAPPEND": {1, 1, 1}, "JSON.ARRLEN": {1, 1, 1},
+	"JSON.OBJKEYS": {1, 1, 1}, "JSON.TYPE": {1, 1, 1}, "JSON.STRLEN": {1, 1, 1},
+
+	// HyperLogLog
+	"PFADD": {1, 1, 1}, "PFCOUNT": {1, -1, 1}, "PFMERGE": {1, -1, 1},
+
+	// Streams
+	"XADD": {1, 1, 1}, "XRANGE": {1, 1, 1}, "XREVRANGE": {1, 1, 1},
+	"XLEN": {1, 1, 1}, "XDEL": {1, 1, 1}, "XTRIM": {1, 1, 1},
+	"XGROUP": {2, 2, 1}, "XACK": {1, 1, 1}, "XPENDING": {1, 1, 1},
+	"XCLAIM": {1, 1, 1}, "XAUTOCLAIM": {1, 1, 1}, "XINFO": {2, 2, 1},
+}
+
+// noKeyCommands don't touch any key, so they don't affect the keys of the
+// transaction they're queued in
+var noKeyCommands = map[string]bool{
+	"PING": true, "ECHO": true, "QUIT": true, "COMMAND": true, "CLUSTER": true,
+	"CONFIG": true, "SELECT": true, "PUBLISH": true,
+}
+
+// commandKeys returns the keys a command accesses, and false if they aren't
+// known from the command table
+func commandKeys(name string, args []resp.Value) ([]string, bool) {
+	if noKeyCommands[name] {
+		return nil, true
+	}
+	spec, ok := commandKeySpecs[name]
+	if !ok {
+		return nil, false
+	}
+	// args excludes the command name, so position i is args[i-1]
+	last := spec.last
+	if last < 0 {
+		last += len(args) + 1
+	}
+	last = min(last, len(args))
+	var keys []string
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, args[i-1].Bulk)
+	}
+	return keys, true
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/mnorrsken/postkeys/internal/metrics"
 	"github.com/mnorrsken/postkeys/internal/resp"
 	"github.com/mnorrsken/postkeys/internal/storage"
+	"github.com/mnorrsken/postkeys/internal/stringmatch"
 )
 
 // Context key for protocol version
@@ -43,6 +44,30 @@ type ClientState interface {
 	SetName(name string)
 	SetLibInfo(libName, libVersion string)
 	GetInfo() string
+	GetDB() int
+	SetDB(db int)
+	PrimaryReads() bool
+	SetPrimaryReads(primary bool)
+}
+
+// NumDatabases is the number of logical databases selectable with SELECT
+const NumDatabases = 16
+
+var (
+	errDBIndexNotInteger = fmt.Errorf("ERR value is not an integer or out of range")
+	errDBIndexRange      = fmt.Errorf("ERR DB index is out of range")
+)
+
+// parseDBIndex parses a logical database index
+func parseDBIndex(s string) (int, error) {
+	db, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errDBIndexNotInteger
+	}
+	if db < 0 || db >= NumDatabases {
+		return 0, errDBIndexRange
+	}
+	return db, nil
 }
 
 // TransactionClientState interface for transaction-capable client state
@@ -54,9 +79,21 @@ type TransactionClientState interface {
 	GetQueuedCommands() []resp.Value
 	DiscardTransaction() error
 	QueueLength() int
+	FlagTransaction()
+	TransactionFlagged() bool
+	Watch(key WatchedKey)
+	WatchedKeys() []WatchedKey
+	Unwatch()
 }
 
-// ListNotifier interface for notifying about list push operations
+// WatchedKey is a key watched with WATCH and its version at the time
+type WatchedKey struct {
+	DB      int
+	Key     string
+	Version int64
+}
+
+// ListNotifier interface for notifying about list push and stream append operations
 type ListNotifier interface {
 	NotifyPush(ctx context.Context, key string) error
 	WaitForKey(ctx context.Context, key string, timeout time.Duration) bool
@@ -80,7 +117,7 @@ func New(store storage.Backend, password string) *Handler {
 	}
 }
 
-// SetListNotifier sets the list notifier for BRPOP/BLPOP
+// SetListNotifier sets the list notifier for BRPOP/BLPOP and XREAD/XREADGROUP BLOCK
 func (h *Handler) SetListNotifier(n ListNotifier) {
 	h.listNotifier = n
 }
@@ -161,9 +198,18 @@ func (h *Handler) Handle(ctx context.Context, cmd resp.Value) resp.Value {
 	cmdName := strings.ToUpper(cmd.Array[0].Bulk)
 	args := cmd.Array[1:]
 
+	// Declare the command's keys, so storage applies their durability class
+	if keys, ok := commandKeys(cmdName, args); ok {
+		ctx = storage.WithKeys(ctx, keys)
+	}
+
+	// Keep the command's failed statements, to tell whether an error reply
+	// was caused by a deadlock or serialization failure
+	ctx = storage.WithStatementErrors(ctx)
+
 	// Record metrics
 	start := time.Now()
-	result := h.executeCommand(ctx, cmdName, args)
+	result := tryAgain(h.executeCommand(ctx, cmdName, args), storage.StatementError(ctx))
 	duration := time.Since(start)
 	isError := result.Type == resp.Error
 	metrics.RecordCommand(cmdName, duration, isError)
@@ -195,8 +241,12 @@ func (h *Handler) executeCommand(ctx context.Context, cmdName string, args []res
 		return resp.Err("ERR " + cmdName + " must be handled with transaction context")
 
 	// FLUSHDB/FLUSHALL - only available on Backend, not in transactions
-	case "FLUSHDB", "FLUSHALL":
+	case "FLUSHDB":
 		return h.flushdb(ctx, args)
+	case "FLUSHALL":
+		return h.flushall(ctx, args)
+	case "CONFIG":
+		return h.config(ctx, args)
 
 	// All other commands use the unified Operations interface
 	default:
@@ -204,6 +254,31 @@ func (h *Handler) executeCommand(ctx context.Context, cmdName string, args []res
 	}
 }
 
+// HandleSelect processes SELECT, switching the connection's logical database
+func (h *Handler) HandleSelect(cmd resp.Value, client ClientState) resp.Value {
+	if cmd.Type != resp.Array || len(cmd.Array) != 2 {
+		return resp.ErrWrongArgs("select")
+	}
+
+	db, err := parseDBIndex(cmd.Array[1].Bulk)
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+	client.SetDB(db)
+	return resp.OK()
+}
+
+// HandleReadMode processes READONLY and READWRITE, which choose whether the
+// connection's read-only commands may be served by the read replica (the
+// default) or always go to the primary
+func (h *Handler) HandleReadMode(cmd resp.Value, client ClientState) resp.Value {
+	if cmd.Type != resp.Array || len(cmd.Array) != 1 {
+		return resp.ErrWrongArgs(strings.ToLower(cmd.Array[0].Bulk))
+	}
+	client.SetPrimaryReads(strings.EqualFold(cmd.Array[0].Bulk, "READWRITE"))
+	return resp.OK()
+}
+
 // HandleClient processes CLIENT commands with connection state
 func (h *Handler) HandleClient(cmd resp.Value, client ClientState) resp.Value {
 	if cmd.Type != resp.Array || len(cmd.Array) < 2 {
@@ -303,21 +378,191 @@ func (h *Handler) HandleDiscard(client TransactionClientState) resp.Value {
 	if err := client.DiscardTransaction(); err != nil {
 		return resp.Err(err.Error())
 	}
+	client.Unwatch()
 	return resp.OK()
 }
 
+// HandleQueue validates a command sent inside MULTI and queues it. Like in
+// Redis, a command that fails validation is rejected right away and makes the
+// following EXEC fail with EXECABORT.
+func (h *Handler) HandleQueue(cmd resp.Value, client TransactionClientState) resp.Value {
+	if err := checkCommand(cmd); err != nil {
+		client.FlagTransaction()
+		return resp.ErrCustom(err.Error())
+	}
+	if cmdName := strings.ToUpper(cmd.Array[0].Bulk); noMultiCommands[cmdName] {
+		client.FlagTransaction()
+		return resp.Err(fmt.Sprintf("%s is not allowed inside MULTI", cmdName))
+	}
+	client.QueueCommand(cmd)
+	return resp.Value{Type: resp.SimpleString, Str: "QUEUED"}
+}
+
+// HandleWatch handles the WATCH command, recording the current version of
+// each key so EXEC can abort if any of them changed in the meantime
+func (h *Handler) HandleWatch(ctx context.Context, cmd resp.Value, client TransactionClientState) resp.Value {
+	if cmd.Type != resp.Array || len(cmd.Array) < 2 {
+		return resp.ErrWrongArgs("watch")
+	}
+	if client.InTransaction() {
+		client.FlagTransaction()
+		return resp.Err("WATCH inside MULTI is not allowed")
+	}
+
+	keys := make([]string, len(cmd.Array)-1)
+	for i, arg := range cmd.Array[1:] {
+		keys[i] = arg.Bulk
+	}
+	versions, err := h.store.KeyVersions(ctx, keys)
+	if err != nil {
+		return resp.Err(err.Error())
+	}
+
+	db := storage.DBFromContext(ctx)
+	for i, key := range keys {
+		client.Watch(WatchedKey{DB: db, Key: key, Version: versions[i]})
+	}
+	return resp.OK()
+}
+
+// HandleUnwatch handles the UNWATCH command
+func (h *Handler) HandleUnwatch(client TransactionClientState) resp.Value {
+	client.Unwatch()
+	return resp.OK()
+}
+
+// execInSavepoint runs one queued command under a savepoint, so a command
+// that fails, even with a SQL error, is rolled back on its own and doesn't
+// leave the PostgreSQL transaction aborted for the commands after it. A
+// failed command's reply comes with the error of its last failed statement,
+// if any.
+func (h *Handler) execInSavepoint(ctx context.Context, tx storage.Transaction, cmdName string, args []resp.Value) (resp.Value, error) {
+	if err := tx.Savepoint(ctx); err != nil {
+		return resp.Err(fmt.Sprintf("savepoint failed: %v", err)), err
+	}
+
+	cmdCtx := storage.WithStatementErrors(ctx)
+	result := h.ExecuteWithOps(cmdCtx, tx, cmdName, args)
+	cause := storage.StatementError(cmdCtx)
+	if result.Type != resp.Error {
+		// Releasing fails if a statement error was swallowed by the command
+		err := tx.ReleaseSavepoint(ctx)
+		if err == nil {
+			return result, nil
+		}
+		result = resp.Err(err.Error())
+		if cause == nil {
+			cause = err
+		}
+	}
+
+	if err := tx.RollbackToSavepoint(ctx); err != nil {
+		return resp.Err(fmt.Sprintf("rollback to savepoint failed: %v", err)), err
+	}
+	return result, cause
+}
+
+// watchedKeysChanged reports whether any watched key has a different version
+// now, locking the unchanged ones in tx until it commits
+func watchedKeysChanged(ctx context.Context, tx storage.Transaction, watched []WatchedKey) (bool, error) {
+	byDB := make(map[int][]WatchedKey)
+	for _, w := range watched {
+		byDB[w.DB] = append(byDB[w.DB], w)
+	}
+
+	for db, keys := range byDB {
+		names := make([]string, len(keys))
+		for i, w := range keys {
+			names[i] = w.Key
+		}
+		versions, err := tx.KeyVersions(storage.WithDB(ctx, db), names)
+		if err != nil {
+			return false, err
+		}
+		for i, w := range keys {
+			if versions[i] != w.Version {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
 // HandleExec executes all queued commands in a transaction
 func (h *Handler) HandleExec(ctx context.Context, client TransactionClientState) resp.Value {
 	if !client.InTransaction() {
 		return resp.Err("ERR EXEC without MULTI")
 	}
 
+	// EXEC always unwatches, whether or not the transaction runs
+	watched := client.WatchedKeys()
+	client.Unwatch()
+
+	// Nothing runs if a command was rejected while queuing
+	if client.TransactionFlagged() {
+		client.DiscardTransaction()
+		return resp.ErrCustom("EXECABORT Transaction discarded because of previous errors.")
+	}
+
 	commands := client.GetQueuedCommands()
 
-	// Start a storage transaction
-	tx, err := h.store.BeginTx(ctx)
+	// Start a storage transaction, declaring the keys of all the commands
+	var txKeys []string
+	keysKnown := true
+	for _, cmd := range commands {
+		if cmd.Type != resp.Array || len(cmd.Array) == 0 {
+			continue
+		}
+		keys, ok := commandKeys(strings.ToUpper(cmd.Array[0].Bulk), cmd.Array[1:])
+		txKeys = append(txKeys, keys...)
+		keysKnown = keysKnown && ok
+	}
+	txCtx := ctx
+	if keysKnown {
+		txCtx = storage.WithKeys(ctx, txKeys)
+	}
+
+	// After a deadlock or serialization failure the whole batch runs again,
+	// from the database selected when EXEC started
+	db := client.GetDB()
+	var reply resp.Value
+	err := storage.RetryTx(ctx, "exec", func() error {
+		client.SetDB(db)
+		var err error
+		reply, err = h.execQueued(ctx, txCtx, client, commands, watched)
+		return err
+	})
+	if err != nil {
+		return tryAgain(reply, err)
+	}
+
+	// Record metrics for EXEC
+	metrics.RecordCommand("EXEC", 0, false)
+
+	return reply
+}
+
+// execQueued runs the queued commands of a transaction in one storage
+// transaction. If the transaction hits a deadlock or serialization failure
+// anywhere, it is rolled back and the error is returned with its reply, so
+// HandleExec can run the batch again.
+func (h *Handler) execQueued(ctx, txCtx context.Context, client TransactionClientState, commands []resp.Value, watched []WatchedKey) (resp.Value, error) {
+	tx, err := h.store.BeginTx(txCtx)
 	if err != nil {
-		return resp.Err(fmt.Sprintf("ERR transaction start failed: %v", err))
+		return resp.Err(fmt.Sprintf("ERR transaction start failed: %v", err)), retryable(err)
+	}
+
+	// Abort with a null reply if a watched key changed since WATCH
+	if len(watched) > 0 {
+		changed, err := watchedKeysChanged(ctx, tx, watched)
+		if err != nil {
+			tx.Rollback(ctx)
+			return resp.Err(fmt.Sprintf("transaction watch check failed: %v", err)), retryable(err)
+		}
+		if changed {
+			tx.Rollback(ctx)
+			return resp.NullArray(), nil
+		}
 	}
 
 	// Execute all commands within the transaction using the unified Operations interface
@@ -332,20 +577,51 @@ func (h *Handler) HandleExec(ctx context.Context, client TransactionClientState)
 		cmdName := strings.ToUpper(cmd.Array[0].Bulk)
 		args := cmd.Array[1:]
 
-		// Execute using the unified handler with the transaction
-		results[i] = h.ExecuteWithOps(ctx, tx, cmdName, args)
+		switch cmdName {
+		case "SELECT":
+			// SELECT switches the database for the commands queued after it
+			results[i] = h.HandleSelect(cmd, client)
+			ctx = storage.WithDB(ctx, client.GetDB())
+		case "PING", "ECHO", "QUIT", "COMMAND", "CLUSTER", "CONFIG":
+			// Connection and server configuration commands don't touch storage
+			results[i] = h.executeCommand(ctx, cmdName, args)
+		default:
+			// Execute using the unified handler with the transaction
+			var err error
+			results[i], err = h.execInSavepoint(ctx, tx, cmdName, args)
+			if storage.IsRetryable(err) {
+				tx.Rollback(ctx)
+				return results[i], err
+			}
+		}
 	}
 
 	// Commit the transaction
 	if err := tx.Commit(ctx); err != nil {
 		tx.Rollback(ctx)
-		return resp.Err(fmt.Sprintf("ERR transaction commit failed: %v", err))
+		return resp.Err(fmt.Sprintf("ERR transaction commit failed: %v", err)), retryable(err)
 	}
 
-	// Record metrics for EXEC
-	metrics.RecordCommand("EXEC", 0, false)
+	return resp.Value{Type: resp.Array, Array: results}, nil
+}
 
-	return resp.Value{Type: resp.Array, Array: results}
+// retryable returns err if it is a deadlock, serialization failure or lock
+// timeout worth running the transaction again for, else nil
+func retryable(err error) error {
+	if storage.IsRetryable(err) {
+		return err
+	}
+	return nil
+}
+
+// tryAgain turns an error reply caused by err, a deadlock, serialization
+// failure or lock timeout that outlasted its retries, into a TRYAGAIN error,
+// which tells the client the command may succeed if sent again
+func tryAgain(reply resp.Value, err error) resp.Value {
+	if reply.Type != resp.Error || !storage.IsRetryable(err) {
+		return reply
+	}
+	return resp.ErrCustom("TRYAGAIN " + strings.TrimPrefix(reply.Str, "ERR "))
 }
 
 // ============== Connection Commands ==============
@@ -484,3 +760,72 @@ func (h *Handler) flushdb(ctx context.Context, args []resp.Value) resp.Value {
 	}
 	return resp.OK()
 }
+
+func (h *Handler) flushall(ctx context.Context, args []resp.Value) resp.Value {
+	if err := h.store.FlushAll(ctx); err != nil {
+		return resp.Err(err.Error())
+	}
+	return resp.OK()
+}
+
+// config handles CONFIG GET/SET. Only the parameters postkeys acts on are
+// exposed, and settings apply to this server process only.
+func (h *Handler) config(ctx context.Context, args []resp.Value) resp.Value {
+	if len(args) == 0 {
+		return resp.ErrWrongArgs("config")
+	}
+
+	subCmd := strings.ToUpper(args[0].Bulk)
+	switch subCmd {
+	case "GET":
+		if len(args) < 2 {
+			return resp.ErrWrongArgs("config|get")
+		}
+		params := map[string]string{
+			"notify-keyspace-events": h.store.NotifyKeyspaceEvents(),
+			"databases":              strconv.Itoa(NumDatabases),
+		}
+		matched := make(map[string]string)
+		for name, value := range params {
+			for _, pattern := range args[1:] {
+				if stringmatch.Match(pattern.Bulk, name, true) {
+					matched[name] = value
+					break
+				}
+			}
+		}
+		if UseRESP3(ctx) {
+			return resp.MapVal(matched)
+		}
+		result := make([]resp.Value, 0, len(matched)*2)
+		for name, value := range matched {
+			result = append(result, resp.Bulk(name), resp.Bulk(value))
+		}
+		return resp.Arr(result...)
+
+	case "SET":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return resp.ErrWrongArgs("config|set")
+		}
+		for i := 1; i < len(args); i += 2 {
+			name := strings.ToLower(args[i].Bulk)
+			switch name {
+			case "notify-keyspace-events":
+				if err := h.store.SetNotifyKeyspaceEvents(args[i+1].Bulk); err != nil {
+					return resp.Err(fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - %v", name, err))
+				}
+			case "databases":
+				return resp.Err(fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", name))
+			default:
+				return resp.Err(fmt.Sprintf("Unknown option or number of arguments for CONFIG SET - '%s'", args[i].Bulk))
+			}
+		}
+		return resp.OK()
+
+	case "RESETSTAT", "REWRITE":
+		return resp.OK()
+
+	default:
+		return resp.Err(fmt.Sprintf("Unknown subcommand or wrong number of arguments for '%s'", subCmd))
+	}
+}
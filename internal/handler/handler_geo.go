@@ -0,0 +1,492 @@
+// Package handler implements Redis command handlers.
+// This file contains the geospatial command handlers. Like Redis, positions
+// are stored as sorted set members scored by their 52-bit geohash.
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mnorrsken/postkeys/internal/geo"
+	"github.com/mnorrsken/postkeys/internal/resp"
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// geoUnitFactor returns the number of meters in a GEO distance unit
+func geoUnitFactor(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+// formatGeoDistance formats a distance with four decimals, like Redis
+func formatGeoDistance(dist float64) resp.Value {
+	return resp.Bulk(strconv.FormatFloat(dist, 'f', 4, 64))
+}
+
+// geoCoordReply returns a [longitude, latitude] reply
+func geoCoordReply(long, lat float64) resp.Value {
+	return resp.Arr(
+		resp.Bulk(strconv.FormatFloat(long, 'f', 17, 64)),
+		resp.Bulk(strconv.FormatFloat(lat, 'f', 17, 64)),
+	)
+}
+
+// geoaddOp implements GEOADD key [NX|XX] [CH] longitude latitude member [...]
+func (h *Handler) geoaddOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 4 {
+		return resp.ErrWrongArgs("geoadd")
+	}
+
+	key := args[0].Bulk
+	i := 1
+	var nx, xx, ch bool
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		case "CH":
+			ch = true
+			continue
+		}
+		break
+	}
+
+	if nx && xx {
+		return resp.Err("XX and NX options at the same time are not compatible")
+	}
+	if remaining := len(args) - i; remaining == 0 || remaining%3 != 0 {
+		return resp.ErrCustom("ERR syntax error")
+	}
+
+	var members []storage.ZMember
+	for ; i < len(args); i += 3 {
+		long, err1 := strconv.ParseFloat(args[i].Bulk, 64)
+		lat, err2 := strconv.ParseFloat(args[i+1].Bulk, 64)
+		if err1 != nil || err2 != nil {
+			return resp.Err("value is not a valid float")
+		}
+		if !geo.ValidCoordinates(long, lat) {
+			return resp.Err(fmt.Sprintf("invalid longitude,latitude pair %f,%f", long, lat))
+		}
+		members = append(members, storage.ZMember{Member: args[i+2].Bulk, Score: float64(geo.Encode(long, lat))})
+	}
+
+	// Look up existing positions to apply NX/XX and count additions and changes
+	var added, changed int64
+	toAdd := members[:0]
+	for _, m := range members {
+		score, exists, err := ops.ZScore(ctx, key, m.Member)
+		if err != nil {
+			return errReply(err)
+		}
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if !exists {
+			added++
+		} else if score != m.Score {
+			changed++
+		}
+		toAdd = append(toAdd, m)
+	}
+
+	if len(toAdd) > 0 {
+		if _, err := ops.ZAdd(ctx, key, toAdd); err != nil {
+			return errReply(err)
+		}
+	}
+
+	if ch {
+		return resp.Int(added + changed)
+	}
+	return resp.Int(added)
+}
+
+// geoposOp implements GEOPOS key [member ...]
+func (h *Handler) geoposOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.ErrWrongArgs("geopos")
+	}
+
+	key := args[0].Bulk
+	result := make([]resp.Value, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		score, found, err := ops.ZScore(ctx, key, arg.Bulk)
+		if err != nil {
+			return errReply(err)
+		}
+		if !found {
+			result = append(result, resp.NullArray())
+			continue
+		}
+		result = append(result, geoCoordReply(geo.Decode(uint64(score))))
+	}
+	return resp.Arr(result...)
+}
+
+// geodistOp implements GEODIST key member1 member2 [M|KM|FT|MI]
+func (h *Handler) geodistOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) != 3 && len(args) != 4 {
+		return resp.ErrWrongArgs("geodist")
+	}
+
+	factor := 1.0
+	if len(args) == 4 {
+		var err error
+		if factor, err = geoUnitFactor(args[3].Bulk); err != nil {
+			return resp.ErrCustom(err.Error())
+		}
+	}
+
+	key := args[0].Bulk
+	score1, found1, err := ops.ZScore(ctx, key, args[1].Bulk)
+	if err != nil {
+		return errReply(err)
+	}
+	score2, found2, err := ops.ZScore(ctx, key, args[2].Bulk)
+	if err != nil {
+		return errReply(err)
+	}
+	if !found1 || !found2 {
+		return resp.NullBulk()
+	}
+
+	long1, lat1 := geo.Decode(uint64(score1))
+	long2, lat2 := geo.Decode(uint64(score2))
+	return formatGeoDistance(geo.Distance(long1, lat1, long2, lat2) / factor)
+}
+
+// geohashOp implements GEOHASH key [member ...]
+func (h *Handler) geohashOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.ErrWrongArgs("geohash")
+	}
+
+	key := args[0].Bulk
+	result := make([]resp.Value, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		score, found, err := ops.ZScore(ctx, key, arg.Bulk)
+		if err != nil {
+			return errReply(err)
+		}
+		if !found {
+			result = append(result, resp.NullBulk())
+			continue
+		}
+		result = append(result, resp.Bulk(geo.String(uint64(score))))
+	}
+	return resp.Arr(result...)
+}
+
+// geoSearchArgs holds the parsed arguments of GEOSEARCH and GEOSEARCHSTORE
+type geoSearchArgs struct {
+	fromMember string
+	hasMember  bool
+	long, lat  float64
+	hasLonLat  bool
+	shape      geo.Shape
+	hasShape   bool
+	unitFactor float64
+	sort       string // "", "ASC" or "DESC"
+	count      int64
+	any        bool
+	withCoord  bool
+	withDist   bool
+	withHash   bool
+	storeDist  bool
+}
+
+// parseGeoSearchArgs parses the options following the source key of
+// GEOSEARCH and GEOSEARCHSTORE
+func parseGeoSearchArgs(args []resp.Value, store bool) (geoSearchArgs, error) {
+	var s geoSearchArgs
+	syntaxErr := errors.New("ERR syntax error")
+
+	parseFloats := func(i, n int) ([]float64, error) {
+		if i+n >= len(args) {
+			return nil, syntaxErr
+		}
+		vals := make([]float64, n)
+		for j := range vals {
+			v, err := strconv.ParseFloat(args[i+1+j].Bulk, 64)
+			if err != nil {
+				return nil, errors.New("ERR value is not a valid float")
+			}
+			vals[j] = v
+		}
+		return vals, nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i].Bulk); opt {
+		case "FROMMEMBER":
+			if i+1 >= len(args) || s.hasLonLat {
+				return s, syntaxErr
+			}
+			s.fromMember, s.hasMember = args[i+1].Bulk, true
+			i++
+		case "FROMLONLAT":
+			if s.hasMember {
+				return s, syntaxErr
+			}
+			vals, err := parseFloats(i, 2)
+			if err != nil {
+				return s, err
+			}
+			if !geo.ValidCoordinates(vals[0], vals[1]) {
+				return s, fmt.Errorf("ERR invalid longitude,latitude pair %f,%f", vals[0], vals[1])
+			}
+			s.long, s.lat, s.hasLonLat = vals[0], vals[1], true
+			i += 2
+		case "BYRADIUS", "BYBOX":
+			if s.hasShape {
+				return s, syntaxErr
+			}
+			n := 1
+			if opt == "BYBOX" {
+				n = 2
+			}
+			vals, err := parseFloats(i, n)
+			if err != nil {
+				return s, err
+			}
+			if i+n+1 >= len(args) {
+				return s, syntaxErr
+			}
+			if s.unitFactor, err = geoUnitFactor(args[i+n+1].Bulk); err != nil {
+				return s, err
+			}
+			if opt == "BYRADIUS" {
+				if vals[0] < 0 {
+					return s, errors.New("ERR radius cannot be negative")
+				}
+				s.shape.Radius = vals[0] * s.unitFactor
+			} else {
+				if vals[0] < 0 || vals[1] < 0 {
+					return s, errors.New("ERR height or width cannot be negative")
+				}
+				s.shape.Width, s.shape.Height, s.shape.IsBox = vals[0]*s.unitFactor, vals[1]*s.unitFactor, true
+			}
+			s.hasShape = true
+			i += n + 1
+		case "ASC", "DESC":
+			s.sort = opt
+		case "COUNT":
+			if i+1 >= len(args) {
+				return s, syntaxErr
+			}
+			n, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return s, errors.New("ERR value is not an integer or out of range")
+			}
+			if n <= 0 {
+				return s, errors.New("ERR COUNT must be > 0")
+			}
+			s.count = n
+			i++
+			if i+1 < len(args) && strings.ToUpper(args[i+1].Bulk) == "ANY" {
+				s.any = true
+				i++
+			}
+		case "WITHCOORD", "WITHDIST", "WITHHASH":
+			if store {
+				return s, syntaxErr
+			}
+			switch opt {
+			case "WITHCOORD":
+				s.withCoord = true
+			case "WITHDIST":
+				s.withDist = true
+			default:
+				s.withHash = true
+			}
+		case "STOREDIST":
+			if !store {
+				return s, syntaxErr
+			}
+			s.storeDist = true
+		default:
+			return s, syntaxErr
+		}
+	}
+
+	if !s.hasMember && !s.hasLonLat {
+		return s, errors.New("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch")
+	}
+	if !s.hasShape {
+		return s, errors.New("ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch")
+	}
+	return s, nil
+}
+
+// geoResult is a member found by a geo search
+type geoResult struct {
+	member string
+	score  float64
+	dist   float64 // meters
+	long   float64
+	lat    float64
+}
+
+// geoSearch runs a parsed GEOSEARCH against key, returning matches in reply order
+func geoSearch(ctx context.Context, ops storage.Operations, key string, s geoSearchArgs) ([]geoResult, error) {
+	keyType, err := ops.Type(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if keyType != storage.TypeZSet && keyType != storage.TypeNone {
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	if keyType == storage.TypeNone {
+		return nil, nil
+	}
+
+	if s.hasMember {
+		score, found, err := ops.ZScore(ctx, key, s.fromMember)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, errors.New("ERR could not decode requested zset member")
+		}
+		s.long, s.lat = geo.Decode(uint64(score))
+	}
+
+	shape := s.shape
+	shape.Long, shape.Lat = s.long, s.lat
+
+	var results []geoResult
+	for _, r := range shape.ScoreRanges() {
+		// Score ranges are half-open, scores are integers
+		members, err := ops.ZRangeByScore(ctx, key, float64(r[0]), float64(r[1]-1), true, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			long, lat := geo.Decode(uint64(m.Score))
+			dist, ok := shape.Within(long, lat)
+			if !ok {
+				continue
+			}
+			results = append(results, geoResult{member: m.Member, score: m.Score, dist: dist, long: long, lat: lat})
+			if s.any && int64(len(results)) >= s.count {
+				break
+			}
+		}
+		if s.any && int64(len(results)) >= s.count {
+			break
+		}
+	}
+
+	// COUNT without ANY returns the closest matches
+	order := s.sort
+	if order == "" && s.count > 0 && !s.any {
+		order = "ASC"
+	}
+	switch order {
+	case "ASC":
+		sort.SliceStable(results, func(i, j int) bool { return results[i].dist < results[j].dist })
+	case "DESC":
+		sort.SliceStable(results, func(i, j int) bool { return results[i].dist > results[j].dist })
+	}
+
+	if s.count > 0 && int64(len(results)) > s.count {
+		results = results[:s.count]
+	}
+	return results, nil
+}
+
+// geosearchOp implements GEOSEARCH key FROMMEMBER member|FROMLONLAT lon lat
+// BYRADIUS radius unit|BYBOX width height unit [ASC|DESC] [COUNT count [ANY]]
+// [WITHCOORD] [WITHDIST] [WITHHASH]
+func (h *Handler) geosearchOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 5 {
+		return resp.ErrWrongArgs("geosearch")
+	}
+
+	s, err := parseGeoSearchArgs(args[1:], false)
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+
+	results, err := geoSearch(ctx, ops, args[0].Bulk, s)
+	if err != nil {
+		return errReply(err)
+	}
+
+	reply := make([]resp.Value, len(results))
+	for i, r := range results {
+		if !s.withDist && !s.withHash && !s.withCoord {
+			reply[i] = resp.Bulk(r.member)
+			continue
+		}
+		item := []resp.Value{resp.Bulk(r.member)}
+		if s.withDist {
+			item = append(item, formatGeoDistance(r.dist/s.unitFactor))
+		}
+		if s.withHash {
+			item = append(item, resp.Int(int64(r.score)))
+		}
+		if s.withCoord {
+			item = append(item, geoCoordReply(r.long, r.lat))
+		}
+		reply[i] = resp.Arr(item...)
+	}
+	return resp.Arr(reply...)
+}
+
+// geosearchstoreOp implements GEOSEARCHSTORE destination source ... [STOREDIST]
+func (h *Handler) geosearchstoreOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 6 {
+		return resp.ErrWrongArgs("geosearchstore")
+	}
+
+	s, err := parseGeoSearchArgs(args[2:], true)
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+
+	results, err := geoSearch(ctx, ops, args[1].Bulk, s)
+	if err != nil {
+		return errReply(err)
+	}
+
+	members := make([]storage.ZMember, len(results))
+	for i, r := range results {
+		score := r.score
+		if s.storeDist {
+			score = r.dist / s.unitFactor
+		}
+		members[i] = storage.ZMember{Member: r.member, Score: score}
+	}
+
+	// The destination is overwritten, and deleted when nothing matched
+	dest := args[0].Bulk
+	if _, err := ops.Del(ctx, []string{dest}); err != nil {
+		return errReply(err)
+	}
+	if len(members) > 0 {
+		if _, err := ops.ZAdd(ctx, dest, members); err != nil {
+			return errReply(err)
+		}
+	}
+	return resp.Int(int64(len(members)))
+}
@@ -0,0 +1,388 @@
+// Package handler implements Redis command handlers.
+// This file contains the RedisJSON (JSON.*) command handlers.
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mnorrsken/postkeys/internal/resp"
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// jsonPathNotExist returns the error for a legacy path that matched nothing
+func jsonPathNotExist(path string) resp.Value {
+	return resp.Err(fmt.Sprintf("Path '%s' does not exist", path))
+}
+
+// jsonTypeMatches reports whether a matched value has the type a command expects
+func jsonTypeMatches(m storage.JSONMatch, want string) bool {
+	if want == "number" {
+		return m.Type == "integer" || m.Type == "number"
+	}
+	return m.Type == want
+}
+
+// jsonMatchesReply builds the reply of a command applied to every value matched
+// by path. JSONPath returns one reply per match (nil for values of the wrong
+// type); legacy paths return the reply for the first match.
+func jsonMatchesReply(path string, matches []storage.JSONMatch, want string, reply func(storage.JSONMatch) resp.Value) resp.Value {
+	if storage.IsLegacyJSONPath(path) {
+		if len(matches) == 0 {
+			return jsonPathNotExist(path)
+		}
+		if want != "" && !jsonTypeMatches(matches[0], want) {
+			return resp.ErrCustom(fmt.Sprintf("WRONGTYPE wrong type of path value - expected %s but found %s", want, matches[0].Type))
+		}
+		return reply(matches[0])
+	}
+
+	result := make([]resp.Value, len(matches))
+	for i, m := range matches {
+		if want != "" && !jsonTypeMatches(m, want) {
+			result[i] = resp.NullBulk()
+			continue
+		}
+		result[i] = reply(m)
+	}
+	return resp.Arr(result...)
+}
+
+// formatJSON re-indents compact JSON using the JSON.GET INDENT, NEWLINE and SPACE strings
+func formatJSON(s, indent, newline, space string) string {
+	if indent == "" && newline == "" && space == "" {
+		return s
+	}
+
+	var b strings.Builder
+	depth := 0
+	inString := false
+	writeBreak := func() {
+		b.WriteString(newline)
+		b.WriteString(strings.Repeat(indent, depth))
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			b.WriteByte(c)
+			if c == '\\' && i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+			b.WriteByte(c)
+		case '{', '[':
+			b.WriteByte(c)
+			// Empty containers stay on one line
+			if i+1 < len(s) && (s[i+1] == '}' || s[i+1] == ']') {
+				i++
+				b.WriteByte(s[i])
+				continue
+			}
+			depth++
+			writeBreak()
+		case '}', ']':
+			depth--
+			writeBreak()
+			b.WriteByte(c)
+		case ',':
+			b.WriteByte(c)
+			writeBreak()
+		case ':':
+			b.WriteByte(c)
+			b.WriteString(space)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// jsonValues joins matched values into a JSON array
+func jsonValues(matches []storage.JSONMatch) string {
+	values := make([]string, len(matches))
+	for i, m := range matches {
+		values[i] = m.Value
+	}
+	return "[" + strings.Join(values, ",") + "]"
+}
+
+// jsonsetOp implements JSON.SET key path value [NX|XX]
+func (h *Handler) jsonsetOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) != 3 && len(args) != 4 {
+		return resp.ErrWrongArgs("json.set")
+	}
+
+	var nx, xx bool
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3].Bulk) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return resp.Err("syntax error")
+		}
+	}
+
+	value := args[2].Bulk
+	if !json.Valid([]byte(value)) {
+		return resp.Err("invalid JSON value")
+	}
+
+	set, err := ops.JSONSet(ctx, args[0].Bulk, args[1].Bulk, value, nx, xx)
+	if err != nil {
+		return errReply(err)
+	}
+	if !set {
+		return resp.NullBulk()
+	}
+	return resp.OK()
+}
+
+// jsongetOp implements JSON.GET key [INDENT indent] [NEWLINE newline] [SPACE space] [path ...]
+func (h *Handler) jsongetOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.ErrWrongArgs("json.get")
+	}
+
+	var indent, newline, space string
+	var paths []string
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToUpper(args[i].Bulk)
+		if (opt == "INDENT" || opt == "NEWLINE" || opt == "SPACE") && i+1 < len(args) {
+			switch opt {
+			case "INDENT":
+				indent = args[i+1].Bulk
+			case "NEWLINE":
+				newline = args[i+1].Bulk
+			case "SPACE":
+				space = args[i+1].Bulk
+			}
+			i++
+			continue
+		}
+		paths = append(paths, args[i].Bulk)
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	results, exists, err := ops.JSONGet(ctx, args[0].Bulk, paths)
+	if err != nil {
+		return errReply(err)
+	}
+	if !exists {
+		return resp.NullBulk()
+	}
+
+	// A single path returns its value; several return an object keyed by path.
+	// JSONPath results are arrays of all matches, and any JSONPath makes them all arrays.
+	allLegacy := true
+	for _, p := range paths {
+		if !storage.IsLegacyJSONPath(p) {
+			allLegacy = false
+		}
+	}
+
+	values := make([]string, len(paths))
+	for i, p := range paths {
+		if !allLegacy {
+			values[i] = jsonValues(results[i])
+			continue
+		}
+		if len(results[i]) == 0 {
+			return jsonPathNotExist(p)
+		}
+		values[i] = results[i][0].Value
+	}
+
+	if len(paths) == 1 {
+		return resp.Bulk(formatJSON(values[0], indent, newline, space))
+	}
+
+	parts := make([]string, len(paths))
+	for i, p := range paths {
+		name, _ := json.Marshal(p)
+		parts[i] = string(name) + ":" + values[i]
+	}
+	return resp.Bulk(formatJSON("{"+strings.Join(parts, ",")+"}", indent, newline, space))
+}
+
+// jsonmgetOp implements JSON.MGET key [key ...] path
+func (h *Handler) jsonmgetOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return resp.ErrWrongArgs("json.mget")
+	}
+
+	path := args[len(args)-1].Bulk
+	legacy := storage.IsLegacyJSONPath(path)
+	result := make([]resp.Value, len(args)-1)
+	for i, arg := range args[:len(args)-1] {
+		results, exists, err := ops.JSONGet(ctx, arg.Bulk, []string{path})
+		if err != nil && !strings.Contains(err.Error(), "WRONGTYPE") {
+			return errReply(err)
+		}
+
+		// Missing keys, keys of other types and unmatched legacy paths are nil
+		switch {
+		case err != nil || !exists:
+			result[i] = resp.NullBulk()
+		case !legacy:
+			result[i] = resp.Bulk(jsonValues(results[0]))
+		case len(results[0]) == 0:
+			result[i] = resp.NullBulk()
+		default:
+			result[i] = resp.Bulk(results[0][0].Value)
+		}
+	}
+	return resp.Arr(result...)
+}
+
+// jsondelOp implements JSON.DEL key [path] (and its alias JSON.FORGET)
+func (h *Handler) jsondelOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) != 1 && len(args) != 2 {
+		return resp.ErrWrongArgs("json.del")
+	}
+
+	path := "$"
+	if len(args) == 2 {
+		path = args[1].Bulk
+	}
+
+	deleted, err := ops.JSONDel(ctx, args[0].Bulk, path)
+	if err != nil {
+		return errReply(err)
+	}
+	return resp.Int(deleted)
+}
+
+// jsonnumincrbyOp implements JSON.NUMINCRBY key path value
+func (h *Handler) jsonnumincrbyOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) != 3 {
+		return resp.ErrWrongArgs("json.numincrby")
+	}
+
+	increment := args[2].Bulk
+	if _, err := strconv.ParseFloat(increment, 64); err != nil {
+		return resp.Err("value is not a valid number")
+	}
+
+	path := args[1].Bulk
+	matches, exists, err := ops.JSONNumIncrBy(ctx, args[0].Bulk, path, increment)
+	if err != nil {
+		return errReply(err)
+	}
+	if !exists {
+		return resp.Err("could not perform this operation on a key that doesn't exist")
+	}
+
+	// The new values are returned as JSON, with null for values that aren't numbers
+	if storage.IsLegacyJSONPath(path) {
+		return jsonMatchesReply(path, matches, "number", func(m storage.JSONMatch) resp.Value {
+			return resp.Bulk(m.Value)
+		})
+	}
+	values := make([]string, len(matches))
+	for i, m := range matches {
+		values[i] = "null"
+		if jsonTypeMatches(m, "number") {
+			values[i] = m.Value
+		}
+	}
+	return resp.Bulk("[" + strings.Join(values, ",") + "]")
+}
+
+// jsonarrappendOp implements JSON.ARRAPPEND key [path] value [value ...]
+func (h *Handler) jsonarrappendOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return resp.ErrWrongArgs("json.arrappend")
+	}
+
+	path := "$"
+	valueArgs := args[1:]
+	if len(args) > 2 {
+		path = args[1].Bulk
+		valueArgs = args[2:]
+	}
+
+	values := make([]string, len(valueArgs))
+	for i, v := range valueArgs {
+		if !json.Valid([]byte(v.Bulk)) {
+			return resp.Err("invalid JSON value")
+		}
+		values[i] = v.Bulk
+	}
+
+	matches, exists, err := ops.JSONArrAppend(ctx, args[0].Bulk, path, values)
+	if err != nil {
+		return errReply(err)
+	}
+	if !exists {
+		return resp.Err("could not perform this operation on a key that doesn't exist")
+	}
+	return jsonMatchesReply(path, matches, "array", func(m storage.JSONMatch) resp.Value {
+		return resp.Int(m.Len)
+	})
+}
+
+// jsonDescribeOp implements the read-only JSON commands that describe matched
+// values: ARRLEN, OBJKEYS, STRLEN and TYPE. Without a path they use the legacy root.
+func (h *Handler) jsonDescribeOp(ctx context.Context, ops storage.Operations, args []resp.Value, cmd, want string, reply func(storage.JSONMatch) resp.Value) resp.Value {
+	if len(args) != 1 && len(args) != 2 {
+		return resp.ErrWrongArgs(cmd)
+	}
+
+	path := "."
+	if len(args) == 2 {
+		path = args[1].Bulk
+	}
+
+	matches, exists, err := ops.JSONDescribe(ctx, args[0].Bulk, path, cmd == "json.objkeys")
+	if err != nil {
+		return errReply(err)
+	}
+	if !exists {
+		return resp.NullBulk()
+	}
+	return jsonMatchesReply(path, matches, want, reply)
+}
+
+func (h *Handler) jsonarrlenOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	return h.jsonDescribeOp(ctx, ops, args, "json.arrlen", "array", func(m storage.JSONMatch) resp.Value {
+		return resp.Int(m.Len)
+	})
+}
+
+func (h *Handler) jsonstrlenOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	return h.jsonDescribeOp(ctx, ops, args, "json.strlen", "string", func(m storage.JSONMatch) resp.Value {
+		return resp.Int(m.Len)
+	})
+}
+
+func (h *Handler) jsonobjkeysOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	return h.jsonDescribeOp(ctx, ops, args, "json.objkeys", "object", func(m storage.JSONMatch) resp.Value {
+		keys := make([]resp.Value, len(m.Keys))
+		for i, k := range m.Keys {
+			keys[i] = resp.Bulk(k)
+		}
+		return resp.Arr(keys...)
+	})
+}
+
+func (h *Handler) jsontypeOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	return h.jsonDescribeOp(ctx, ops, args, "json.type", "", func(m storage.JSONMatch) resp.Value {
+		return resp.Value{Type: resp.SimpleString, Str: m.Type}
+	})
+}
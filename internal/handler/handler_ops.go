@@ -40,6 +40,21 @@ func parseScoreBound(s string) (float64, error) {
 	return score, nil
 }
 
+// errReply converts a storage error into a RESP error, keeping the error code
+// (ERR, WRONGTYPE, ...) when the storage layer already supplied one
+func errReply(err error) resp.Value {
+	msg := err.Error()
+	if strings.Contains(msg, "WRONGTYPE") {
+		return resp.ErrWrongType()
+	}
+	for _, code := range []string{"ERR ", "NOGROUP ", "BUSYGROUP "} {
+		if strings.HasPrefix(msg, code) {
+			return resp.ErrCustom(msg)
+		}
+	}
+	return resp.Err(msg)
+}
+
 // ============== Unified Command Handlers ==============
 // These handlers work with storage.Operations interface, which is implemented
 // by both Backend (h.store) and Transaction (tx). This eliminates duplication
@@ -749,11 +764,26 @@ func (h *Handler) copyOp(ctx context.Context, ops storage.Operations, args []res
 
 	// Parse optional arguments
 	for i := 2; i < len(args); i++ {
-		opt := strings.ToUpper(args[i].Bulk)
-		if opt == "REPLACE" {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "REPLACE":
 			replace = true
+		case "DB":
+			if i+1 >= len(args) {
+				return resp.Err("syntax error")
+			}
+			db, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil {
+				return resp.Err("value is not an integer or out of range")
+			}
+			if db != storage.DBFromContext(ctx) {
+				return resp.Err("copying to another database is not supported")
+			}
+			i++
 		}
-		// DB option is ignored (single DB)
+	}
+
+	if source == destination {
+		return resp.Err("source and destination objects are the same")
 	}
 
 	ok, err := ops.Copy(ctx, source, destination, replace)
@@ -766,6 +796,29 @@ func (h *Handler) copyOp(ctx context.Context, ops storage.Operations, args []res
 	return resp.Int(0)
 }
 
+func (h *Handler) moveOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.ErrWrongArgs("move")
+	}
+
+	db, err := parseDBIndex(args[1].Bulk)
+	if err != nil {
+		return errReply(err)
+	}
+	if db == storage.DBFromContext(ctx) {
+		return resp.Err("source and destination objects are the same")
+	}
+
+	ok, err := ops.Move(ctx, args[0].Bulk, db)
+	if err != nil {
+		return resp.Err(err.Error())
+	}
+	if ok {
+		return resp.Int(1)
+	}
+	return resp.Int(0)
+}
+
 func (h *Handler) ttlOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
 	if len(args) != 1 {
 		return resp.ErrWrongArgs("ttl")
@@ -1101,89 +1154,37 @@ func (h *Handler) hscanOp(ctx context.Context, ops storage.Operations, args []re
 		return resp.ErrWrongArgs("hscan")
 	}
 
-	key := args[0].Bulk
-	// cursor is args[1], we ignore it since we return all results at once
-
-	// Parse optional MATCH and COUNT arguments
-	var pattern string
-	for i := 2; i < len(args)-1; i += 2 {
-		opt := strings.ToUpper(args[i].Bulk)
-		switch opt {
-		case "MATCH":
-			pattern = args[i+1].Bulk
-		case "COUNT":
-			// Ignore COUNT, we return all matches
-		}
-	}
-
-	// Get all fields from the hash
-	fields, err := ops.HGetAll(ctx, key)
+	sa, err := parseScanArgs(args[1:], false)
 	if err != nil {
-		return resp.Err(err.Error())
+		return resp.ErrCustom(err.Error())
 	}
 
-	// Build result array with field-value pairs
-	result := make([]resp.Value, 0, len(fields)*2)
-	for field, value := range fields {
-		// Apply pattern matching if specified
-		if pattern != "" && pattern != "*" {
-			matched, _ := matchGlob(pattern, field)
-			if !matched {
-				continue
-			}
-		}
-		result = append(result, resp.Bulk(field), resp.Bulk(value))
+	nextCursor, fields, err := ops.HScan(ctx, args[0].Bulk, sa.cursor, sa.pattern, sa.count)
+	if err != nil {
+		return errReply(err)
 	}
 
 	// HSCAN returns [cursor, [field1, value1, field2, value2, ...]]
-	// We always return cursor "0" to indicate scan is complete
+	items := make([]resp.Value, 0, len(fields)*2)
+	for _, f := range fields {
+		items = append(items, resp.Bulk(f.Field), resp.Bulk(f.Value))
+	}
 	return resp.Arr(
-		resp.Bulk("0"),
-		resp.Arr(result...),
+		resp.Bulk(strconv.FormatUint(nextCursor, 10)),
+		resp.Arr(items...),
 	)
 }
 
-// matchGlob performs simple glob pattern matching (supports * and ?)
-func matchGlob(pattern, s string) (bool, error) {
-	pi, si := 0, 0
-	starIdx, matchIdx := -1, 0
-
-	for si < len(s) {
-		if pi < len(pattern) && (pattern[pi] == '?' || pattern[pi] == s[si]) {
-			pi++
-			si++
-		} else if pi < len(pattern) && pattern[pi] == '*' {
-			starIdx = pi
-			matchIdx = si
-			pi++
-		} else if starIdx != -1 {
-			pi = starIdx + 1
-			matchIdx++
-			si = matchIdx
-		} else {
-			return false, nil
-		}
-	}
-
-	for pi < len(pattern) && pattern[pi] == '*' {
-		pi++
-	}
-
-	return pi == len(pattern), nil
-}
-
 // ============== Watch Commands ==============
-// WATCH and UNWATCH are used for optimistic locking in Redis.
-// Since we use PostgreSQL transactions with proper isolation,
-// we implement these as no-ops for compatibility.
+// WATCH and UNWATCH need connection state and are handled by HandleWatch and
+// HandleUnwatch. These only run for commands queued inside MULTI.
 
 func (h *Handler) watchOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
-	// WATCH is a no-op - PostgreSQL transactions provide proper isolation
-	return resp.OK()
+	return resp.Err("WATCH inside MULTI is not allowed")
 }
 
 func (h *Handler) unwatchOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
-	// UNWATCH is a no-op - PostgreSQL transactions provide proper isolation
+	// EXEC unwatches every key anyway
 	return resp.OK()
 }
 
@@ -1482,102 +1483,73 @@ func (h *Handler) blpopOp(ctx context.Context, ops storage.Operations, args []re
 
 // ============== Key Scan Commands ==============
 
-// scanOp implements SCAN - incrementally iterate over keys
-// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
-func (h *Handler) scanOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
-	if len(args) < 1 {
-		return resp.ErrWrongArgs("scan")
-	}
-
-	cursor, err := strconv.ParseInt(args[0].Bulk, 10, 64)
-	if err != nil {
-		return resp.Err("invalid cursor")
-	}
+// scanArgs holds the arguments shared by SCAN, HSCAN, SSCAN and ZSCAN
+type scanArgs struct {
+	cursor  uint64
+	pattern string
+	count   int64
+	keyType string
+}
 
-	// Parse optional arguments
-	pattern := "*"
-	count := int64(10)
-	var typeFilter string
+// parseScanArgs parses a SCAN-family cursor and its options; TYPE is only
+// accepted by SCAN itself
+func parseScanArgs(args []resp.Value, withType bool) (scanArgs, error) {
+	sa := scanArgs{pattern: "*", count: 10}
+	syntaxErr := errors.New("ERR syntax error")
 
-	for i := 1; i < len(args); i++ {
-		opt := strings.ToUpper(args[i].Bulk)
-		switch opt {
-		case "MATCH":
-			if i+1 >= len(args) {
-				return resp.Err("syntax error")
-			}
-			i++
-			pattern = args[i].Bulk
-		case "COUNT":
-			if i+1 >= len(args) {
-				return resp.Err("syntax error")
-			}
-			i++
-			count, err = strconv.ParseInt(args[i].Bulk, 10, 64)
-			if err != nil {
-				return resp.Err("value is not an integer or out of range")
-			}
-		case "TYPE":
-			if i+1 >= len(args) {
-				return resp.Err("syntax error")
-			}
-			i++
-			typeFilter = strings.ToLower(args[i].Bulk)
-		}
-	}
-
-	// Get all matching keys
-	allKeys, err := ops.Keys(ctx, pattern)
+	cursor, err := strconv.ParseUint(args[0].Bulk, 10, 64)
 	if err != nil {
-		return resp.Err(err.Error())
+		return sa, errors.New("ERR invalid cursor")
 	}
+	sa.cursor = cursor
 
-	// Filter by type if specified
-	var filteredKeys []string
-	if typeFilter != "" {
-		for _, key := range allKeys {
-			keyType, err := ops.Type(ctx, key)
+	for i := 1; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return sa, syntaxErr
+		}
+		switch opt := strings.ToUpper(args[i].Bulk); {
+		case opt == "MATCH":
+			sa.pattern = args[i+1].Bulk
+		case opt == "COUNT":
+			sa.count, err = strconv.ParseInt(args[i+1].Bulk, 10, 64)
 			if err != nil {
-				continue
+				return sa, errors.New("ERR value is not an integer or out of range")
 			}
-			if string(keyType) == typeFilter {
-				filteredKeys = append(filteredKeys, key)
+			if sa.count < 1 {
+				return sa, syntaxErr
 			}
+		case opt == "TYPE" && withType:
+			sa.keyType = args[i+1].Bulk
+		default:
+			return sa, syntaxErr
 		}
-	} else {
-		filteredKeys = allKeys
+		i++
 	}
+	return sa, nil
+}
 
-	// Simulate cursor-based pagination
-	// cursor is the start index, we return up to 'count' keys
-	start := int(cursor)
-	if start >= len(filteredKeys) {
-		// No more keys, return cursor 0 (end of iteration)
-		return resp.Arr(resp.Bulk("0"), resp.Arr())
+// scanOp implements SCAN - incrementally iterate over keys
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func (h *Handler) scanOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.ErrWrongArgs("scan")
 	}
 
-	end := start + int(count)
-	if end > len(filteredKeys) {
-		end = len(filteredKeys)
+	sa, err := parseScanArgs(args, true)
+	if err != nil {
+		return resp.ErrCustom(err.Error())
 	}
 
-	resultKeys := filteredKeys[start:end]
-
-	// Calculate next cursor
-	var nextCursor string
-	if end >= len(filteredKeys) {
-		nextCursor = "0" // End of iteration
-	} else {
-		nextCursor = strconv.Itoa(end)
+	nextCursor, keys, err := ops.Scan(ctx, sa.cursor, sa.pattern, sa.count, storage.KeyType(sa.keyType))
+	if err != nil {
+		return errReply(err)
 	}
 
-	// Build result array
-	keyValues := make([]resp.Value, len(resultKeys))
-	for i, key := range resultKeys {
+	keyValues := make([]resp.Value, len(keys))
+	for i, key := range keys {
 		keyValues[i] = resp.Bulk(key)
 	}
-
-	return resp.Arr(resp.Bulk(nextCursor), resp.Arr(keyValues...))
+	return resp.Arr(resp.Bulk(strconv.FormatUint(nextCursor, 10)), resp.Arr(keyValues...))
 }
 
 // ============== Set Commands ==============
@@ -2328,42 +2300,14 @@ func (h *Handler) zscanOp(ctx context.Context, ops storage.Operations, args []re
 		return resp.ErrWrongArgs("zscan")
 	}
 
-	key := args[0].Bulk
-	cursor, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	sa, err := parseScanArgs(args[1:], false)
 	if err != nil {
-		return resp.Err("ERR value is not an integer or out of range")
+		return resp.ErrCustom(err.Error())
 	}
 
-	pattern := "*"
-	count := int64(10)
-
-	for i := 2; i < len(args); i++ {
-		opt := strings.ToUpper(args[i].Bulk)
-		switch opt {
-		case "MATCH":
-			if i+1 >= len(args) {
-				return resp.Err("ERR syntax error")
-			}
-			i++
-			pattern = args[i].Bulk
-		case "COUNT":
-			if i+1 >= len(args) {
-				return resp.Err("ERR syntax error")
-			}
-			i++
-			count, err = strconv.ParseInt(args[i].Bulk, 10, 64)
-			if err != nil {
-				return resp.Err("ERR value is not an integer or out of range")
-			}
-		}
-	}
-
-	nextCursor, members, err := ops.ZScan(ctx, key, cursor, pattern, count)
+	nextCursor, members, err := ops.ZScan(ctx, args[0].Bulk, sa.cursor, sa.pattern, sa.count)
 	if err != nil {
-		if strings.Contains(err.Error(), "WRONGTYPE") {
-			return resp.ErrWrongType()
-		}
-		return resp.Err(err.Error())
+		return errReply(err)
 	}
 
 	// Build result array with member/score pairs
@@ -2374,7 +2318,7 @@ func (h *Handler) zscanOp(ctx context.Context, ops storage.Operations, args []re
 	}
 
 	return resp.Arr(
-		resp.Bulk(strconv.FormatInt(nextCursor, 10)),
+		resp.Bulk(strconv.FormatUint(nextCursor, 10)),
 		resp.Arr(items...),
 	)
 }
@@ -2866,81 +2810,21 @@ func (h *Handler) sscanOp(ctx context.Context, ops storage.Operations, args []re
 		return resp.ErrWrongArgs("sscan")
 	}
 
-	key := args[0].Bulk
-	cursor, err := strconv.ParseUint(args[1].Bulk, 10, 64)
+	sa, err := parseScanArgs(args[1:], false)
 	if err != nil {
-		return resp.Err("ERR invalid cursor")
-	}
-
-	// Parse options
-	pattern := "*"
-	count := int64(10)
-
-	for i := 2; i < len(args); i++ {
-		opt := strings.ToUpper(args[i].Bulk)
-		switch opt {
-		case "MATCH":
-			if i+1 >= len(args) {
-				return resp.ErrWrongArgs("sscan")
-			}
-			pattern = args[i+1].Bulk
-			i++
-		case "COUNT":
-			if i+1 >= len(args) {
-				return resp.ErrWrongArgs("sscan")
-			}
-			count, err = strconv.ParseInt(args[i+1].Bulk, 10, 64)
-			if err != nil {
-				return resp.Err("ERR value is not an integer or out of range")
-			}
-			i++
-		}
+		return resp.ErrCustom(err.Error())
 	}
 
-	// Get all members
-	allMembers, err := ops.SMembers(ctx, key)
+	nextCursor, members, err := ops.SScan(ctx, args[0].Bulk, sa.cursor, sa.pattern, sa.count)
 	if err != nil {
-		if strings.Contains(err.Error(), "WRONGTYPE") {
-			return resp.ErrWrongType()
-		}
-		return resp.Err(err.Error())
-	}
-
-	// Filter by pattern
-	var filteredMembers []string
-	for _, member := range allMembers {
-		matched, _ := matchGlob(pattern, member)
-		if matched {
-			filteredMembers = append(filteredMembers, member)
-		}
-	}
-
-	// Simulate cursor-based pagination
-	start := int(cursor)
-	if start >= len(filteredMembers) {
-		return resp.Arr(resp.Bulk("0"), resp.Arr())
+		return errReply(err)
 	}
 
-	end := start + int(count)
-	if end > len(filteredMembers) {
-		end = len(filteredMembers)
-	}
-
-	resultMembers := filteredMembers[start:end]
-
-	var nextCursor string
-	if end >= len(filteredMembers) {
-		nextCursor = "0"
-	} else {
-		nextCursor = strconv.Itoa(end)
-	}
-
-	memberValues := make([]resp.Value, len(resultMembers))
-	for i, member := range resultMembers {
+	memberValues := make([]resp.Value, len(members))
+	for i, member := range members {
 		memberValues[i] = resp.Bulk(member)
 	}
-
-	return resp.Arr(resp.Bulk(nextCursor), resp.Arr(memberValues...))
+	return resp.Arr(resp.Bulk(strconv.FormatUint(nextCursor, 10)), resp.Arr(memberValues...))
 }
 
 func (h *Handler) unlinkOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
@@ -3180,7 +3064,7 @@ func (h *Handler) bitposOp(ctx context.Context, ops storage.Operations, args []r
 
 func (h *Handler) infoOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
 	uptime := time.Since(h.startTime)
-	dbSize, _ := ops.DBSize(ctx)
+	keyspace, _ := ops.Keyspace(ctx)
 
 	info := fmt.Sprintf(`# Server
 redis_version:7.0.0-postkeys
@@ -3192,12 +3076,38 @@ uptime_in_seconds:%d
 uptime_in_days:%d
 
 # Keyspace
-db0:keys=%d
-`, runtime.GOOS, runtime.GOARCH, int(uptime.Seconds()), int(uptime.Hours()/24), dbSize)
+`, runtime.GOOS, runtime.GOARCH, int(uptime.Seconds()), int(uptime.Hours()/24))
+
+	for _, db := range keyspace {
+		info += fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=0\n", db.DB, db.Keys, db.Expires)
+	}
 
 	return resp.Bulk(info)
 }
 
+func (h *Handler) swapdbOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.ErrWrongArgs("swapdb")
+	}
+
+	db1, err := parseDBIndex(args[0].Bulk)
+	if err != nil {
+		return errReply(err)
+	}
+	db2, err := parseDBIndex(args[1].Bulk)
+	if err != nil {
+		return errReply(err)
+	}
+	if db1 == db2 {
+		return resp.OK()
+	}
+
+	if err := ops.SwapDB(ctx, db1, db2); err != nil {
+		return resp.Err(err.Error())
+	}
+	return resp.OK()
+}
+
 func (h *Handler) dbsizeOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
 	size, err := ops.DBSize(ctx)
 	if err != nil {
@@ -3277,6 +3187,8 @@ func (h *Handler) ExecuteWithOps(ctx context.Context, ops storage.Operations, cm
 		return h.renameOp(ctx, ops, args)
 	case "COPY":
 		return h.copyOp(ctx, ops, args)
+	case "MOVE":
+		return h.moveOp(ctx, ops, args)
 
 	// Hash commands
 	case "HGET":
@@ -3308,7 +3220,7 @@ func (h *Handler) ExecuteWithOps(ctx context.Context, ops storage.Operations, cm
 	case "HSCAN":
 		return h.hscanOp(ctx, ops, args)
 
-	// Watch commands (no-ops for PostgreSQL compatibility)
+	// Watch commands (only reached inside MULTI)
 	case "WATCH":
 		return h.watchOp(ctx, ops, args)
 	case "UNWATCH":
@@ -3418,6 +3330,54 @@ func (h *Handler) ExecuteWithOps(ctx context.Context, ops storage.Operations, cm
 	case "ZINTERSTORE":
 		return h.zinterstoreOp(ctx, ops, args)
 
+	// Geo commands
+	case "GEOADD":
+		return h.geoaddOp(ctx, ops, args)
+	case "GEOPOS":
+		return h.geoposOp(ctx, ops, args)
+	case "GEODIST":
+		return h.geodistOp(ctx, ops, args)
+	case "GEOHASH":
+		return h.geohashOp(ctx, ops, args)
+	case "GEOSEARCH":
+		return h.geosearchOp(ctx, ops, args)
+	case "GEOSEARCHSTORE":
+		return h.geosearchstoreOp(ctx, ops, args)
+
+	// JSON commands
+	case "JSON.SET":
+		return h.jsonsetOp(ctx, ops, args)
+	case "JSON.GET":
+		return h.jsongetOp(ctx, ops, args)
+	case "JSON.MGET":
+		return h.jsonmgetOp(ctx, ops, args)
+	case "JSON.DEL", "JSON.FORGET":
+		return h.jsondelOp(ctx, ops, args)
+	case "JSON.NUMINCRBY":
+		return h.jsonnumincrbyOp(ctx, ops, args)
+	case "JSON.ARRAPPEND":
+		return h.jsonarrappendOp(ctx, ops, args)
+	case "JSON.ARRLEN":
+		return h.jsonarrlenOp(ctx, ops, args)
+	case "JSON.OBJKEYS":
+		return h.jsonobjkeysOp(ctx, ops, args)
+	case "JSON.TYPE":
+		return h.jsontypeOp(ctx, ops, args)
+	case "JSON.STRLEN":
+		return h.jsonstrlenOp(ctx, ops, args)
+
+	// Search commands
+	case "FT.CREATE":
+		return h.ftcreateOp(ctx, ops, args)
+	case "FT.SEARCH":
+		return h.ftsearchOp(ctx, ops, args)
+	case "FT.INFO":
+		return h.ftinfoOp(ctx, ops, args)
+	case "FT.DROPINDEX":
+		return h.ftdropindexOp(ctx, ops, args)
+	case "FT._LIST":
+		return h.ftlistOp(ctx, ops, args)
+
 	// HyperLogLog commands
 	case "PFADD":
 		return h.pfaddOp(ctx, ops, args)
@@ -3426,6 +3386,36 @@ func (h *Handler) ExecuteWithOps(ctx context.Context, ops storage.Operations, cm
 	case "PFMERGE":
 		return h.pfmergeOp(ctx, ops, args)
 
+	// Stream commands
+	case "XADD":
+		return h.xaddOp(ctx, ops, args)
+	case "XRANGE":
+		return h.xrangeOp(ctx, ops, args)
+	case "XREVRANGE":
+		return h.xrevrangeOp(ctx, ops, args)
+	case "XLEN":
+		return h.xlenOp(ctx, ops, args)
+	case "XDEL":
+		return h.xdelOp(ctx, ops, args)
+	case "XTRIM":
+		return h.xtrimOp(ctx, ops, args)
+	case "XREAD":
+		return h.xreadOp(ctx, ops, args)
+	case "XGROUP":
+		return h.xgroupOp(ctx, ops, args)
+	case "XREADGROUP":
+		return h.xreadgroupOp(ctx, ops, args)
+	case "XACK":
+		return h.xackOp(ctx, ops, args)
+	case "XPENDING":
+		return h.xpendingOp(ctx, ops, args)
+	case "XCLAIM":
+		return h.xclaimOp(ctx, ops, args)
+	case "XAUTOCLAIM":
+		return h.xautoclaimOp(ctx, ops, args)
+	case "XINFO":
+		return h.xinfoOp(ctx, ops, args)
+
 	// Bitmap commands
 	case "SETBIT":
 		return h.setbitOp(ctx, ops, args)
@@ -3443,6 +3433,8 @@ func (h *Handler) ExecuteWithOps(ctx context.Context, ops storage.Operations, cm
 		return h.infoOp(ctx, ops, args)
 	case "DBSIZE":
 		return h.dbsizeOp(ctx, ops, args)
+	case "SWAPDB":
+		return h.swapdbOp(ctx, ops, args)
 
 	// Scripting commands
 	case "EVAL":
@@ -0,0 +1,305 @@
+// Package handler implements Redis command handlers.
+// This file contains the RediSearch (FT.*) command handlers.
+package handler
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/mnorrsken/postkeys/internal/resp"
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// ftcreateOp implements FT.CREATE index [ON HASH] [PREFIX count prefix ...] SCHEMA field [AS alias] TEXT|NUMERIC|TAG [options] ...
+func (h *Handler) ftcreateOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 4 {
+		return resp.ErrWrongArgs("ft.create")
+	}
+
+	index := storage.FTIndex{Name: args[0].Bulk}
+	i := 1
+	for ; i < len(args) && !strings.EqualFold(args[i].Bulk, "SCHEMA"); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "ON":
+			if i+1 >= len(args) {
+				return resp.Err("syntax error")
+			}
+			i++
+			switch strings.ToUpper(args[i].Bulk) {
+			case "HASH":
+			case "JSON":
+				return resp.Err("indexing JSON documents is not supported")
+			default:
+				return resp.Err("Unknown index type")
+			}
+		case "PREFIX":
+			if i+1 >= len(args) {
+				return resp.Err("syntax error")
+			}
+			n, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil || n < 1 || i+1+n >= len(args) {
+				return resp.Err("Bad arguments for PREFIX")
+			}
+			for _, p := range args[i+2 : i+2+n] {
+				index.Prefixes = append(index.Prefixes, p.Bulk)
+			}
+			i += 1 + n
+		case "STOPWORDS":
+			if i+1 >= len(args) {
+				return resp.Err("syntax error")
+			}
+			n, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil || n < 0 || i+1+n >= len(args) {
+				return resp.Err("Bad arguments for STOPWORDS")
+			}
+			i += 1 + n
+		case "LANGUAGE", "LANGUAGE_FIELD", "SCORE", "SCORE_FIELD", "PAYLOAD_FIELD", "TEMPORARY":
+			// Accepted for compatibility; text is always indexed with the 'simple' configuration
+			if i+1 >= len(args) {
+				return resp.Err("syntax error")
+			}
+			i++
+		case "MAXTEXTFIELDS", "NOOFFSETS", "NOHL", "NOFIELDS", "NOFREQS", "SKIPINITIALSCAN":
+		default:
+			return resp.Err("Unknown argument `" + args[i].Bulk + "`")
+		}
+	}
+	if i >= len(args) {
+		return resp.Err("No schema found")
+	}
+	if len(index.Prefixes) == 0 {
+		index.Prefixes = []string{""}
+	}
+
+	seen := make(map[string]bool)
+	for i++; i < len(args); {
+		field := storage.FTField{Name: args[i].Bulk}
+		i++
+		if i+1 < len(args) && strings.EqualFold(args[i].Bulk, "AS") {
+			field.Alias = args[i+1].Bulk
+			i += 2
+		}
+		if i >= len(args) {
+			return resp.Err("Field `" + field.Name + "` has no type")
+		}
+		field.Type = strings.ToUpper(args[i].Bulk)
+		switch field.Type {
+		case "TEXT", "NUMERIC":
+		case "TAG":
+			field.Separator = ","
+		default:
+			return resp.Err("Invalid field type for field `" + field.Name + "`")
+		}
+		i++
+
+	options:
+		for i < len(args) {
+			switch strings.ToUpper(args[i].Bulk) {
+			case "SORTABLE":
+				field.Sortable = true
+				i++
+				if i < len(args) && strings.EqualFold(args[i].Bulk, "UNF") {
+					i++
+				}
+			case "NOSTEM", "NOINDEX", "INDEXEMPTY", "INDEXMISSING":
+				i++
+			case "WEIGHT", "PHONETIC":
+				i += 2
+			case "SEPARATOR":
+				if field.Type != "TAG" || i+1 >= len(args) || len(args[i+1].Bulk) != 1 {
+					return resp.Err("Bad arguments for SEPARATOR")
+				}
+				field.Separator = args[i+1].Bulk
+				i += 2
+			default:
+				break options
+			}
+		}
+		if i > len(args) {
+			return resp.Err("syntax error")
+		}
+
+		attr := field.Name
+		if field.Alias != "" {
+			attr = field.Alias
+		}
+		if seen[attr] {
+			return resp.Err("Duplicate field in schema - " + attr)
+		}
+		seen[attr] = true
+		index.Fields = append(index.Fields, field)
+	}
+	if len(index.Fields) == 0 {
+		return resp.Err("Fields arguments are missing")
+	}
+
+	if err := ops.FTCreate(ctx, index); err != nil {
+		return errReply(err)
+	}
+	return resp.OK()
+}
+
+// ftsearchOp implements FT.SEARCH index query [NOCONTENT] [RETURN count field ...] [SORTBY field [ASC|DESC]] [LIMIT offset num]
+func (h *Handler) ftsearchOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return resp.ErrWrongArgs("ft.search")
+	}
+
+	opts := storage.FTSearchOptions{Limit: 10}
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "NOCONTENT":
+			opts.NoContent = true
+		case "VERBATIM", "NOSTOPWORDS", "WITHSORTKEYS":
+		case "RETURN":
+			if i+1 >= len(args) {
+				return resp.Err("syntax error")
+			}
+			n, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil || n < 0 || i+1+n >= len(args) {
+				return resp.Err("Bad arguments for RETURN")
+			}
+			opts.Return = []string{}
+			for _, f := range args[i+2 : i+2+n] {
+				opts.Return = append(opts.Return, f.Bulk)
+			}
+			i += 1 + n
+		case "SORTBY":
+			if i+1 >= len(args) {
+				return resp.Err("syntax error")
+			}
+			opts.SortBy = args[i+1].Bulk
+			i++
+			if i+1 < len(args) {
+				switch strings.ToUpper(args[i+1].Bulk) {
+				case "ASC":
+					i++
+				case "DESC":
+					opts.SortDesc = true
+					i++
+				}
+			}
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return resp.Err("syntax error")
+			}
+			offset, err1 := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			num, err2 := strconv.ParseInt(args[i+2].Bulk, 10, 64)
+			if err1 != nil || err2 != nil || offset < 0 || num < 0 {
+				return resp.Err("Bad arguments for LIMIT")
+			}
+			opts.Offset, opts.Limit = offset, num
+			i += 2
+		case "DIALECT", "TIMEOUT", "LANGUAGE", "SLOP":
+			i++
+		default:
+			return resp.Err("Unknown argument `" + args[i].Bulk + "`")
+		}
+	}
+
+	total, docs, err := ops.FTSearch(ctx, args[0].Bulk, args[1].Bulk, opts)
+	if err != nil {
+		return errReply(err)
+	}
+
+	result := []resp.Value{resp.Int(total)}
+	for _, doc := range docs {
+		result = append(result, resp.Bulk(doc.Key))
+		if opts.NoContent {
+			continue
+		}
+		fields := make([]resp.Value, len(doc.Fields))
+		for i, f := range doc.Fields {
+			fields[i] = resp.Bulk(f)
+		}
+		result = append(result, resp.Arr(fields...))
+	}
+	return resp.Arr(result...)
+}
+
+// ftinfoOp implements FT.INFO index
+func (h *Handler) ftinfoOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return resp.ErrWrongArgs("ft.info")
+	}
+
+	index, numDocs, err := ops.FTInfo(ctx, args[0].Bulk)
+	if err != nil {
+		return errReply(err)
+	}
+
+	prefixes := make([]resp.Value, len(index.Prefixes))
+	for i, p := range index.Prefixes {
+		prefixes[i] = resp.Bulk(p)
+	}
+
+	attributes := make([]resp.Value, len(index.Fields))
+	for i, f := range index.Fields {
+		attr := f.Name
+		if f.Alias != "" {
+			attr = f.Alias
+		}
+		desc := []resp.Value{
+			resp.Bulk("identifier"), resp.Bulk(f.Name),
+			resp.Bulk("attribute"), resp.Bulk(attr),
+			resp.Bulk("type"), resp.Bulk(f.Type),
+		}
+		if f.Type == "TAG" {
+			desc = append(desc, resp.Bulk("SEPARATOR"), resp.Bulk(f.Separator))
+		}
+		if f.Sortable {
+			desc = append(desc, resp.Bulk("SORTABLE"))
+		}
+		attributes[i] = resp.Arr(desc...)
+	}
+
+	return resp.Arr(
+		resp.Bulk("index_name"), resp.Bulk(index.Name),
+		resp.Bulk("index_options"), resp.Arr(),
+		resp.Bulk("index_definition"), resp.Arr(
+			resp.Bulk("key_type"), resp.Bulk("HASH"),
+			resp.Bulk("prefixes"), resp.Arr(prefixes...),
+			resp.Bulk("default_score"), resp.Bulk("1"),
+		),
+		resp.Bulk("attributes"), resp.Arr(attributes...),
+		resp.Bulk("num_docs"), resp.Int(numDocs),
+	)
+}
+
+// ftdropindexOp implements FT.DROPINDEX index [DD]
+func (h *Handler) ftdropindexOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) != 1 && len(args) != 2 {
+		return resp.ErrWrongArgs("ft.dropindex")
+	}
+
+	deleteDocs := false
+	if len(args) == 2 {
+		if !strings.EqualFold(args[1].Bulk, "DD") {
+			return resp.Err("syntax error")
+		}
+		deleteDocs = true
+	}
+
+	if err := ops.FTDropIndex(ctx, args[0].Bulk, deleteDocs); err != nil {
+		return errReply(err)
+	}
+	return resp.OK()
+}
+
+// ftlistOp implements FT._LIST
+func (h *Handler) ftlistOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) != 0 {
+		return resp.ErrWrongArgs("ft._list")
+	}
+
+	names, err := ops.FTList(ctx)
+	if err != nil {
+		return errReply(err)
+	}
+	result := make([]resp.Value, len(names))
+	for i, name := range names {
+		result[i] = resp.Bulk(name)
+	}
+	return resp.Arr(result...)
+}
@@ -0,0 +1,957 @@
+// Package handler implements Redis command handlers.
+// This file contains the stream command handlers.
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mnorrsken/postkeys/internal/resp"
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// parseStreamRangeBound parses an XRANGE/XREVRANGE bound: "-", "+", an exclusive
+// "(<id>" or a full or incomplete ID. Incomplete end IDs cover the whole millisecond.
+func parseStreamRangeBound(s string, isEnd bool) (storage.StreamID, error) {
+	switch s {
+	case "-":
+		return storage.StreamID{}, nil
+	case "+":
+		return storage.MaxStreamID, nil
+	}
+
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	defaultSeq := uint64(0)
+	if isEnd {
+		defaultSeq = storage.MaxStreamID.Seq
+	}
+	id, err := storage.ParseStreamID(s, defaultSeq)
+	if err != nil {
+		return storage.StreamID{}, err
+	}
+	if !exclusive {
+		return id, nil
+	}
+
+	if isEnd {
+		if id == (storage.StreamID{}) {
+			return id, errors.New("ERR invalid end ID for the interval")
+		}
+		if id.Seq == 0 {
+			return storage.StreamID{Ms: id.Ms - 1, Seq: storage.MaxStreamID.Seq}, nil
+		}
+		return storage.StreamID{Ms: id.Ms, Seq: id.Seq - 1}, nil
+	}
+	if id == storage.MaxStreamID {
+		return id, errors.New("ERR invalid start ID for the interval")
+	}
+	if id.Seq == storage.MaxStreamID.Seq {
+		return storage.StreamID{Ms: id.Ms + 1}, nil
+	}
+	return storage.StreamID{Ms: id.Ms, Seq: id.Seq + 1}, nil
+}
+
+// parseStreamTrim parses "MAXLEN|MINID [=|~] threshold [LIMIT count]" starting at args[i].
+// It returns the trim options and the index of the last consumed argument.
+func parseStreamTrim(args []resp.Value, i int) (storage.StreamTrim, int, error) {
+	trim := storage.StreamTrim{Strategy: strings.ToUpper(args[i].Bulk)}
+	i++
+	if i < len(args) && (args[i].Bulk == "~" || args[i].Bulk == "=") {
+		trim.Approx = args[i].Bulk == "~"
+		i++
+	}
+	if i >= len(args) {
+		return trim, i, errors.New("ERR syntax error")
+	}
+
+	if trim.Strategy == "MAXLEN" {
+		maxLen, err := strconv.ParseInt(args[i].Bulk, 10, 64)
+		if err != nil {
+			return trim, i, errors.New("ERR value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return trim, i, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+		trim.MaxLen = maxLen
+	} else {
+		minID, err := storage.ParseStreamID(args[i].Bulk, 0)
+		if err != nil {
+			return trim, i, err
+		}
+		trim.MinID = minID
+	}
+
+	if i+1 < len(args) && strings.ToUpper(args[i+1].Bulk) == "LIMIT" {
+		if i+2 >= len(args) {
+			return trim, i, errors.New("ERR syntax error")
+		}
+		limit, err := strconv.ParseInt(args[i+2].Bulk, 10, 64)
+		if err != nil {
+			return trim, i, errors.New("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return trim, i, errors.New("ERR The LIMIT argument must be >= 0.")
+		}
+		if !trim.Approx {
+			return trim, i, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		// LIMIT 0 disables the eviction cap
+		trim.Limit = limit
+		if limit == 0 {
+			trim.Limit = -1
+		}
+		i += 2
+	}
+	return trim, i, nil
+}
+
+// streamEntryReply formats a stream entry as [id, [field, value, ...]]
+func streamEntryReply(entry storage.StreamEntry) resp.Value {
+	if entry.Fields == nil {
+		// Pending entry that was deleted from the stream
+		return resp.Arr(resp.Bulk(entry.ID.String()), resp.NullArray())
+	}
+	fields := make([]resp.Value, len(entry.Fields))
+	for i, f := range entry.Fields {
+		fields[i] = resp.Bulk(f)
+	}
+	return resp.Arr(resp.Bulk(entry.ID.String()), resp.Arr(fields...))
+}
+
+// streamEntriesReply formats stream entries as [[id, [field, value, ...]], ...]
+func streamEntriesReply(entries []storage.StreamEntry) resp.Value {
+	result := make([]resp.Value, len(entries))
+	for i, entry := range entries {
+		result[i] = streamEntryReply(entry)
+	}
+	return resp.Arr(result...)
+}
+
+// parseStreamIDs parses a list of full or incomplete stream IDs
+func parseStreamIDs(args []resp.Value) ([]storage.StreamID, error) {
+	ids := make([]storage.StreamID, len(args))
+	for i, arg := range args {
+		id, err := storage.ParseStreamID(arg.Bulk, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// streamIDsReply formats stream IDs as an array of bulk strings
+func streamIDsReply(ids []storage.StreamID) resp.Value {
+	result := make([]resp.Value, len(ids))
+	for i, id := range ids {
+		result[i] = resp.Bulk(id.String())
+	}
+	return resp.Arr(result...)
+}
+
+// nullableInt returns an integer reply, or a null reply for unknown values
+func nullableInt(n *int64) resp.Value {
+	if n == nil {
+		return resp.NullBulk()
+	}
+	return resp.Int(*n)
+}
+
+// ============== Stream Commands ==============
+
+// xaddOp implements XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func (h *Handler) xaddOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 4 {
+		return resp.ErrWrongArgs("xadd")
+	}
+
+	key := args[0].Bulk
+	noMkStream := false
+	var trim storage.StreamTrim
+
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "NOMKSTREAM":
+			noMkStream = true
+		case "MAXLEN", "MINID":
+			var err error
+			trim, i, err = parseStreamTrim(args, i)
+			if err != nil {
+				return resp.ErrCustom(err.Error())
+			}
+		default:
+			break options
+		}
+	}
+
+	// ID followed by at least one field/value pair
+	if i >= len(args) || (len(args)-i-1) == 0 || (len(args)-i-1)%2 != 0 {
+		return resp.ErrWrongArgs("xadd")
+	}
+	id := args[i].Bulk
+	fields := make([]string, 0, len(args)-i-1)
+	for _, arg := range args[i+1:] {
+		fields = append(fields, arg.Bulk)
+	}
+
+	newID, added, err := ops.XAdd(ctx, key, id, fields, noMkStream, trim)
+	if err != nil {
+		return errReply(err)
+	}
+	if !added {
+		return resp.NullBulk()
+	}
+
+	// Wake up XREAD/XREADGROUP BLOCK clients on all instances
+	if h.listNotifier != nil {
+		h.listNotifier.NotifyPush(ctx, key)
+	}
+	return resp.Bulk(newID)
+}
+
+// xrangeOp implements XRANGE key start end [COUNT count]
+func (h *Handler) xrangeOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	return h.streamRangeOp(ctx, ops, args, "xrange", false)
+}
+
+// xrevrangeOp implements XREVRANGE key end start [COUNT count]
+func (h *Handler) xrevrangeOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	return h.streamRangeOp(ctx, ops, args, "xrevrange", true)
+}
+
+func (h *Handler) streamRangeOp(ctx context.Context, ops storage.Operations, args []resp.Value, cmd string, reverse bool) resp.Value {
+	if len(args) != 3 && len(args) != 5 {
+		return resp.ErrWrongArgs(cmd)
+	}
+
+	startArg, endArg := args[1].Bulk, args[2].Bulk
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := parseStreamRangeBound(startArg, false)
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+	end, err := parseStreamRangeBound(endArg, true)
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+
+	count := int64(-1)
+	if len(args) == 5 {
+		if strings.ToUpper(args[3].Bulk) != "COUNT" {
+			return resp.Err("syntax error")
+		}
+		count, err = strconv.ParseInt(args[4].Bulk, 10, 64)
+		if err != nil {
+			return resp.Err("value is not an integer or out of range")
+		}
+		if count < 0 {
+			count = 0
+		}
+	}
+
+	var entries []storage.StreamEntry
+	if reverse {
+		entries, err = ops.XRevRange(ctx, args[0].Bulk, end, start, count)
+	} else {
+		entries, err = ops.XRange(ctx, args[0].Bulk, start, end, count)
+	}
+	if err != nil {
+		return errReply(err)
+	}
+	if count == 0 {
+		return resp.NullArray()
+	}
+	return streamEntriesReply(entries)
+}
+
+// xlenOp implements XLEN key
+func (h *Handler) xlenOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return resp.ErrWrongArgs("xlen")
+	}
+
+	length, err := ops.XLen(ctx, args[0].Bulk)
+	if err != nil {
+		return errReply(err)
+	}
+	return resp.Int(length)
+}
+
+// xdelOp implements XDEL key id [id ...]
+func (h *Handler) xdelOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return resp.ErrWrongArgs("xdel")
+	}
+
+	ids, err := parseStreamIDs(args[1:])
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+
+	deleted, err := ops.XDel(ctx, args[0].Bulk, ids)
+	if err != nil {
+		return errReply(err)
+	}
+	return resp.Int(deleted)
+}
+
+// xtrimOp implements XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func (h *Handler) xtrimOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return resp.ErrWrongArgs("xtrim")
+	}
+
+	strategy := strings.ToUpper(args[1].Bulk)
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return resp.Err("syntax error")
+	}
+	trim, last, err := parseStreamTrim(args, 1)
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+	if last != len(args)-1 {
+		return resp.Err("syntax error")
+	}
+
+	trimmed, err := ops.XTrim(ctx, args[0].Bulk, trim)
+	if err != nil {
+		return errReply(err)
+	}
+	return resp.Int(trimmed)
+}
+
+// xreadOp implements XREAD [COUNT count] [BLOCK ms] STREAMS key [key ...] id [id ...]
+func (h *Handler) xreadOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return resp.ErrWrongArgs("xread")
+	}
+
+	read, err := parseStreamReadArgs(args, "xread")
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+
+	// IDs are exclusive; "$" is resolved once so entries added while blocked are returned
+	starts := make([]storage.StreamID, len(read.keys))
+	for i, id := range read.ids {
+		if id != "$" {
+			if starts[i], err = parseStreamRangeBound("("+id, false); err != nil {
+				return resp.ErrCustom(err.Error())
+			}
+			continue
+		}
+		last, err := ops.XRevRange(ctx, read.keys[i], storage.MaxStreamID, storage.StreamID{}, 1)
+		if err != nil {
+			return errReply(err)
+		}
+		if len(last) > 0 {
+			starts[i], _ = parseStreamRangeBound("("+last[0].ID.String(), false)
+		}
+	}
+
+	return h.blockingStreamRead(ctx, ops, read, func() ([]storage.StreamReadResult, error) {
+		var results []storage.StreamReadResult
+		for i, key := range read.keys {
+			entries, err := ops.XRange(ctx, key, starts[i], storage.MaxStreamID, read.count)
+			if err != nil {
+				return nil, err
+			}
+			if len(entries) > 0 {
+				results = append(results, storage.StreamReadResult{Key: key, Entries: entries})
+			}
+		}
+		return results, nil
+	})
+}
+
+// streamReadArgs holds the parsed arguments of XREAD and XREADGROUP
+type streamReadArgs struct {
+	group    string
+	consumer string
+	count    int64 // -1 for no limit
+	block    bool
+	timeout  time.Duration // with block set, 0 waits forever
+	noAck    bool
+	keys     []string
+	ids      []string
+}
+
+// parseStreamReadArgs parses the options and STREAMS section of XREAD and XREADGROUP
+func parseStreamReadArgs(args []resp.Value, cmd string) (streamReadArgs, error) {
+	read := streamReadArgs{count: -1}
+	hasGroup := false
+	streamsIdx := -1
+
+	for i := 0; i < len(args) && streamsIdx < 0; i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "GROUP":
+			if cmd != "xreadgroup" || i+2 >= len(args) {
+				return read, errors.New("ERR syntax error")
+			}
+			read.group, read.consumer = args[i+1].Bulk, args[i+2].Bulk
+			hasGroup = true
+			i += 2
+		case "COUNT":
+			if i+1 >= len(args) {
+				return read, errors.New("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return read, errors.New("ERR value is not an integer or out of range")
+			}
+			if n > 0 {
+				read.count = n
+			}
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return read, errors.New("ERR syntax error")
+			}
+			ms, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return read, errors.New("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return read, errors.New("ERR timeout is negative")
+			}
+			read.block = true
+			read.timeout = time.Duration(ms) * time.Millisecond
+			i++
+		case "NOACK":
+			if cmd != "xreadgroup" {
+				return read, errors.New("ERR syntax error")
+			}
+			read.noAck = true
+		case "STREAMS":
+			streamsIdx = i + 1
+		default:
+			return read, errors.New("ERR syntax error")
+		}
+	}
+
+	if cmd == "xreadgroup" && !hasGroup {
+		return read, errors.New("ERR Missing GROUP option for XREADGROUP")
+	}
+	if streamsIdx < 0 || streamsIdx >= len(args) || (len(args)-streamsIdx)%2 != 0 {
+		idHint := "'$'"
+		if cmd == "xreadgroup" {
+			idHint = "'>'"
+		}
+		return read, fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or %s must be specified.", cmd, idHint)
+	}
+
+	numStreams := (len(args) - streamsIdx) / 2
+	read.keys = make([]string, numStreams)
+	read.ids = make([]string, numStreams)
+	for i := 0; i < numStreams; i++ {
+		read.keys[i] = args[streamsIdx+i].Bulk
+		read.ids[i] = args[streamsIdx+numStreams+i].Bulk
+	}
+	return read, nil
+}
+
+// blockingStreamRead runs read until it returns entries. With BLOCK it waits for
+// XADD notifications on the keys (from any instance) until the timeout expires.
+func (h *Handler) blockingStreamRead(ctx context.Context, ops storage.Operations, read streamReadArgs, readFn func() ([]storage.StreamReadResult, error)) resp.Value {
+	// Like Redis, never block inside MULTI/EXEC
+	if _, inTx := ops.(storage.Transaction); inTx {
+		read.block = false
+	}
+
+	var deadline time.Time
+	if read.timeout > 0 {
+		deadline = time.Now().Add(read.timeout)
+	}
+
+	for {
+		results, err := readFn()
+		if err != nil {
+			return errReply(err)
+		}
+		if len(results) > 0 {
+			reply := make([]resp.Value, len(results))
+			for i, r := range results {
+				reply[i] = resp.Arr(resp.Bulk(r.Key), streamEntriesReply(r.Entries))
+			}
+			return resp.Arr(reply...)
+		}
+
+		if !read.block || (!deadline.IsZero() && !time.Now().Before(deadline)) {
+			return resp.NullArray()
+		}
+
+		// Notifications can be missed between reading and subscribing,
+		// so never wait longer than the fallback poll interval
+		waitTime := 100 * time.Millisecond
+		if !deadline.IsZero() {
+			if remaining := time.Until(deadline); remaining < waitTime {
+				waitTime = remaining
+			}
+		}
+		if h.listNotifier != nil {
+			h.listNotifier.WaitForKeys(ctx, read.keys, waitTime)
+		} else {
+			select {
+			case <-ctx.Done():
+			case <-time.After(waitTime):
+			}
+		}
+
+		if ctx.Err() != nil {
+			return resp.NullArray()
+		}
+	}
+}
+
+// ============== Stream Consumer Group Commands ==============
+
+// xgroupOp implements XGROUP CREATE|SETID|DESTROY|CREATECONSUMER|DELCONSUMER
+func (h *Handler) xgroupOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.ErrWrongArgs("xgroup")
+	}
+
+	subCmd := strings.ToUpper(args[0].Bulk)
+	switch subCmd {
+	case "CREATE", "SETID":
+		// XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+		// XGROUP SETID key group id|$ [ENTRIESREAD entries-read]
+		if len(args) < 4 {
+			return resp.ErrWrongArgs("xgroup|" + strings.ToLower(subCmd))
+		}
+		mkStream := false
+		var entriesRead *int64
+		for i := 4; i < len(args); i++ {
+			switch strings.ToUpper(args[i].Bulk) {
+			case "MKSTREAM":
+				if subCmd != "CREATE" {
+					return resp.Err("syntax error")
+				}
+				mkStream = true
+			case "ENTRIESREAD":
+				if i+1 >= len(args) {
+					return resp.Err("syntax error")
+				}
+				n, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+				if err != nil {
+					return resp.Err("value is not an integer or out of range")
+				}
+				if n < -1 {
+					return resp.Err("value for ENTRIESREAD must be positive or -1")
+				}
+				entriesRead = &n
+				i++
+			default:
+				return resp.Err("syntax error")
+			}
+		}
+
+		var err error
+		if subCmd == "CREATE" {
+			err = ops.XGroupCreate(ctx, args[1].Bulk, args[2].Bulk, args[3].Bulk, mkStream, entriesRead)
+		} else {
+			err = ops.XGroupSetID(ctx, args[1].Bulk, args[2].Bulk, args[3].Bulk, entriesRead)
+		}
+		if err != nil {
+			return errReply(err)
+		}
+		return resp.OK()
+
+	case "DESTROY":
+		if len(args) != 3 {
+			return resp.ErrWrongArgs("xgroup|destroy")
+		}
+		destroyed, err := ops.XGroupDestroy(ctx, args[1].Bulk, args[2].Bulk)
+		if err != nil {
+			return errReply(err)
+		}
+		if destroyed {
+			return resp.Int(1)
+		}
+		return resp.Int(0)
+
+	case "CREATECONSUMER":
+		if len(args) != 4 {
+			return resp.ErrWrongArgs("xgroup|createconsumer")
+		}
+		created, err := ops.XGroupCreateConsumer(ctx, args[1].Bulk, args[2].Bulk, args[3].Bulk)
+		if err != nil {
+			return errReply(err)
+		}
+		if created {
+			return resp.Int(1)
+		}
+		return resp.Int(0)
+
+	case "DELCONSUMER":
+		if len(args) != 4 {
+			return resp.ErrWrongArgs("xgroup|delconsumer")
+		}
+		pending, err := ops.XGroupDelConsumer(ctx, args[1].Bulk, args[2].Bulk, args[3].Bulk)
+		if err != nil {
+			return errReply(err)
+		}
+		return resp.Int(pending)
+
+	default:
+		return resp.Err(fmt.Sprintf("unknown subcommand '%s'. Try XGROUP HELP.", args[0].Bulk))
+	}
+}
+
+// xreadgroupOp implements XREADGROUP GROUP group consumer [COUNT count] [BLOCK ms] [NOACK] STREAMS key [key ...] id [id ...]
+func (h *Handler) xreadgroupOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 6 {
+		return resp.ErrWrongArgs("xreadgroup")
+	}
+
+	read, err := parseStreamReadArgs(args, "xreadgroup")
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+	for _, id := range read.ids {
+		if id == "$" {
+			return resp.Err("The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		}
+		if id != ">" {
+			if _, err := storage.ParseStreamID(id, 0); err != nil {
+				return resp.ErrCustom(err.Error())
+			}
+		}
+	}
+
+	return h.blockingStreamRead(ctx, ops, read, func() ([]storage.StreamReadResult, error) {
+		return ops.XReadGroup(ctx, read.group, read.consumer, read.keys, read.ids, read.count, read.noAck)
+	})
+}
+
+// xackOp implements XACK key group id [id ...]
+func (h *Handler) xackOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return resp.ErrWrongArgs("xack")
+	}
+
+	ids, err := parseStreamIDs(args[2:])
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+
+	acked, err := ops.XAck(ctx, args[0].Bulk, args[1].Bulk, ids)
+	if err != nil {
+		return errReply(err)
+	}
+	return resp.Int(acked)
+}
+
+// xpendingOp implements XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func (h *Handler) xpendingOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return resp.ErrWrongArgs("xpending")
+	}
+	key, group := args[0].Bulk, args[1].Bulk
+
+	// Summary form
+	if len(args) == 2 {
+		summary, err := ops.XPendingSummary(ctx, key, group)
+		if err != nil {
+			return errReply(err)
+		}
+		if summary.Count == 0 {
+			return resp.Arr(resp.Int(0), resp.NullBulk(), resp.NullBulk(), resp.NullArray())
+		}
+		consumers := make([]resp.Value, len(summary.Consumers))
+		for i, c := range summary.Consumers {
+			consumers[i] = resp.Arr(resp.Bulk(c.Name), resp.Bulk(strconv.FormatInt(c.Pending, 10)))
+		}
+		return resp.Arr(
+			resp.Int(summary.Count),
+			resp.Bulk(summary.Lowest.String()),
+			resp.Bulk(summary.Highest.String()),
+			resp.Arr(consumers...),
+		)
+	}
+
+	// Extended form
+	i := 2
+	minIdle := int64(0)
+	if strings.ToUpper(args[i].Bulk) == "IDLE" {
+		if i+1 >= len(args) {
+			return resp.Err("syntax error")
+		}
+		n, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+		if err != nil {
+			return resp.Err("value is not an integer or out of range")
+		}
+		if n > 0 {
+			minIdle = n
+		}
+		i += 2
+	}
+	if len(args)-i != 3 && len(args)-i != 4 {
+		return resp.Err("syntax error")
+	}
+
+	start, err := parseStreamRangeBound(args[i].Bulk, false)
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+	end, err := parseStreamRangeBound(args[i+1].Bulk, true)
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+	count, err := strconv.ParseInt(args[i+2].Bulk, 10, 64)
+	if err != nil {
+		return resp.Err("value is not an integer or out of range")
+	}
+	consumer := ""
+	if len(args)-i == 4 {
+		consumer = args[i+3].Bulk
+	}
+
+	entries, err := ops.XPending(ctx, key, group, start, end, count, consumer, minIdle)
+	if err != nil {
+		return errReply(err)
+	}
+	result := make([]resp.Value, len(entries))
+	for j, p := range entries {
+		result[j] = resp.Arr(resp.Bulk(p.ID.String()), resp.Bulk(p.Consumer), resp.Int(p.Idle), resp.Int(p.DeliveryCount))
+	}
+	return resp.Arr(result...)
+}
+
+// xclaimOp implements XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func (h *Handler) xclaimOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 5 {
+		return resp.ErrWrongArgs("xclaim")
+	}
+
+	minIdle, err := strconv.ParseInt(args[3].Bulk, 10, 64)
+	if err != nil {
+		return resp.Err("Invalid min-idle-time argument for XCLAIM")
+	}
+	if minIdle < 0 {
+		minIdle = 0
+	}
+
+	// IDs come first, options start at the first argument that isn't an ID
+	i := 4
+	var ids []storage.StreamID
+	for ; i < len(args); i++ {
+		id, err := storage.ParseStreamID(args[i].Bulk, 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return resp.ErrCustom("ERR Invalid stream ID specified as stream command argument")
+	}
+
+	var opts storage.StreamClaimOptions
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i].Bulk)
+		switch opt {
+		case "FORCE":
+			opts.Force = true
+		case "JUSTID":
+			opts.JustID = true
+		case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+			if i+1 >= len(args) {
+				return resp.Err("syntax error")
+			}
+			i++
+			if opt == "LASTID" {
+				id, err := storage.ParseStreamID(args[i].Bulk, 0)
+				if err != nil {
+					return resp.ErrCustom(err.Error())
+				}
+				opts.LastID = &id
+				continue
+			}
+			n, err := strconv.ParseInt(args[i].Bulk, 10, 64)
+			if err != nil {
+				return resp.Err(fmt.Sprintf("Invalid %s option argument for XCLAIM", opt))
+			}
+			if n < 0 {
+				n = 0
+			}
+			switch opt {
+			case "IDLE":
+				opts.Idle = &n
+			case "TIME":
+				opts.Time = &n
+			case "RETRYCOUNT":
+				opts.RetryCount = &n
+			}
+		default:
+			return resp.Err(fmt.Sprintf("Unrecognized XCLAIM option '%s'", args[i].Bulk))
+		}
+	}
+
+	claimed, err := ops.XClaim(ctx, args[0].Bulk, args[1].Bulk, args[2].Bulk, minIdle, ids, opts)
+	if err != nil {
+		return errReply(err)
+	}
+	if opts.JustID {
+		claimedIDs := make([]storage.StreamID, len(claimed))
+		for j, entry := range claimed {
+			claimedIDs[j] = entry.ID
+		}
+		return streamIDsReply(claimedIDs)
+	}
+	return streamEntriesReply(claimed)
+}
+
+// xautoclaimOp implements XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func (h *Handler) xautoclaimOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 5 {
+		return resp.ErrWrongArgs("xautoclaim")
+	}
+
+	minIdle, err := strconv.ParseInt(args[3].Bulk, 10, 64)
+	if err != nil {
+		return resp.Err("Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	if minIdle < 0 {
+		minIdle = 0
+	}
+	start, err := parseStreamRangeBound(args[4].Bulk, false)
+	if err != nil {
+		return resp.ErrCustom(err.Error())
+	}
+
+	count := int64(100)
+	justID := false
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return resp.Err("syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return resp.Err("value is not an integer or out of range")
+			}
+			if n < 1 {
+				return resp.Err("COUNT must be > 0")
+			}
+			count = n
+			i++
+		case "JUSTID":
+			justID = true
+		default:
+			return resp.Err("syntax error")
+		}
+	}
+
+	next, claimed, deleted, err := ops.XAutoClaim(ctx, args[0].Bulk, args[1].Bulk, args[2].Bulk, minIdle, start, count, justID)
+	if err != nil {
+		return errReply(err)
+	}
+
+	var claimedReply resp.Value
+	if justID {
+		claimedIDs := make([]storage.StreamID, len(claimed))
+		for i, entry := range claimed {
+			claimedIDs[i] = entry.ID
+		}
+		claimedReply = streamIDsReply(claimedIDs)
+	} else {
+		claimedReply = streamEntriesReply(claimed)
+	}
+	return resp.Arr(resp.Bulk(next.String()), claimedReply, streamIDsReply(deleted))
+}
+
+// xinfoOp implements XINFO STREAM key | XINFO GROUPS key | XINFO CONSUMERS key group
+func (h *Handler) xinfoOp(ctx context.Context, ops storage.Operations, args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.ErrWrongArgs("xinfo")
+	}
+
+	switch strings.ToUpper(args[0].Bulk) {
+	case "STREAM":
+		if len(args) < 2 {
+			return resp.ErrWrongArgs("xinfo|stream")
+		}
+		if len(args) > 2 {
+			if strings.ToUpper(args[2].Bulk) == "FULL" {
+				return resp.Err("XINFO STREAM FULL is not supported")
+			}
+			return resp.Err("syntax error")
+		}
+		info, err := ops.XInfoStream(ctx, args[1].Bulk)
+		if err != nil {
+			return errReply(err)
+		}
+		entryReply := func(entry *storage.StreamEntry) resp.Value {
+			if entry == nil {
+				return resp.NullBulk()
+			}
+			return streamEntryReply(*entry)
+		}
+		return resp.Arr(
+			resp.Bulk("length"), resp.Int(info.Length),
+			resp.Bulk("last-generated-id"), resp.Bulk(info.LastGeneratedID.String()),
+			resp.Bulk("max-deleted-entry-id"), resp.Bulk(info.MaxDeletedEntryID.String()),
+			resp.Bulk("entries-added"), resp.Int(info.EntriesAdded),
+			resp.Bulk("recorded-first-entry-id"), resp.Bulk(info.RecordedFirstEntryID.String()),
+			resp.Bulk("groups"), resp.Int(info.Groups),
+			resp.Bulk("first-entry"), entryReply(info.FirstEntry),
+			resp.Bulk("last-entry"), entryReply(info.LastEntry),
+		)
+
+	case "GROUPS":
+		if len(args) != 2 {
+			return resp.ErrWrongArgs("xinfo|groups")
+		}
+		groups, err := ops.XInfoGroups(ctx, args[1].Bulk)
+		if err != nil {
+			return errReply(err)
+		}
+		result := make([]resp.Value, len(groups))
+		for i, g := range groups {
+			result[i] = resp.Arr(
+				resp.Bulk("name"), resp.Bulk(g.Name),
+				resp.Bulk("consumers"), resp.Int(g.Consumers),
+				resp.Bulk("pending"), resp.Int(g.Pending),
+				resp.Bulk("last-delivered-id"), resp.Bulk(g.LastDeliveredID.String()),
+				resp.Bulk("entries-read"), nullableInt(g.EntriesRead),
+				resp.Bulk("lag"), nullableInt(g.Lag),
+			)
+		}
+		return resp.Arr(result...)
+
+	case "CONSUMERS":
+		if len(args) != 3 {
+			return resp.ErrWrongArgs("xinfo|consumers")
+		}
+		consumers, err := ops.XInfoConsumers(ctx, args[1].Bulk, args[2].Bulk)
+		if err != nil {
+			return errReply(err)
+		}
+		result := make([]resp.Value, len(consumers))
+		for i, c := range consumers {
+			result[i] = resp.Arr(
+				resp.Bulk("name"), resp.Bulk(c.Name),
+				resp.Bulk("pending"), resp.Int(c.Pending),
+				resp.Bulk("idle"), resp.Int(c.Idle),
+				resp.Bulk("inactive"), resp.Int(c.Inactive),
+			)
+		}
+		return resp.Arr(result...)
+
+	default:
+		return resp.Err(fmt.Sprintf("unknown subcommand '%s'. Try XINFO HELP.", args[0].Bulk))
+	}
+}
@@ -81,7 +81,8 @@ type luaExecutor struct {
 // newLuaExecutor creates a new Lua executor
 func newLuaExecutor(ctx context.Context, h *Handler, ops storage.Operations, keys, argv []string) *luaExecutor {
 	return &luaExecutor{
-		ctx:  ctx,
+		// Scripts read from the primary, so they see their own writes
+		ctx:  storage.WithReplicaReads(ctx, false),
 		h:    h,
 		ops:  ops,
 		keys: keys,
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mnorrsken/postkeys/internal/metrics"
+	"github.com/mnorrsken/postkeys/internal/resp"
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// Pipeline collects a run of pipelined commands to run in one round trip to
+// PostgreSQL as a storage.Batch. Only commands that take a single statement
+// can join: GET, HGET, and with the pk_* functions SET without options,
+// INCR, DECR, INCRBY, DECRBY, HSET, LPUSH, RPUSH and SADD.
+type Pipeline struct {
+	h       *Handler
+	ctx     context.Context
+	batcher storage.Batcher
+	batch   *storage.Batch
+	cmds    []resp.Value
+	names   []string
+	replies []resp.Value
+	pushed  []string // list keys to wake BRPOP/BLPOP waiters for
+}
+
+// NewPipeline returns a Pipeline for commands run in ctx, or nil if the
+// store can't run batches
+func (h *Handler) NewPipeline(ctx context.Context) *Pipeline {
+	batcher, ok := h.store.(storage.Batcher)
+	if !ok {
+		return nil
+	}
+	batch := batcher.NewBatch(ctx)
+	if batch == nil {
+		return nil
+	}
+	return &Pipeline{h: h, ctx: ctx, batcher: batcher, batch: batch}
+}
+
+// Len returns the number of commands in the pipeline
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Command returns the pipeline's ith command
+func (p *Pipeline) Command(i int) resp.Value {
+	return p.cmds[i]
+}
+
+// Add adds cmd to the pipeline, unless it can't run in a batch
+func (p *Pipeline) Add(cmd resp.Value) bool {
+	if cmd.Type != resp.Array || len(cmd.Array) == 0 {
+		return false
+	}
+	name := strings.ToUpper(cmd.Array[0].Bulk)
+	i := len(p.replies)
+	p.replies = append(p.replies, resp.Value{})
+	if !p.queue(name, cmd.Array[1:], i) {
+		p.replies = p.replies[:i]
+		return false
+	}
+	p.cmds = append(p.cmds, cmd)
+	p.names = append(p.names, name)
+	return true
+}
+
+// queue queues the command name with args on the batch, to set reply i
+func (p *Pipeline) queue(name string, args []resp.Value, i int) bool {
+	b := p.batch
+	bulk := func(value string, found bool) {
+		if found {
+			p.replies[i] = resp.Bulk(value)
+		} else {
+			p.replies[i] = resp.NullBulk()
+		}
+	}
+	integer := func(n int64) { p.replies[i] = resp.Int(n) }
+
+	switch name {
+	case "GET":
+		if len(args) != 1 {
+			return false
+		}
+		b.Get(args[0].Bulk, bulk)
+		return true
+	case "HGET":
+		if len(args) != 2 {
+			return false
+		}
+		b.HGet(args[0].Bulk, args[1].Bulk, bulk)
+		return true
+	}
+
+	if !b.CanWrite() {
+		return false
+	}
+	switch name {
+	case "SET":
+		if len(args) != 2 {
+			return false
+		}
+		b.Set(args[0].Bulk, args[1].Bulk, func() { p.replies[i] = resp.OK() })
+	case "INCR", "DECR":
+		if len(args) != 1 {
+			return false
+		}
+		delta := int64(1)
+		if name == "DECR" {
+			delta = -1
+		}
+		b.Incr(args[0].Bulk, delta, integer)
+	case "INCRBY", "DECRBY":
+		if len(args) != 2 {
+			return false
+		}
+		delta, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+		if err != nil {
+			return false
+		}
+		if name == "DECRBY" {
+			delta = -delta
+		}
+		b.Incr(args[0].Bulk, delta, integer)
+	case "HSET":
+		if len(args) < 3 || (len(args)-1)%2 != 0 {
+			return false
+		}
+		fields := make(map[string]string)
+		for j := 1; j < len(args); j += 2 {
+			fields[args[j].Bulk] = args[j+1].Bulk
+		}
+		b.HSet(args[0].Bulk, fields, integer)
+	case "LPUSH", "RPUSH":
+		if len(args) < 2 {
+			return false
+		}
+		key := args[0].Bulk
+		values := make([]string, len(args)-1)
+		for j := 1; j < len(args); j++ {
+			values[j-1] = args[j].Bulk
+		}
+		if name == "LPUSH" {
+			b.LPush(key, values, integer)
+		} else {
+			b.RPush(key, values, integer)
+		}
+		p.pushed = append(p.pushed, key)
+	case "SADD":
+		if len(args) < 2 {
+			return false
+		}
+		members := make([]string, len(args)-1)
+		for j := 1; j < len(args); j++ {
+			members[j-1] = args[j].Bulk
+		}
+		b.SAdd(args[0].Bulk, members, integer)
+	default:
+		return false
+	}
+	return true
+}
+
+// Exec runs the pipeline's commands and returns their replies. If
+// PostgreSQL rejected a statement, none of the commands took effect, and
+// they run again one at a time so each gets its own reply, like a WRONGTYPE
+// error, as if it hadn't been pipelined.
+func (p *Pipeline) Exec() []resp.Value {
+	if len(p.cmds) == 0 {
+		return nil
+	}
+	start := time.Now()
+	err := p.batcher.SendBatch(p.ctx, p.batch)
+	if errors.Is(err, storage.ErrBatchRolledBack) {
+		metrics.PipelineFallbacks.Inc()
+		for i, cmd := range p.cmds {
+			p.replies[i] = p.h.Handle(p.ctx, cmd)
+		}
+		return p.replies
+	}
+	metrics.PipelineBatchSize.Observe(float64(len(p.cmds)))
+
+	// Each command is recorded with an equal share of the round trip
+	duration := time.Since(start) / time.Duration(len(p.cmds))
+	for i, name := range p.names {
+		if err != nil {
+			p.replies[i] = tryAgain(resp.Err(err.Error()), err)
+		}
+		metrics.RecordCommand(name, duration, err != nil)
+	}
+	if err == nil && p.h.listNotifier != nil {
+		for _, key := range p.pushed {
+			p.h.listNotifier.NotifyPush(p.ctx, key)
+		}
+	}
+	return p.replies
+}
@@ -1,5 +1,5 @@
 // Package listnotify provides LISTEN/NOTIFY based notifications for blocking list operations.
-// This allows BRPOP/BLPOP to wait efficiently without polling.
+// This allows BRPOP/BLPOP and XREAD/XREADGROUP BLOCK to wait efficiently without polling.
 package listnotify
 
 import (
@@ -13,15 +13,18 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mnorrsken/postkeys/internal/pubsub"
 )
 
-// Channel name for list push notifications
+// Channel name for list push and stream append notifications.
+// Keys share one namespace, so lists and streams use the same channel.
 const listPushChannel = "postkeys_list_push"
 
 // Notifier manages notifications for blocking list operations
 type Notifier struct {
-	pool    *pgxpool.Pool
-	connStr string
+	pool       *pgxpool.Pool
+	connConfig *pgx.ConnConfig
+	channel    string // PostgreSQL channel, namespaced by SetNamespace
 
 	// Listener connection
 	listenerConn *pgx.Conn
@@ -37,11 +40,12 @@ type Notifier struct {
 }
 
 // New creates a new list notifier
-func New(pool *pgxpool.Pool, connStr string) *Notifier {
+func New(pool *pgxpool.Pool, connConfig *pgx.ConnConfig) *Notifier {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Notifier{
 		pool:        pool,
-		connStr:     connStr,
+		connConfig:  connConfig,
+		channel:     pubsub.ChannelName("", listPushChannel),
 		subscribers: make(map[string][]chan string),
 		ctx:         ctx,
 		cancel:      cancel,
@@ -53,16 +57,22 @@ func (n *Notifier) SetDebug(debug bool) {
 	n.debug = debug
 }
 
+// SetNamespace scopes the notifier's PostgreSQL channel to a tenant. It must
+// be called before Start.
+func (n *Notifier) SetNamespace(namespace string) {
+	n.channel = pubsub.ChannelName(namespace, listPushChannel)
+}
+
 // Start initializes the notifier and starts listening
 func (n *Notifier) Start(ctx context.Context) error {
-	conn, err := pgx.Connect(ctx, n.connStr)
+	conn, err := pgx.ConnectConfig(ctx, n.connConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create listener connection: %w", err)
 	}
 	n.listenerConn = conn
 
 	// Start listening on the list push channel
-	_, err = conn.Exec(ctx, fmt.Sprintf("LISTEN %s", listPushChannel))
+	_, err = conn.Exec(ctx, fmt.Sprintf("LISTEN %s", pgx.Identifier{n.channel}.Sanitize()))
 	if err != nil {
 		conn.Close(ctx)
 		return fmt.Errorf("failed to LISTEN: %w", err)
@@ -85,8 +95,9 @@ func (n *Notifier) Stop() {
 }
 
 // NotifyPush sends a notification that items were pushed to a list key
+// or appended to a stream key
 func (n *Notifier) NotifyPush(ctx context.Context, key string) error {
-	_, err := n.pool.Exec(ctx, "SELECT pg_notify($1, $2)", listPushChannel, key)
+	_, err := n.pool.Exec(ctx, "SELECT pg_notify($1, $2)", n.channel, key)
 	return err
 }
 
@@ -252,14 +263,14 @@ func (n *Notifier) reconnect() bool {
 	ctx, cancel := context.WithTimeout(n.ctx, 10*time.Second)
 	defer cancel()
 
-	conn, err := pgx.Connect(ctx, n.connStr)
+	conn, err := pgx.ConnectConfig(ctx, n.connConfig)
 	if err != nil {
 		log.Printf("List notifier reconnect failed: %v", err)
 		return false
 	}
 
 	// Re-subscribe to the channel
-	_, err = conn.Exec(ctx, fmt.Sprintf("LISTEN %s", listPushChannel))
+	_, err = conn.Exec(ctx, fmt.Sprintf("LISTEN %s", pgx.Identifier{n.channel}.Sanitize()))
 	if err != nil {
 		conn.Close(ctx)
 		log.Printf("List notifier LISTEN failed after reconnect: %v", err)
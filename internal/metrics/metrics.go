@@ -72,6 +72,95 @@ var (
 		},
 	)
 
+	// ExpiredKeys counts the keys deleted by the expiry sweeper
+	ExpiredKeys = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "postkeys_expired_keys_total",
+			Help: "Total number of expired keys deleted by the expiry sweeper",
+		},
+	)
+
+	// ExpirySweepDuration measures the duration of expiry sweeper batches
+	ExpirySweepDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "postkeys_expiry_sweep_duration_seconds",
+			Help:    "Duration of expiry sweeper batches in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms to ~4s
+		},
+	)
+
+	// ExpirySweeperLeader is 1 on the instance currently running the expiry sweeper
+	ExpirySweeperLeader = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "postkeys_expiry_sweeper_leader",
+			Help: "Whether this instance holds the expiry sweeper lock (1) or not (0)",
+		},
+	)
+
+	// ReplicaLag is the replication lag of the read replica in seconds
+	ReplicaLag = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "postkeys_replica_lag_seconds",
+			Help: "Replication lag of the read replica in seconds",
+		},
+	)
+
+	// ReplicaReads is 1 while read-only commands are sent to the replica
+	ReplicaReads = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "postkeys_replica_reads_enabled",
+			Help: "Whether read-only commands are sent to the read replica (1) or the primary (0)",
+		},
+	)
+
+	// TxRetries counts transactions run again after a deadlock, serialization
+	// failure or lock timeout
+	TxRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "postkeys_tx_retries_total",
+			Help: "Total number of transactions retried after a transient PostgreSQL error",
+		},
+		[]string{"scope", "sqlstate"},
+	)
+
+	// TxRetriesExhausted counts transactions that still failed after their
+	// last retry
+	TxRetriesExhausted = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "postkeys_tx_retries_exhausted_total",
+			Help: "Total number of transactions that failed with a transient PostgreSQL error after all retries",
+		},
+		[]string{"scope"},
+	)
+
+	// PipelineBatchSize measures the number of pipelined commands run
+	// together in one round trip to PostgreSQL
+	PipelineBatchSize = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "postkeys_pipeline_batch_size",
+			Help:    "Number of pipelined commands run in one PostgreSQL round trip",
+			Buckets: prometheus.ExponentialBuckets(2, 2, 8), // 2 to 256
+		},
+	)
+
+	// PipelineFallbacks counts batches of pipelined commands rolled back by
+	// an error and run again one command at a time
+	PipelineFallbacks = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "postkeys_pipeline_fallbacks_total",
+			Help: "Total number of pipelined batches rerun one command at a time after an error",
+		},
+	)
+
+	// NotificationsDropped counts keyspace notifications too large for a
+	// NOTIFY payload
+	NotificationsDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "postkeys_notifications_dropped_total",
+			Help: "Total number of keyspace notifications dropped for exceeding the NOTIFY payload limit",
+		},
+	)
+
 	// CacheSkips counts cache skips by reason (smart policy decisions)
 	CacheSkips = promauto.NewCounterVec(
 		prometheus.CounterOpts{
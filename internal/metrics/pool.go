@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pools are the PostgreSQL connection pools whose statistics are exported,
+// by name
+var (
+	poolsMu sync.Mutex
+	pools   = make(map[string]*pgxpool.Pool)
+)
+
+// RegisterPool exports the statistics of pool, labelled with name
+func RegisterPool(name string, pool *pgxpool.Pool) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	pools[name] = pool
+}
+
+// UnregisterPool stops exporting the statistics of pool, unless another pool
+// was registered with name since
+func UnregisterPool(name string, pool *pgxpool.Pool) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	if pools[name] == pool {
+		delete(pools, name)
+	}
+}
+
+// poolMetric is one statistic of pgxpool.Stat
+type poolMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(*pgxpool.Stat) float64
+}
+
+func newPoolMetric(name, help string, valueType prometheus.ValueType, value func(*pgxpool.Stat) float64) poolMetric {
+	return poolMetric{
+		desc:      prometheus.NewDesc("postkeys_pg_pool_"+name, help, []string{"pool"}, nil),
+		valueType: valueType,
+		value:     value,
+	}
+}
+
+var poolMetrics = []poolMetric{
+	newPoolMetric("acquired_conns", "Number of connections currently in use", prometheus.GaugeValue,
+		func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }),
+	newPoolMetric("idle_conns", "Number of idle connections", prometheus.GaugeValue,
+		func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }),
+	newPoolMetric("total_conns", "Number of open connections, including ones being established", prometheus.GaugeValue,
+		func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }),
+	newPoolMetric("max_conns", "Maximum size of the pool", prometheus.GaugeValue,
+		func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) }),
+	newPoolMetric("acquires_total", "Total number of connections acquired from the pool", prometheus.CounterValue,
+		func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) }),
+	newPoolMetric("acquire_duration_seconds_total", "Total time spent acquiring connections in seconds", prometheus.CounterValue,
+		func(s *pgxpool.Stat) float64 { return s.AcquireDuration().Seconds() }),
+	newPoolMetric("waiting_acquires_total", "Total number of acquires that waited for a connection because none was idle", prometheus.CounterValue,
+		func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) }),
+	newPoolMetric("waiting_duration_seconds_total", "Total time acquires spent waiting for a connection in seconds", prometheus.CounterValue,
+		func(s *pgxpool.Stat) float64 { return s.EmptyAcquireWaitTime().Seconds() }),
+	newPoolMetric("canceled_acquires_total", "Total number of acquires canceled by their context", prometheus.CounterValue,
+		func(s *pgxpool.Stat) float64 { return float64(s.CanceledAcquireCount()) }),
+}
+
+// poolCollector reads the statistics of the registered pools at scrape time
+type poolCollector struct{}
+
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range poolMetrics {
+		ch <- m.desc
+	}
+}
+
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	for name, pool := range pools {
+		stat := pool.Stat()
+		for _, m := range poolMetrics {
+			ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, m.value(stat), name)
+		}
+	}
+}
+
+func init() {
+	prometheus.MustRegister(poolCollector{})
+}
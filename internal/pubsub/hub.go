@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,11 +18,20 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mnorrsken/postkeys/internal/resp"
+	"github.com/mnorrsken/postkeys/internal/stringmatch"
 )
 
 // PostgreSQL channel name limit is 63 bytes (NAMEDATALEN-1)
 const maxPgChannelLen = 63
 
+// PostgreSQL NOTIFY payload limit is 8000 bytes, including the terminator
+const maxPgPayloadLen = 7999
+
+// broadcastChannel is the pg channel every message is also sent on, so hubs
+// with pattern subscriptions see messages published on any channel from any pod.
+// Its payload is the Redis channel name prefixed with its length, then the message.
+const broadcastChannel = "postkeys_broadcast"
+
 // wrappedPayloadPrefix is the magic prefix for wrapped payloads (used when channel name is hashed)
 const wrappedPayloadPrefix = "\x1EPKW:"
 
@@ -31,16 +41,48 @@ type wrappedPayload struct {
 	Message string `json:"m"`
 }
 
-// pgChannel converts a Redis channel name to a PostgreSQL-safe channel name.
-// If the channel name is longer than 63 bytes, it's hashed to fit.
-func pgChannel(channel string) string {
-	if len(channel) <= maxPgChannelLen {
-		return channel
+// defaultNamespace is the namespace of the tenant on the default schema
+const defaultNamespace = "public"
+
+// ChannelName returns the PostgreSQL channel for name in namespace. Tenants
+// sharing a database each use their own namespace, so they never receive each
+// other's notifications; the empty namespace is the default tenant's. Names
+// longer than 63 bytes once namespaced are hashed to fit.
+func ChannelName(namespace, name string) string {
+	pgChan, _ := namespacedChannel(namespace, name, false)
+	return pgChan
+}
+
+// pgChannel converts a Redis channel name to a PostgreSQL-safe channel name
+// in namespace, and reports whether it had to be hashed to fit in 63 bytes.
+// The broadcast channel's own name is always hashed, so it can't be
+// subscribed to directly.
+func pgChannel(namespace, channel string) (string, bool) {
+	return namespacedChannel(namespace, channel, channel == broadcastChannel)
+}
+
+// namespacedChannel prefixes name with namespace, hashing the result if it's
+// longer than 63 bytes or hash is set. Every tenant is prefixed, the default
+// one too: namespaces are schema names, which have no colon, so no channel
+// name of one tenant maps to a channel of another, and hashed names, which
+// have no colon either, hash the namespace with the name.
+func namespacedChannel(namespace, name string, hash bool) (string, bool) {
+	name = channelPrefix(namespace) + name
+	if len(name) <= maxPgChannelLen && !hash {
+		return name, false
 	}
 	// Hash long channel names: use prefix + hash for uniqueness
-	hash := sha256.Sum256([]byte(channel))
+	sum := sha256.Sum256([]byte(name))
 	// Use "h_" prefix + 40 chars of hex = 42 chars total, well under 63
-	return "h_" + hex.EncodeToString(hash[:20])
+	return "h_" + hex.EncodeToString(sum[:20]), true
+}
+
+// channelPrefix returns the prefix of the unhashed channels of namespace
+func channelPrefix(namespace string) string {
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return namespace + ":"
 }
 
 // Subscriber represents a client that can receive pub/sub messages
@@ -53,8 +95,11 @@ type Subscriber interface {
 
 // Hub manages pub/sub subscriptions and message routing
 type Hub struct {
-	pool     *pgxpool.Pool
-	connStr  string
+	pool       *pgxpool.Pool
+	connConfig *pgx.ConnConfig
+
+	// namespace scopes the hub's PostgreSQL channels to one tenant
+	namespace string
 
 	mu            sync.RWMutex
 	subscriptions map[string]map[uint64]Subscriber // channel -> subscriberID -> subscriber
@@ -85,11 +130,11 @@ type listenCmd struct {
 }
 
 // NewHub creates a new pub/sub hub
-func NewHub(pool *pgxpool.Pool, connStr string) *Hub {
+func NewHub(pool *pgxpool.Pool, connConfig *pgx.ConnConfig) *Hub {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Hub{
 		pool:          pool,
-		connStr:       connStr,
+		connConfig:    connConfig,
 		subscriptions: make(map[string]map[uint64]Subscriber),
 		subscribers:   make(map[uint64]map[string]bool),
 		patterns:      make(map[string]map[uint64]Subscriber),
@@ -107,10 +152,17 @@ func (h *Hub) SetDebug(debug bool) {
 	h.debug = debug
 }
 
+// SetNamespace scopes the hub's PostgreSQL channels to a tenant, so hubs of
+// different tenants sharing a database never see each other's messages. It
+// must be called before Start.
+func (h *Hub) SetNamespace(namespace string) {
+	h.namespace = namespace
+}
+
 // Start initializes the hub and starts the notification listener
 func (h *Hub) Start(ctx context.Context) error {
 	// Create a dedicated connection for LISTEN/NOTIFY
-	listenerConn, err := pgx.Connect(ctx, h.connStr)
+	listenerConn, err := pgx.ConnectConfig(ctx, h.connConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create listener connection: %w", err)
 	}
@@ -129,6 +181,12 @@ func (h *Hub) Stop() {
 	h.wg.Wait()
 
 	if h.listenerConn != nil {
+		h.listenerMu.Lock()
+		patterns := h.listening[ChannelName(h.namespace, broadcastChannel)]
+		h.listenerMu.Unlock()
+		if patterns {
+			h.unregisterPatterns(context.Background(), h.listenerConn)
+		}
 		h.listenerConn.Close(context.Background())
 	}
 }
@@ -225,6 +283,10 @@ func (h *Hub) PSubscribe(sub Subscriber, patterns ...string) []int {
 	for i, pattern := range patterns {
 		// Initialize pattern's subscriber set if needed
 		if _, exists := h.patterns[pattern]; !exists {
+			if len(h.patterns) == 0 {
+				// First pattern on this pod: start receiving every message
+				h.startListeningBroadcast()
+			}
 			h.patterns[pattern] = make(map[uint64]Subscriber)
 		}
 
@@ -264,6 +326,9 @@ func (h *Hub) PUnsubscribe(sub Subscriber, patterns ...string) []int {
 			delete(subs, subID)
 			if len(subs) == 0 {
 				delete(h.patterns, pattern)
+				if len(h.patterns) == 0 {
+					h.stopListeningBroadcast()
+				}
 			}
 		}
 
@@ -283,30 +348,97 @@ func (h *Hub) PUnsubscribe(sub Subscriber, patterns ...string) []int {
 // Publish publishes a message to a channel, returns the number of subscribers that received it
 func (h *Hub) Publish(ctx context.Context, channel, message string) (int64, error) {
 	// Use PostgreSQL NOTIFY to broadcast the message
+	notifications, err := EncodeNotify(h.namespace, channel, message)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := h.pool.Exec(ctx, NotifySQL, NotifyArgs(notifications)...); err != nil {
+		return 0, fmt.Errorf("failed to publish: %w", err)
+	}
+
+	// Count subscribers (channel + pattern matches)
+	count := h.countSubscribers(channel)
+	return int64(count), nil
+}
+
+// ErrMessageTooLarge is returned by EncodeNotify for messages that don't fit
+// a NOTIFY payload
+var ErrMessageTooLarge = errors.New("message too large for a PostgreSQL NOTIFY payload")
+
+// Notification is a single pg_notify call
+type Notification struct {
+	Channel string
+	Payload string
+
+	// Patterns marks the copy for pattern subscribers, only sent while a hub
+	// has some
+	Patterns bool
+}
+
+// NotifySQL sends the notifications given by NotifyArgs. The copies for
+// pattern subscribers are skipped unless a hub in the schema registered in
+// kv_pattern_listeners.
+const NotifySQL = `SELECT pg_notify(c, p) FROM unnest($1::text[], $2::text[], $3::bool[]) AS n(c, p, patterns)
+	WHERE NOT patterns OR EXISTS (SELECT 1 FROM kv_pattern_listeners)`
+
+// NotifyArgs returns the arguments of NotifySQL for notifications
+func NotifyArgs(notifications []Notification) []any {
+	channels := make([]string, len(notifications))
+	payloads := make([]string, len(notifications))
+	patterns := make([]bool, len(notifications))
+	for i, n := range notifications {
+		channels[i], payloads[i], patterns[i] = n.Channel, n.Payload, n.Patterns
+	}
+	return []any{channels, payloads, patterns}
+}
+
+// EncodeNotify returns the pg_notify calls that publish message on a Redis
+// channel in namespace: one on the channel itself for SUBSCRIBE, and one on
+// the broadcast channel for PSUBSCRIBE. Messages sent with NOTIFY outside the
+// hub (such as keyspace notifications sent inside a storage transaction) are
+// delivered like PUBLISH. It returns ErrMessageTooLarge if either payload
+// exceeds the NOTIFY limit; the broadcast one also carries the channel name.
+func EncodeNotify(namespace, channel, message string) ([]Notification, error) {
 	// Convert to pg-safe channel name (hash if too long)
-	pgChan := pgChannel(channel)
+	pgChan, hashed := pgChannel(namespace, channel)
 	payload := message
 
 	// If channel name was hashed, we need to include the original channel in the payload
 	// so subscribers can match it. Use JSON encoding since pg_notify doesn't allow null bytes.
-	if pgChan != channel {
+	if hashed {
 		wrapped := wrappedPayload{Channel: channel, Message: message}
 		jsonBytes, err := json.Marshal(wrapped)
 		if err != nil {
-			return 0, fmt.Errorf("failed to encode payload: %w", err)
+			return nil, fmt.Errorf("failed to encode payload: %w", err)
 		}
 		// Prefix with magic marker to identify wrapped payloads
 		payload = wrappedPayloadPrefix + base64.StdEncoding.EncodeToString(jsonBytes)
 	}
 
-	_, err := h.pool.Exec(ctx, "SELECT pg_notify($1, $2)", pgChan, payload)
-	if err != nil {
-		return 0, fmt.Errorf("failed to publish: %w", err)
+	broadcast := strconv.Itoa(len(channel)) + ":" + channel + message
+	if len(payload) > maxPgPayloadLen || len(broadcast) > maxPgPayloadLen {
+		return nil, ErrMessageTooLarge
 	}
+	return []Notification{
+		{Channel: pgChan, Payload: payload},
+		{Channel: ChannelName(namespace, broadcastChannel), Payload: broadcast, Patterns: true},
+	}, nil
+}
 
-	// Count subscribers (channel + pattern matches)
-	count := h.countSubscribers(channel)
-	return int64(count), nil
+// decodeBroadcast splits a broadcast channel payload into the Redis channel
+// and the message
+func decodeBroadcast(payload string) (channel, message string, ok bool) {
+	sep := strings.IndexByte(payload, ':')
+	if sep < 0 {
+		return "", "", false
+	}
+	n, err := strconv.Atoi(payload[:sep])
+	if err != nil || n < 0 || sep+1+n > len(payload) {
+		return "", "", false
+	}
+	rest := payload[sep+1:]
+	return rest[:n], rest[n:], true
 }
 
 // countSubscribers counts how many subscribers would receive a message on this channel
@@ -385,6 +517,9 @@ func (h *Hub) RemoveSubscriber(subID uint64) {
 				delete(subs, subID)
 				if len(subs) == 0 {
 					delete(h.patterns, pattern)
+					if len(h.patterns) == 0 {
+						h.stopListeningBroadcast()
+					}
 				}
 			}
 		}
@@ -395,7 +530,7 @@ func (h *Hub) RemoveSubscriber(subID uint64) {
 
 // startListening queues a LISTEN command for the channel
 func (h *Hub) startListening(channel string) {
-	pgChan := pgChannel(channel)
+	pgChan, hashed := pgChannel(h.namespace, channel)
 
 	h.listenerMu.Lock()
 	if h.listening[pgChan] {
@@ -405,7 +540,7 @@ func (h *Hub) startListening(channel string) {
 	// Mark as listening immediately to avoid duplicate commands
 	h.listening[pgChan] = true
 	// Store mapping from pg channel to redis channel
-	if pgChan != channel {
+	if hashed {
 		h.pgToRedis[pgChan] = channel
 	}
 	h.listenerMu.Unlock()
@@ -421,9 +556,41 @@ func (h *Hub) startListening(channel string) {
 	}
 }
 
+// startListeningBroadcast queues a LISTEN on the broadcast channel, called
+// when the first pattern subscription is added
+func (h *Hub) startListeningBroadcast() {
+	pgChan := ChannelName(h.namespace, broadcastChannel)
+
+	h.listenerMu.Lock()
+	h.listening[pgChan] = true
+	h.listenerMu.Unlock()
+
+	select {
+	case h.listenCmds <- listenCmd{channel: pgChan, listen: true}:
+	default:
+		log.Printf("Warning: LISTEN command queue full for channel %s", pgChan)
+	}
+}
+
+// stopListeningBroadcast queues an UNLISTEN on the broadcast channel, called
+// when the last pattern subscription is removed
+func (h *Hub) stopListeningBroadcast() {
+	pgChan := ChannelName(h.namespace, broadcastChannel)
+
+	h.listenerMu.Lock()
+	delete(h.listening, pgChan)
+	h.listenerMu.Unlock()
+
+	select {
+	case h.listenCmds <- listenCmd{channel: pgChan, listen: false}:
+	default:
+		log.Printf("Warning: UNLISTEN command queue full for channel %s", pgChan)
+	}
+}
+
 // stopListening queues an UNLISTEN command for the channel
 func (h *Hub) stopListening(channel string) {
-	pgChan := pgChannel(channel)
+	pgChan, _ := pgChannel(h.namespace, channel)
 
 	h.listenerMu.Lock()
 	if !h.listening[pgChan] {
@@ -460,6 +627,9 @@ func (h *Hub) processListenCmds() {
 				} else if h.debug {
 					log.Printf("[DEBUG] Started LISTEN on channel: %s", cmd.channel)
 				}
+				if err == nil && cmd.channel == ChannelName(h.namespace, broadcastChannel) {
+					h.registerPatterns(h.ctx, h.listenerConn)
+				}
 			} else {
 				_, err := h.listenerConn.Exec(h.ctx, fmt.Sprintf("UNLISTEN %s", pgxIdentifier(cmd.channel)))
 				if err != nil {
@@ -467,6 +637,9 @@ func (h *Hub) processListenCmds() {
 				} else if h.debug {
 					log.Printf("[DEBUG] Stopped LISTEN on channel: %s", cmd.channel)
 				}
+				if cmd.channel == ChannelName(h.namespace, broadcastChannel) {
+					h.unregisterPatterns(h.ctx, h.listenerConn)
+				}
 			}
 		default:
 			return
@@ -474,6 +647,27 @@ func (h *Hub) processListenCmds() {
 	}
 }
 
+// registerPatterns records in kv_pattern_listeners that the hub listening on
+// conn has pattern subscriptions, so publishers send the broadcast copy of
+// their messages. Rows of sessions that have gone away are cleared first.
+func (h *Hub) registerPatterns(ctx context.Context, conn *pgx.Conn) {
+	_, err := conn.Exec(ctx, `WITH gone AS (
+			DELETE FROM kv_pattern_listeners WHERE pid NOT IN (SELECT pid FROM pg_stat_activity)
+		)
+		INSERT INTO kv_pattern_listeners (pid) VALUES (pg_backend_pid()) ON CONFLICT DO NOTHING`)
+	if err != nil {
+		log.Printf("Failed to register pattern subscriptions: %v", err)
+	}
+}
+
+// unregisterPatterns removes the registration of registerPatterns once the
+// hub's last pattern subscription is gone or the hub stops
+func (h *Hub) unregisterPatterns(ctx context.Context, conn *pgx.Conn) {
+	if _, err := conn.Exec(ctx, "DELETE FROM kv_pattern_listeners WHERE pid = pg_backend_pid()"); err != nil {
+		log.Printf("Failed to unregister pattern subscriptions: %v", err)
+	}
+}
+
 // listenLoop continuously waits for PostgreSQL notifications
 func (h *Hub) listenLoop() {
 	defer h.wg.Done()
@@ -549,13 +743,24 @@ func (h *Hub) listenLoop() {
 			log.Printf("[DEBUG] Received notification on channel %s: %s", notification.Channel, notification.Payload)
 		}
 
+		// Pattern subscribers get every message from the broadcast channel,
+		// exact subscribers from the channel itself
+		if notification.Channel == ChannelName(h.namespace, broadcastChannel) {
+			if channel, message, ok := decodeBroadcast(notification.Payload); ok {
+				h.deliverToPatterns(channel, message)
+			}
+			continue
+		}
+
 		// Determine the original Redis channel name and extract the actual payload
 		pgChan := notification.Channel
 		redisChannel := pgChan
 		payload := notification.Payload
 
-		// Check if this is a hashed channel (starts with "h_")
-		if strings.HasPrefix(pgChan, "h_") {
+		// Check if this is a namespaced channel, or else a hashed one (starts with "h_")
+		if prefix := channelPrefix(h.namespace); strings.HasPrefix(pgChan, prefix) {
+			redisChannel = pgChan[len(prefix):]
+		} else if strings.HasPrefix(pgChan, "h_") {
 			// Look up the original channel name from our mapping
 			h.listenerMu.Lock()
 			if origChannel, ok := h.pgToRedis[pgChan]; ok {
@@ -579,9 +784,6 @@ func (h *Hub) listenLoop() {
 
 		// Deliver to channel subscribers
 		h.deliverToChannel(redisChannel, payload)
-
-		// Deliver to pattern subscribers
-		h.deliverToPatterns(redisChannel, payload)
 	}
 }
 
@@ -596,7 +798,7 @@ func (h *Hub) reconnect() bool {
 	ctx, cancel := context.WithTimeout(h.ctx, 10*time.Second)
 	defer cancel()
 
-	conn, err := pgx.Connect(ctx, h.connStr)
+	conn, err := pgx.ConnectConfig(ctx, h.connConfig)
 	if err != nil {
 		log.Printf("Pub/sub hub reconnect failed: %v", err)
 		return false
@@ -616,6 +818,8 @@ func (h *Hub) reconnect() bool {
 		if err != nil {
 			log.Printf("Failed to re-LISTEN on channel %s after reconnect: %v", ch, err)
 			// Don't fail entirely - continue with other channels
+		} else if ch == ChannelName(h.namespace, broadcastChannel) {
+			h.registerPatterns(ctx, conn)
 		}
 	}
 
@@ -690,40 +894,7 @@ func (h *Hub) deliverToPatterns(channel, payload string) {
 
 // matchPattern checks if a channel matches a Redis-style glob pattern
 func matchPattern(pattern, channel string) bool {
-	return globMatch(pattern, channel)
-}
-
-// globMatch implements Redis-style glob matching
-func globMatch(pattern, str string) bool {
-	px := 0
-	sx := 0
-	pLen := len(pattern)
-	sLen := len(str)
-	starIdx := -1
-	matchIdx := 0
-
-	for sx < sLen {
-		if px < pLen && (pattern[px] == '?' || pattern[px] == str[sx]) {
-			px++
-			sx++
-		} else if px < pLen && pattern[px] == '*' {
-			starIdx = px
-			matchIdx = sx
-			px++
-		} else if starIdx != -1 {
-			px = starIdx + 1
-			matchIdx++
-			sx = matchIdx
-		} else {
-			return false
-		}
-	}
-
-	for px < pLen && pattern[px] == '*' {
-		px++
-	}
-
-	return px == pLen
+	return stringmatch.Match(pattern, channel, false)
 }
 
 // pgxIdentifier quotes a PostgreSQL identifier
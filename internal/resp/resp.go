@@ -68,6 +68,12 @@ func (r *Reader) debugLog(format string, v ...interface{}) {
 	}
 }
 
+// Buffered returns the number of bytes already received but not yet read,
+// which a pipelining client has sent ahead of the current command
+func (r *Reader) Buffered() int {
+	return r.reader.Buffered()
+}
+
 // Read reads a single RESP value
 func (r *Reader) Read() (Value, error) {
 	typeByte, err := r.reader.ReadByte()
@@ -267,6 +267,22 @@ func TestReader_EOF(t *testing.T) {
 	}
 }
 
+func TestReader_Buffered(t *testing.T) {
+	r := NewReader(strings.NewReader("*1\r\n$4\r\nPING\r\n*1\r\n$4\r\nPING\r\n"))
+	if _, err := r.Read(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Buffered() != 14 {
+		t.Errorf("expected the second command to be buffered, got %d bytes", r.Buffered())
+	}
+	if _, err := r.Read(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Buffered() != 0 {
+		t.Errorf("expected nothing buffered, got %d bytes", r.Buffered())
+	}
+}
+
 // ============== Writer Tests ==============
 
 func TestWriter_WriteSimpleString(t *testing.T) {
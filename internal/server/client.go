@@ -9,6 +9,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/mnorrsken/postkeys/internal/handler"
 	"github.com/mnorrsken/postkeys/internal/resp"
 )
 
@@ -27,10 +28,18 @@ type ClientState struct {
 	
 	// Protocol version (2 or 3, defaults to 2 for RESP2)
 	protocolVersion int
+
+	// Logical database selected with SELECT
+	db int
+
+	// primaryReads is set by READWRITE to keep reads off the read replica
+	primaryReads bool
 	
 	// Transaction state
 	inTransaction   bool
 	queuedCommands  []resp.Value
+	txFlagged       bool // a command was rejected while queuing
+	watchedKeys     []handler.WatchedKey
 
 	// Pub/sub state
 	inPubSubMode   bool
@@ -108,6 +117,34 @@ func (c *ClientState) SetLibInfo(libName, libVersion string) {
 	}
 }
 
+// GetDB returns the selected logical database
+func (c *ClientState) GetDB() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.db
+}
+
+// SetDB selects a logical database
+func (c *ClientState) SetDB(db int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.db = db
+}
+
+// PrimaryReads reports whether the connection's reads must go to the primary
+func (c *ClientState) PrimaryReads() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.primaryReads
+}
+
+// SetPrimaryReads sets whether the connection's reads must go to the primary
+func (c *ClientState) SetPrimaryReads(primary bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.primaryReads = primary
+}
+
 // GetInfo returns a formatted info string for CLIENT INFO/LIST
 func (c *ClientState) GetInfo() string {
 	c.mu.RLock()
@@ -115,8 +152,8 @@ func (c *ClientState) GetInfo() string {
 	
 	age := int64(time.Since(c.CreatedAt).Seconds())
 	
-	info := fmt.Sprintf("id=%d addr=%s age=%d name=%s",
-		c.ID, c.Addr, age, c.Name)
+	info := fmt.Sprintf("id=%d addr=%s age=%d name=%s db=%d",
+		c.ID, c.Addr, age, c.Name, c.db)
 	
 	if c.LibName != "" {
 		info += fmt.Sprintf(" lib-name=%s", c.LibName)
@@ -144,6 +181,7 @@ func (c *ClientState) StartTransaction() error {
 	}
 	c.inTransaction = true
 	c.queuedCommands = nil
+	c.txFlagged = false
 	return nil
 }
 
@@ -161,6 +199,7 @@ func (c *ClientState) GetQueuedCommands() []resp.Value {
 	cmds := c.queuedCommands
 	c.queuedCommands = nil
 	c.inTransaction = false
+	c.txFlagged = false
 	return cmds
 }
 
@@ -173,6 +212,7 @@ func (c *ClientState) DiscardTransaction() error {
 	}
 	c.inTransaction = false
 	c.queuedCommands = nil
+	c.txFlagged = false
 	return nil
 }
 
@@ -183,6 +223,47 @@ func (c *ClientState) QueueLength() int {
 	return len(c.queuedCommands)
 }
 
+// FlagTransaction marks the transaction as failed, so EXEC aborts it
+func (c *ClientState) FlagTransaction() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.txFlagged = true
+}
+
+// TransactionFlagged returns true if a command was rejected while queuing
+func (c *ClientState) TransactionFlagged() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.txFlagged
+}
+
+// Watch adds a key to the watched keys, keeping the first version seen if it
+// is already watched
+func (c *ClientState) Watch(key handler.WatchedKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.watchedKeys {
+		if w.DB == key.DB && w.Key == key.Key {
+			return
+		}
+	}
+	c.watchedKeys = append(c.watchedKeys, key)
+}
+
+// WatchedKeys returns the keys watched with WATCH
+func (c *ClientState) WatchedKeys() []handler.WatchedKey {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.watchedKeys
+}
+
+// Unwatch forgets all watched keys
+func (c *ClientState) Unwatch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watchedKeys = nil
+}
+
 // SetWriter sets the RESP writer for pub/sub message delivery
 func (c *ClientState) SetWriter(w *resp.Writer) {
 	c.writerMu.Lock()
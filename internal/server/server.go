@@ -16,6 +16,7 @@ import (
 	"github.com/mnorrsken/postkeys/internal/metrics"
 	"github.com/mnorrsken/postkeys/internal/pubsub"
 	"github.com/mnorrsken/postkeys/internal/resp"
+	"github.com/mnorrsken/postkeys/internal/storage"
 )
 
 // Server represents a Redis-compatible server
@@ -154,6 +155,11 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 	// Track authentication state for this connection
 	authenticated := !s.handler.RequiresAuth()
 
+	// pending is a command read while collecting a pipeline that couldn't
+	// join it, and readErr the error that ended one
+	var pending *resp.Value
+	var readErr error
+
 	for {
 		select {
 		case <-s.quit:
@@ -164,7 +170,16 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 		}
 
 		// Read command
-		cmd, err := reader.Read()
+		var cmd resp.Value
+		err := readErr
+		if pending != nil {
+			cmd, pending = *pending, nil
+		} else if err == nil {
+			cmd, err = reader.Read()
+			if err == nil {
+				s.traceCommand(conn, cmd)
+			}
+		}
 		if err != nil {
 			if err == io.EOF {
 				return
@@ -177,16 +192,43 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 			return
 		}
 
-		// Trace incoming command
-		s.traceCommand(conn, cmd)
-
 		// Check authentication before processing commands
 		var response resp.Value
 		var multiResponse []resp.Value
 		
-		// Add protocol version to context for handlers
-		cmdCtx := handler.WithProtocolVersion(ctx, client.GetProtocolVersion())
-		
+		// Add protocol version, selected database and read mode to context for handlers
+		cmdCtx := storage.WithDB(handler.WithProtocolVersion(ctx, client.GetProtocolVersion()), client.GetDB())
+		cmdCtx = storage.WithReplicaReads(cmdCtx, !client.PrimaryReads())
+
+		// Run the commands a pipelining client sent together in one round
+		// trip to PostgreSQL, outside MULTI and pub/sub mode, whose commands
+		// depend on the connection state
+		if authenticated && reader.Buffered() > 0 && !client.InTransaction() && !client.InPubSubMode() {
+			if pipe := s.handler.NewPipeline(cmdCtx); pipe != nil && pipe.Add(cmd) {
+				pending, readErr = s.collectPipeline(conn, reader, pipe)
+				for i, r := range pipe.Exec() {
+					s.traceResponse(conn, pipe.Command(i), r)
+					if err := writer.WriteValue(r); err != nil {
+						if s.debug {
+							log.Printf("[DEBUG] Write error to %s: %v", conn.RemoteAddr(), err)
+						} else {
+							log.Printf("Write error: %v", err)
+						}
+						return
+					}
+				}
+				if err := writer.Flush(); err != nil {
+					if s.debug {
+						log.Printf("[DEBUG] Flush error to %s: %v", conn.RemoteAddr(), err)
+					} else {
+						log.Printf("Flush error: %v", err)
+					}
+					return
+				}
+				continue
+			}
+		}
+
 		if cmd.Type == resp.Array && len(cmd.Array) > 0 {
 			cmdName := strings.ToUpper(cmd.Array[0].Bulk)
 
@@ -203,7 +245,8 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 				protoVersion := s.handler.GetHelloProtocolVersion(cmd)
 				client.SetProtocolVersion(protoVersion)
 				// Update cmdCtx with the new protocol version
-				cmdCtx = handler.WithProtocolVersion(ctx, protoVersion)
+				cmdCtx = storage.WithDB(handler.WithProtocolVersion(ctx, protoVersion), client.GetDB())
+				cmdCtx = storage.WithReplicaReads(cmdCtx, !client.PrimaryReads())
 				response = s.handler.Handle(cmdCtx, cmd)
 				// If HELLO included AUTH and it succeeded, mark as authenticated
 				if hasAuth && authSuccess && response.Type != resp.Error {
@@ -285,13 +328,24 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 			} else if cmdName == "DISCARD" {
 				// Discard the transaction
 				response = s.handler.HandleDiscard(client)
+			} else if cmdName == "WATCH" {
+				// Watch keys for the next EXEC (rejected inside MULTI)
+				response = s.handler.HandleWatch(cmdCtx, cmd, client)
 			} else if client.InTransaction() {
 				// Queue commands if in transaction mode (except MULTI, EXEC, DISCARD which are handled above)
-				client.QueueCommand(cmd)
-				response = resp.Value{Type: resp.SimpleString, Str: "QUEUED"}
+				response = s.handler.HandleQueue(cmd, client)
 			} else if cmdName == "CLIENT" {
 				// Handle CLIENT commands with client state
 				response = s.handler.HandleClient(cmd, client)
+			} else if cmdName == "SELECT" {
+				// Switch the connection's logical database
+				response = s.handler.HandleSelect(cmd, client)
+			} else if cmdName == "READONLY" || cmdName == "READWRITE" {
+				// Choose whether reads may be served by the read replica
+				response = s.handler.HandleReadMode(cmd, client)
+			} else if cmdName == "UNWATCH" {
+				// Forget the keys watched by this connection
+				response = s.handler.HandleUnwatch(client)
 			} else {
 				response = s.handler.Handle(cmdCtx, cmd)
 			}
@@ -350,6 +404,27 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 	}
 }
 
+// maxPipelineBatch is the most pipelined commands run in one round trip
+const maxPipelineBatch = 256
+
+// collectPipeline adds the commands already buffered by reader to pipe,
+// until one can't join it, which it returns, or it reaches
+// maxPipelineBatch. A read error ends the pipeline and is returned for
+// after its replies are written.
+func (s *Server) collectPipeline(conn net.Conn, reader *resp.Reader, pipe *handler.Pipeline) (*resp.Value, error) {
+	for pipe.Len() < maxPipelineBatch && reader.Buffered() > 0 {
+		cmd, err := reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		s.traceCommand(conn, cmd)
+		if !pipe.Add(cmd) {
+			return &cmd, nil
+		}
+	}
+	return nil, nil
+}
+
 // extractBulkStrings extracts bulk strings from a slice of RESP values
 func extractBulkStrings(values []resp.Value) []string {
 	result := make([]string, len(values))
@@ -446,7 +521,8 @@ func getCommandLevel(cmdName string) int {
 		"EXPIRE", "TTL", "PTTL", "EXPIREAT", "PEXPIRE", "PEXPIREAT", "PERSIST",
 		"EXISTS", "DEL", "TYPE", "KEYS", "SCAN", "HSCAN", "SSCAN", "ZSCAN",
 		"PING", "ECHO", "TIME", "DBSIZE",
-		"PFADD", "PFCOUNT", "PFMERGE":
+		"PFADD", "PFCOUNT", "PFMERGE",
+		"XADD", "XLEN", "XRANGE", "XREAD", "XREADGROUP", "XACK":
 		return 3
 
 	// Level 2: Everything else (moderate frequency)
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// defaultApplicationName identifies postkeys' connections in
+// pg_stat_activity unless the DSN or Config names them
+const defaultApplicationName = "postkeys"
+
+// withParam adds the setting key=value to the connection string dsn, in
+// either URL or keyword/value form; an empty value leaves dsn unchanged
+func withParam(dsn, key, value string) string {
+	if value == "" {
+		return dsn
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if u, err := url.Parse(dsn); err == nil {
+			q := u.Query()
+			q.Set(key, value)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " " + key + "=" + value
+}
+
+// poolConfig parses connStr into a pool configuration with cfg's pool sizing
+// and connection settings, recording failed statements for StatementError
+func (cfg Config) poolConfig(connStr string) (*pgxpool.Config, error) {
+	poolCfg, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.applyConnSettings(poolCfg.ConnConfig); err != nil {
+		return nil, err
+	}
+	poolCfg.ConnConfig.Tracer = statementTracer{}
+	if cfg.MaxConns > 0 {
+		poolCfg.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolCfg.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolCfg.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolCfg.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	return poolCfg, nil
+}
+
+// sessionConfig returns the configuration for cfg's connections that need a
+// session of their own, with the same connection settings as the pools
+func (cfg Config) sessionConfig() (*pgx.ConnConfig, error) {
+	connCfg, err := pgx.ParseConfig(cfg.sessionConnString())
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.applyConnSettings(connCfg); err != nil {
+		return nil, err
+	}
+	return connCfg, nil
+}
+
+// applyConnSettings applies cfg's connection settings to connCfg. Timeouts
+// and the application name are sent as startup parameters; TCP keepalives,
+// from Config or the libpq keepalives settings in the DSN, go to the dialer.
+func (cfg Config) applyConnSettings(connCfg *pgx.ConnConfig) error {
+	params := connCfg.RuntimeParams
+	if cfg.ConnectTimeout > 0 {
+		connCfg.ConnectTimeout = cfg.ConnectTimeout
+	}
+	if cfg.StatementTimeout > 0 {
+		params["statement_timeout"] = strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
+	}
+	if cfg.LockTimeout > 0 {
+		params["lock_timeout"] = strconv.FormatInt(cfg.LockTimeout.Milliseconds(), 10)
+	}
+	if cfg.ApplicationName != "" {
+		params["application_name"] = cfg.ApplicationName
+	} else if _, ok := params["application_name"]; !ok {
+		params["application_name"] = defaultApplicationName
+	}
+
+	keepAlive, set, err := keepAliveConfig(params)
+	if err != nil {
+		return err
+	}
+	if cfg.TCPKeepAlive > 0 {
+		keepAlive.Enable = true
+		keepAlive.Idle = cfg.TCPKeepAlive
+		set = true
+	}
+	if set {
+		dialer := &net.Dialer{Timeout: connCfg.ConnectTimeout, KeepAliveConfig: keepAlive}
+		if !keepAlive.Enable {
+			dialer.KeepAlive = -1
+		}
+		connCfg.DialFunc = dialer.DialContext
+	}
+	return nil
+}
+
+// keepAliveConfig removes the libpq keepalives, keepalives_idle,
+// keepalives_interval and keepalives_count settings, which pgx would send to
+// the server, from params, and returns them as a dialer configuration. set
+// is false if params had none of them.
+func keepAliveConfig(params map[string]string) (cfg net.KeepAliveConfig, set bool, err error) {
+	cfg.Enable = true
+	for _, name := range []string{"keepalives", "keepalives_idle", "keepalives_interval", "keepalives_count"} {
+		value, ok := params[name]
+		if !ok {
+			continue
+		}
+		delete(params, name)
+		set = true
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return cfg, false, fmt.Errorf("invalid %s %q", name, value)
+		}
+		switch name {
+		case "keepalives":
+			cfg.Enable = n != 0
+		case "keepalives_idle":
+			cfg.Idle = time.Duration(n) * time.Second
+		case "keepalives_interval":
+			cfg.Interval = time.Duration(n) * time.Second
+		case "keepalives_count":
+			cfg.Count = n
+		}
+	}
+	return cfg, set, nil
+}
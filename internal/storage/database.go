@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+)
+
+type dbContextKey struct{}
+
+// WithDB returns a context selecting the logical database (SELECT index) that
+// storage operations run against
+func WithDB(ctx context.Context, db int) context.Context {
+	return context.WithValue(ctx, dbContextKey{}, db)
+}
+
+// DBFromContext returns the logical database selected in ctx (defaults to 0)
+func DBFromContext(ctx context.Context) int {
+	if db, ok := ctx.Value(dbContextKey{}).(int); ok {
+		return db
+	}
+	return 0
+}
+
+// ops returns the query operations scoped to the database selected in ctx
+func (s *Store) ops(ctx context.Context) queryOps {
+	return queryOps{db: DBFromContext(ctx), events: s.events}
+}
+
+// ops returns the query operations scoped to the database selected in ctx
+func (t *TxStore) ops(ctx context.Context) queryOps {
+	return queryOps{db: DBFromContext(ctx), events: t.events}
+}
+
+// move moves key to database db unless it already exists there
+func (o queryOps) move(ctx context.Context, q Querier, key string, db int) (bool, error) {
+	keyType, err := o.getKeyType(ctx, q, key)
+	if err != nil || keyType == TypeNone {
+		return false, err
+	}
+	dst := queryOps{db: db, events: o.events}
+	dstType, err := dst.getKeyType(ctx, q, key)
+	if err != nil || dstType != TypeNone {
+		return false, err
+	}
+
+	// Clear an expired key that would collide with the moved one; the data
+	// follows the key's row
+	if err := dst.deleteKeys(ctx, q, []string{key}); err != nil {
+		return false, err
+	}
+	if _, err := q.Exec(ctx, "UPDATE kv_keys SET db = $3 WHERE db = $2 AND key = $1", key, o.db, db); err != nil {
+		return false, err
+	}
+	o.notify(ctx, q, notifyGeneric, "move_from", key)
+	dst.notify(ctx, q, notifyGeneric, "move_to", key)
+	return true, nil
+}
+
+// swapDB exchanges the contents of two databases. Keys pass through a
+// temporary database number so (db, key) never collides. It must run in a
+// transaction: kv_keys is locked against writes for the rest of it, so no
+// key is created in db1 or db2 between the steps and no other SWAPDB
+// interleaves.
+func (o queryOps) swapDB(ctx context.Context, q Querier, db1, db2 int) error {
+	if _, err := q.Exec(ctx, "LOCK TABLE kv_keys IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+	const tmp = -1
+	for _, step := range [][2]int{{db1, tmp}, {db2, db1}, {tmp, db2}} {
+		if _, err := q.Exec(ctx, "UPDATE kv_keys SET db = $2 WHERE db = $1", step[0], step[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushDB deletes every key of the selected database
+func (o queryOps) flushDB(ctx context.Context, q Querier) error {
+	_, err := q.Exec(ctx, "DELETE FROM kv_keys WHERE db = $1", o.db)
+	return err
+}
+
+// keyspace returns the number of live keys, and of those with a TTL, in every non-empty database
+func (o queryOps) keyspace(ctx context.Context, q Querier) ([]KeyspaceInfo, error) {
+	rows, err := q.Query(ctx,
+		`SELECT db, COUNT(*), COUNT(expires_at) FROM kv_keys
+		 WHERE expires_at IS NULL OR expires_at > NOW()
+		 GROUP BY db ORDER BY db`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dbs []KeyspaceInfo
+	for rows.Next() {
+		var info KeyspaceInfo
+		if err := rows.Scan(&info.DB, &info.Keys, &info.Expires); err != nil {
+			return nil, err
+		}
+		dbs = append(dbs, info)
+	}
+	return dbs, rows.Err()
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/mnorrsken/postkeys/internal/stringmatch"
+)
+
+// Keys matching Config.VolatileKeyPatterns are written with asynchronous
+// commit: their transactions run on a second pool whose connections have
+// synchronous_commit off, so PostgreSQL acknowledges a commit before its WAL
+// reaches disk. A crash may lose the last few hundred milliseconds of such
+// writes, but never corrupts or reorders them, and they are visible to other
+// connections as soon as they commit. Both tiers share the same tables, so
+// reads, TYPE, RENAME and COPY work across them unchanged.
+//
+// The Store can't tell which keys an operation will touch, so callers declare
+// a command's keys with WithKeys. A transaction is volatile only if every key
+// it declares is; operations with no declared keys stay durable.
+
+type keysContextKey struct{}
+
+// WithKeys returns a context declaring the keys the storage operations run
+// with it access, so the Store can apply their durability class
+func WithKeys(ctx context.Context, keys []string) context.Context {
+	return context.WithValue(ctx, keysContextKey{}, keys)
+}
+
+// keysFromContext returns the keys declared in ctx, and false if none were
+func keysFromContext(ctx context.Context) ([]string, bool) {
+	keys, ok := ctx.Value(keysContextKey{}).([]string)
+	return keys, ok && len(keys) > 0
+}
+
+// volatile reports whether every key declared in ctx matches a volatile
+// key pattern
+func (s *Store) volatile(ctx context.Context) bool {
+	if s.volatilePool == nil {
+		return false
+	}
+	keys, ok := keysFromContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, key := range keys {
+		if !matchesAny(s.volatilePatterns, key) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAny reports whether key matches any of the glob patterns
+func matchesAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if stringmatch.Match(pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
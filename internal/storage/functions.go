@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// The busiest write commands each take a round trip per query, plus BEGIN
+// and COMMIT. Migration 0002_functions installs PL/pgSQL versions of them
+// (pk_set, pk_incr, pk_hset, pk_lpush, pk_rpush and pk_sadd), which Store
+// calls as a single statement outside a transaction. Keyspace notifications,
+// when enabled, still follow as a statement of their own.
+//
+// Inside MULTI the queries run one by one as before: an exception raised by
+// a function would abort the whole transaction, where the command's error
+// reply should leave the rest of the batch running.
+
+// funcError returns the error reply raised by a pk_* function, like
+// WRONGTYPE, as the error the queries in querier.go return for it
+func funcError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "P0001" { // raise_exception
+		return errors.New(pgErr.Message)
+	}
+	return err
+}
+
+func (o queryOps) setFunc(ctx context.Context, q Querier, key, value string, ttl time.Duration) error {
+	var expiresAt *time.Time
+	if ttl > 0 {
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+	}
+
+	_, err := q.Exec(ctx, "SELECT pk_set($1, $2, $3, $4)", o.db, key, []byte(value), expiresAt)
+	if err != nil {
+		return funcError(err)
+	}
+	o.notify(ctx, q, notifyString, "set", key)
+	if expiresAt != nil {
+		o.notify(ctx, q, notifyGeneric, "expire", key)
+	}
+	return nil
+}
+
+func (o queryOps) incrFunc(ctx context.Context, q Querier, key string, delta int64) (int64, error) {
+	var result int64
+	if err := q.QueryRow(ctx, "SELECT pk_incr($1, $2, $3)", o.db, key, delta).Scan(&result); err != nil {
+		return 0, funcError(err)
+	}
+	o.notify(ctx, q, notifyString, "incrby", key)
+	return result, nil
+}
+
+func (o queryOps) hSetFunc(ctx context.Context, q Querier, key string, fields map[string]string) (int64, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+
+	fieldNames := make([]string, 0, len(fields))
+	fieldValues := make([][]byte, 0, len(fields))
+	for field, value := range fields {
+		fieldNames = append(fieldNames, encodeField(field))
+		fieldValues = append(fieldValues, []byte(value))
+	}
+
+	var added int64
+	err := q.QueryRow(ctx,
+		"SELECT pk_hset($1, $2, $3::text[], $4::bytea[])",
+		o.db, key, fieldNames, fieldValues,
+	).Scan(&added)
+	if err != nil {
+		return 0, funcError(err)
+	}
+	o.notify(ctx, q, notifyHash, "hset", key)
+	return added, nil
+}
+
+// pushFunc calls pk_lpush or pk_rpush, for event "lpush" or "rpush"
+func (o queryOps) pushFunc(ctx context.Context, q Querier, event, key string, values []string) (int64, error) {
+	valueBytes := make([][]byte, len(values))
+	for i, value := range values {
+		valueBytes[i] = []byte(value)
+	}
+
+	var length int64
+	err := q.QueryRow(ctx, "SELECT pk_"+event+"($1, $2, $3::bytea[])", o.db, key, valueBytes).Scan(&length)
+	if err != nil {
+		return 0, funcError(err)
+	}
+	if len(values) > 0 {
+		o.notify(ctx, q, notifyList, event, key)
+	}
+	return length, nil
+}
+
+func (o queryOps) sAddFunc(ctx context.Context, q Querier, key string, members []string) (int64, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+
+	memberBytes := make([][]byte, len(members))
+	for i, m := range members {
+		memberBytes[i] = []byte(m)
+	}
+
+	var added int64
+	if err := q.QueryRow(ctx, "SELECT pk_sadd($1, $2, $3::bytea[])", o.db, key, memberBytes).Scan(&added); err != nil {
+		return 0, funcError(err)
+	}
+	if added > 0 {
+		o.notify(ctx, q, notifySet, "sadd", key)
+	}
+	return added, nil
+}
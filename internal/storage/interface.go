@@ -14,15 +14,32 @@ const (
 	TypeList   KeyType = "list"
 	TypeSet    KeyType = "set"
 	TypeZSet   KeyType = "zset"
+	TypeStream KeyType = "stream"
+	TypeJSON   KeyType = "ReJSON-RL" // the type name Redis reports for RedisJSON keys
 	TypeNone   KeyType = "none"
 )
 
+// HashField represents a hash field with its value
+type HashField struct {
+	Field string
+	Value string
+}
+
 // ZMember represents a sorted set member with its score
 type ZMember struct {
 	Member string
 	Score  float64
 }
 
+// JSONMatch describes a value matched by a JSON path. Value is only filled in
+// by commands returning values, Keys only by JSON.OBJKEYS.
+type JSONMatch struct {
+	Type  string   // object, array, string, integer, number, boolean or null
+	Value string   // compact JSON encoding
+	Len   int64    // array length, string length or number of object keys
+	Keys  []string // object keys
+}
+
 // BitFieldOp represents a BITFIELD operation (GET, SET, INCRBY)
 type BitFieldOp struct {
 	OpType   string // "GET", "SET", "INCRBY"
@@ -31,7 +48,136 @@ type BitFieldOp struct {
 	Value    int64  // for SET and INCRBY
 }
 
+// StreamID identifies a stream entry as <ms>-<seq>
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// StreamEntry represents a single stream entry
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string // alternating field/value pairs, in insertion order; nil if the entry was deleted
+}
+
+// StreamReadResult holds the entries read from one stream by XREADGROUP
+type StreamReadResult struct {
+	Key     string
+	Entries []StreamEntry
+}
+
+// StreamClaimOptions holds the optional XCLAIM arguments
+type StreamClaimOptions struct {
+	Idle       *int64    // IDLE: set the idle time (ms) of claimed entries
+	Time       *int64    // TIME: set the last delivery time (unix ms) of claimed entries
+	RetryCount *int64    // RETRYCOUNT: set the delivery counter instead of incrementing it
+	Force      bool      // FORCE: create pending entries for IDs not yet in the PEL
+	JustID     bool      // JUSTID: return IDs only and don't increment the delivery counter
+	LastID     *StreamID // LASTID: advance the group's last delivered ID
+}
+
+// StreamPendingEntry is a single entry of a consumer group's pending entries list
+type StreamPendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	Idle          int64 // milliseconds since the last delivery
+	DeliveryCount int64
+}
+
+// StreamPendingSummary is the summary form of XPENDING
+type StreamPendingSummary struct {
+	Count     int64
+	Lowest    StreamID
+	Highest   StreamID
+	Consumers []StreamConsumerPending
+}
+
+// StreamConsumerPending is the number of pending entries owned by a consumer
+type StreamConsumerPending struct {
+	Name    string
+	Pending int64
+}
+
+// StreamInfo holds the XINFO STREAM fields
+type StreamInfo struct {
+	Length               int64
+	LastGeneratedID      StreamID
+	MaxDeletedEntryID    StreamID
+	EntriesAdded         int64
+	RecordedFirstEntryID StreamID
+	Groups               int64
+	FirstEntry           *StreamEntry
+	LastEntry            *StreamEntry
+}
+
+// StreamGroupInfo holds the XINFO GROUPS fields of one consumer group
+type StreamGroupInfo struct {
+	Name            string
+	Consumers       int64
+	Pending         int64
+	LastDeliveredID StreamID
+	EntriesRead     *int64 // nil when it can't be determined
+	Lag             *int64 // nil when it can't be determined
+}
+
+// StreamConsumerInfo holds the XINFO CONSUMERS fields of one consumer
+type StreamConsumerInfo struct {
+	Name     string
+	Pending  int64
+	Idle     int64 // milliseconds since the last attempted interaction
+	Inactive int64 // milliseconds since the last successful interaction, -1 if never
+}
+
+// StreamTrim describes an XADD/XTRIM trimming strategy
+type StreamTrim struct {
+	Strategy string   // "MAXLEN", "MINID" or "" for no trimming
+	Approx   bool     // "~" modifier: only trim whole nodes of entries
+	MaxLen   int64    // threshold for MAXLEN
+	MinID    StreamID // threshold for MINID
+	Limit    int64    // maximum number of entries to evict with "~" (0 = default)
+}
+
+// FTField is an attribute of a search index schema
+type FTField struct {
+	Name      string `json:"name"`                // hash field
+	Alias     string `json:"alias,omitempty"`     // attribute name used in queries, defaults to Name
+	Type      string `json:"type"`                // "TEXT", "NUMERIC" or "TAG"
+	Separator string `json:"separator,omitempty"` // TAG separator, defaults to ","
+	Sortable  bool   `json:"sortable,omitempty"`
+}
+
+// FTIndex is a search index over hashes whose keys start with one of Prefixes
+type FTIndex struct {
+	Name     string
+	Prefixes []string
+	Fields   []FTField
+}
+
+// FTSearchOptions holds the FT.SEARCH modifiers
+type FTSearchOptions struct {
+	SortBy    string // attribute to sort by, "" for key order
+	SortDesc  bool
+	Offset    int64
+	Limit     int64
+	NoContent bool
+	Return    []string // attributes to return, nil for all fields
+}
+
+// FTDocument is a hash matched by FT.SEARCH
+type FTDocument struct {
+	Key    string
+	Fields []string // alternating field/value pairs
+}
+
+// KeyspaceInfo holds the INFO keyspace statistics of one non-empty database
+type KeyspaceInfo struct {
+	DB      int
+	Keys    int64
+	Expires int64
+}
+
 // Operations defines the common storage operations available in both regular and transaction contexts
+
 type Operations interface {
 	// String commands
 	Get(ctx context.Context, key string) (string, bool, error)
@@ -59,9 +205,11 @@ type Operations interface {
 	PTTL(ctx context.Context, key string) (int64, error)
 	Persist(ctx context.Context, key string) (bool, error)
 	Keys(ctx context.Context, pattern string) ([]string, error)
+	Scan(ctx context.Context, cursor uint64, pattern string, count int64, keyType KeyType) (uint64, []string, error)
 	Type(ctx context.Context, key string) (KeyType, error)
 	Rename(ctx context.Context, oldKey, newKey string) error
 	Copy(ctx context.Context, source, destination string, replace bool) (bool, error)
+	Move(ctx context.Context, key string, db int) (bool, error)
 
 	// Bitmap commands
 	SetBit(ctx context.Context, key string, offset int64, value int) (int64, error)
@@ -75,6 +223,7 @@ type Operations interface {
 	HSet(ctx context.Context, key string, fields map[string]string) (int64, error)
 	HDel(ctx context.Context, key string, fields []string) (int64, error)
 	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	HScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []HashField, error)
 	HMGet(ctx context.Context, key string, fields []string) ([]interface{}, error)
 	HExists(ctx context.Context, key, field string) (bool, error)
 	HKeys(ctx context.Context, key string) ([]string, error)
@@ -103,6 +252,7 @@ type Operations interface {
 	SAdd(ctx context.Context, key string, members []string) (int64, error)
 	SRem(ctx context.Context, key string, members []string) (int64, error)
 	SMembers(ctx context.Context, key string) ([]string, error)
+	SScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []string, error)
 	SIsMember(ctx context.Context, key, member string) (bool, error)
 	SCard(ctx context.Context, key string) (int64, error)
 	SMIsMember(ctx context.Context, key string, members []string) ([]bool, error)
@@ -128,7 +278,7 @@ type Operations interface {
 	ZRank(ctx context.Context, key, member string) (int64, bool, error)
 	ZRevRank(ctx context.Context, key, member string) (int64, bool, error)
 	ZCount(ctx context.Context, key string, min, max float64) (int64, error)
-	ZScan(ctx context.Context, key string, cursor int64, pattern string, count int64) (int64, []ZMember, error)
+	ZScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []ZMember, error)
 	ZUnionStore(ctx context.Context, destination string, keys []string, weights []float64, aggregate string) (int64, error)
 	ZInterStore(ctx context.Context, destination string, keys []string, weights []float64, aggregate string) (int64, error)
 
@@ -137,8 +287,51 @@ type Operations interface {
 	PFCount(ctx context.Context, keys []string) (int64, error)
 	PFMerge(ctx context.Context, destKey string, sourceKeys []string) error
 
+	// Stream commands
+	XAdd(ctx context.Context, key, id string, fields []string, noMkStream bool, trim StreamTrim) (string, bool, error)
+	XRange(ctx context.Context, key string, start, end StreamID, count int64) ([]StreamEntry, error)
+	XRevRange(ctx context.Context, key string, end, start StreamID, count int64) ([]StreamEntry, error)
+	XLen(ctx context.Context, key string) (int64, error)
+	XDel(ctx context.Context, key string, ids []StreamID) (int64, error)
+	XTrim(ctx context.Context, key string, trim StreamTrim) (int64, error)
+	XGroupCreate(ctx context.Context, key, group, id string, mkStream bool, entriesRead *int64) error
+	XGroupSetID(ctx context.Context, key, group, id string, entriesRead *int64) error
+	XGroupDestroy(ctx context.Context, key, group string) (bool, error)
+	XGroupCreateConsumer(ctx context.Context, key, group, consumer string) (bool, error)
+	XGroupDelConsumer(ctx context.Context, key, group, consumer string) (int64, error)
+	XReadGroup(ctx context.Context, group, consumer string, keys, ids []string, count int64, noAck bool) ([]StreamReadResult, error)
+	XAck(ctx context.Context, key, group string, ids []StreamID) (int64, error)
+	XPendingSummary(ctx context.Context, key, group string) (StreamPendingSummary, error)
+	XPending(ctx context.Context, key, group string, start, end StreamID, count int64, consumer string, minIdle int64) ([]StreamPendingEntry, error)
+	XClaim(ctx context.Context, key, group, consumer string, minIdle int64, ids []StreamID, opts StreamClaimOptions) ([]StreamEntry, error)
+	XAutoClaim(ctx context.Context, key, group, consumer string, minIdle int64, start StreamID, count int64, justID bool) (StreamID, []StreamEntry, []StreamID, error)
+	XInfoStream(ctx context.Context, key string) (StreamInfo, error)
+	XInfoGroups(ctx context.Context, key string) ([]StreamGroupInfo, error)
+	XInfoConsumers(ctx context.Context, key, group string) ([]StreamConsumerInfo, error)
+
+	// JSON commands
+	JSONSet(ctx context.Context, key, path, value string, nx, xx bool) (bool, error)
+	JSONGet(ctx context.Context, key string, paths []string) ([][]JSONMatch, bool, error)
+	JSONDel(ctx context.Context, key, path string) (int64, error)
+	JSONNumIncrBy(ctx context.Context, key, path, increment string) ([]JSONMatch, bool, error)
+	JSONArrAppend(ctx context.Context, key, path string, values []string) ([]JSONMatch, bool, error)
+	JSONDescribe(ctx context.Context, key, path string, withKeys bool) ([]JSONMatch, bool, error)
+
+	// Search commands
+	FTCreate(ctx context.Context, index FTIndex) error
+	FTSearch(ctx context.Context, name, query string, opts FTSearchOptions) (int64, []FTDocument, error)
+	FTInfo(ctx context.Context, name string) (FTIndex, int64, error)
+	FTDropIndex(ctx context.Context, name string, deleteDocs bool) error
+	FTList(ctx context.Context) ([]string, error)
+
 	// Server commands
 	DBSize(ctx context.Context) (int64, error)
+	SwapDB(ctx context.Context, db1, db2 int) error
+	Keyspace(ctx context.Context) ([]KeyspaceInfo, error)
+
+	// Optimistic locking: the version of each key (0 if missing) for WATCH.
+	// In a transaction the keys are locked until commit.
+	KeyVersions(ctx context.Context, keys []string) ([]int64, error)
 }
 
 // Backend extends Operations with lifecycle and transaction support
@@ -147,6 +340,11 @@ type Backend interface {
 
 	// Server commands (not available in transactions)
 	FlushDB(ctx context.Context) error
+	FlushAll(ctx context.Context) error
+
+	// Keyspace notifications, configured with notify-keyspace-events flags
+	SetNotifyKeyspaceEvents(flags string) error
+	NotifyKeyspaceEvents() string
 
 	// Transaction support
 	BeginTx(ctx context.Context) (Transaction, error)
@@ -162,6 +360,12 @@ type Transaction interface {
 	// Transaction control
 	Commit(ctx context.Context) error
 	Rollback(ctx context.Context) error
+
+	// Savepoints, so a failed command can be undone without aborting the
+	// whole transaction
+	Savepoint(ctx context.Context) error
+	ReleaseSavepoint(ctx context.Context) error
+	RollbackToSavepoint(ctx context.Context) error
 }
 
 // Ensure Store implements Backend
@@ -0,0 +1,487 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errJSONNewAtRoot = fmt.Errorf("ERR new objects must be created at the root")
+
+// jsonNodeSQL is a lateral join exposing n.v, the value at each path of the
+// JSON array of text[] paths in $2, ordered by x.ord
+const jsonNodeSQL = `
+	CROSS JOIN LATERAL jsonb_array_elements($2::jsonb) WITH ORDINALITY AS x(p, ord)
+	CROSS JOIN LATERAL (SELECT j.value #> ARRAY(SELECT jsonb_array_elements_text(x.p)) AS v) n`
+
+// jsonChildrenSQL lists the object keys or array indexes of a value
+const jsonChildrenSQL = `
+	SELECT e.key, e.value FROM jsonb_each(CASE WHEN jsonb_typeof(%[1]s) = 'object' THEN %[1]s ELSE '{}'::jsonb END) e
+	UNION ALL
+	SELECT (a.i - 1)::text, a.e FROM jsonb_array_elements(CASE WHEN jsonb_typeof(%[1]s) = 'array' THEN %[1]s ELSE '[]'::jsonb END) WITH ORDINALITY AS a(e, i)`
+
+// jsonSetExpr returns SQL replacing the value at path $2 with newValue;
+// jsonb_set can't replace the root, so that case is handled separately
+func jsonSetExpr(newValue string) string {
+	return fmt.Sprintf("CASE WHEN coalesce(cardinality($2::text[]), 0) = 0 THEN %[1]s ELSE jsonb_set(value, $2::text[], %[1]s) END", newValue)
+}
+
+// jsonTypeName maps a number to "integer" when it has no fraction or exponent, like RedisJSON
+func jsonTypeName(typ, value string) string {
+	if typ == "number" && !strings.ContainsAny(value, ".eE") {
+		return "integer"
+	}
+	return typ
+}
+
+// compactJSON strips the whitespace PostgreSQL adds when printing jsonb
+func compactJSON(s string) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(s)); err != nil {
+		return s
+	}
+	return buf.String()
+}
+
+// encodeJSONPaths encodes document paths as a JSON array of text arrays for SQL
+func encodeJSONPaths(paths [][]string) string {
+	data, _ := json.Marshal(paths)
+	return string(data)
+}
+
+// jsonPathLess orders document paths element by element, comparing array indexes numerically
+func jsonPathLess(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		ai, aErr := strconv.Atoi(a[i])
+		bi, bErr := strconv.Atoi(b[i])
+		if aErr == nil && bErr == nil {
+			return ai < bi
+		}
+		return a[i] < b[i]
+	}
+	return len(a) < len(b)
+}
+
+// jsonKeyExists reports whether key holds a JSON document, failing with WRONGTYPE for other types
+func (o queryOps) jsonKeyExists(ctx context.Context, q Querier, key string) (bool, error) {
+	keyType, err := o.getKeyType(ctx, q, key)
+	if err != nil {
+		return false, err
+	}
+	if keyType == TypeNone {
+		return false, nil
+	}
+	if keyType != TypeJSON {
+		return false, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return true, nil
+}
+
+// lockJSON locks the document row so paths resolved by a write stay valid until it commits
+func (o queryOps) lockJSON(ctx context.Context, q Querier, key string) error {
+	_, err := q.Exec(ctx, "SELECT 1 FROM kv_json WHERE key_id = postkeys_key_id($2, $1) FOR UPDATE", key, o.db)
+	return err
+}
+
+// jsonChildren returns the paths of the direct children of each path
+func (o queryOps) jsonChildren(ctx context.Context, q Querier, key string, paths [][]string) ([][]string, error) {
+	rows, err := q.Query(ctx,
+		`SELECT x.ord, c.k FROM kv_json j`+jsonNodeSQL+`
+		 CROSS JOIN LATERAL (`+fmt.Sprintf(jsonChildrenSQL, "n.v")+`) c(k, v)
+		 WHERE j.key_id = postkeys_key_id($3, $1)
+		 ORDER BY x.ord`,
+		key, encodeJSONPaths(paths), o.db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var children [][]string
+	for rows.Next() {
+		var ord int64
+		var k string
+		if err := rows.Scan(&ord, &k); err != nil {
+			return nil, err
+		}
+		parent := paths[ord-1]
+		child := make([]string, len(parent), len(parent)+1)
+		copy(child, parent)
+		children = append(children, append(child, k))
+	}
+	return children, rows.Err()
+}
+
+// jsonDescendants returns each path followed by the paths of all values nested below it
+func (o queryOps) jsonDescendants(ctx context.Context, q Querier, key string, paths [][]string) ([][]string, error) {
+	rows, err := q.Query(ctx,
+		`WITH RECURSIVE t(p, v) AS (
+			SELECT ARRAY(SELECT jsonb_array_elements_text(x.p)), n.v FROM kv_json j`+jsonNodeSQL+`
+			WHERE j.key_id = postkeys_key_id($3, $1)
+		  UNION ALL
+			SELECT t.p || c.k, c.v FROM t
+			CROSS JOIN LATERAL (`+fmt.Sprintf(jsonChildrenSQL, "t.v")+`) c(k, v)
+		 )
+		 SELECT p FROM t WHERE v IS NOT NULL`,
+		key, encodeJSONPaths(paths), o.db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result [][]string
+	for rows.Next() {
+		var p []string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(result, func(i, j int) bool { return jsonPathLess(result[i], result[j]) })
+	return result, nil
+}
+
+// jsonMatches resolves a path against the document at key, returning the
+// document path of every matched value along with its type and length.
+// Wildcards and ".." are expanded in SQL, so documents are never loaded into Go.
+func (o queryOps) jsonMatches(ctx context.Context, q Querier, key string, p jsonPath, withValues, withKeys bool) ([][]string, []JSONMatch, error) {
+	paths := [][]string{{}}
+	var err error
+	for _, seg := range p.segs {
+		if seg.recursive {
+			if paths, err = o.jsonDescendants(ctx, q, key, paths); err != nil {
+				return nil, nil, err
+			}
+		}
+		switch seg.kind {
+		case jsonSegKey, jsonSegIndex:
+			elem := seg.key
+			if seg.kind == jsonSegIndex {
+				elem = strconv.Itoa(seg.index)
+			}
+			for i := range paths {
+				paths[i] = append(paths[i][:len(paths[i]):len(paths[i])], elem)
+			}
+		case jsonSegWildcard:
+			if paths, err = o.jsonChildren(ctx, q, key, paths); err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(paths) == 0 {
+			return nil, nil, nil
+		}
+	}
+
+	// Nested ".." segments can reach the same value more than once
+	if !p.definite() {
+		seen := make(map[string]bool, len(paths))
+		unique := paths[:0]
+		for _, path := range paths {
+			id := strings.Join(path, "\x00")
+			if !seen[id] {
+				seen[id] = true
+				unique = append(unique, path)
+			}
+		}
+		paths = unique
+	}
+
+	rows, err := q.Query(ctx,
+		`SELECT x.ord, jsonb_typeof(n.v),
+			CASE jsonb_typeof(n.v)
+				WHEN 'array' THEN jsonb_array_length(n.v)
+				WHEN 'string' THEN length(n.v #>> '{}')
+				WHEN 'object' THEN (SELECT count(*) FROM jsonb_object_keys(n.v))
+				ELSE 0 END,
+			CASE WHEN $3 OR jsonb_typeof(n.v) = 'number' THEN n.v::text END,
+			CASE WHEN $4 AND jsonb_typeof(n.v) = 'object' THEN ARRAY(SELECT jsonb_object_keys(n.v)) END
+		 FROM kv_json j`+jsonNodeSQL+`
+		 WHERE j.key_id = postkeys_key_id($5, $1) AND n.v IS NOT NULL
+		 ORDER BY x.ord`,
+		key, encodeJSONPaths(paths), withValues, withKeys, o.db,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var matchedPaths [][]string
+	var matches []JSONMatch
+	for rows.Next() {
+		var ord int64
+		var m JSONMatch
+		var value *string
+		if err := rows.Scan(&ord, &m.Type, &m.Len, &value, &m.Keys); err != nil {
+			return nil, nil, err
+		}
+		if value != nil {
+			m.Type = jsonTypeName(m.Type, *value)
+			if withValues {
+				m.Value = compactJSON(*value)
+			}
+		}
+		matchedPaths = append(matchedPaths, paths[ord-1])
+		matches = append(matches, m)
+	}
+	return matchedPaths, matches, rows.Err()
+}
+
+// ============== JSON Commands ==============
+
+func (o queryOps) jsonSet(ctx context.Context, q Querier, key, path, value string, nx, xx bool) (bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return false, err
+	}
+	exists, err := o.jsonKeyExists(ctx, q, key)
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		if !p.isRoot() {
+			return false, errJSONNewAtRoot
+		}
+		if xx {
+			return false, nil
+		}
+		id, err := o.newKey(ctx, q, key, TypeJSON, nil)
+		if err != nil {
+			return false, err
+		}
+		if _, err := q.Exec(ctx, "INSERT INTO kv_json (key_id, value) VALUES ($1, $2::jsonb)", id, value); err != nil {
+			return false, err
+		}
+		o.notify(ctx, q, notifyModule, "json.set", key)
+		return true, nil
+	}
+
+	if err := o.lockJSON(ctx, q, key); err != nil {
+		return false, err
+	}
+
+	paths, _, err := o.jsonMatches(ctx, q, key, p, false, false)
+	if err != nil {
+		return false, err
+	}
+	if len(paths) > 0 {
+		if nx {
+			return false, nil
+		}
+		for _, dp := range paths {
+			_, err := q.Exec(ctx,
+				"UPDATE kv_json SET value = "+jsonSetExpr("$3::jsonb")+" WHERE key_id = postkeys_key_id($4, $1)",
+				key, dp, value, o.db,
+			)
+			if err != nil {
+				return false, err
+			}
+		}
+		o.notify(ctx, q, notifyModule, "json.set", key)
+		return true, nil
+	}
+
+	// Nothing matched: add the last key to every matching parent object
+	last := p.segs[len(p.segs)-1]
+	if xx || last.kind != jsonSegKey || last.recursive {
+		return false, nil
+	}
+	parent := jsonPath{raw: p.raw, legacy: p.legacy, segs: p.segs[:len(p.segs)-1]}
+	parents, matches, err := o.jsonMatches(ctx, q, key, parent, false, false)
+	if err != nil {
+		return false, err
+	}
+
+	set := false
+	for i, dp := range parents {
+		if matches[i].Type != "object" {
+			continue
+		}
+		_, err := q.Exec(ctx,
+			"UPDATE kv_json SET value = jsonb_set(value, $2::text[], $3::jsonb, true) WHERE key_id = postkeys_key_id($4, $1)",
+			key, append(dp, last.key), value, o.db,
+		)
+		if err != nil {
+			return false, err
+		}
+		set = true
+	}
+	if set {
+		o.notify(ctx, q, notifyModule, "json.set", key)
+	}
+	return set, nil
+}
+
+func (o queryOps) jsonGet(ctx context.Context, q Querier, key string, paths []string) ([][]JSONMatch, bool, error) {
+	parsed := make([]jsonPath, len(paths))
+	for i, path := range paths {
+		p, err := parseJSONPath(path)
+		if err != nil {
+			return nil, false, err
+		}
+		parsed[i] = p
+	}
+
+	exists, err := o.jsonKeyExists(ctx, q, key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	results := make([][]JSONMatch, len(parsed))
+	for i, p := range parsed {
+		if _, results[i], err = o.jsonMatches(ctx, q, key, p, true, false); err != nil {
+			return nil, false, err
+		}
+	}
+	return results, true, nil
+}
+
+func (o queryOps) jsonDel(ctx context.Context, q Querier, key, path string) (int64, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return 0, err
+	}
+	exists, err := o.jsonKeyExists(ctx, q, key)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	if p.isRoot() {
+		if err := o.deleteKeys(ctx, q, []string{key}); err != nil {
+			return 0, err
+		}
+		o.notify(ctx, q, notifyModule, "json.del", key)
+		return 1, nil
+	}
+
+	if err := o.lockJSON(ctx, q, key); err != nil {
+		return 0, err
+	}
+	paths, _, err := o.jsonMatches(ctx, q, key, p, false, false)
+	if err != nil {
+		return 0, err
+	}
+
+	// Delete later array elements and nested values first so the remaining paths stay valid
+	sort.SliceStable(paths, func(i, j int) bool { return jsonPathLess(paths[j], paths[i]) })
+	for _, dp := range paths {
+		if _, err := q.Exec(ctx, "UPDATE kv_json SET value = value #- $2::text[] WHERE key_id = postkeys_key_id($3, $1)", key, dp, o.db); err != nil {
+			return 0, err
+		}
+	}
+	if len(paths) > 0 {
+		o.notify(ctx, q, notifyModule, "json.del", key)
+	}
+	return int64(len(paths)), nil
+}
+
+func (o queryOps) jsonNumIncrBy(ctx context.Context, q Querier, key, path, increment string) ([]JSONMatch, bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	exists, err := o.jsonKeyExists(ctx, q, key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	if err := o.lockJSON(ctx, q, key); err != nil {
+		return nil, false, err
+	}
+	paths, matches, err := o.jsonMatches(ctx, q, key, p, false, false)
+	if err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	for i, dp := range paths {
+		if matches[i].Type != "integer" && matches[i].Type != "number" {
+			continue
+		}
+		var value string
+		err := q.QueryRow(ctx,
+			"UPDATE kv_json SET value = "+jsonSetExpr("to_jsonb((value #>> $2::text[])::numeric + $3::text::numeric)")+
+				" WHERE key_id = postkeys_key_id($4, $1) RETURNING (value #> $2::text[])::text",
+			key, dp, increment, o.db,
+		).Scan(&value)
+		if err != nil {
+			return nil, false, err
+		}
+		matches[i].Type = jsonTypeName("number", value)
+		matches[i].Value = value
+		changed = true
+	}
+	if changed {
+		o.notify(ctx, q, notifyModule, "json.numincrby", key)
+	}
+	return matches, true, nil
+}
+
+func (o queryOps) jsonArrAppend(ctx context.Context, q Querier, key, path string, values []string) ([]JSONMatch, bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	exists, err := o.jsonKeyExists(ctx, q, key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	if err := o.lockJSON(ctx, q, key); err != nil {
+		return nil, false, err
+	}
+	paths, matches, err := o.jsonMatches(ctx, q, key, p, false, false)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Appending a JSON array concatenates its elements
+	appended := "[" + strings.Join(values, ",") + "]"
+	changed := false
+	for i, dp := range paths {
+		if matches[i].Type != "array" {
+			continue
+		}
+		err := q.QueryRow(ctx,
+			"UPDATE kv_json SET value = "+jsonSetExpr("(value #> $2::text[]) || $3::jsonb")+
+				" WHERE key_id = postkeys_key_id($4, $1) RETURNING jsonb_array_length(value #> $2::text[])",
+			key, dp, appended, o.db,
+		).Scan(&matches[i].Len)
+		if err != nil {
+			return nil, false, err
+		}
+		changed = true
+	}
+	if changed {
+		o.notify(ctx, q, notifyModule, "json.arrappend", key)
+	}
+	return matches, true, nil
+}
+
+func (o queryOps) jsonDescribe(ctx context.Context, q Querier, key, path string, withKeys bool) ([]JSONMatch, bool, error) {
+	p, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	exists, err := o.jsonKeyExists(ctx, q, key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	_, matches, err := o.jsonMatches(ctx, q, key, p, false, withKeys)
+	if err != nil {
+		return nil, false, err
+	}
+	return matches, true, nil
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonSegKind is the kind of a JSON path segment
+type jsonSegKind int
+
+const (
+	jsonSegKey      jsonSegKind = iota // .name or ['name']
+	jsonSegIndex                       // [n], negative counts from the end
+	jsonSegWildcard                    // .* or [*]
+)
+
+// jsonPathSeg is one step of a JSON path
+type jsonPathSeg struct {
+	kind  jsonSegKind
+	key   string
+	index int
+	// recursive is set for segments reached through "..", which match at any depth
+	recursive bool
+}
+
+// jsonPath is a parsed RedisJSON path. Paths starting with "$" are JSONPath and
+// may match many values; anything else is a legacy path matching at most one.
+type jsonPath struct {
+	raw    string
+	legacy bool
+	segs   []jsonPathSeg
+}
+
+// isRoot reports whether the path selects the whole document
+func (p jsonPath) isRoot() bool {
+	return len(p.segs) == 0
+}
+
+// definite reports whether the path can match at most one value without
+// looking at the document
+func (p jsonPath) definite() bool {
+	for _, seg := range p.segs {
+		if seg.kind == jsonSegWildcard || seg.recursive {
+			return false
+		}
+	}
+	return true
+}
+
+// IsLegacyJSONPath reports whether path uses the legacy (non "$") syntax
+func IsLegacyJSONPath(path string) bool {
+	return !strings.HasPrefix(path, "$")
+}
+
+func invalidJSONPathError(path string) error {
+	return fmt.Errorf("ERR invalid JSON path '%s'", path)
+}
+
+// parseJSONPath parses a JSONPath ("$.a[0]", "$..b", "$.*") or legacy (".a[0]", "a.b") path.
+// Filter expressions, unions and slices are not supported.
+func parseJSONPath(raw string) (jsonPath, error) {
+	p := jsonPath{raw: raw, legacy: IsLegacyJSONPath(raw)}
+
+	s := raw
+	if p.legacy {
+		// Legacy paths may omit the leading dot: "a.b" is ".a.b"
+		if s != "" && s[0] != '.' && s[0] != '[' {
+			s = "." + s
+		}
+		if s == "." {
+			s = ""
+		}
+	} else {
+		s = s[1:]
+	}
+
+	for len(s) > 0 {
+		recursive := false
+		switch {
+		case strings.HasPrefix(s, ".."):
+			recursive = true
+			s = s[2:]
+			if s == "" {
+				return p, invalidJSONPathError(raw)
+			}
+			if strings.HasPrefix(s, "[") {
+				break
+			}
+			fallthrough
+		case s[0] == '.':
+			if s[0] == '.' {
+				s = s[1:]
+			}
+			if strings.HasPrefix(s, "*") {
+				p.segs = append(p.segs, jsonPathSeg{kind: jsonSegWildcard, recursive: recursive})
+				s = s[1:]
+				continue
+			}
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return p, invalidJSONPathError(raw)
+			}
+			p.segs = append(p.segs, jsonPathSeg{kind: jsonSegKey, key: s[:end], recursive: recursive})
+			s = s[end:]
+			continue
+		case s[0] != '[':
+			return p, invalidJSONPathError(raw)
+		}
+
+		// Bracket segment: [*], [n], ['name'] or ["name"]
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return p, invalidJSONPathError(raw)
+		}
+		inner := strings.TrimSpace(s[1:end])
+		seg := jsonPathSeg{recursive: recursive}
+		switch {
+		case inner == "*":
+			seg.kind = jsonSegWildcard
+		case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"'):
+			// Quoted names may contain ']', so find the matching quote
+			quote := inner[0]
+			closing := strings.IndexByte(s[2:], quote)
+			if closing < 0 {
+				return p, invalidJSONPathError(raw)
+			}
+			seg.kind = jsonSegKey
+			seg.key = s[2 : 2+closing]
+			end = 2 + closing + 1
+			if end >= len(s) || s[end] != ']' {
+				return p, invalidJSONPathError(raw)
+			}
+		default:
+			n, err := strconv.Atoi(inner)
+			if err != nil {
+				return p, invalidJSONPathError(raw)
+			}
+			seg.kind = jsonSegIndex
+			seg.index = n
+		}
+		p.segs = append(p.segs, seg)
+		s = s[end+1:]
+	}
+
+	return p, nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		legacy   bool
+		definite bool
+		segs     []jsonPathSeg
+	}{
+		{"$", false, true, nil},
+		{".", true, true, nil},
+		{"", true, true, nil},
+		{"$.a.b", false, true, []jsonPathSeg{{kind: jsonSegKey, key: "a"}, {kind: jsonSegKey, key: "b"}}},
+		{"a.b", true, true, []jsonPathSeg{{kind: jsonSegKey, key: "a"}, {kind: jsonSegKey, key: "b"}}},
+		{".a[0]", true, true, []jsonPathSeg{{kind: jsonSegKey, key: "a"}, {kind: jsonSegIndex, index: 0}}},
+		{"$.a[-1]", false, true, []jsonPathSeg{{kind: jsonSegKey, key: "a"}, {kind: jsonSegIndex, index: -1}}},
+		{"$['a b'][\"c]d\"]", false, true, []jsonPathSeg{{kind: jsonSegKey, key: "a b"}, {kind: jsonSegKey, key: "c]d"}}},
+		{"$.*", false, false, []jsonPathSeg{{kind: jsonSegWildcard}}},
+		{"$.a[*].b", false, false, []jsonPathSeg{{kind: jsonSegKey, key: "a"}, {kind: jsonSegWildcard}, {kind: jsonSegKey, key: "b"}}},
+		{"$..b", false, false, []jsonPathSeg{{kind: jsonSegKey, key: "b", recursive: true}}},
+		{"$..[0]", false, false, []jsonPathSeg{{kind: jsonSegIndex, index: 0, recursive: true}}},
+	}
+
+	for _, tt := range tests {
+		p, err := parseJSONPath(tt.path)
+		if err != nil {
+			t.Errorf("parseJSONPath(%q) failed: %v", tt.path, err)
+			continue
+		}
+		if p.legacy != tt.legacy || p.definite() != tt.definite {
+			t.Errorf("parseJSONPath(%q): legacy=%v definite=%v", tt.path, p.legacy, p.definite())
+		}
+		if !reflect.DeepEqual(p.segs, tt.segs) {
+			t.Errorf("parseJSONPath(%q) = %+v, want %+v", tt.path, p.segs, tt.segs)
+		}
+	}
+
+	for _, bad := range []string{"$.", "$..", "$[", "$[abc]", "$['a]", "$a", "$.a[?(@.b>1)]"} {
+		if _, err := parseJSONPath(bad); err == nil {
+			t.Errorf("parseJSONPath(%q) should fail", bad)
+		}
+	}
+}
+
+func TestJSONPathLess(t *testing.T) {
+	if !jsonPathLess([]string{"a", "2"}, []string{"a", "10"}) {
+		t.Errorf("Array indexes should compare numerically")
+	}
+	if !jsonPathLess([]string{"a"}, []string{"a", "0"}) {
+		t.Errorf("Parents should sort before their children")
+	}
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mnorrsken/postkeys/internal/metrics"
+	"github.com/mnorrsken/postkeys/internal/pubsub"
+)
+
+// Keyspace notification classes, matching the notify-keyspace-events flags
+const (
+	notifyKeyspace = 1 << iota // K: __keyspace@<db>__:<key> channels
+	notifyKeyevent             // E: __keyevent@<db>__:<event> channels
+	notifyGeneric              // g: DEL, EXPIRE, RENAME, ...
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x
+	notifyEvicted              // e (never raised, keys are not evicted)
+	notifyStream               // t
+	notifyKeyMiss              // m
+	notifyModule               // d: JSON commands
+
+	// notifyAll is the A alias
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZSet | notifyExpired | notifyEvicted | notifyStream | notifyModule
+)
+
+// keyspaceEventFlags maps each flag letter to its class, in the order
+// CONFIG GET reports them
+var keyspaceEventFlags = []struct {
+	letter byte
+	class  int
+}{
+	{'g', notifyGeneric}, {'$', notifyString}, {'l', notifyList}, {'s', notifySet},
+	{'h', notifyHash}, {'z', notifyZSet}, {'x', notifyExpired}, {'e', notifyEvicted},
+	{'t', notifyStream}, {'d', notifyModule}, {'K', notifyKeyspace},
+	{'E', notifyKeyevent}, {'m', notifyKeyMiss},
+}
+
+// parseKeyspaceEvents parses a notify-keyspace-events string
+func parseKeyspaceEvents(s string) (int, error) {
+	flags := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		found := false
+		for _, f := range keyspaceEventFlags {
+			if f.letter == s[i] {
+				flags |= f.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid event class character '%c'", s[i])
+		}
+	}
+	return flags, nil
+}
+
+// formatKeyspaceEvents formats flags the way CONFIG GET reports them
+func formatKeyspaceEvents(flags int) string {
+	var sb strings.Builder
+	if flags&notifyAll == notifyAll {
+		sb.WriteByte('A')
+	}
+	for _, f := range keyspaceEventFlags {
+		if flags&notifyAll == notifyAll && f.class&notifyAll != 0 {
+			continue
+		}
+		if flags&f.class != 0 {
+			sb.WriteByte(f.letter)
+		}
+	}
+	return sb.String()
+}
+
+// keyspaceEvents holds the notify-keyspace-events setting shared by a Store
+// and its transactions
+type keyspaceEvents struct {
+	flags atomic.Int32
+
+	// namespace scopes the notifications' channels to the Store's tenant
+	namespace string
+}
+
+// enabled reports whether notifications of class are sent at all
+func (e *keyspaceEvents) enabled(class int) bool {
+	if e == nil {
+		return false
+	}
+	flags := int(e.flags.Load())
+	return flags&class != 0 && flags&(notifyKeyspace|notifyKeyevent) != 0
+}
+
+// SetNotifyKeyspaceEvents sets the keyspace notification classes, using the
+// notify-keyspace-events flag letters (an empty string disables them)
+func (s *Store) SetNotifyKeyspaceEvents(flags string) error {
+	parsed, err := parseKeyspaceEvents(flags)
+	if err != nil {
+		return err
+	}
+	s.events.flags.Store(int32(parsed))
+	return nil
+}
+
+// NotifyKeyspaceEvents returns the keyspace notification classes as flag letters
+func (s *Store) NotifyKeyspaceEvents() string {
+	return formatKeyspaceEvents(int(s.events.flags.Load()))
+}
+
+// notify publishes keyspace and keyevent notifications for event on keys if
+// class is enabled. They are sent with pg_notify on q, so every pod's pub/sub
+// hub delivers them, and inside a transaction only once it commits.
+func (o queryOps) notify(ctx context.Context, q Querier, class int, event string, keys ...string) {
+	o.notifyDB(ctx, q, o.db, class, event, keys...)
+}
+
+// notifyDB is notify for keys in database db rather than the selected one
+func (o queryOps) notifyDB(ctx context.Context, q Querier, db, class int, event string, keys ...string) {
+	notifications := o.notifications(db, class, event, keys)
+	if len(notifications) == 0 {
+		return
+	}
+	if _, err := q.Exec(ctx, pubsub.NotifySQL, pubsub.NotifyArgs(notifications)...); err != nil {
+		log.Printf("Failed to send keyspace notification %s: %v", event, err)
+	}
+}
+
+// queueNotify is notify for a pgx.Batch, whose errors its sender logs
+func (o queryOps) queueNotify(batch *pgx.Batch, class int, event string, keys ...string) {
+	if notifications := o.notifications(o.db, class, event, keys); len(notifications) > 0 {
+		batch.Queue(pubsub.NotifySQL, pubsub.NotifyArgs(notifications)...)
+	}
+}
+
+// notifications returns the channels and payloads of the notifications for
+// event on keys in database db, if class is enabled. Notifications too large
+// for a NOTIFY payload are dropped, logged and counted.
+func (o queryOps) notifications(db, class int, event string, keys []string) (notifications []pubsub.Notification) {
+	if !o.events.enabled(class) || len(keys) == 0 {
+		return nil
+	}
+	flags := int(o.events.flags.Load())
+
+	add := func(channel, message string) {
+		encoded, err := pubsub.EncodeNotify(o.events.namespace, channel, message)
+		if err != nil {
+			log.Printf("Dropped keyspace notification on %s: %v", channel, err)
+			metrics.NotificationsDropped.Inc()
+			return
+		}
+		notifications = append(notifications, encoded...)
+	}
+	for _, key := range keys {
+		if flags&notifyKeyspace != 0 {
+			add(fmt.Sprintf("__keyspace@%d__:%s", db, key), event)
+		}
+		if flags&notifyKeyevent != 0 {
+			add(fmt.Sprintf("__keyevent@%d__:%s", db, event), key)
+		}
+	}
+	return notifications
+}
+
+// quiet returns a copy of o that sends no notifications, for commands built
+// on other commands that only report their own event
+func (o queryOps) quiet() queryOps {
+	o.events = nil
+	return o
+}
@@ -0,0 +1,42 @@
+package storage
+
+import "testing"
+
+func TestKeyspaceEventFlags(t *testing.T) {
+	tests := []struct {
+		flags string
+		want  string
+	}{
+		{"", ""},
+		{"KEA", "AKE"},
+		{"Ex", "xE"},
+		{"Kg$lshzxetd", "AK"},
+		{"AKEm", "AKEm"},
+		{"h", "h"},
+	}
+
+	for _, tt := range tests {
+		parsed, err := parseKeyspaceEvents(tt.flags)
+		if err != nil {
+			t.Errorf("parseKeyspaceEvents(%q) failed: %v", tt.flags, err)
+			continue
+		}
+		if got := formatKeyspaceEvents(parsed); got != tt.want {
+			t.Errorf("formatKeyspaceEvents(parseKeyspaceEvents(%q)) = %q, want %q", tt.flags, got, tt.want)
+		}
+	}
+
+	if _, err := parseKeyspaceEvents("KEQ"); err == nil {
+		t.Error("parseKeyspaceEvents(\"KEQ\") should fail")
+	}
+
+	var e keyspaceEvents
+	e.flags.Store(notifyString)
+	if e.enabled(notifyString) {
+		t.Error("classes without K or E should not be enabled")
+	}
+	e.flags.Store(notifyKeyevent | notifyString)
+	if !e.enabled(notifyString) || e.enabled(notifyList) {
+		t.Error("only the configured classes should be enabled")
+	}
+}
@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Schema migrations live in migrations/<version>_<name>.up.sql, each with a
+// .down.sql that reverts it. Applied migrations are recorded in
+// kv_schema_version with a checksum of their SQL, so a migration edited after
+// it shipped is detected rather than silently skipped.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the advisory lock held while migrations run, so pods
+// starting together apply each migration once ("pkMigrat" in ASCII)
+const migrationLockID int64 = 0x706b4d6967726174
+
+// MigrateMode selects what New does about pending schema migrations
+type MigrateMode string
+
+const (
+	// MigrateAuto applies pending migrations at startup
+	MigrateAuto MigrateMode = "auto"
+	// MigrateCheck refuses to start while migrations are pending, for
+	// deployments that apply them out of band with `postkeys migrate up`
+	MigrateCheck MigrateMode = "check"
+)
+
+// ParseMigrateMode parses a MigrateMode, defaulting to MigrateAuto
+func ParseMigrateMode(s string) (MigrateMode, error) {
+	switch mode := MigrateMode(strings.ToLower(s)); mode {
+	case "":
+		return MigrateAuto, nil
+	case MigrateAuto, MigrateCheck:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid migrate mode %q (expected auto or check)", s)
+	}
+}
+
+// migration is one versioned schema change
+type migration struct {
+	version  int
+	name     string
+	up, down string
+	checksum string
+}
+
+// MigrationStatus describes a migration known to the binary or applied to
+// the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+
+	// Modified is set when the migration's SQL changed since it was applied
+	Modified bool
+	// Unknown is set for migrations applied by a newer version of postkeys
+	Unknown bool
+}
+
+// appliedMigration is a row of kv_schema_version
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// loadMigrations reads the embedded migrations in version order
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".up.sql")
+		prefix, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %s", file)
+		}
+		up, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		down, err := migrationFiles.ReadFile(strings.TrimSuffix(file, ".up.sql") + ".down.sql")
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no down migration: %w", base, err)
+		}
+		sum := sha256.Sum256(up)
+		migrations = append(migrations, migration{
+			version:  version,
+			name:     name,
+			up:       string(up),
+			down:     string(down),
+			checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].version)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator applies and reverts the schema migrations embedded in the binary
+type Migrator struct {
+	pool       *pgxpool.Pool
+	schema     string // created before the first migration, if set
+	lockID     int64
+	migrations []migration
+}
+
+// NewMigrator connects to the database in cfg to manage its schema
+func NewMigrator(ctx context.Context, cfg Config) (*Migrator, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	// The migration lock is held across transactions, so it needs a session
+	poolCfg, err := cfg.poolConfig(cfg.sessionConnString())
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection settings: %w", err)
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pool: %w", err)
+	}
+	m, err := newMigrator(pool, cfg)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func newMigrator(pool *pgxpool.Pool, cfg Config) (*Migrator, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	return &Migrator{
+		pool:       pool,
+		schema:     cfg.Schema,
+		lockID:     advisoryLockID(migrationLockID, cfg.namespace()),
+		migrations: migrations,
+	}, nil
+}
+
+// Close closes the Migrator's connections
+func (m *Migrator) Close() {
+	m.pool.Close()
+}
+
+// migrateSchema brings the schema in cfg up to date, or with MigrateCheck
+// only verifies that it is
+func (s *Store) migrateSchema(ctx context.Context, cfg Config) error {
+	var m *Migrator
+	var err error
+	if cfg.PgBouncer {
+		// Connections through PgBouncer can't hold the migration lock
+		m, err = NewMigrator(ctx, cfg)
+		if err == nil {
+			defer m.Close()
+		}
+	} else {
+		m, err = newMigrator(s.pool, cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	if cfg.Migrate == MigrateCheck {
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		var pending []string
+		for _, st := range statuses {
+			if err := st.err(); err != nil {
+				return err
+			}
+			if !st.Applied {
+				pending = append(pending, st.String())
+			}
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("schema migrations are pending: %s", strings.Join(pending, ", "))
+		}
+		return nil
+	}
+
+	_, err = m.Up(ctx)
+	return err
+}
+
+// String returns the migration's file name prefix, e.g. 0001_baseline
+func (st MigrationStatus) String() string {
+	return fmt.Sprintf("%04d_%s", st.Version, st.Name)
+}
+
+// err reports an applied migration that this binary can't vouch for
+func (st MigrationStatus) err() error {
+	switch {
+	case st.Unknown:
+		return fmt.Errorf("schema migration %s was applied by a newer version of postkeys", st)
+	case st.Modified:
+		return fmt.Errorf("schema migration %s changed since it was applied", st)
+	}
+	return nil
+}
+
+// Status returns every migration known to the binary, followed by any
+// applied migrations it doesn't know, in version order
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var exists bool
+	if err := m.pool.QueryRow(ctx, "SELECT to_regclass('kv_schema_version') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := map[int]appliedMigration{}
+	if exists {
+		var err error
+		if applied, err = m.applied(ctx, m.pool); err != nil {
+			return nil, err
+		}
+	}
+	return m.status(applied), nil
+}
+
+func (m *Migrator) status(applied map[int]appliedMigration) []MigrationStatus {
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	known := make(map[int]bool, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.version] = true
+		st := MigrationStatus{Version: mig.version, Name: mig.name}
+		if a, ok := applied[mig.version]; ok {
+			st.Applied, st.AppliedAt = true, a.appliedAt
+			st.Modified = a.checksum != mig.checksum
+		}
+		statuses = append(statuses, st)
+	}
+	for version, a := range applied {
+		if !known[version] {
+			statuses = append(statuses, MigrationStatus{
+				Version: version, Name: a.name, Applied: true, AppliedAt: a.appliedAt, Unknown: true,
+			})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses
+}
+
+// applied returns the rows of kv_schema_version by version
+func (m *Migrator) applied(ctx context.Context, q Querier) (map[int]appliedMigration, error) {
+	rows, err := q.Query(ctx, "SELECT version, name, checksum, applied_at FROM kv_schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// withLock runs fn on a connection holding the migration lock, after making
+// sure kv_schema_version exists. Other pods wait for the lock, then find the
+// migrations already applied.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn, applied map[int]appliedMigration) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", m.lockID); err != nil {
+		return fmt.Errorf("failed to take the migration lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", m.lockID)
+
+	if m.schema != "" {
+		// Only create the schema if it's missing, since CREATE SCHEMA IF NOT
+		// EXISTS needs the CREATE privilege on the database either way
+		var exists bool
+		if err := conn.QueryRow(ctx, "SELECT to_regnamespace($1) IS NOT NULL", m.schema).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			if _, err := conn.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+pgx.Identifier{m.schema}.Sanitize()); err != nil {
+				return fmt.Errorf("failed to create schema %s: %w", m.schema, err)
+			}
+		}
+	}
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS kv_schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create kv_schema_version: %w", err)
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	for _, st := range m.status(applied) {
+		if err := st.err(); err != nil {
+			return err
+		}
+	}
+	return fn(conn, applied)
+}
+
+// Up applies every pending migration, each in its own transaction, and
+// returns the ones it applied
+func (m *Migrator) Up(ctx context.Context) ([]MigrationStatus, error) {
+	var done []MigrationStatus
+	err := m.withLock(ctx, func(conn *pgxpool.Conn, applied map[int]appliedMigration) error {
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.version]; ok {
+				continue
+			}
+			st := MigrationStatus{Version: mig.version, Name: mig.name}
+			start := time.Now()
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, mig.up); err != nil {
+					return err
+				}
+				return tx.QueryRow(ctx,
+					"INSERT INTO kv_schema_version (version, name, checksum) VALUES ($1, $2, $3) RETURNING applied_at",
+					mig.version, mig.name, mig.checksum,
+				).Scan(&st.AppliedAt)
+			})
+			if err != nil {
+				return fmt.Errorf("schema migration %s failed: %w", st, err)
+			}
+			log.Printf("Applied schema migration %s in %v", st, time.Since(start).Round(time.Millisecond))
+			st.Applied = true
+			done = append(done, st)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the most recently applied migration and returns it
+func (m *Migrator) Down(ctx context.Context) (MigrationStatus, error) {
+	var st MigrationStatus
+	err := m.withLock(ctx, func(conn *pgxpool.Conn, applied map[int]appliedMigration) error {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.version]; !ok {
+				continue
+			}
+			st = MigrationStatus{Version: mig.version, Name: mig.name}
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, mig.down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "DELETE FROM kv_schema_version WHERE version = $1", mig.version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("reverting schema migration %s failed: %w", st, err)
+			}
+			log.Printf("Reverted schema migration %s", st)
+			return nil
+		}
+		return fmt.Errorf("no schema migrations are applied")
+	})
+	return st, err
+}
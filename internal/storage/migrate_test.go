@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations failed: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("Expected embedded migrations")
+	}
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("Migration %d has version %d, want versions numbered from 1 without gaps", i, m.version)
+		}
+		if strings.TrimSpace(m.up) == "" || strings.TrimSpace(m.down) == "" {
+			t.Errorf("Migration %04d_%s has an empty up or down migration", m.version, m.name)
+		}
+		if len(m.checksum) != 64 {
+			t.Errorf("Migration %04d_%s has checksum %q", m.version, m.name, m.checksum)
+		}
+	}
+	if migrations[0].name != "baseline" {
+		t.Errorf("First migration is %q, want baseline", migrations[0].name)
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	m := &Migrator{migrations: []migration{
+		{version: 1, name: "baseline", checksum: "a"},
+		{version: 2, name: "second", checksum: "b"},
+		{version: 3, name: "third", checksum: "c"},
+	}}
+
+	statuses := m.status(map[int]appliedMigration{
+		1: {name: "baseline", checksum: "a"},
+		2: {name: "second", checksum: "edited"},
+		4: {name: "future", checksum: "d"},
+	})
+
+	want := []struct {
+		name                       string
+		applied, modified, unknown bool
+	}{
+		{"0001_baseline", true, false, false},
+		{"0002_second", true, true, false},
+		{"0003_third", false, false, false},
+		{"0004_future", true, false, true},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("Got %d statuses, want %d", len(statuses), len(want))
+	}
+	for i, w := range want {
+		st := statuses[i]
+		if st.String() != w.name || st.Applied != w.applied || st.Modified != w.modified || st.Unknown != w.unknown {
+			t.Errorf("Status %d = %+v, want %+v", i, st, w)
+		}
+		if (st.err() != nil) != (w.modified || w.unknown) {
+			t.Errorf("Status %s err() = %v", st, st.err())
+		}
+	}
+}
+
+func TestParseMigrateMode(t *testing.T) {
+	for in, want := range map[string]MigrateMode{"": MigrateAuto, "auto": MigrateAuto, "CHECK": MigrateCheck} {
+		got, err := ParseMigrateMode(in)
+		if err != nil || got != want {
+			t.Errorf("ParseMigrateMode(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseMigrateMode("never"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,11 @@
+-- Drops every postkeys table, and all data with them
+DROP TABLE IF EXISTS
+	kv_strings, kv_hashes, kv_lists, kv_sets, kv_zsets, kv_hyperloglog,
+	kv_streams, kv_stream_meta, kv_stream_groups, kv_stream_consumers,
+	kv_stream_pending, kv_json, kv_meta, kv_ft_indexes, kv_scan_cursors;
+
+DROP SEQUENCE IF EXISTS kv_version_seq;
+
+DROP FUNCTION IF EXISTS
+	postkeys_ft_text(BYTEA), postkeys_ft_numeric(BYTEA), postkeys_ft_tags(BYTEA, TEXT),
+	postkeys_meta_version(), postkeys_touch_keys();
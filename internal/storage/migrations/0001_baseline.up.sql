@@ -0,0 +1,265 @@
+-- Baseline schema: everything postkeys created at startup before schema
+-- migrations were versioned. Every statement is idempotent, so this also
+-- adopts databases created by those earlier versions.
+
+-- Main key-value store for string types (BYTEA for binary-safe storage)
+CREATE TABLE IF NOT EXISTS kv_strings (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	value BYTEA NOT NULL,
+	expires_at TIMESTAMPTZ,
+	PRIMARY KEY (db, key)
+);
+CREATE INDEX IF NOT EXISTS idx_kv_strings_expires ON kv_strings(expires_at) WHERE expires_at IS NOT NULL;
+
+-- Hash type storage
+CREATE TABLE IF NOT EXISTS kv_hashes (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	field TEXT NOT NULL,
+	value BYTEA NOT NULL,
+	expires_at TIMESTAMPTZ,
+	PRIMARY KEY (db, key, field)
+);
+CREATE INDEX IF NOT EXISTS idx_kv_hashes_expires ON kv_hashes(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_kv_hashes_key ON kv_hashes(key);
+
+-- List type storage
+CREATE TABLE IF NOT EXISTS kv_lists (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	idx BIGINT NOT NULL,
+	value BYTEA NOT NULL,
+	expires_at TIMESTAMPTZ,
+	PRIMARY KEY (db, key, idx)
+);
+CREATE INDEX IF NOT EXISTS idx_kv_lists_expires ON kv_lists(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_kv_lists_key ON kv_lists(key);
+
+-- Set type storage
+CREATE TABLE IF NOT EXISTS kv_sets (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	member BYTEA NOT NULL,
+	expires_at TIMESTAMPTZ,
+	PRIMARY KEY (db, key, member)
+);
+CREATE INDEX IF NOT EXISTS idx_kv_sets_expires ON kv_sets(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_kv_sets_key ON kv_sets(key);
+
+-- Sorted set type storage
+CREATE TABLE IF NOT EXISTS kv_zsets (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	member BYTEA NOT NULL,
+	score DOUBLE PRECISION NOT NULL,
+	expires_at TIMESTAMPTZ,
+	PRIMARY KEY (db, key, member)
+);
+CREATE INDEX IF NOT EXISTS idx_kv_zsets_expires ON kv_zsets(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_kv_zsets_key ON kv_zsets(key);
+CREATE INDEX IF NOT EXISTS idx_kv_zsets_score ON kv_zsets(key, score);
+
+-- Key metadata for tracking types and TTL; version changes on every
+-- write to the key (see the key versions below) and is checked by WATCH
+CREATE SEQUENCE IF NOT EXISTS kv_version_seq;
+CREATE TABLE IF NOT EXISTS kv_meta (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	key_type TEXT NOT NULL,
+	expires_at TIMESTAMPTZ,
+	version BIGINT NOT NULL DEFAULT nextval('kv_version_seq'),
+	PRIMARY KEY (db, key)
+);
+CREATE INDEX IF NOT EXISTS idx_kv_meta_expires ON kv_meta(expires_at) WHERE expires_at IS NOT NULL;
+
+-- HyperLogLog storage (stores serialized HLL registers)
+CREATE TABLE IF NOT EXISTS kv_hyperloglog (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	registers BYTEA NOT NULL,
+	expires_at TIMESTAMPTZ,
+	PRIMARY KEY (db, key)
+);
+CREATE INDEX IF NOT EXISTS idx_kv_hyperloglog_expires ON kv_hyperloglog(expires_at) WHERE expires_at IS NOT NULL;
+
+-- Stream type storage (entries keyed by their <ms>-<seq> ID, fields as alternating field/value pairs)
+CREATE TABLE IF NOT EXISTS kv_streams (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	ms BIGINT NOT NULL,
+	seq BIGINT NOT NULL,
+	fields BYTEA[] NOT NULL,
+	PRIMARY KEY (db, key, ms, seq)
+);
+
+-- Per-stream state that must survive XDEL/XTRIM (last generated ID, counters)
+CREATE TABLE IF NOT EXISTS kv_stream_meta (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	last_ms BIGINT NOT NULL DEFAULT 0,
+	last_seq BIGINT NOT NULL DEFAULT 0,
+	max_deleted_ms BIGINT NOT NULL DEFAULT 0,
+	max_deleted_seq BIGINT NOT NULL DEFAULT 0,
+	entries_added BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (db, key)
+);
+
+-- Stream consumer groups (entries_read is NULL when it can't be determined)
+CREATE TABLE IF NOT EXISTS kv_stream_groups (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	group_name TEXT NOT NULL,
+	last_ms BIGINT NOT NULL DEFAULT 0,
+	last_seq BIGINT NOT NULL DEFAULT 0,
+	entries_read BIGINT,
+	PRIMARY KEY (db, key, group_name)
+);
+
+CREATE TABLE IF NOT EXISTS kv_stream_consumers (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	group_name TEXT NOT NULL,
+	consumer TEXT NOT NULL,
+	seen_time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	active_time TIMESTAMPTZ,
+	PRIMARY KEY (db, key, group_name, consumer)
+);
+
+-- Pending entries lists: entries delivered to a consumer but not yet acknowledged
+CREATE TABLE IF NOT EXISTS kv_stream_pending (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	group_name TEXT NOT NULL,
+	ms BIGINT NOT NULL,
+	seq BIGINT NOT NULL,
+	consumer TEXT NOT NULL,
+	delivered_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	delivery_count BIGINT NOT NULL DEFAULT 1,
+	PRIMARY KEY (db, key, group_name, ms, seq)
+);
+CREATE INDEX IF NOT EXISTS idx_kv_stream_pending_consumer ON kv_stream_pending(key, group_name, consumer);
+
+-- JSON documents (RedisJSON), updated in place with jsonb_set
+CREATE TABLE IF NOT EXISTS kv_json (
+	db INTEGER NOT NULL DEFAULT 0,
+	key TEXT NOT NULL,
+	value JSONB NOT NULL,
+	PRIMARY KEY (db, key)
+);
+
+-- Search index definitions (FT.CREATE); each attribute is backed by a
+-- partial expression index on kv_hashes named kv_ft_<hash>_<n>
+CREATE TABLE IF NOT EXISTS kv_ft_indexes (
+	db INTEGER NOT NULL DEFAULT 0,
+	name TEXT NOT NULL,
+	prefixes TEXT[] NOT NULL,
+	fields JSONB NOT NULL,
+	PRIMARY KEY (db, name)
+);
+
+-- Positions SCAN, HSCAN, SSCAN and ZSCAN cursors resume after, keyed by
+-- the cursor returned to the client
+CREATE TABLE IF NOT EXISTS kv_scan_cursors (
+	id BIGSERIAL PRIMARY KEY,
+	position BYTEA NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_kv_scan_cursors_created ON kv_scan_cursors(created_at);
+
+-- Immutable accessors used in the search expression indexes; values that
+-- aren't valid UTF-8 or numbers index as NULL instead of failing writes
+CREATE OR REPLACE FUNCTION postkeys_ft_text(v BYTEA) RETURNS TEXT AS $$
+BEGIN
+	RETURN convert_from(v, 'UTF8');
+EXCEPTION WHEN others THEN
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION postkeys_ft_numeric(v BYTEA) RETURNS NUMERIC AS $$
+BEGIN
+	RETURN trim(convert_from(v, 'UTF8'))::numeric;
+EXCEPTION WHEN others THEN
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+CREATE OR REPLACE FUNCTION postkeys_ft_tags(v BYTEA, sep TEXT) RETURNS TEXT[] AS $$
+	SELECT coalesce(array_agg(lower(trim(t))), '{}')
+	FROM unnest(string_to_array(postkeys_ft_text(v), sep)) AS t
+	WHERE trim(t) <> ''
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Add the db column to tables created before multiple databases were
+-- supported; existing data ends up in database 0
+DO $$
+DECLARE
+	t RECORD;
+BEGIN
+	FOR t IN SELECT * FROM (VALUES
+		('kv_strings', 'key'), ('kv_hashes', 'key, field'), ('kv_lists', 'key, idx'),
+		('kv_sets', 'key, member'), ('kv_zsets', 'key, member'), ('kv_hyperloglog', 'key'),
+		('kv_streams', 'key, ms, seq'), ('kv_stream_meta', 'key'),
+		('kv_stream_groups', 'key, group_name'), ('kv_stream_consumers', 'key, group_name, consumer'),
+		('kv_stream_pending', 'key, group_name, ms, seq'), ('kv_json', 'key'), ('kv_meta', 'key'),
+		('kv_ft_indexes', 'name')
+	) AS v(name, pkey) LOOP
+		IF NOT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = t.name AND column_name = 'db'
+		) THEN
+			EXECUTE format('ALTER TABLE %I ADD COLUMN db INTEGER NOT NULL DEFAULT 0', t.name);
+			EXECUTE format('ALTER TABLE %I DROP CONSTRAINT %I, ADD PRIMARY KEY (db, %s)', t.name, t.name || '_pkey', t.pkey);
+		END IF;
+	END LOOP;
+END $$;
+
+-- Key versions for WATCH: every change to a key's rows moves it to a new
+-- version drawn from kv_version_seq, so a key that is deleted and recreated
+-- never gets a version it had before
+ALTER TABLE kv_meta ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT nextval('kv_version_seq');
+
+CREATE OR REPLACE FUNCTION postkeys_meta_version() RETURNS trigger AS $$
+BEGIN
+	NEW.version := nextval('kv_version_seq');
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION postkeys_touch_keys() RETURNS trigger AS $$
+BEGIN
+	UPDATE kv_meta m SET version = nextval('kv_version_seq')
+	FROM (SELECT DISTINCT db, key FROM changed_rows) c
+	WHERE m.db = c.db AND m.key = c.key;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Statement-level triggers with transition tables, so deleting a large hash
+-- or list bumps its version once rather than once per row
+DO $$
+DECLARE
+	t TEXT;
+	e RECORD;
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'kv_meta_version' AND tgrelid = 'kv_meta'::regclass) THEN
+		CREATE TRIGGER kv_meta_version BEFORE UPDATE ON kv_meta
+		FOR EACH ROW WHEN (OLD.version = NEW.version) EXECUTE FUNCTION postkeys_meta_version();
+	END IF;
+
+	FOREACH t IN ARRAY ARRAY[
+		'kv_strings', 'kv_hashes', 'kv_lists', 'kv_sets', 'kv_zsets', 'kv_hyperloglog',
+		'kv_streams', 'kv_stream_meta', 'kv_stream_groups', 'kv_stream_consumers',
+		'kv_stream_pending', 'kv_json'
+	] LOOP
+		FOR e IN SELECT * FROM (VALUES ('ins', 'INSERT', 'NEW'), ('upd', 'UPDATE', 'NEW'), ('del', 'DELETE', 'OLD')) AS v(name, event, transition) LOOP
+			IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = t || '_touch_' || e.name AND tgrelid = t::regclass) THEN
+				EXECUTE format(
+					'CREATE TRIGGER %I AFTER %s ON %I REFERENCING %s TABLE AS changed_rows FOR EACH STATEMENT EXECUTE FUNCTION postkeys_touch_keys()',
+					t || '_touch_' || e.name, e.event, t, e.transition
+				);
+			END IF;
+		END LOOP;
+	END LOOP;
+END $$;
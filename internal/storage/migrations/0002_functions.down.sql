@@ -0,0 +1,10 @@
+-- Drops the command functions; postkeys falls back to running the commands'
+-- queries one by one when started with PG_FUNCTIONS=false
+DROP FUNCTION IF EXISTS
+	pk_set(INTEGER, TEXT, BYTEA, TIMESTAMPTZ),
+	pk_incr(INTEGER, TEXT, BIGINT),
+	pk_hset(INTEGER, TEXT, TEXT[], BYTEA[]),
+	pk_lpush(INTEGER, TEXT, BYTEA[]),
+	pk_rpush(INTEGER, TEXT, BYTEA[]),
+	pk_sadd(INTEGER, TEXT, BYTEA[]),
+	pk_check_type(INTEGER, TEXT, TEXT);
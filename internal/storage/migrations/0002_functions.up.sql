@@ -0,0 +1,149 @@
+-- Server-side versions of the busiest write commands, so each runs as one
+-- statement instead of a round trip per query (see functions.go). They
+-- mirror the queries in querier.go, and raise the command's error reply as
+-- the exception message.
+
+CREATE OR REPLACE FUNCTION pk_set(p_db INTEGER, p_key TEXT, p_value BYTEA, p_expires_at TIMESTAMPTZ) RETURNS VOID AS $$
+BEGIN
+	DELETE FROM kv_hashes WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_lists WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_sets WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_zsets WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_streams WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_stream_meta WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_stream_groups WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_stream_consumers WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_stream_pending WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_json WHERE db = p_db AND key = p_key;
+
+	INSERT INTO kv_strings (db, key, value, expires_at) VALUES (p_db, p_key, p_value, p_expires_at)
+	ON CONFLICT (db, key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at;
+	INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'string', p_expires_at)
+	ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_incr(p_db INTEGER, p_key TEXT, p_delta BIGINT) RETURNS BIGINT AS $$
+DECLARE
+	v_text TEXT;
+	v_current NUMERIC := 0;
+	v_result NUMERIC;
+BEGIN
+	SELECT encode(value, 'escape') INTO v_text FROM kv_strings
+	WHERE db = p_db AND key = p_key AND (expires_at IS NULL OR expires_at > NOW())
+	FOR UPDATE;
+	IF FOUND THEN
+		IF v_text !~ '^[+-]?[0-9]+$' THEN
+			RAISE EXCEPTION 'value is not an integer';
+		END IF;
+		v_current := v_text::numeric;
+		IF v_current NOT BETWEEN -9223372036854775808 AND 9223372036854775807 THEN
+			RAISE EXCEPTION 'value is not an integer';
+		END IF;
+	END IF;
+
+	v_result := v_current + p_delta;
+	IF v_result NOT BETWEEN -9223372036854775808 AND 9223372036854775807 THEN
+		RAISE EXCEPTION 'increment or decrement would overflow';
+	END IF;
+
+	INSERT INTO kv_strings (db, key, value) VALUES (p_db, p_key, convert_to(v_result::text, 'UTF8'))
+	ON CONFLICT (db, key) DO UPDATE SET value = EXCLUDED.value;
+	INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'string', NULL)
+	ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+	RETURN v_result;
+END;
+$$ LANGUAGE plpgsql;
+
+-- pk_check_type raises WRONGTYPE unless the key is missing or of key_type
+CREATE OR REPLACE FUNCTION pk_check_type(p_db INTEGER, p_key TEXT, p_key_type TEXT) RETURNS VOID AS $$
+DECLARE
+	v_type TEXT;
+BEGIN
+	SELECT key_type INTO v_type FROM kv_meta
+	WHERE db = p_db AND key = p_key AND (expires_at IS NULL OR expires_at > NOW());
+	IF v_type IS NOT NULL AND v_type <> p_key_type THEN
+		RAISE EXCEPTION 'WRONGTYPE Operation against a key holding the wrong kind of value';
+	END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- pk_hset returns the number of fields added
+CREATE OR REPLACE FUNCTION pk_hset(p_db INTEGER, p_key TEXT, p_fields TEXT[], p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_existing BIGINT;
+BEGIN
+	PERFORM pk_check_type(p_db, p_key, 'hash');
+
+	SELECT count(*) INTO v_existing FROM kv_hashes
+	WHERE db = p_db AND key = p_key AND field = ANY(p_fields);
+	INSERT INTO kv_hashes (db, key, field, value)
+	SELECT p_db, p_key, f, v FROM unnest(p_fields, p_values) AS t(f, v)
+	ON CONFLICT (db, key, field) DO UPDATE SET value = EXCLUDED.value;
+	INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'hash', NULL)
+	ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+	RETURN cardinality(p_fields) - v_existing;
+END;
+$$ LANGUAGE plpgsql;
+
+-- pk_lpush and pk_rpush return the length of the list; the first value
+-- pushed is the first to end up at the head or tail
+CREATE OR REPLACE FUNCTION pk_lpush(p_db INTEGER, p_key TEXT, p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_min BIGINT;
+	v_length BIGINT;
+BEGIN
+	PERFORM pk_check_type(p_db, p_key, 'list');
+
+	IF coalesce(cardinality(p_values), 0) > 0 THEN
+		-- Serialize list operations on this key, like lPush
+		PERFORM pg_advisory_xact_lock(hashtext(p_key)::bigint);
+		SELECT COALESCE(MIN(idx), 0) INTO v_min FROM kv_lists WHERE db = p_db AND key = p_key;
+		INSERT INTO kv_lists (db, key, idx, value)
+		SELECT p_db, p_key, v_min - t.i, t.v FROM unnest(p_values) WITH ORDINALITY AS t(v, i);
+		INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'list', NULL)
+		ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+	END IF;
+
+	SELECT count(*) INTO v_length FROM kv_lists WHERE db = p_db AND key = p_key;
+	RETURN v_length;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_rpush(p_db INTEGER, p_key TEXT, p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_max BIGINT;
+	v_length BIGINT;
+BEGIN
+	PERFORM pk_check_type(p_db, p_key, 'list');
+
+	IF coalesce(cardinality(p_values), 0) > 0 THEN
+		PERFORM pg_advisory_xact_lock(hashtext(p_key)::bigint);
+		SELECT COALESCE(MAX(idx), -1) INTO v_max FROM kv_lists WHERE db = p_db AND key = p_key;
+		INSERT INTO kv_lists (db, key, idx, value)
+		SELECT p_db, p_key, v_max + t.i, t.v FROM unnest(p_values) WITH ORDINALITY AS t(v, i);
+		INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'list', NULL)
+		ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+	END IF;
+
+	SELECT count(*) INTO v_length FROM kv_lists WHERE db = p_db AND key = p_key;
+	RETURN v_length;
+END;
+$$ LANGUAGE plpgsql;
+
+-- pk_sadd returns the number of members added
+CREATE OR REPLACE FUNCTION pk_sadd(p_db INTEGER, p_key TEXT, p_members BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_added BIGINT;
+BEGIN
+	PERFORM pk_check_type(p_db, p_key, 'set');
+
+	INSERT INTO kv_sets (db, key, member)
+	SELECT p_db, p_key, unnest(p_members)
+	ON CONFLICT (db, key, member) DO NOTHING;
+	GET DIAGNOSTICS v_added = ROW_COUNT;
+	INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'set', NULL)
+	ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+	RETURN v_added;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,239 @@
+-- Moves keys back into kv_meta, copying db, key and expiry back into every
+-- type table, and restores the command functions of 0002_functions
+
+DROP TRIGGER IF EXISTS kv_keys_version ON kv_keys;
+DO $$
+DECLARE
+	t TEXT;
+	e TEXT;
+BEGIN
+	FOREACH t IN ARRAY ARRAY[
+		'kv_strings', 'kv_hashes', 'kv_lists', 'kv_sets', 'kv_zsets', 'kv_hyperloglog',
+		'kv_streams', 'kv_stream_meta', 'kv_stream_groups', 'kv_stream_consumers',
+		'kv_stream_pending', 'kv_json'
+	] LOOP
+		FOREACH e IN ARRAY ARRAY['touch_ins', 'touch_upd', 'touch_del', 'drop_empty'] LOOP
+			EXECUTE format('DROP TRIGGER IF EXISTS %I ON %I', t || '_' || e, t);
+		END LOOP;
+	END LOOP;
+END $$;
+
+DO $$
+DECLARE
+	t RECORD;
+BEGIN
+	FOR t IN SELECT * FROM (VALUES
+		('kv_strings', 'key', true), ('kv_hashes', 'key, field', true), ('kv_lists', 'key, idx', true),
+		('kv_sets', 'key, member', true), ('kv_zsets', 'key, member', true), ('kv_hyperloglog', 'key', true),
+		('kv_streams', 'key, ms, seq', false), ('kv_stream_meta', 'key', false),
+		('kv_stream_groups', 'key, group_name', false), ('kv_stream_consumers', 'key, group_name, consumer', false),
+		('kv_stream_pending', 'key, group_name, ms, seq', false), ('kv_json', 'key', false)
+	) AS v(name, pkey, expires) LOOP
+		EXECUTE format('ALTER TABLE %I ADD COLUMN db INTEGER NOT NULL DEFAULT 0, ADD COLUMN key TEXT', t.name);
+		IF t.expires THEN
+			EXECUTE format('ALTER TABLE %I ADD COLUMN expires_at TIMESTAMPTZ', t.name);
+			EXECUTE format(
+				'UPDATE %I d SET db = k.db, key = k.key, expires_at = k.expires_at FROM kv_keys k WHERE k.id = d.key_id',
+				t.name
+			);
+		ELSE
+			EXECUTE format('UPDATE %I d SET db = k.db, key = k.key FROM kv_keys k WHERE k.id = d.key_id', t.name);
+		END IF;
+		EXECUTE format(
+			'ALTER TABLE %I DROP COLUMN key_id, ALTER COLUMN key SET NOT NULL, ADD PRIMARY KEY (db, %s)',
+			t.name, t.pkey
+		);
+	END LOOP;
+END $$;
+
+CREATE INDEX idx_kv_strings_expires ON kv_strings(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX idx_kv_hashes_expires ON kv_hashes(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX idx_kv_hashes_key ON kv_hashes(key);
+CREATE INDEX idx_kv_lists_expires ON kv_lists(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX idx_kv_lists_key ON kv_lists(key);
+CREATE INDEX idx_kv_sets_expires ON kv_sets(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX idx_kv_sets_key ON kv_sets(key);
+CREATE INDEX idx_kv_zsets_expires ON kv_zsets(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX idx_kv_zsets_key ON kv_zsets(key);
+CREATE INDEX idx_kv_zsets_score ON kv_zsets(key, score);
+CREATE INDEX idx_kv_hyperloglog_expires ON kv_hyperloglog(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX idx_kv_stream_pending_consumer ON kv_stream_pending(key, group_name, consumer);
+
+ALTER TABLE kv_keys DROP CONSTRAINT kv_keys_pkey, DROP CONSTRAINT kv_keys_db_key_key, DROP COLUMN id;
+ALTER TABLE kv_keys RENAME TO kv_meta;
+ALTER TABLE kv_meta ADD CONSTRAINT kv_meta_pkey PRIMARY KEY (db, key);
+ALTER INDEX idx_kv_keys_expires RENAME TO idx_kv_meta_expires;
+
+DROP FUNCTION postkeys_key_id(INTEGER, TEXT), postkeys_drop_empty_keys();
+
+CREATE OR REPLACE FUNCTION postkeys_touch_keys() RETURNS trigger AS $$
+BEGIN
+	UPDATE kv_meta m SET version = nextval('kv_version_seq')
+	FROM (SELECT DISTINCT db, key FROM changed_rows) c
+	WHERE m.db = c.db AND m.key = c.key;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER kv_meta_version BEFORE UPDATE ON kv_meta
+FOR EACH ROW WHEN (OLD.version = NEW.version) EXECUTE FUNCTION postkeys_meta_version();
+
+DO $$
+DECLARE
+	t TEXT;
+	e RECORD;
+BEGIN
+	FOREACH t IN ARRAY ARRAY[
+		'kv_strings', 'kv_hashes', 'kv_lists', 'kv_sets', 'kv_zsets', 'kv_hyperloglog',
+		'kv_streams', 'kv_stream_meta', 'kv_stream_groups', 'kv_stream_consumers',
+		'kv_stream_pending', 'kv_json'
+	] LOOP
+		FOR e IN SELECT * FROM (VALUES ('ins', 'INSERT', 'NEW'), ('upd', 'UPDATE', 'NEW'), ('del', 'DELETE', 'OLD')) AS v(name, event, transition) LOOP
+			EXECUTE format(
+				'CREATE TRIGGER %I AFTER %s ON %I REFERENCING %s TABLE AS changed_rows FOR EACH STATEMENT EXECUTE FUNCTION postkeys_touch_keys()',
+				t || '_touch_' || e.name, e.event, t, e.transition
+			);
+		END LOOP;
+	END LOOP;
+END $$;
+
+DROP FUNCTION pk_list_id(INTEGER, TEXT, BYTEA[]), pk_key_id(INTEGER, TEXT, TEXT);
+
+CREATE OR REPLACE FUNCTION pk_set(p_db INTEGER, p_key TEXT, p_value BYTEA, p_expires_at TIMESTAMPTZ) RETURNS VOID AS $$
+BEGIN
+	DELETE FROM kv_hashes WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_lists WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_sets WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_zsets WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_streams WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_stream_meta WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_stream_groups WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_stream_consumers WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_stream_pending WHERE db = p_db AND key = p_key;
+	DELETE FROM kv_json WHERE db = p_db AND key = p_key;
+
+	INSERT INTO kv_strings (db, key, value, expires_at) VALUES (p_db, p_key, p_value, p_expires_at)
+	ON CONFLICT (db, key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at;
+	INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'string', p_expires_at)
+	ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_incr(p_db INTEGER, p_key TEXT, p_delta BIGINT) RETURNS BIGINT AS $$
+DECLARE
+	v_text TEXT;
+	v_current NUMERIC := 0;
+	v_result NUMERIC;
+BEGIN
+	SELECT encode(value, 'escape') INTO v_text FROM kv_strings
+	WHERE db = p_db AND key = p_key AND (expires_at IS NULL OR expires_at > NOW())
+	FOR UPDATE;
+	IF FOUND THEN
+		IF v_text !~ '^[+-]?[0-9]+$' THEN
+			RAISE EXCEPTION 'value is not an integer';
+		END IF;
+		v_current := v_text::numeric;
+		IF v_current NOT BETWEEN -9223372036854775808 AND 9223372036854775807 THEN
+			RAISE EXCEPTION 'value is not an integer';
+		END IF;
+	END IF;
+
+	v_result := v_current + p_delta;
+	IF v_result NOT BETWEEN -9223372036854775808 AND 9223372036854775807 THEN
+		RAISE EXCEPTION 'increment or decrement would overflow';
+	END IF;
+
+	INSERT INTO kv_strings (db, key, value) VALUES (p_db, p_key, convert_to(v_result::text, 'UTF8'))
+	ON CONFLICT (db, key) DO UPDATE SET value = EXCLUDED.value;
+	INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'string', NULL)
+	ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+	RETURN v_result;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_check_type(p_db INTEGER, p_key TEXT, p_key_type TEXT) RETURNS VOID AS $$
+DECLARE
+	v_type TEXT;
+BEGIN
+	SELECT key_type INTO v_type FROM kv_meta
+	WHERE db = p_db AND key = p_key AND (expires_at IS NULL OR expires_at > NOW());
+	IF v_type IS NOT NULL AND v_type <> p_key_type THEN
+		RAISE EXCEPTION 'WRONGTYPE Operation against a key holding the wrong kind of value';
+	END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_hset(p_db INTEGER, p_key TEXT, p_fields TEXT[], p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_existing BIGINT;
+BEGIN
+	PERFORM pk_check_type(p_db, p_key, 'hash');
+
+	SELECT count(*) INTO v_existing FROM kv_hashes
+	WHERE db = p_db AND key = p_key AND field = ANY(p_fields);
+	INSERT INTO kv_hashes (db, key, field, value)
+	SELECT p_db, p_key, f, v FROM unnest(p_fields, p_values) AS t(f, v)
+	ON CONFLICT (db, key, field) DO UPDATE SET value = EXCLUDED.value;
+	INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'hash', NULL)
+	ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+	RETURN cardinality(p_fields) - v_existing;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_lpush(p_db INTEGER, p_key TEXT, p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_min BIGINT;
+	v_length BIGINT;
+BEGIN
+	PERFORM pk_check_type(p_db, p_key, 'list');
+
+	IF coalesce(cardinality(p_values), 0) > 0 THEN
+		PERFORM pg_advisory_xact_lock(hashtext(p_key)::bigint);
+		SELECT COALESCE(MIN(idx), 0) INTO v_min FROM kv_lists WHERE db = p_db AND key = p_key;
+		INSERT INTO kv_lists (db, key, idx, value)
+		SELECT p_db, p_key, v_min - t.i, t.v FROM unnest(p_values) WITH ORDINALITY AS t(v, i);
+		INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'list', NULL)
+		ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+	END IF;
+
+	SELECT count(*) INTO v_length FROM kv_lists WHERE db = p_db AND key = p_key;
+	RETURN v_length;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_rpush(p_db INTEGER, p_key TEXT, p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_max BIGINT;
+	v_length BIGINT;
+BEGIN
+	PERFORM pk_check_type(p_db, p_key, 'list');
+
+	IF coalesce(cardinality(p_values), 0) > 0 THEN
+		PERFORM pg_advisory_xact_lock(hashtext(p_key)::bigint);
+		SELECT COALESCE(MAX(idx), -1) INTO v_max FROM kv_lists WHERE db = p_db AND key = p_key;
+		INSERT INTO kv_lists (db, key, idx, value)
+		SELECT p_db, p_key, v_max + t.i, t.v FROM unnest(p_values) WITH ORDINALITY AS t(v, i);
+		INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'list', NULL)
+		ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+	END IF;
+
+	SELECT count(*) INTO v_length FROM kv_lists WHERE db = p_db AND key = p_key;
+	RETURN v_length;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_sadd(p_db INTEGER, p_key TEXT, p_members BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_added BIGINT;
+BEGIN
+	PERFORM pk_check_type(p_db, p_key, 'set');
+
+	INSERT INTO kv_sets (db, key, member)
+	SELECT p_db, p_key, unnest(p_members)
+	ON CONFLICT (db, key, member) DO NOTHING;
+	GET DIAGNOSTICS v_added = ROW_COUNT;
+	INSERT INTO kv_meta (db, key, key_type, expires_at) VALUES (p_db, p_key, 'set', NULL)
+	ON CONFLICT (db, key) DO UPDATE SET key_type = EXCLUDED.key_type, expires_at = EXCLUDED.expires_at;
+	RETURN v_added;
+END;
+$$ LANGUAGE plpgsql;
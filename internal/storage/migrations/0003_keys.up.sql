@@ -0,0 +1,307 @@
+-- Key registry: every key has one row in kv_keys holding its type, expiry
+-- and WATCH version, and the type tables reference it by id with ON DELETE
+-- CASCADE instead of repeating db, key and expires_at. Deleting, expiring or
+-- renaming a key is a single-row change to kv_keys, and data can no longer
+-- outlive its key.
+--
+-- Where kv_meta and a type table disagreed about a key's expiry, kv_meta
+-- wins. Rows no live key of their table's type owns are dropped, as are
+-- keys left without data.
+
+-- The triggers are recreated once the tables have their new layout
+DROP TRIGGER IF EXISTS kv_meta_version ON kv_meta;
+DO $$
+DECLARE
+	t TEXT;
+	e TEXT;
+BEGIN
+	FOREACH t IN ARRAY ARRAY[
+		'kv_strings', 'kv_hashes', 'kv_lists', 'kv_sets', 'kv_zsets', 'kv_hyperloglog',
+		'kv_streams', 'kv_stream_meta', 'kv_stream_groups', 'kv_stream_consumers',
+		'kv_stream_pending', 'kv_json'
+	] LOOP
+		FOREACH e IN ARRAY ARRAY['ins', 'upd', 'del'] LOOP
+			EXECUTE format('DROP TRIGGER IF EXISTS %I ON %I', t || '_touch_' || e, t);
+		END LOOP;
+	END LOOP;
+END $$;
+
+ALTER TABLE kv_meta RENAME TO kv_keys;
+ALTER INDEX idx_kv_meta_expires RENAME TO idx_kv_keys_expires;
+ALTER TABLE kv_keys ADD COLUMN id BIGSERIAL;
+ALTER TABLE kv_keys DROP CONSTRAINT kv_meta_pkey, ADD PRIMARY KEY (id), ADD UNIQUE (db, key);
+
+-- Register keys that have data but were missing from kv_meta
+INSERT INTO kv_keys (db, key, key_type, expires_at)
+SELECT db, key, 'string', expires_at FROM kv_strings
+UNION ALL SELECT db, key, 'hash', max(expires_at) FROM kv_hashes GROUP BY db, key
+UNION ALL SELECT db, key, 'list', max(expires_at) FROM kv_lists GROUP BY db, key
+UNION ALL SELECT db, key, 'set', max(expires_at) FROM kv_sets GROUP BY db, key
+UNION ALL SELECT db, key, 'zset', max(expires_at) FROM kv_zsets GROUP BY db, key
+UNION ALL SELECT db, key, 'hyperloglog', expires_at FROM kv_hyperloglog
+UNION ALL SELECT db, key, 'stream', NULL FROM kv_stream_meta
+UNION ALL SELECT db, key, 'ReJSON-RL', NULL FROM kv_json
+ON CONFLICT (db, key) DO NOTHING;
+
+-- Point every row at its key, then swap (db, key) for key_id
+DO $$
+DECLARE
+	t RECORD;
+BEGIN
+	FOR t IN SELECT * FROM (VALUES
+		('kv_strings', 'string', ''), ('kv_hashes', 'hash', ', field'), ('kv_lists', 'list', ', idx'),
+		('kv_sets', 'set', ', member'), ('kv_zsets', 'zset', ', member'), ('kv_hyperloglog', 'hyperloglog', ''),
+		('kv_streams', 'stream', ', ms, seq'), ('kv_stream_meta', 'stream', ''),
+		('kv_stream_groups', 'stream', ', group_name'), ('kv_stream_consumers', 'stream', ', group_name, consumer'),
+		('kv_stream_pending', 'stream', ', group_name, ms, seq'), ('kv_json', 'ReJSON-RL', '')
+	) AS v(name, key_type, pkey) LOOP
+		EXECUTE format('ALTER TABLE %I ADD COLUMN key_id BIGINT', t.name);
+		EXECUTE format(
+			'UPDATE %I d SET key_id = k.id FROM kv_keys k WHERE k.db = d.db AND k.key = d.key AND k.key_type = %L',
+			t.name, t.key_type
+		);
+		EXECUTE format('DELETE FROM %I WHERE key_id IS NULL', t.name);
+		EXECUTE format(
+			'ALTER TABLE %I DROP COLUMN db, DROP COLUMN key, DROP COLUMN IF EXISTS expires_at, '
+			'ALTER COLUMN key_id SET NOT NULL, ADD PRIMARY KEY (key_id%s), '
+			'ADD FOREIGN KEY (key_id) REFERENCES kv_keys(id) ON DELETE CASCADE',
+			t.name, t.pkey
+		);
+	END LOOP;
+END $$;
+CREATE INDEX idx_kv_zsets_score ON kv_zsets(key_id, score);
+CREATE INDEX idx_kv_stream_pending_consumer ON kv_stream_pending(key_id, group_name, consumer);
+
+DELETE FROM kv_keys k WHERE NOT CASE k.key_type
+	WHEN 'string' THEN EXISTS (SELECT 1 FROM kv_strings t WHERE t.key_id = k.id)
+	WHEN 'hash' THEN EXISTS (SELECT 1 FROM kv_hashes t WHERE t.key_id = k.id)
+	WHEN 'list' THEN EXISTS (SELECT 1 FROM kv_lists t WHERE t.key_id = k.id)
+	WHEN 'set' THEN EXISTS (SELECT 1 FROM kv_sets t WHERE t.key_id = k.id)
+	WHEN 'zset' THEN EXISTS (SELECT 1 FROM kv_zsets t WHERE t.key_id = k.id)
+	WHEN 'hyperloglog' THEN EXISTS (SELECT 1 FROM kv_hyperloglog t WHERE t.key_id = k.id)
+	WHEN 'stream' THEN EXISTS (SELECT 1 FROM kv_stream_meta t WHERE t.key_id = k.id)
+	WHEN 'ReJSON-RL' THEN EXISTS (SELECT 1 FROM kv_json t WHERE t.key_id = k.id)
+	ELSE false
+END;
+
+-- postkeys_key_id returns the id of the live key p_key of database p_db, or
+-- NULL if it doesn't exist or expired. Reads select a key's rows with
+-- WHERE key_id = postkeys_key_id($db, $key).
+CREATE OR REPLACE FUNCTION postkeys_key_id(p_db INTEGER, p_key TEXT) RETURNS BIGINT AS $$
+	SELECT id FROM kv_keys
+	WHERE db = p_db AND key = p_key AND (expires_at IS NULL OR expires_at > NOW())
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION postkeys_touch_keys() RETURNS trigger AS $$
+BEGIN
+	UPDATE kv_keys k SET version = nextval('kv_version_seq')
+	FROM (SELECT DISTINCT key_id FROM changed_rows) c
+	WHERE k.id = c.key_id;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Like in Redis, a hash, list, set or sorted set stops existing when its
+-- last member is removed
+CREATE OR REPLACE FUNCTION postkeys_drop_empty_keys() RETURNS trigger AS $$
+BEGIN
+	IF TG_TABLE_NAME = 'kv_hashes' THEN
+		DELETE FROM kv_keys k USING (SELECT DISTINCT key_id FROM changed_rows) c
+		WHERE k.id = c.key_id AND NOT EXISTS (SELECT 1 FROM kv_hashes t WHERE t.key_id = k.id);
+	ELSIF TG_TABLE_NAME = 'kv_lists' THEN
+		DELETE FROM kv_keys k USING (SELECT DISTINCT key_id FROM changed_rows) c
+		WHERE k.id = c.key_id AND NOT EXISTS (SELECT 1 FROM kv_lists t WHERE t.key_id = k.id);
+	ELSIF TG_TABLE_NAME = 'kv_sets' THEN
+		DELETE FROM kv_keys k USING (SELECT DISTINCT key_id FROM changed_rows) c
+		WHERE k.id = c.key_id AND NOT EXISTS (SELECT 1 FROM kv_sets t WHERE t.key_id = k.id);
+	ELSIF TG_TABLE_NAME = 'kv_zsets' THEN
+		DELETE FROM kv_keys k USING (SELECT DISTINCT key_id FROM changed_rows) c
+		WHERE k.id = c.key_id AND NOT EXISTS (SELECT 1 FROM kv_zsets t WHERE t.key_id = k.id);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER kv_keys_version BEFORE UPDATE ON kv_keys
+FOR EACH ROW WHEN (OLD.version = NEW.version) EXECUTE FUNCTION postkeys_meta_version();
+
+DO $$
+DECLARE
+	t TEXT;
+	e RECORD;
+BEGIN
+	FOREACH t IN ARRAY ARRAY[
+		'kv_strings', 'kv_hashes', 'kv_lists', 'kv_sets', 'kv_zsets', 'kv_hyperloglog',
+		'kv_streams', 'kv_stream_meta', 'kv_stream_groups', 'kv_stream_consumers',
+		'kv_stream_pending', 'kv_json'
+	] LOOP
+		FOR e IN SELECT * FROM (VALUES ('ins', 'INSERT', 'NEW'), ('upd', 'UPDATE', 'NEW'), ('del', 'DELETE', 'OLD')) AS v(name, event, transition) LOOP
+			EXECUTE format(
+				'CREATE TRIGGER %I AFTER %s ON %I REFERENCING %s TABLE AS changed_rows FOR EACH STATEMENT EXECUTE FUNCTION postkeys_touch_keys()',
+				t || '_touch_' || e.name, e.event, t, e.transition
+			);
+		END LOOP;
+	END LOOP;
+
+	FOREACH t IN ARRAY ARRAY['kv_hashes', 'kv_lists', 'kv_sets', 'kv_zsets'] LOOP
+		EXECUTE format(
+			'CREATE TRIGGER %I AFTER DELETE ON %I REFERENCING OLD TABLE AS changed_rows FOR EACH STATEMENT EXECUTE FUNCTION postkeys_drop_empty_keys()',
+			t || '_drop_empty', t
+		);
+	END LOOP;
+END $$;
+
+-- The command functions of 0002_functions, on the new layout. pk_key_id
+-- replaces pk_check_type: it also registers the key, and its row lock
+-- replaces the advisory lock pk_lpush and pk_rpush took.
+DROP FUNCTION pk_check_type(INTEGER, TEXT, TEXT);
+
+-- pk_key_id returns the id of key p_key, registering it as a key of
+-- p_key_type if it doesn't exist or expired, and raises WRONGTYPE if it
+-- holds another type. The key's row stays locked until the transaction ends.
+CREATE OR REPLACE FUNCTION pk_key_id(p_db INTEGER, p_key TEXT, p_key_type TEXT) RETURNS BIGINT AS $$
+DECLARE
+	v_id BIGINT;
+	v_type TEXT;
+	v_expired BOOLEAN;
+BEGIN
+	LOOP
+		INSERT INTO kv_keys (db, key, key_type) VALUES (p_db, p_key, p_key_type)
+		ON CONFLICT (db, key) DO UPDATE SET key_type = kv_keys.key_type
+		RETURNING id, key_type, coalesce(expires_at <= NOW(), false) INTO v_id, v_type, v_expired;
+		EXIT WHEN NOT v_expired;
+		DELETE FROM kv_keys WHERE id = v_id;
+	END LOOP;
+	IF v_type <> p_key_type THEN
+		RAISE EXCEPTION 'WRONGTYPE Operation against a key holding the wrong kind of value';
+	END IF;
+	RETURN v_id;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_set(p_db INTEGER, p_key TEXT, p_value BYTEA, p_expires_at TIMESTAMPTZ) RETURNS VOID AS $$
+DECLARE
+	v_id BIGINT;
+BEGIN
+	DELETE FROM kv_keys WHERE db = p_db AND key = p_key;
+	INSERT INTO kv_keys (db, key, key_type, expires_at) VALUES (p_db, p_key, 'string', p_expires_at)
+	RETURNING id INTO v_id;
+	INSERT INTO kv_strings (key_id, value) VALUES (v_id, p_value);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_incr(p_db INTEGER, p_key TEXT, p_delta BIGINT) RETURNS BIGINT AS $$
+DECLARE
+	v_id BIGINT;
+	v_text TEXT;
+	v_current NUMERIC := 0;
+	v_result NUMERIC;
+BEGIN
+	v_id := pk_key_id(p_db, p_key, 'string');
+	SELECT encode(value, 'escape') INTO v_text FROM kv_strings WHERE key_id = v_id;
+	IF FOUND THEN
+		IF v_text !~ '^[+-]?[0-9]+$' THEN
+			RAISE EXCEPTION 'value is not an integer';
+		END IF;
+		v_current := v_text::numeric;
+		IF v_current NOT BETWEEN -9223372036854775808 AND 9223372036854775807 THEN
+			RAISE EXCEPTION 'value is not an integer';
+		END IF;
+	END IF;
+
+	v_result := v_current + p_delta;
+	IF v_result NOT BETWEEN -9223372036854775808 AND 9223372036854775807 THEN
+		RAISE EXCEPTION 'increment or decrement would overflow';
+	END IF;
+
+	INSERT INTO kv_strings (key_id, value) VALUES (v_id, convert_to(v_result::text, 'UTF8'))
+	ON CONFLICT (key_id) DO UPDATE SET value = EXCLUDED.value;
+	RETURN v_result;
+END;
+$$ LANGUAGE plpgsql;
+
+-- pk_hset returns the number of fields added
+CREATE OR REPLACE FUNCTION pk_hset(p_db INTEGER, p_key TEXT, p_fields TEXT[], p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_id BIGINT;
+	v_existing BIGINT;
+BEGIN
+	v_id := pk_key_id(p_db, p_key, 'hash');
+	SELECT count(*) INTO v_existing FROM kv_hashes WHERE key_id = v_id AND field = ANY(p_fields);
+	INSERT INTO kv_hashes (key_id, field, value)
+	SELECT v_id, f, v FROM unnest(p_fields, p_values) AS t(f, v)
+	ON CONFLICT (key_id, field) DO UPDATE SET value = EXCLUDED.value;
+	RETURN cardinality(p_fields) - v_existing;
+END;
+$$ LANGUAGE plpgsql;
+
+-- pk_list_id returns the id of list p_key for a push of p_values: the key
+-- is only registered when there are values to push
+CREATE OR REPLACE FUNCTION pk_list_id(p_db INTEGER, p_key TEXT, p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_id BIGINT;
+	v_type TEXT;
+BEGIN
+	IF coalesce(cardinality(p_values), 0) > 0 THEN
+		RETURN pk_key_id(p_db, p_key, 'list');
+	END IF;
+	SELECT id, key_type INTO v_id, v_type FROM kv_keys WHERE id = postkeys_key_id(p_db, p_key);
+	IF v_type <> 'list' THEN
+		RAISE EXCEPTION 'WRONGTYPE Operation against a key holding the wrong kind of value';
+	END IF;
+	RETURN v_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- pk_lpush and pk_rpush return the length of the list; the first value
+-- pushed is the first to end up at the head or tail
+CREATE OR REPLACE FUNCTION pk_lpush(p_db INTEGER, p_key TEXT, p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_id BIGINT;
+	v_min BIGINT;
+	v_length BIGINT;
+BEGIN
+	v_id := pk_list_id(p_db, p_key, p_values);
+	IF coalesce(cardinality(p_values), 0) > 0 THEN
+		SELECT COALESCE(MIN(idx), 0) INTO v_min FROM kv_lists WHERE key_id = v_id;
+		INSERT INTO kv_lists (key_id, idx, value)
+		SELECT v_id, v_min - t.i, t.v FROM unnest(p_values) WITH ORDINALITY AS t(v, i);
+	END IF;
+
+	SELECT count(*) INTO v_length FROM kv_lists WHERE key_id = v_id;
+	RETURN v_length;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_rpush(p_db INTEGER, p_key TEXT, p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_id BIGINT;
+	v_max BIGINT;
+	v_length BIGINT;
+BEGIN
+	v_id := pk_list_id(p_db, p_key, p_values);
+	IF coalesce(cardinality(p_values), 0) > 0 THEN
+		SELECT COALESCE(MAX(idx), -1) INTO v_max FROM kv_lists WHERE key_id = v_id;
+		INSERT INTO kv_lists (key_id, idx, value)
+		SELECT v_id, v_max + t.i, t.v FROM unnest(p_values) WITH ORDINALITY AS t(v, i);
+	END IF;
+
+	SELECT count(*) INTO v_length FROM kv_lists WHERE key_id = v_id;
+	RETURN v_length;
+END;
+$$ LANGUAGE plpgsql;
+
+-- pk_sadd returns the number of members added
+CREATE OR REPLACE FUNCTION pk_sadd(p_db INTEGER, p_key TEXT, p_members BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_id BIGINT;
+	v_added BIGINT;
+BEGIN
+	v_id := pk_key_id(p_db, p_key, 'set');
+	INSERT INTO kv_sets (key_id, member)
+	SELECT v_id, unnest(p_members)
+	ON CONFLICT (key_id, member) DO NOTHING;
+	GET DIAGNOSTICS v_added = ROW_COUNT;
+	RETURN v_added;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,50 @@
+-- Packs lists back into consecutive positions and restores the pk_lpush and
+-- pk_rpush of 0003_keys
+DROP TABLE kv_list_meta;
+
+ALTER TABLE kv_lists DROP CONSTRAINT kv_lists_pkey;
+UPDATE kv_lists l SET idx = r.pos
+FROM (
+	SELECT key_id, idx, row_number() OVER (PARTITION BY key_id ORDER BY idx) - 1 AS pos
+	FROM kv_lists
+) r
+WHERE l.key_id = r.key_id AND l.idx = r.idx;
+ALTER TABLE kv_lists ADD PRIMARY KEY (key_id, idx);
+
+-- pk_lpush and pk_rpush return the length of the list; the first value
+-- pushed is the first to end up at the head or tail
+CREATE OR REPLACE FUNCTION pk_lpush(p_db INTEGER, p_key TEXT, p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_id BIGINT;
+	v_min BIGINT;
+	v_length BIGINT;
+BEGIN
+	v_id := pk_list_id(p_db, p_key, p_values);
+	IF coalesce(cardinality(p_values), 0) > 0 THEN
+		SELECT COALESCE(MIN(idx), 0) INTO v_min FROM kv_lists WHERE key_id = v_id;
+		INSERT INTO kv_lists (key_id, idx, value)
+		SELECT v_id, v_min - t.i, t.v FROM unnest(p_values) WITH ORDINALITY AS t(v, i);
+	END IF;
+
+	SELECT count(*) INTO v_length FROM kv_lists WHERE key_id = v_id;
+	RETURN v_length;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_rpush(p_db INTEGER, p_key TEXT, p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_id BIGINT;
+	v_max BIGINT;
+	v_length BIGINT;
+BEGIN
+	v_id := pk_list_id(p_db, p_key, p_values);
+	IF coalesce(cardinality(p_values), 0) > 0 THEN
+		SELECT COALESCE(MAX(idx), -1) INTO v_max FROM kv_lists WHERE key_id = v_id;
+		INSERT INTO kv_lists (key_id, idx, value)
+		SELECT v_id, v_max + t.i, t.v FROM unnest(p_values) WITH ORDINALITY AS t(v, i);
+	END IF;
+
+	SELECT count(*) INTO v_length FROM kv_lists WHERE key_id = v_id;
+	RETURN v_length;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,74 @@
+-- Gap-indexed lists: elements are stored 65536 positions apart, so LINSERT
+-- can take a position between two neighbours instead of renumbering the
+-- rest of the list. kv_list_meta keeps each list's length and the positions
+-- of its head and tail. While a list is dense, its elements sit exactly
+-- 65536 positions apart from head to tail, so the element at any index is
+-- found by its position.
+CREATE TABLE kv_list_meta (
+	key_id BIGINT PRIMARY KEY REFERENCES kv_keys(id) ON DELETE CASCADE,
+	head BIGINT NOT NULL,
+	tail BIGINT NOT NULL,
+	length BIGINT NOT NULL,
+	dense BOOLEAN NOT NULL DEFAULT true
+);
+
+-- Spread existing lists out. The primary key is dropped meanwhile, as the
+-- new positions of some elements are the old ones of others.
+ALTER TABLE kv_lists DROP CONSTRAINT kv_lists_pkey;
+UPDATE kv_lists l SET idx = r.pos
+FROM (
+	SELECT key_id, idx, (row_number() OVER (PARTITION BY key_id ORDER BY idx) - 1) * 65536 AS pos
+	FROM kv_lists
+) r
+WHERE l.key_id = r.key_id AND l.idx = r.idx;
+ALTER TABLE kv_lists ADD PRIMARY KEY (key_id, idx);
+
+INSERT INTO kv_list_meta (key_id, head, tail, length)
+SELECT key_id, min(idx), max(idx), count(*) FROM kv_lists GROUP BY key_id;
+
+-- pk_lpush and pk_rpush return the length of the list; the first value
+-- pushed is the first to end up at the head or tail. An empty list's head
+-- and tail are 0 and -65536, so pushes start from position 0.
+CREATE OR REPLACE FUNCTION pk_lpush(p_db INTEGER, p_key TEXT, p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_id BIGINT;
+	v_count BIGINT := coalesce(cardinality(p_values), 0);
+	v_head BIGINT;
+	v_length BIGINT;
+BEGIN
+	v_id := pk_list_id(p_db, p_key, p_values);
+	IF v_count > 0 THEN
+		INSERT INTO kv_list_meta AS m (key_id, head, tail, length)
+		VALUES (v_id, -v_count * 65536, -65536, v_count)
+		ON CONFLICT (key_id) DO UPDATE SET head = m.head - v_count * 65536, length = m.length + v_count
+		RETURNING head INTO v_head;
+		INSERT INTO kv_lists (key_id, idx, value)
+		SELECT v_id, v_head + (v_count - t.i) * 65536, t.v FROM unnest(p_values) WITH ORDINALITY AS t(v, i);
+	END IF;
+
+	SELECT length INTO v_length FROM kv_list_meta WHERE key_id = v_id;
+	RETURN coalesce(v_length, 0);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION pk_rpush(p_db INTEGER, p_key TEXT, p_values BYTEA[]) RETURNS BIGINT AS $$
+DECLARE
+	v_id BIGINT;
+	v_count BIGINT := coalesce(cardinality(p_values), 0);
+	v_tail BIGINT;
+	v_length BIGINT;
+BEGIN
+	v_id := pk_list_id(p_db, p_key, p_values);
+	IF v_count > 0 THEN
+		INSERT INTO kv_list_meta AS m (key_id, head, tail, length)
+		VALUES (v_id, 0, (v_count - 1) * 65536, v_count)
+		ON CONFLICT (key_id) DO UPDATE SET tail = m.tail + v_count * 65536, length = m.length + v_count
+		RETURNING tail INTO v_tail;
+		INSERT INTO kv_lists (key_id, idx, value)
+		SELECT v_id, v_tail - (v_count - t.i) * 65536, t.v FROM unnest(p_values) WITH ORDINALITY AS t(v, i);
+	END IF;
+
+	SELECT length INTO v_length FROM kv_list_meta WHERE key_id = v_id;
+	RETURN coalesce(v_length, 0);
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,10 @@
+-- Restores kv_scan_cursors; scans in progress must start over
+DROP INDEX idx_kv_keys_scan, idx_kv_hashes_scan, idx_kv_sets_scan, idx_kv_zsets_scan;
+DROP FUNCTION postkeys_scan_hash(TEXT), postkeys_scan_hash(BYTEA);
+
+CREATE TABLE kv_scan_cursors (
+	id BIGSERIAL PRIMARY KEY,
+	position BYTEA NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_kv_scan_cursors_created ON kv_scan_cursors(created_at);
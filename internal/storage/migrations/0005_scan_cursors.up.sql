@@ -0,0 +1,18 @@
+-- SCAN cursors carry the position a scan resumes at instead of pointing at a
+-- kv_scan_cursors row: scans page through keys, fields and members in order
+-- of postkeys_scan_hash, and a cursor is the hash its page starts at. The
+-- indexes make each page a range scan.
+CREATE FUNCTION postkeys_scan_hash(v TEXT) RETURNS BIGINT AS $$
+	SELECT hashtextextended(v, 0)
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+CREATE FUNCTION postkeys_scan_hash(v BYTEA) RETURNS BIGINT AS $$
+	SELECT ('x' || left(md5(v), 16))::bit(64)::bigint
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+CREATE INDEX idx_kv_keys_scan ON kv_keys (db, postkeys_scan_hash(key));
+CREATE INDEX idx_kv_hashes_scan ON kv_hashes (key_id, postkeys_scan_hash(field));
+CREATE INDEX idx_kv_sets_scan ON kv_sets (key_id, postkeys_scan_hash(member));
+CREATE INDEX idx_kv_zsets_scan ON kv_zsets (key_id, postkeys_scan_hash(member));
+
+DROP TABLE kv_scan_cursors;
@@ -0,0 +1,6 @@
+-- Sparse lists go back to walking their elements to find an index
+DROP TRIGGER kv_lists_blocks_ins ON kv_lists;
+DROP TRIGGER kv_lists_blocks_upd ON kv_lists;
+DROP TRIGGER kv_lists_blocks_del ON kv_lists;
+DROP FUNCTION postkeys_count_list_blocks();
+DROP TABLE kv_list_blocks;
@@ -0,0 +1,60 @@
+-- kv_list_blocks counts the elements of each list by block of 2^26
+-- positions, 1024 elements of a dense list. Once LINSERT or LREM left a list
+-- sparse, the element at an index is found by adding up the counts of the
+-- blocks before it, then stepping through the one block it falls in,
+-- instead of stepping through every element before it.
+CREATE TABLE kv_list_blocks (
+	key_id BIGINT NOT NULL REFERENCES kv_keys(id) ON DELETE CASCADE,
+	block BIGINT NOT NULL,
+	length INTEGER NOT NULL,
+	PRIMARY KEY (key_id, block)
+);
+
+INSERT INTO kv_list_blocks (key_id, block, length)
+SELECT key_id, idx >> 26, count(*) FROM kv_lists GROUP BY 1, 2;
+
+-- The counts follow every change to kv_lists. Deletes only update existing
+-- counts: when a key is deleted, its blocks may be gone before its
+-- elements.
+CREATE FUNCTION postkeys_count_list_blocks() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'INSERT' THEN
+		INSERT INTO kv_list_blocks AS b (key_id, block, length)
+		SELECT key_id, idx >> 26, count(*) FROM new_rows GROUP BY 1, 2
+		ON CONFLICT (key_id, block) DO UPDATE SET length = b.length + excluded.length;
+		RETURN NULL;
+	END IF;
+
+	IF TG_OP = 'UPDATE' THEN
+		INSERT INTO kv_list_blocks AS b (key_id, block, length)
+		SELECT key_id, block, sum(n) FROM (
+			SELECT key_id, idx >> 26 AS block, 1 AS n FROM new_rows
+			UNION ALL
+			SELECT key_id, idx >> 26, -1 FROM old_rows
+		) c
+		GROUP BY 1, 2 HAVING sum(n) <> 0
+		ON CONFLICT (key_id, block) DO UPDATE SET length = b.length + excluded.length;
+	ELSE
+		UPDATE kv_list_blocks b SET length = b.length - c.n
+		FROM (SELECT key_id, idx >> 26 AS block, count(*) AS n FROM old_rows GROUP BY 1, 2) c
+		WHERE b.key_id = c.key_id AND b.block = c.block;
+	END IF;
+
+	DELETE FROM kv_list_blocks b
+	USING (SELECT DISTINCT key_id, idx >> 26 AS block FROM old_rows) c
+	WHERE b.key_id = c.key_id AND b.block = c.block AND b.length = 0;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER kv_lists_blocks_ins AFTER INSERT ON kv_lists
+REFERENCING NEW TABLE AS new_rows
+FOR EACH STATEMENT EXECUTE FUNCTION postkeys_count_list_blocks();
+
+CREATE TRIGGER kv_lists_blocks_upd AFTER UPDATE ON kv_lists
+REFERENCING OLD TABLE AS old_rows NEW TABLE AS new_rows
+FOR EACH STATEMENT EXECUTE FUNCTION postkeys_count_list_blocks();
+
+CREATE TRIGGER kv_lists_blocks_del AFTER DELETE ON kv_lists
+REFERENCING OLD TABLE AS old_rows
+FOR EACH STATEMENT EXECUTE FUNCTION postkeys_count_list_blocks();
@@ -0,0 +1,2 @@
+-- Pub/sub goes back to copying every message to the broadcast channel
+DROP TABLE kv_pattern_listeners;
@@ -0,0 +1,7 @@
+-- Hubs with pattern subscriptions register the backend of their LISTEN
+-- connection here, and every message is only copied to the pub/sub broadcast
+-- channel while a row exists. Rows of sessions that went away are cleared by
+-- the next hub registering; the table is unlogged, as a crash ends them all.
+CREATE UNLOGGED TABLE kv_pattern_listeners (
+	pid INTEGER PRIMARY KEY
+);
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// With Config.PgBouncer set, the pools work through PgBouncer in transaction
+// pooling mode, where each transaction may run on a different server
+// connection. Queries use the simple protocol instead of named prepared
+// statements, which would be missing on the next server connection, and no
+// session parameters are set. Connections that need a session of their own
+// (LISTEN, and the sweeper and migration advisory locks) use
+// Config.ListenDSN, which should reach PostgreSQL directly; New fails unless
+// notifications reach it.
+
+const (
+	// listenCheckChannel is the channel New notifies to check LISTEN
+	listenCheckChannel = "postkeys_listen_check"
+
+	// listenCheckTimeout is how long New waits for the check's notification
+	listenCheckTimeout = 5 * time.Second
+)
+
+// checkListen verifies that a LISTEN connection with the Store's session
+// configuration receives a notification sent through the pool, which it
+// doesn't if PgBouncer hands its session to other clients
+func (s *Store) checkListen(ctx context.Context) error {
+	conn, err := pgx.ConnectConfig(ctx, s.connConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+listenCheckChannel); err != nil {
+		return fmt.Errorf("failed to LISTEN: %w", err)
+	}
+	// Other pods may be checking too, so only our own payload counts
+	token := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if _, err := s.pool.Exec(ctx, "SELECT pg_notify($1, $2)", listenCheckChannel, token); err != nil {
+		return fmt.Errorf("failed to NOTIFY: %w", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, listenCheckTimeout)
+	defer cancel()
+	for {
+		n, err := conn.WaitForNotification(waitCtx)
+		if err != nil {
+			return fmt.Errorf("no notification received within %v: %w", listenCheckTimeout, err)
+		}
+		if n.Payload == token {
+			return nil
+		}
+	}
+}
+
+// checkSearchPath verifies that pool connections find their tables in
+// schema. Through PgBouncer the search_path can't be set per connection, so
+// it has to come from the role or database settings.
+func (s *Store) checkSearchPath(ctx context.Context, schema string) error {
+	var current *string
+	if err := s.pool.QueryRow(ctx, "SELECT current_schema()").Scan(&current); err != nil {
+		return fmt.Errorf("failed to check the search_path: %w", err)
+	}
+	if current == nil || *current != schema {
+		return fmt.Errorf("schema %s must come first in the search_path of connections through PgBouncer (ALTER ROLE ... SET search_path = %s)", schema, schema)
+	}
+	return nil
+}
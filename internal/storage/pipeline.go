@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// A client pipelining commands sends many before reading any reply. The
+// server collects runs of simple commands into a Batch, which SendBatch
+// sends as one pgx.Batch: a single round trip, run by PostgreSQL as one
+// implicit transaction. A Batch only holds commands that take a single
+// statement, reads and the writes backed by the pk_* functions (see
+// functions.go), so no statement depends on another's result.
+
+// ErrBatchRolledBack wraps the error of a statement PostgreSQL rejected in a
+// batch, like a WRONGTYPE raised by a pk_* function, which rolled back every
+// command of the batch
+var ErrBatchRolledBack = errors.New("batch rolled back")
+
+// Batcher is implemented by backends that can run a Batch in one round trip
+type Batcher interface {
+	// NewBatch returns an empty Batch for the database selected in ctx, or
+	// nil if batches can't run, like when a wrapped backend isn't a Batcher
+	NewBatch(ctx context.Context) *Batch
+
+	// SendBatch runs b's commands and passes their results to their
+	// callbacks. On error none of them took effect, and no callback was run.
+	SendBatch(ctx context.Context, b *Batch) error
+}
+
+// Batch queues commands for SendBatch
+type Batch struct {
+	ops       queryOps
+	functions bool // writes can be queued
+	batch     pgx.Batch
+	keys      []string
+	writes    bool
+	written   []string
+	onGet     func(key, value string, found bool)
+
+	// done pass each command's result to its callback and queue its keyspace
+	// notifications, once the batch committed
+	done []func(notify *pgx.Batch)
+}
+
+// NewBatch returns an empty Batch for the database selected in ctx
+func (s *Store) NewBatch(ctx context.Context) *Batch {
+	return &Batch{ops: s.ops(ctx), functions: s.functions}
+}
+
+// Len returns the number of queued commands
+func (b *Batch) Len() int {
+	return len(b.done)
+}
+
+// CanWrite reports whether writes can be queued, which needs the pk_*
+// functions
+func (b *Batch) CanWrite() bool {
+	return b.functions
+}
+
+// OnGet sets fn to be passed the result of every GET once the batch
+// committed, so a cache in front of the store can keep it
+func (b *Batch) OnGet(fn func(key, value string, found bool)) {
+	b.onGet = fn
+}
+
+// Written returns the keys of the batch's writes
+func (b *Batch) Written() []string {
+	return b.written
+}
+
+// queue adds the statement of a command on key, whose row scan reads; done
+// runs once the batch committed
+func (b *Batch) queue(key string, write bool, scan func(row pgx.Row) error, done func(notify *pgx.Batch), sql string, args ...any) {
+	b.batch.Queue(sql, args...).QueryRow(scan)
+	b.keys = append(b.keys, key)
+	b.writes = b.writes || write
+	if write {
+		b.written = append(b.written, key)
+	}
+	b.done = append(b.done, done)
+}
+
+// Get queues GET key
+func (b *Batch) Get(key string, fn func(value string, found bool)) {
+	var value []byte
+	var found bool
+	b.queue(key, false,
+		func(row pgx.Row) error {
+			found = true
+			err := row.Scan(&value)
+			if err == pgx.ErrNoRows {
+				found = false
+				return nil
+			}
+			return err
+		},
+		func(notify *pgx.Batch) {
+			if !found {
+				b.ops.queueNotify(notify, notifyKeyMiss, "keymiss", key)
+			}
+			if b.onGet != nil {
+				b.onGet(key, string(value), found)
+			}
+			fn(string(value), found)
+		},
+		"SELECT value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+		key, b.ops.db,
+	)
+}
+
+// HGet queues HGET key field
+func (b *Batch) HGet(key, field string, fn func(value string, found bool)) {
+	var value []byte
+	var found bool
+	b.queue(key, false,
+		func(row pgx.Row) error {
+			found = true
+			err := row.Scan(&value)
+			if err == pgx.ErrNoRows {
+				found = false
+				return nil
+			}
+			return err
+		},
+		func(*pgx.Batch) { fn(string(value), found) },
+		"SELECT value FROM kv_hashes WHERE key_id = postkeys_key_id($3, $1) AND field = $2",
+		key, encodeField(field), b.ops.db,
+	)
+}
+
+// Set queues SET key value, without a TTL. Writes need CanWrite.
+func (b *Batch) Set(key, value string, fn func()) {
+	b.queue(key, true,
+		func(row pgx.Row) error { return row.Scan(nil) },
+		func(notify *pgx.Batch) {
+			b.ops.queueNotify(notify, notifyString, "set", key)
+			fn()
+		},
+		"SELECT pk_set($1, $2, $3, NULL)", b.ops.db, key, []byte(value),
+	)
+}
+
+// Incr queues INCRBY key delta
+func (b *Batch) Incr(key string, delta int64, fn func(result int64)) {
+	var result int64
+	b.queue(key, true,
+		func(row pgx.Row) error { return row.Scan(&result) },
+		func(notify *pgx.Batch) {
+			b.ops.queueNotify(notify, notifyString, "incrby", key)
+			fn(result)
+		},
+		"SELECT pk_incr($1, $2, $3)", b.ops.db, key, delta,
+	)
+}
+
+// HSet queues HSET key with fields, which must not be empty
+func (b *Batch) HSet(key string, fields map[string]string, fn func(added int64)) {
+	fieldNames := make([]string, 0, len(fields))
+	fieldValues := make([][]byte, 0, len(fields))
+	for field, value := range fields {
+		fieldNames = append(fieldNames, encodeField(field))
+		fieldValues = append(fieldValues, []byte(value))
+	}
+
+	var added int64
+	b.queue(key, true,
+		func(row pgx.Row) error { return row.Scan(&added) },
+		func(notify *pgx.Batch) {
+			b.ops.queueNotify(notify, notifyHash, "hset", key)
+			fn(added)
+		},
+		"SELECT pk_hset($1, $2, $3::text[], $4::bytea[])", b.ops.db, key, fieldNames, fieldValues,
+	)
+}
+
+// LPush queues LPUSH key with values, which must not be empty
+func (b *Batch) LPush(key string, values []string, fn func(length int64)) {
+	b.push("lpush", key, values, fn)
+}
+
+// RPush queues RPUSH key with values, which must not be empty
+func (b *Batch) RPush(key string, values []string, fn func(length int64)) {
+	b.push("rpush", key, values, fn)
+}
+
+// push queues pk_lpush or pk_rpush, for event "lpush" or "rpush"
+func (b *Batch) push(event, key string, values []string, fn func(length int64)) {
+	valueBytes := make([][]byte, len(values))
+	for i, value := range values {
+		valueBytes[i] = []byte(value)
+	}
+
+	var length int64
+	b.queue(key, true,
+		func(row pgx.Row) error { return row.Scan(&length) },
+		func(notify *pgx.Batch) {
+			b.ops.queueNotify(notify, notifyList, event, key)
+			fn(length)
+		},
+		"SELECT pk_"+event+"($1, $2, $3::bytea[])", b.ops.db, key, valueBytes,
+	)
+}
+
+// SAdd queues SADD key with members, which must not be empty
+func (b *Batch) SAdd(key string, members []string, fn func(added int64)) {
+	memberBytes := make([][]byte, len(members))
+	for i, m := range members {
+		memberBytes[i] = []byte(m)
+	}
+
+	var added int64
+	b.queue(key, true,
+		func(row pgx.Row) error { return row.Scan(&added) },
+		func(notify *pgx.Batch) {
+			if added > 0 {
+				b.ops.queueNotify(notify, notifySet, "sadd", key)
+			}
+			fn(added)
+		},
+		"SELECT pk_sadd($1, $2, $3::bytea[])", b.ops.db, key, memberBytes,
+	)
+}
+
+// SendBatch runs b in one round trip: on the replica if it only reads and
+// ctx allows it, else on the pool for the durability class of its keys.
+// Keyspace notifications follow in a second batch once it committed.
+func (s *Store) SendBatch(ctx context.Context, b *Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	if b.writes && !b.functions {
+		return fmt.Errorf("batched writes need the pk_* functions")
+	}
+
+	ctx = WithKeys(ctx, b.keys)
+	pool := s.poolFor(ctx)
+	if !b.writes && !s.events.enabled(notifyKeyMiss) {
+		pool = s.readerPool(ctx)
+	}
+
+	start := time.Now()
+	err := RetryTx(ctx, "pipeline", func() error {
+		return pool.SendBatch(ctx, &b.batch).Close()
+	})
+	if s.sqlTraceLevel > 0 {
+		duration := time.Since(start)
+		if err != nil {
+			log.Printf("[SQLTRACE] batch of %d commands -> ERROR: %v (%v)", b.Len(), err, duration)
+		} else if s.sqlTraceLevel >= 2 {
+			log.Printf("[SQLTRACE] batch of %d commands (%v)", b.Len(), duration)
+		}
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			return fmt.Errorf("%w: %w", ErrBatchRolledBack, funcError(err))
+		}
+		return err
+	}
+
+	notify := &pgx.Batch{}
+	for _, done := range b.done {
+		done(notify)
+	}
+	if notify.Len() > 0 {
+		if err := s.pool.SendBatch(ctx, notify).Close(); err != nil {
+			log.Printf("Failed to send keyspace notifications: %v", err)
+		}
+	}
+	return nil
+}
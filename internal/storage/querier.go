@@ -3,9 +3,7 @@ package storage
 
 import (
 	"context"
-	"crypto/sha256"
 	"encoding/base64"
-	"encoding/hex"
 	"fmt"
 	"strconv"
 	"strings"
@@ -14,6 +12,7 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mnorrsken/postkeys/internal/stringmatch"
 )
 
 // Querier is the common interface implemented by both pgxpool.Pool and pgx.Tx
@@ -46,42 +45,27 @@ func decodeField(field string) string {
 	return field
 }
 
-// keyspaceChannelPrefix is the prefix for keyspace notification channels
-const keyspaceChannelPrefix = "__keyspace@0__:"
-
-// maxPgChannelLen is the maximum length for PostgreSQL NOTIFY channel names (NAMEDATALEN - 1)
-const maxPgChannelLen = 63
-
-// keyspaceChannel creates a safe channel name for keyspace notifications.
-// PostgreSQL channel names are limited to 63 bytes. For long keys, we use
-// a hash suffix to ensure uniqueness while staying within the limit.
-func keyspaceChannel(key string) string {
-	channel := keyspaceChannelPrefix + key
-	if len(channel) <= maxPgChannelLen {
-		return channel
-	}
-
-	// For long keys, use: prefix + truncated key + hash suffix
-	// Hash suffix is 8 chars (short hex hash) + 1 for separator = 9 chars
-	// Available for key: 63 - 14 (prefix) - 9 (hash suffix) = 40 chars
-	hash := sha256.Sum256([]byte(key))
-	hashSuffix := ":" + hex.EncodeToString(hash[:4]) // 8 hex chars
-	maxKeyLen := maxPgChannelLen - len(keyspaceChannelPrefix) - len(hashSuffix)
-
-	return keyspaceChannelPrefix + key[:maxKeyLen] + hashSuffix
-}
-
 // queryOps provides the actual implementation of storage operations using a Querier.
-// This is shared between Store (using pool) and TxStore (using tx).
-type queryOps struct{}
+// This is shared between Store (using pool) and TxStore (using tx). Every query
+// is scoped to the logical database db, and writes send keyspace notifications
+// through events.
+type queryOps struct {
+	db     int
+	events *keyspaceEvents
+}
 
 // ============== Helper Methods ==============
 
-func (queryOps) getKeyType(ctx context.Context, q Querier, key string) (KeyType, error) {
+// Every key has a row in kv_keys holding its type and expiry, which the rows
+// of the type tables reference by key_id. Reads select a key's rows with
+// key_id = postkeys_key_id(db, key), which is NULL once the key expired.
+// Deleting the kv_keys row deletes the key's data with it.
+
+func (o queryOps) getKeyType(ctx context.Context, q Querier, key string) (KeyType, error) {
 	var keyType string
 	err := q.QueryRow(ctx,
-		"SELECT key_type FROM kv_meta WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT key_type FROM kv_keys WHERE db = $2 AND key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
+		key, o.db,
 	).Scan(&keyType)
 
 	if err == pgx.ErrNoRows {
@@ -93,65 +77,69 @@ func (queryOps) getKeyType(ctx context.Context, q Querier, key string) (KeyType,
 	return KeyType(keyType), nil
 }
 
-func (queryOps) setMeta(ctx context.Context, q Querier, key string, keyType KeyType, expiresAt *time.Time) error {
-	_, err := q.Exec(ctx,
-		`INSERT INTO kv_meta (key, key_type, expires_at) VALUES ($1, $2, $3)
-		 ON CONFLICT (key) DO UPDATE SET key_type = $2, expires_at = $3`,
-		key, string(keyType), expiresAt,
-	)
-	return err
+// keyID returns the id of key, registering it as a key of keyType if it
+// doesn't exist or expired, and fails with WRONGTYPE if it holds another
+// type. The key's row stays locked until the transaction ends, which
+// serializes writers to the key, so callers run in a transaction and only
+// call it when they are about to add data. Mirrors pk_key_id.
+func (o queryOps) keyID(ctx context.Context, q Querier, key string, keyType KeyType) (int64, error) {
+	for {
+		var id int64
+		var current string
+		var expired bool
+		err := q.QueryRow(ctx,
+			`INSERT INTO kv_keys (db, key, key_type) VALUES ($3, $1, $2)
+			 ON CONFLICT (db, key) DO UPDATE SET key_type = kv_keys.key_type
+			 RETURNING id, key_type, coalesce(expires_at <= NOW(), false)`,
+			key, string(keyType), o.db,
+		).Scan(&id, &current, &expired)
+		if err != nil {
+			return 0, err
+		}
+		if expired {
+			if _, err := q.Exec(ctx, "DELETE FROM kv_keys WHERE id = $1", id); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if KeyType(current) != keyType {
+			return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		return id, nil
+	}
 }
 
-// setMetaBatch sets metadata for multiple keys at once
-func (queryOps) setMetaBatch(ctx context.Context, q Querier, keys []string, keyType KeyType) error {
-	if len(keys) == 0 {
-		return nil
+// newKey replaces key, whatever it held, with an empty key of keyType
+// expiring at expiresAt, and returns its id
+func (o queryOps) newKey(ctx context.Context, q Querier, key string, keyType KeyType, expiresAt *time.Time) (int64, error) {
+	if err := o.deleteKeys(ctx, q, []string{key}); err != nil {
+		return 0, err
 	}
+	var id int64
+	err := q.QueryRow(ctx,
+		"INSERT INTO kv_keys (db, key, key_type, expires_at) VALUES ($4, $1, $2, $3) RETURNING id",
+		key, string(keyType), expiresAt, o.db,
+	).Scan(&id)
+	return id, err
+}
+
+// putString stores value as the string of the key with id
+func (o queryOps) putString(ctx context.Context, q Querier, id int64, value []byte) error {
 	_, err := q.Exec(ctx,
-		`INSERT INTO kv_meta (key, key_type)
-		 SELECT unnest($1::text[]), $2
-		 ON CONFLICT (key) DO UPDATE SET key_type = EXCLUDED.key_type`,
-		keys, string(keyType),
+		`INSERT INTO kv_strings (key_id, value) VALUES ($1, $2)
+		 ON CONFLICT (key_id) DO UPDATE SET value = EXCLUDED.value`,
+		id, value,
 	)
 	return err
 }
 
-func (queryOps) deleteKeyFromAllTables(ctx context.Context, q Querier, key string) error {
-	queries := []string{
-		"DELETE FROM kv_strings WHERE key = $1",
-		"DELETE FROM kv_hashes WHERE key = $1",
-		"DELETE FROM kv_lists WHERE key = $1",
-		"DELETE FROM kv_sets WHERE key = $1",
-		"DELETE FROM kv_zsets WHERE key = $1",
-		"DELETE FROM kv_meta WHERE key = $1",
-	}
-	for _, query := range queries {
-		if _, err := q.Exec(ctx, query, key); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-// deleteKeysFromAllTables deletes multiple keys from all tables in batch
-func (queryOps) deleteKeysFromAllTables(ctx context.Context, q Querier, keys []string) error {
+// deleteKeys deletes keys along with their data, whether or not they expired
+func (o queryOps) deleteKeys(ctx context.Context, q Querier, keys []string) error {
 	if len(keys) == 0 {
 		return nil
 	}
-	queries := []string{
-		"DELETE FROM kv_strings WHERE key = ANY($1)",
-		"DELETE FROM kv_hashes WHERE key = ANY($1)",
-		"DELETE FROM kv_lists WHERE key = ANY($1)",
-		"DELETE FROM kv_sets WHERE key = ANY($1)",
-		"DELETE FROM kv_zsets WHERE key = ANY($1)",
-		"DELETE FROM kv_meta WHERE key = ANY($1)",
-	}
-	for _, query := range queries {
-		if _, err := q.Exec(ctx, query, keys); err != nil {
-			return err
-		}
-	}
-	return nil
+	_, err := q.Exec(ctx, "DELETE FROM kv_keys WHERE db = $2 AND key = ANY($1)", keys, o.db)
+	return err
 }
 
 // ============== String Commands ==============
@@ -159,11 +147,12 @@ func (queryOps) deleteKeysFromAllTables(ctx context.Context, q Querier, keys []s
 func (o queryOps) get(ctx context.Context, q Querier, key string) (string, bool, error) {
 	var value []byte
 	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&value)
 
 	if err == pgx.ErrNoRows {
+		o.notify(ctx, q, notifyKeyMiss, "keymiss", key)
 		return "", false, nil
 	}
 	if err != nil {
@@ -173,56 +162,63 @@ func (o queryOps) get(ctx context.Context, q Querier, key string) (string, bool,
 }
 
 func (o queryOps) set(ctx context.Context, q Querier, key, value string, ttl time.Duration) error {
-	if err := o.deleteKeyFromAllTables(ctx, q, key); err != nil {
-		return err
-	}
-
 	var expiresAt *time.Time
 	if ttl > 0 {
 		t := time.Now().Add(ttl)
 		expiresAt = &t
 	}
 
-	_, err := q.Exec(ctx,
-		`INSERT INTO kv_strings (key, value, expires_at) VALUES ($1, $2, $3)
-		 ON CONFLICT (key) DO UPDATE SET value = $2, expires_at = $3`,
-		key, []byte(value), expiresAt,
-	)
+	id, err := o.newKey(ctx, q, key, TypeString, expiresAt)
 	if err != nil {
 		return err
 	}
-
-	return o.setMeta(ctx, q, key, TypeString, expiresAt)
+	if _, err := q.Exec(ctx, "INSERT INTO kv_strings (key_id, value) VALUES ($1, $2)", id, []byte(value)); err != nil {
+		return err
+	}
+	o.notify(ctx, q, notifyString, "set", key)
+	if expiresAt != nil {
+		o.notify(ctx, q, notifyGeneric, "expire", key)
+	}
+	return nil
 }
 
 func (o queryOps) setNX(ctx context.Context, q Querier, key, value string) (bool, error) {
-	result, err := q.Exec(ctx,
-		`INSERT INTO kv_strings (key, value) VALUES ($1, $2)
-		 ON CONFLICT (key) DO NOTHING`,
-		key, []byte(value),
+	// An expired key is replaced, so clear it out of the way first
+	_, err := q.Exec(ctx,
+		"DELETE FROM kv_keys WHERE db = $2 AND key = $1 AND expires_at <= NOW()",
+		key, o.db,
 	)
 	if err != nil {
 		return false, err
 	}
 
-	if result.RowsAffected() > 0 {
-		q.Exec(ctx,
-			`INSERT INTO kv_meta (key, key_type) VALUES ($1, $2)
-			 ON CONFLICT (key) DO UPDATE SET key_type = $2`,
-			key, TypeString,
-		)
-		return true, nil
+	var id int64
+	err = q.QueryRow(ctx,
+		`INSERT INTO kv_keys (db, key, key_type) VALUES ($3, $1, $2)
+		 ON CONFLICT (db, key) DO NOTHING RETURNING id`,
+		key, string(TypeString), o.db,
+	).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return false, nil
 	}
-	return false, nil
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := q.Exec(ctx, "INSERT INTO kv_strings (key_id, value) VALUES ($1, $2)", id, []byte(value)); err != nil {
+		return false, err
+	}
+	o.notify(ctx, q, notifyString, "set", key)
+	return true, nil
 }
 
 func (o queryOps) mGet(ctx context.Context, q Querier, keys []string) ([]interface{}, error) {
 	results := make([]interface{}, len(keys))
 
 	rows, err := q.Query(ctx,
-		`SELECT key, value FROM kv_strings 
-		 WHERE key = ANY($1) AND (expires_at IS NULL OR expires_at > NOW())`,
-		keys,
+		`SELECT k.key, s.value FROM kv_keys k JOIN kv_strings s ON s.key_id = k.id
+		 WHERE k.db = $2 AND k.key = ANY($1) AND (k.expires_at IS NULL OR k.expires_at > NOW())`,
+		keys, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -239,13 +235,16 @@ func (o queryOps) mGet(ctx context.Context, q Querier, keys []string) ([]interfa
 		keyValues[key] = string(value)
 	}
 
+	var missed []string
 	for i, key := range keys {
 		if val, ok := keyValues[key]; ok {
 			results[i] = val
 		} else {
 			results[i] = nil
+			missed = append(missed, key)
 		}
 	}
+	o.notify(ctx, q, notifyKeyMiss, "keymiss", missed...)
 
 	return results, nil
 }
@@ -263,32 +262,35 @@ func (o queryOps) mSet(ctx context.Context, q Querier, pairs map[string]string)
 		values = append(values, []byte(value))
 	}
 
-	// Batch delete from all tables
-	if err := o.deleteKeysFromAllTables(ctx, q, keys); err != nil {
+	// Replace the keys, registering them and inserting their values in one statement
+	if err := o.deleteKeys(ctx, q, keys); err != nil {
 		return err
 	}
-
-	// Batch insert using UNNEST
 	_, err := q.Exec(ctx,
-		`INSERT INTO kv_strings (key, value)
-		 SELECT unnest($1::text[]), unnest($2::bytea[])
-		 ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value`,
-		keys, values,
+		`WITH k AS (
+			INSERT INTO kv_keys (db, key, key_type)
+			SELECT $3, unnest($1::text[]), 'string'
+			RETURNING id, key
+		)
+		INSERT INTO kv_strings (key_id, value)
+		SELECT k.id, v.value FROM k JOIN unnest($1::text[], $2::bytea[]) AS v(key, value) ON v.key = k.key`,
+		keys, values, o.db,
 	)
 	if err != nil {
 		return err
 	}
-
-	// Batch set metadata
-	return o.setMetaBatch(ctx, q, keys, TypeString)
+	o.notify(ctx, q, notifyString, "set", keys...)
+	return nil
 }
 
 func (o queryOps) incr(ctx context.Context, q Querier, key string, delta int64) (int64, error) {
+	id, err := o.keyID(ctx, q, key, TypeString)
+	if err != nil {
+		return 0, err
+	}
+
 	var value []byte
-	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
-	).Scan(&value)
+	err = q.QueryRow(ctx, "SELECT value FROM kv_strings WHERE key_id = $1", id).Scan(&value)
 
 	var current int64
 	if err == pgx.ErrNoRows {
@@ -303,50 +305,43 @@ func (o queryOps) incr(ctx context.Context, q Querier, key string, delta int64)
 	}
 
 	result := current + delta
-	_, err = q.Exec(ctx,
-		`INSERT INTO kv_strings (key, value) VALUES ($1, $2)
-		 ON CONFLICT (key) DO UPDATE SET value = $2`,
-		key, []byte(strconv.FormatInt(result, 10)),
-	)
-	if err != nil {
-		return 0, err
+	if (delta > 0 && result < current) || (delta < 0 && result > current) {
+		return 0, fmt.Errorf("increment or decrement would overflow")
 	}
-
-	if err := o.setMeta(ctx, q, key, TypeString, nil); err != nil {
+	if err := o.putString(ctx, q, id, []byte(strconv.FormatInt(result, 10))); err != nil {
 		return 0, err
 	}
+	o.notify(ctx, q, notifyString, "incrby", key)
 
 	return result, nil
 }
 
 func (o queryOps) appendStr(ctx context.Context, q Querier, key, value string) (int64, error) {
-	_, err := q.Exec(ctx,
-		`INSERT INTO kv_strings (key, value) VALUES ($1, $2)
-		 ON CONFLICT (key) DO UPDATE SET value = kv_strings.value || $2`,
-		key, []byte(value),
-	)
+	id, err := o.keyID(ctx, q, key, TypeString)
 	if err != nil {
 		return 0, err
 	}
 
-	var newValue []byte
-	err = q.QueryRow(ctx, "SELECT value FROM kv_strings WHERE key = $1", key).Scan(&newValue)
+	var length int64
+	err = q.QueryRow(ctx,
+		`INSERT INTO kv_strings (key_id, value) VALUES ($1, $2)
+		 ON CONFLICT (key_id) DO UPDATE SET value = kv_strings.value || $2
+		 RETURNING length(value)`,
+		id, []byte(value),
+	).Scan(&length)
 	if err != nil {
 		return 0, err
 	}
+	o.notify(ctx, q, notifyString, "append", key)
 
-	if err := o.setMeta(ctx, q, key, TypeString, nil); err != nil {
-		return 0, err
-	}
-
-	return int64(len(newValue)), nil
+	return length, nil
 }
 
 func (o queryOps) getRange(ctx context.Context, q Querier, key string, start, end int64) (string, error) {
 	var value []byte
 	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&value)
 	if err == pgx.ErrNoRows {
 		return "", nil
@@ -383,12 +378,14 @@ func (o queryOps) getRange(ctx context.Context, q Querier, key string, start, en
 }
 
 func (o queryOps) setRange(ctx context.Context, q Querier, key string, offset int64, value string) (int64, error) {
+	id, err := o.keyID(ctx, q, key, TypeString)
+	if err != nil {
+		return 0, err
+	}
+
 	// Get existing value or create empty
 	var existing []byte
-	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
-	).Scan(&existing)
+	err = q.QueryRow(ctx, "SELECT value FROM kv_strings WHERE key_id = $1", id).Scan(&existing)
 	if err == pgx.ErrNoRows {
 		existing = []byte{}
 	} else if err != nil {
@@ -407,18 +404,10 @@ func (o queryOps) setRange(ctx context.Context, q Querier, key string, offset in
 	copy(existing[offset:], value)
 
 	// Save back
-	_, err = q.Exec(ctx,
-		`INSERT INTO kv_strings (key, value) VALUES ($1, $2)
-		 ON CONFLICT (key) DO UPDATE SET value = $2`,
-		key, existing,
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	if err := o.setMeta(ctx, q, key, TypeString, nil); err != nil {
+	if err := o.putString(ctx, q, id, existing); err != nil {
 		return 0, err
 	}
+	o.notify(ctx, q, notifyString, "setrange", key)
 
 	return int64(len(existing)), nil
 }
@@ -427,8 +416,8 @@ func (o queryOps) bitField(ctx context.Context, q Querier, key string, ops []Bit
 	// Get existing value or create empty
 	var value []byte
 	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&value)
 	if err == pgx.ErrNoRows {
 		value = []byte{}
@@ -507,18 +496,14 @@ func (o queryOps) bitField(ctx context.Context, q Querier, key string, ops []Bit
 	}
 
 	if modified {
-		_, err = q.Exec(ctx,
-			`INSERT INTO kv_strings (key, value) VALUES ($1, $2)
-			 ON CONFLICT (key) DO UPDATE SET value = $2`,
-			key, value,
-		)
+		id, err := o.keyID(ctx, q, key, TypeString)
 		if err != nil {
 			return nil, err
 		}
-
-		if err := o.setMeta(ctx, q, key, TypeString, nil); err != nil {
+		if err := o.putString(ctx, q, id, value); err != nil {
 			return nil, err
 		}
+		o.notify(ctx, q, notifyString, "setbit", key)
 	}
 
 	return results, nil
@@ -563,8 +548,8 @@ func setBitField(data []byte, bitOffset, bitWidth, value int64) {
 func (o queryOps) strLen(ctx context.Context, q Querier, key string) (int64, error) {
 	var value []byte
 	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&value)
 	if err == pgx.ErrNoRows {
 		return 0, nil
@@ -577,12 +562,12 @@ func (o queryOps) strLen(ctx context.Context, q Querier, key string) (int64, err
 
 func (o queryOps) getEx(ctx context.Context, q Querier, key string, ttl time.Duration, persist bool) (string, bool, error) {
 	var value []byte
-	var expiresAt *time.Time
+	var id int64
 
 	err := q.QueryRow(ctx,
-		"SELECT value, expires_at FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
-	).Scan(&value, &expiresAt)
+		"SELECT key_id, value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
+	).Scan(&id, &value)
 	if err == pgx.ErrNoRows {
 		return "", false, nil
 	}
@@ -590,36 +575,20 @@ func (o queryOps) getEx(ctx context.Context, q Querier, key string, ttl time.Dur
 		return "", false, err
 	}
 
-	// Update expiration based on options - update kv_meta for TTL tracking
+	// Update expiration based on options
 	if persist {
-		// Remove expiration from both tables
-		_, err = q.Exec(ctx,
-			"UPDATE kv_meta SET expires_at = NULL WHERE key = $1",
-			key,
-		)
-		if err == nil {
-			_, err = q.Exec(ctx,
-				"UPDATE kv_strings SET expires_at = NULL WHERE key = $1",
-				key,
-			)
-		}
+		_, err = q.Exec(ctx, "UPDATE kv_keys SET expires_at = NULL WHERE id = $1", id)
 	} else if ttl > 0 {
-		// Set new expiration on both tables
-		newExpiry := time.Now().Add(ttl)
-		_, err = q.Exec(ctx,
-			"UPDATE kv_meta SET expires_at = $2 WHERE key = $1",
-			key, newExpiry,
-		)
-		if err == nil {
-			_, err = q.Exec(ctx,
-				"UPDATE kv_strings SET expires_at = $2 WHERE key = $1",
-				key, newExpiry,
-			)
-		}
+		_, err = q.Exec(ctx, "UPDATE kv_keys SET expires_at = $2 WHERE id = $1", id, time.Now().Add(ttl))
 	}
 	if err != nil {
 		return "", false, err
 	}
+	if persist {
+		o.notify(ctx, q, notifyGeneric, "persist", key)
+	} else if ttl > 0 {
+		o.notify(ctx, q, notifyGeneric, "expire", key)
+	}
 
 	return string(value), true, nil
 }
@@ -627,8 +596,8 @@ func (o queryOps) getEx(ctx context.Context, q Querier, key string, ttl time.Dur
 func (o queryOps) getDel(ctx context.Context, q Querier, key string) (string, bool, error) {
 	var value []byte
 	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&value)
 	if err == pgx.ErrNoRows {
 		return "", false, nil
@@ -638,11 +607,10 @@ func (o queryOps) getDel(ctx context.Context, q Querier, key string) (string, bo
 	}
 
 	// Delete the key
-	_, err = q.Exec(ctx, "DELETE FROM kv_strings WHERE key = $1", key)
-	if err != nil {
+	if err := o.deleteKeys(ctx, q, []string{key}); err != nil {
 		return "", false, err
 	}
-	_, _ = q.Exec(ctx, "DELETE FROM kv_meta WHERE key = $1", key)
+	o.notify(ctx, q, notifyGeneric, "del", key)
 
 	return string(value), true, nil
 }
@@ -651,27 +619,23 @@ func (o queryOps) getSet(ctx context.Context, q Querier, key, value string) (str
 	// Get old value
 	var oldValue []byte
 	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&oldValue)
 	exists := err == nil
 	if err != nil && err != pgx.ErrNoRows {
 		return "", false, err
 	}
 
-	// Set new value (upsert)
-	_, err = q.Exec(ctx,
-		`INSERT INTO kv_strings (key, value, expires_at) VALUES ($1, $2, NULL)
-		 ON CONFLICT (key) DO UPDATE SET value = $2, expires_at = NULL`,
-		key, []byte(value),
-	)
+	// Set new value, clearing any TTL
+	id, err := o.newKey(ctx, q, key, TypeString, nil)
 	if err != nil {
 		return "", false, err
 	}
-
-	if err := o.setMeta(ctx, q, key, TypeString, nil); err != nil {
+	if err := o.putString(ctx, q, id, []byte(value)); err != nil {
 		return "", false, err
 	}
+	o.notify(ctx, q, notifyString, "set", key)
 
 	if exists {
 		return string(oldValue), true, nil
@@ -680,22 +644,15 @@ func (o queryOps) getSet(ctx context.Context, q Querier, key, value string) (str
 }
 
 func (o queryOps) incrByFloat(ctx context.Context, q Querier, key string, delta float64) (float64, error) {
-	// Check key type
-	keyType, err := o.getKeyType(ctx, q, key)
+	id, err := o.keyID(ctx, q, key, TypeString)
 	if err != nil {
 		return 0, err
 	}
-	if keyType != TypeNone && keyType != TypeString {
-		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
 
 	var currentValue float64 = 0
 	var valueBytes []byte
 
-	err = q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
-	).Scan(&valueBytes)
+	err = q.QueryRow(ctx, "SELECT value FROM kv_strings WHERE key_id = $1", id).Scan(&valueBytes)
 	if err == nil {
 		currentValue, err = strconv.ParseFloat(string(valueBytes), 64)
 		if err != nil {
@@ -710,18 +667,10 @@ func (o queryOps) incrByFloat(ctx context.Context, q Querier, key string, delta
 	// Format without trailing zeros, but preserve precision
 	valueStr := strconv.FormatFloat(newValue, 'f', -1, 64)
 
-	_, err = q.Exec(ctx,
-		`INSERT INTO kv_strings (key, value) VALUES ($1, $2)
-		 ON CONFLICT (key) DO UPDATE SET value = $2`,
-		key, []byte(valueStr),
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	if err := o.setMeta(ctx, q, key, TypeString, nil); err != nil {
+	if err := o.putString(ctx, q, id, []byte(valueStr)); err != nil {
 		return 0, err
 	}
+	o.notify(ctx, q, notifyString, "incrbyfloat", key)
 
 	return newValue, nil
 }
@@ -729,79 +678,54 @@ func (o queryOps) incrByFloat(ctx context.Context, q Querier, key string, delta
 // ============== Key Commands ==============
 
 func (o queryOps) del(ctx context.Context, q Querier, keys []string) (int64, error) {
-	var deleted int64
-	for _, key := range keys {
-		keyType, err := o.getKeyType(ctx, q, key)
-		if err != nil {
-			return deleted, err
-		}
-		if keyType == TypeNone {
-			continue
-		}
+	// Expired keys are deleted too, but don't count
+	rows, err := q.Query(ctx,
+		`DELETE FROM kv_keys WHERE db = $2 AND key = ANY($1)
+		 RETURNING key, expires_at IS NULL OR expires_at > NOW()`,
+		keys, o.db,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
 
-		if err := o.deleteKeyFromAllTables(ctx, q, key); err != nil {
-			return deleted, err
+	var deleted []string
+	for rows.Next() {
+		var key string
+		var live bool
+		if err := rows.Scan(&key, &live); err != nil {
+			return 0, err
+		}
+		if live {
+			deleted = append(deleted, key)
 		}
-		deleted++
 	}
-	return deleted, nil
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	o.notify(ctx, q, notifyGeneric, "del", deleted...)
+	return int64(len(deleted)), nil
 }
 
 func (o queryOps) exists(ctx context.Context, q Querier, keys []string) (int64, error) {
 	var count int64
 	err := q.QueryRow(ctx,
-		`SELECT COUNT(*) FROM kv_meta 
-		 WHERE key = ANY($1) AND (expires_at IS NULL OR expires_at > NOW())`,
-		keys,
+		`SELECT COUNT(*) FROM kv_keys 
+		 WHERE db = $2 AND key = ANY($1) AND (expires_at IS NULL OR expires_at > NOW())`,
+		keys, o.db,
 	).Scan(&count)
 	return count, err
 }
 
 func (o queryOps) expire(ctx context.Context, q Querier, key string, ttl time.Duration) (bool, error) {
-	expiresAt := time.Now().Add(ttl)
-
-	result, err := q.Exec(ctx,
-		`UPDATE kv_meta SET expires_at = $2 
-		 WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())`,
-		key, expiresAt,
-	)
-	if err != nil {
-		return false, err
-	}
-
-	if result.RowsAffected() == 0 {
-		return false, nil
-	}
-
-	// Update expires_at in the data table
-	keyType, err := o.getKeyType(ctx, q, key)
-	if err != nil {
-		return false, err
-	}
-
-	var table string
-	switch keyType {
-	case TypeString:
-		table = "kv_strings"
-	case TypeHash:
-		table = "kv_hashes"
-	case TypeList:
-		table = "kv_lists"
-	case TypeSet:
-		table = "kv_sets"
-	default:
-		return true, nil
-	}
-
-	_, err = q.Exec(ctx, fmt.Sprintf("UPDATE %s SET expires_at = $2 WHERE key = $1", table), key, expiresAt)
-	return err == nil, err
+	return o.expireAt(ctx, q, key, time.Now().Add(ttl))
 }
 
 func (o queryOps) ttl(ctx context.Context, q Querier, key string) (int64, error) {
 	var expiresAt *time.Time
 	err := q.QueryRow(ctx,
-		"SELECT expires_at FROM kv_meta WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT expires_at FROM kv_keys WHERE db = $2 AND key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
+		key, o.db,
 	).Scan(&expiresAt)
 
 	if err == pgx.ErrNoRows {
@@ -824,8 +748,8 @@ func (o queryOps) ttl(ctx context.Context, q Querier, key string) (int64, error)
 func (o queryOps) pttl(ctx context.Context, q Querier, key string) (int64, error) {
 	var expiresAt *time.Time
 	err := q.QueryRow(ctx,
-		"SELECT expires_at FROM kv_meta WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT expires_at FROM kv_keys WHERE db = $2 AND key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
+		key, o.db,
 	).Scan(&expiresAt)
 
 	if err == pgx.ErrNoRows {
@@ -847,9 +771,9 @@ func (o queryOps) pttl(ctx context.Context, q Querier, key string) (int64, error
 
 func (o queryOps) persist(ctx context.Context, q Querier, key string) (bool, error) {
 	result, err := q.Exec(ctx,
-		`UPDATE kv_meta SET expires_at = NULL 
-		 WHERE key = $1 AND expires_at IS NOT NULL AND expires_at > NOW()`,
-		key,
+		`UPDATE kv_keys SET expires_at = NULL 
+		 WHERE db = $2 AND key = $1 AND expires_at IS NOT NULL AND expires_at > NOW()`,
+		key, o.db,
 	)
 	if err != nil {
 		return false, err
@@ -858,25 +782,19 @@ func (o queryOps) persist(ctx context.Context, q Querier, key string) (bool, err
 	if result.RowsAffected() == 0 {
 		return false, nil
 	}
-
-	// Also clear expires_at in data tables
-	tables := []string{"kv_strings", "kv_hashes", "kv_lists", "kv_sets"}
-	for _, table := range tables {
-		q.Exec(ctx, fmt.Sprintf("UPDATE %s SET expires_at = NULL WHERE key = $1", table), key)
-	}
+	o.notify(ctx, q, notifyGeneric, "persist", key)
 
 	return true, nil
 }
 
 func (o queryOps) keys(ctx context.Context, q Querier, pattern string) ([]string, error) {
-	// Convert Redis glob pattern to SQL LIKE pattern
-	likePattern := strings.ReplaceAll(pattern, "*", "%")
-	likePattern = strings.ReplaceAll(likePattern, "?", "_")
-
+	// Narrow the keys down in SQL, checking the pattern in Go when LIKE can't
+	// express it exactly
+	like, exact := stringmatch.ToLike(pattern)
 	rows, err := q.Query(ctx,
-		`SELECT key FROM kv_meta 
-		 WHERE key LIKE $1 AND (expires_at IS NULL OR expires_at > NOW())`,
-		likePattern,
+		`SELECT key FROM kv_keys 
+		 WHERE db = $2 AND key LIKE $1 AND (expires_at IS NULL OR expires_at > NOW())`,
+		like, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -889,7 +807,9 @@ func (o queryOps) keys(ctx context.Context, q Querier, pattern string) ([]string
 		if err := rows.Scan(&key); err != nil {
 			return nil, err
 		}
-		keys = append(keys, key)
+		if exact || stringmatch.Match(pattern, key, false) {
+			keys = append(keys, key)
+		}
 	}
 	return keys, nil
 }
@@ -906,33 +826,21 @@ func (o queryOps) rename(ctx context.Context, q Querier, oldKey, newKey string)
 	if keyType == TypeNone {
 		return fmt.Errorf("no such key")
 	}
-
-	// Delete new key if it exists
-	if err := o.deleteKeyFromAllTables(ctx, q, newKey); err != nil {
-		return err
+	if oldKey == newKey {
+		return nil
 	}
 
-	// Rename in data table
-	var table string
-	switch keyType {
-	case TypeString:
-		table = "kv_strings"
-	case TypeHash:
-		table = "kv_hashes"
-	case TypeList:
-		table = "kv_lists"
-	case TypeSet:
-		table = "kv_sets"
+	// The data follows the key's row, whatever its type
+	if err := o.deleteKeys(ctx, q, []string{newKey}); err != nil {
+		return err
 	}
-
-	_, err = q.Exec(ctx, fmt.Sprintf("UPDATE %s SET key = $2 WHERE key = $1", table), oldKey, newKey)
+	_, err = q.Exec(ctx, "UPDATE kv_keys SET key = $2 WHERE db = $3 AND key = $1", oldKey, newKey, o.db)
 	if err != nil {
 		return err
 	}
-
-	// Update meta
-	_, err = q.Exec(ctx, "UPDATE kv_meta SET key = $2 WHERE key = $1", oldKey, newKey)
-	return err
+	o.notify(ctx, q, notifyGeneric, "rename_from", oldKey)
+	o.notify(ctx, q, notifyGeneric, "rename_to", newKey)
+	return nil
 }
 
 // ============== Hash Commands ==============
@@ -940,8 +848,8 @@ func (o queryOps) rename(ctx context.Context, q Querier, oldKey, newKey string)
 func (o queryOps) hGet(ctx context.Context, q Querier, key, field string) (string, bool, error) {
 	var value []byte
 	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_hashes WHERE key = $1 AND field = $2 AND (expires_at IS NULL OR expires_at > NOW())",
-		key, encodeField(field),
+		"SELECT value FROM kv_hashes WHERE key_id = postkeys_key_id($3, $1) AND field = $2",
+		key, encodeField(field), o.db,
 	).Scan(&value)
 
 	if err == pgx.ErrNoRows {
@@ -958,14 +866,10 @@ func (o queryOps) hSet(ctx context.Context, q Querier, key string, fields map[st
 		return 0, nil
 	}
 
-	// Check if key exists but is wrong type
-	keyType, err := o.getKeyType(ctx, q, key)
+	id, err := o.keyID(ctx, q, key, TypeHash)
 	if err != nil {
 		return 0, err
 	}
-	if keyType != TypeNone && keyType != TypeHash {
-		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
 
 	// Collect fields and values for batch insert
 	fieldNames := make([]string, 0, len(fields))
@@ -978,8 +882,8 @@ func (o queryOps) hSet(ctx context.Context, q Querier, key string, fields map[st
 	// Count existing fields before insert (to calculate newly added)
 	var existingCount int64
 	err = q.QueryRow(ctx,
-		"SELECT COUNT(*) FROM kv_hashes WHERE key = $1 AND field = ANY($2)",
-		key, fieldNames,
+		"SELECT COUNT(*) FROM kv_hashes WHERE key_id = $1 AND field = ANY($2)",
+		id, fieldNames,
 	).Scan(&existingCount)
 	if err != nil {
 		return 0, err
@@ -987,19 +891,15 @@ func (o queryOps) hSet(ctx context.Context, q Querier, key string, fields map[st
 
 	// Batch upsert all fields at once
 	_, err = q.Exec(ctx,
-		`INSERT INTO kv_hashes (key, field, value)
+		`INSERT INTO kv_hashes (key_id, field, value)
 		 SELECT $1, unnest($2::text[]), unnest($3::bytea[])
-		 ON CONFLICT (key, field) DO UPDATE SET value = EXCLUDED.value`,
-		key, fieldNames, fieldValues,
+		 ON CONFLICT (key_id, field) DO UPDATE SET value = EXCLUDED.value`,
+		id, fieldNames, fieldValues,
 	)
 	if err != nil {
 		return 0, err
 	}
-
-	// Set metadata
-	if err := o.setMeta(ctx, q, key, TypeHash, nil); err != nil {
-		return 0, err
-	}
+	o.notify(ctx, q, notifyHash, "hset", key)
 
 	// Return number of newly added fields
 	return int64(len(fields)) - existingCount, nil
@@ -1012,12 +912,15 @@ func (o queryOps) hDel(ctx context.Context, q Querier, key string, fields []stri
 		encFields[i] = encodeField(f)
 	}
 	result, err := q.Exec(ctx,
-		"DELETE FROM kv_hashes WHERE key = $1 AND field = ANY($2)",
-		key, encFields,
+		"DELETE FROM kv_hashes WHERE key_id = postkeys_key_id($3, $1) AND field = ANY($2)",
+		key, encFields, o.db,
 	)
 	if err != nil {
 		return 0, err
 	}
+	if result.RowsAffected() > 0 {
+		o.notify(ctx, q, notifyHash, "hdel", key)
+	}
 	return result.RowsAffected(), nil
 }
 
@@ -1032,8 +935,8 @@ func (o queryOps) hGetAll(ctx context.Context, q Querier, key string) (map[strin
 	}
 
 	rows, err := q.Query(ctx,
-		"SELECT field, value FROM kv_hashes WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT field, value FROM kv_hashes WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -1063,8 +966,8 @@ func (o queryOps) hMGet(ctx context.Context, q Querier, key string, fields []str
 
 	rows, err := q.Query(ctx,
 		`SELECT field, value FROM kv_hashes 
-		 WHERE key = $1 AND field = ANY($2) AND (expires_at IS NULL OR expires_at > NOW())`,
-		key, encFields,
+		 WHERE key_id = postkeys_key_id($3, $1) AND field = ANY($2)`,
+		key, encFields, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -1096,16 +999,16 @@ func (o queryOps) hExists(ctx context.Context, q Querier, key, field string) (bo
 	var count int64
 	err := q.QueryRow(ctx,
 		`SELECT COUNT(*) FROM kv_hashes 
-		 WHERE key = $1 AND field = $2 AND (expires_at IS NULL OR expires_at > NOW())`,
-		key, encodeField(field),
+		 WHERE key_id = postkeys_key_id($3, $1) AND field = $2`,
+		key, encodeField(field), o.db,
 	).Scan(&count)
 	return count > 0, err
 }
 
 func (o queryOps) hKeys(ctx context.Context, q Querier, key string) ([]string, error) {
 	rows, err := q.Query(ctx,
-		"SELECT field FROM kv_hashes WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT field FROM kv_hashes WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -1125,8 +1028,8 @@ func (o queryOps) hKeys(ctx context.Context, q Querier, key string) ([]string, e
 
 func (o queryOps) hVals(ctx context.Context, q Querier, key string) ([]string, error) {
 	rows, err := q.Query(ctx,
-		"SELECT value FROM kv_hashes WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT value FROM kv_hashes WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -1147,21 +1050,17 @@ func (o queryOps) hVals(ctx context.Context, q Querier, key string) ([]string, e
 func (o queryOps) hLen(ctx context.Context, q Querier, key string) (int64, error) {
 	var count int64
 	err := q.QueryRow(ctx,
-		"SELECT COUNT(*) FROM kv_hashes WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT COUNT(*) FROM kv_hashes WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&count)
 	return count, err
 }
 
 func (o queryOps) hIncrBy(ctx context.Context, q Querier, key, field string, increment int64) (int64, error) {
-	// Check if key exists but is wrong type
-	keyType, err := o.getKeyType(ctx, q, key)
+	id, err := o.keyID(ctx, q, key, TypeHash)
 	if err != nil {
 		return 0, err
 	}
-	if keyType != TypeNone && keyType != TypeHash {
-		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
 
 	// Encode field name for PostgreSQL
 	encField := encodeField(field)
@@ -1170,8 +1069,8 @@ func (o queryOps) hIncrBy(ctx context.Context, q Querier, key, field string, inc
 	var currentValue int64 = 0
 	var valueBytes []byte
 	err = q.QueryRow(ctx,
-		"SELECT value FROM kv_hashes WHERE key = $1 AND field = $2 AND (expires_at IS NULL OR expires_at > NOW())",
-		key, encField,
+		"SELECT value FROM kv_hashes WHERE key_id = $1 AND field = $2",
+		id, encField,
 	).Scan(&valueBytes)
 	if err == nil {
 		// Parse existing value as integer
@@ -1188,31 +1087,23 @@ func (o queryOps) hIncrBy(ctx context.Context, q Querier, key, field string, inc
 
 	// Upsert the new value
 	_, err = q.Exec(ctx,
-		`INSERT INTO kv_hashes (key, field, value) VALUES ($1, $2, $3)
-		 ON CONFLICT (key, field) DO UPDATE SET value = $3`,
-		key, encField, []byte(strconv.FormatInt(newValue, 10)),
+		`INSERT INTO kv_hashes (key_id, field, value) VALUES ($1, $2, $3)
+		 ON CONFLICT (key_id, field) DO UPDATE SET value = $3`,
+		id, encField, []byte(strconv.FormatInt(newValue, 10)),
 	)
 	if err != nil {
 		return 0, err
 	}
-
-	// Set metadata
-	if err := o.setMeta(ctx, q, key, TypeHash, nil); err != nil {
-		return 0, err
-	}
+	o.notify(ctx, q, notifyHash, "hincrby", key)
 
 	return newValue, nil
 }
 
 func (o queryOps) hIncrByFloat(ctx context.Context, q Querier, key, field string, increment float64) (float64, error) {
-	// Check if key exists but is wrong type
-	keyType, err := o.getKeyType(ctx, q, key)
+	id, err := o.keyID(ctx, q, key, TypeHash)
 	if err != nil {
 		return 0, err
 	}
-	if keyType != TypeNone && keyType != TypeHash {
-		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
 
 	// Encode field name for PostgreSQL
 	encField := encodeField(field)
@@ -1221,8 +1112,8 @@ func (o queryOps) hIncrByFloat(ctx context.Context, q Querier, key, field string
 	var currentValue float64 = 0
 	var valueBytes []byte
 	err = q.QueryRow(ctx,
-		"SELECT value FROM kv_hashes WHERE key = $1 AND field = $2 AND (expires_at IS NULL OR expires_at > NOW())",
-		key, encField,
+		"SELECT value FROM kv_hashes WHERE key_id = $1 AND field = $2",
+		id, encField,
 	).Scan(&valueBytes)
 	if err == nil {
 		// Parse existing value as float
@@ -1242,50 +1133,39 @@ func (o queryOps) hIncrByFloat(ctx context.Context, q Querier, key, field string
 
 	// Upsert the new value
 	_, err = q.Exec(ctx,
-		`INSERT INTO kv_hashes (key, field, value) VALUES ($1, $2, $3)
-		 ON CONFLICT (key, field) DO UPDATE SET value = $3`,
-		key, encField, []byte(valueStr),
+		`INSERT INTO kv_hashes (key_id, field, value) VALUES ($1, $2, $3)
+		 ON CONFLICT (key_id, field) DO UPDATE SET value = $3`,
+		id, encField, []byte(valueStr),
 	)
 	if err != nil {
 		return 0, err
 	}
-
-	// Set metadata
-	if err := o.setMeta(ctx, q, key, TypeHash, nil); err != nil {
-		return 0, err
-	}
+	o.notify(ctx, q, notifyHash, "hincrbyfloat", key)
 
 	return newValue, nil
 }
 
 func (o queryOps) hSetNX(ctx context.Context, q Querier, key, field, value string) (bool, error) {
-	// Check if key exists but is wrong type
-	keyType, err := o.getKeyType(ctx, q, key)
+	id, err := o.keyID(ctx, q, key, TypeHash)
 	if err != nil {
 		return false, err
 	}
-	if keyType != TypeNone && keyType != TypeHash {
-		return false, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
 
 	// Encode field name for PostgreSQL
 	encField := encodeField(field)
 
 	// Try to insert only if not exists
 	result, err := q.Exec(ctx,
-		`INSERT INTO kv_hashes (key, field, value) VALUES ($1, $2, $3)
-		 ON CONFLICT (key, field) DO NOTHING`,
-		key, encField, []byte(value),
+		`INSERT INTO kv_hashes (key_id, field, value) VALUES ($1, $2, $3)
+		 ON CONFLICT (key_id, field) DO NOTHING`,
+		id, encField, []byte(value),
 	)
 	if err != nil {
 		return false, err
 	}
 
 	if result.RowsAffected() > 0 {
-		// Set metadata
-		if err := o.setMeta(ctx, q, key, TypeHash, nil); err != nil {
-			return false, err
-		}
+		o.notify(ctx, q, notifyHash, "hset", key)
 		return true, nil
 	}
 	return false, nil
@@ -1293,215 +1173,246 @@ func (o queryOps) hSetNX(ctx context.Context, q Querier, key, field, value strin
 
 // ============== List Commands ==============
 
-func (o queryOps) lPush(ctx context.Context, q Querier, key string, values []string) (int64, error) {
-	keyType, err := o.getKeyType(ctx, q, key)
-	if err != nil {
-		return 0, err
-	}
-	if keyType != TypeNone && keyType != TypeList {
-		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-
-	if len(values) == 0 {
-		// Just return current length
-		var length int64
-		if err := q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_lists WHERE key = $1", key).Scan(&length); err != nil {
-			return 0, fmt.Errorf("failed to get list length: %w", err)
+// listGap is the distance between the positions of neighbouring list
+// elements, which leaves room for LINSERT to put 16 elements in a row
+// between two neighbours before the list is renumbered. Migration
+// 0004_lists, pk_lpush and pk_rpush use the same value.
+const listGap = 65536
+
+// listBlockBits sets the size of the position blocks kv_list_blocks counts
+// the elements of lists by, 2^26 positions; migration 0006_list_blocks uses
+// the same value
+const listBlockBits = 26
+
+// listMeta is a list's kv_list_meta row. While the list is dense, its
+// elements sit exactly listGap positions apart from head to tail.
+type listMeta struct {
+	id     int64
+	head   int64
+	tail   int64
+	length int64
+	dense  bool
+}
+
+// listMeta returns the kv_list_meta row of list key, and whether the list
+// exists. With forUpdate, it locks the key's kv_keys row and then this one,
+// in the order pushes lock them.
+func (o queryOps) listMeta(ctx context.Context, q Querier, key string, forUpdate bool) (listMeta, bool, error) {
+	query := `SELECT m.key_id, m.head, m.tail, m.length, m.dense
+		FROM kv_keys k JOIN kv_list_meta m ON m.key_id = k.id
+		WHERE k.id = postkeys_key_id($2, $1)`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	var m listMeta
+	err := q.QueryRow(ctx, query, key, o.db).Scan(&m.id, &m.head, &m.tail, &m.length, &m.dense)
+	if err == pgx.ErrNoRows {
+		// Only lists have a kv_list_meta row
+		keyType, err := o.getKeyType(ctx, q, key)
+		if err != nil {
+			return listMeta{}, false, err
 		}
-		return length, nil
+		if keyType != TypeNone {
+			return listMeta{}, false, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		return listMeta{}, false, nil
 	}
-
-	// Use advisory lock to serialize list operations on this key
-	// hashtext returns int4, we need int8 for pg_advisory_xact_lock
-	_, err = q.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1)::bigint)", key)
 	if err != nil {
-		return 0, err
+		return listMeta{}, false, err
 	}
+	return m, true, nil
+}
 
-	// Get current min index
-	var minIdx int64 = 0
-	if err := q.QueryRow(ctx, "SELECT COALESCE(MIN(idx), 0) FROM kv_lists WHERE key = $1", key).Scan(&minIdx); err != nil {
-		return 0, fmt.Errorf("failed to get min index: %w", err)
+// listIdx returns the position of the element at index i of list m, which
+// must be in range. The positions of a dense list are computed; otherwise
+// the element is looked for in the block of kv_list_blocks where the counts
+// of the blocks before it add up past i, so at most one block is walked.
+func (o queryOps) listIdx(ctx context.Context, q Querier, m listMeta, i int64) (int64, error) {
+	switch {
+	case m.dense:
+		return m.head + i*listGap, nil
+	case i == 0:
+		return m.head, nil
+	case i == m.length-1:
+		return m.tail, nil
 	}
 
-	// Prepare indices and values for batch insert
-	// For LPUSH, first value ends up at head, so we insert in reverse order
-	indices := make([]int64, len(values))
+	var idx int64
+	err := q.QueryRow(ctx,
+		`SELECT (
+			SELECT l.idx FROM kv_lists l
+			WHERE l.key_id = $1 AND l.idx >= b.block << $3
+			ORDER BY l.idx LIMIT 1 OFFSET $2 - b.before
+		)
+		FROM (
+			SELECT block, length, sum(length) OVER (ORDER BY block) - length AS before
+			FROM kv_list_blocks WHERE key_id = $1
+		) b
+		WHERE b.before <= $2 AND $2 < b.before + b.length`,
+		m.id, i, listBlockBits,
+	).Scan(&idx)
+	return idx, err
+}
+
+// listPush adds values to the head or tail of list id, the first value
+// ending up furthest in, and returns the new length. An empty list's head
+// and tail are 0 and -listGap, so pushes start from position 0.
+func (o queryOps) listPush(ctx context.Context, q Querier, id int64, values []string, left bool) (int64, error) {
 	valueBytes := make([][]byte, len(values))
 	for i, value := range values {
-		indices[i] = minIdx - int64(i+1)
 		valueBytes[i] = []byte(value)
 	}
 
-	// Batch insert all values at once
-	_, err = q.Exec(ctx,
-		`INSERT INTO kv_lists (key, idx, value)
-		 SELECT $1, unnest($2::bigint[]), unnest($3::bytea[])`,
-		key, indices, valueBytes,
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	if err := o.setMeta(ctx, q, key, TypeList, nil); err != nil {
-		return 0, err
+	// $3 is how far the end moves, and the new values go from it back
+	// towards the old end
+	query := `WITH meta AS (
+			INSERT INTO kv_list_meta AS m (key_id, head, tail, length) VALUES ($1, -$3::bigint, -$4::bigint, $5)
+			ON CONFLICT (key_id) DO UPDATE SET head = m.head - $3::bigint, length = m.length + $5
+			RETURNING head AS edge, length
+		), ins AS (
+			INSERT INTO kv_lists (key_id, idx, value)
+			SELECT $1, meta.edge + ($5 - t.i) * $4::bigint, t.v FROM meta, unnest($2::bytea[]) WITH ORDINALITY AS t(v, i)
+		)
+		SELECT length FROM meta`
+	if !left {
+		query = `WITH meta AS (
+			INSERT INTO kv_list_meta AS m (key_id, head, tail, length) VALUES ($1, 0, $3::bigint - $4::bigint, $5)
+			ON CONFLICT (key_id) DO UPDATE SET tail = m.tail + $3::bigint, length = m.length + $5
+			RETURNING tail AS edge, length
+		), ins AS (
+			INSERT INTO kv_lists (key_id, idx, value)
+			SELECT $1, meta.edge - ($5 - t.i) * $4::bigint, t.v FROM meta, unnest($2::bytea[]) WITH ORDINALITY AS t(v, i)
+		)
+		SELECT length FROM meta`
 	}
 
-	// Return new length
+	n := int64(len(values))
 	var length int64
-	if err := q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_lists WHERE key = $1", key).Scan(&length); err != nil {
-		return 0, fmt.Errorf("failed to get list length: %w", err)
+	err := q.QueryRow(ctx, query, id, valueBytes, n*listGap, int64(listGap), n).Scan(&length)
+	return length, err
+}
+
+// listPopEnd removes and returns the head or tail of list m, which must be
+// locked by listMeta
+func (o queryOps) listPopEnd(ctx context.Context, q Querier, m listMeta, left bool) (string, error) {
+	// The row locks serialize list writes, so this statement sees the
+	// elements every write before it left
+	query := `WITH d AS (
+			DELETE FROM kv_lists WHERE key_id = $1 AND idx = $2 RETURNING value
+		), meta AS (
+			UPDATE kv_list_meta SET length = length - 1,
+				head = COALESCE((SELECT idx FROM kv_lists WHERE key_id = $1 AND idx > $2 ORDER BY idx ASC LIMIT 1), head)
+			WHERE key_id = $1
+		)
+		SELECT value FROM d`
+	end := m.head
+	if !left {
+		query = `WITH d AS (
+			DELETE FROM kv_lists WHERE key_id = $1 AND idx = $2 RETURNING value
+		), meta AS (
+			UPDATE kv_list_meta SET length = length - 1,
+				tail = COALESCE((SELECT idx FROM kv_lists WHERE key_id = $1 AND idx < $2 ORDER BY idx DESC LIMIT 1), tail)
+			WHERE key_id = $1
+		)
+		SELECT value FROM d`
+		end = m.tail
 	}
 
-	return length, nil
-}
-
-func (o queryOps) rPush(ctx context.Context, q Querier, key string, values []string) (int64, error) {
-	keyType, err := o.getKeyType(ctx, q, key)
-	if err != nil {
-		return 0, err
-	}
-	if keyType != TypeNone && keyType != TypeList {
-		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	var value []byte
+	if err := q.QueryRow(ctx, query, m.id, end).Scan(&value); err != nil {
+		return "", err
 	}
+	return string(value), nil
+}
 
+func (o queryOps) lPush(ctx context.Context, q Querier, key string, values []string) (int64, error) {
 	if len(values) == 0 {
 		// Just return current length
-		var length int64
-		if err := q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_lists WHERE key = $1", key).Scan(&length); err != nil {
-			return 0, fmt.Errorf("failed to get list length: %w", err)
-		}
-		return length, nil
+		m, _, err := o.listMeta(ctx, q, key, false)
+		return m.length, err
 	}
 
-	// Use advisory lock to serialize list operations on this key
-	_, err = q.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1)::bigint)", key)
+	// The key's row stays locked, which serializes pushes to it
+	id, err := o.keyID(ctx, q, key, TypeList)
 	if err != nil {
 		return 0, err
 	}
 
-	// Get current max index
-	var maxIdx int64 = -1
-	if err := q.QueryRow(ctx, "SELECT COALESCE(MAX(idx), -1) FROM kv_lists WHERE key = $1", key).Scan(&maxIdx); err != nil {
-		return 0, fmt.Errorf("failed to get max index: %w", err)
+	length, err := o.listPush(ctx, q, id, values, true)
+	if err != nil {
+		return 0, err
 	}
 
-	// Prepare indices and values for batch insert
-	indices := make([]int64, len(values))
-	valueBytes := make([][]byte, len(values))
-	for i, value := range values {
-		indices[i] = maxIdx + int64(i+1)
-		valueBytes[i] = []byte(value)
+	o.notify(ctx, q, notifyList, "lpush", key)
+	return length, nil
+}
+
+func (o queryOps) rPush(ctx context.Context, q Querier, key string, values []string) (int64, error) {
+	if len(values) == 0 {
+		// Just return current length
+		m, _, err := o.listMeta(ctx, q, key, false)
+		return m.length, err
 	}
 
-	// Batch insert all values at once
-	_, err = q.Exec(ctx,
-		`INSERT INTO kv_lists (key, idx, value)
-		 SELECT $1, unnest($2::bigint[]), unnest($3::bytea[])`,
-		key, indices, valueBytes,
-	)
+	// The key's row stays locked, which serializes pushes to it
+	id, err := o.keyID(ctx, q, key, TypeList)
 	if err != nil {
 		return 0, err
 	}
 
-	if err := o.setMeta(ctx, q, key, TypeList, nil); err != nil {
+	length, err := o.listPush(ctx, q, id, values, false)
+	if err != nil {
 		return 0, err
 	}
 
-	// Return new length
-	var length int64
-	if err := q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_lists WHERE key = $1", key).Scan(&length); err != nil {
-		return 0, fmt.Errorf("failed to get list length: %w", err)
-	}
-
+	o.notify(ctx, q, notifyList, "rpush", key)
 	return length, nil
 }
 
 func (o queryOps) lPop(ctx context.Context, q Querier, key string) (string, bool, error) {
-	// Find and delete the leftmost element in a single query using CTE
-	// Use FOR UPDATE SKIP LOCKED to prevent deadlocks when multiple clients pop concurrently
-	var value []byte
-	err := q.QueryRow(ctx,
-		`WITH deleted AS (
-			DELETE FROM kv_lists
-			WHERE key = $1 AND idx = (
-				SELECT idx FROM kv_lists WHERE key = $1 ORDER BY idx ASC LIMIT 1 FOR UPDATE SKIP LOCKED
-			)
-			RETURNING value
-		)
-		SELECT value FROM deleted`,
-		key,
-	).Scan(&value)
-
-	if err == pgx.ErrNoRows {
-		return "", false, nil
+	m, ok, err := o.listMeta(ctx, q, key, true)
+	if err != nil || !ok {
+		return "", false, err
 	}
+
+	value, err := o.listPopEnd(ctx, q, m, true)
 	if err != nil {
 		return "", false, err
 	}
 
-	return string(value), true, nil
+	o.notify(ctx, q, notifyList, "lpop", key)
+	return value, true, nil
 }
 
 func (o queryOps) rPop(ctx context.Context, q Querier, key string) (string, bool, error) {
-	// Find and delete the rightmost element in a single query using CTE
-	// Use FOR UPDATE SKIP LOCKED to prevent deadlocks when multiple clients pop concurrently
-	var value []byte
-	err := q.QueryRow(ctx,
-		`WITH deleted AS (
-			DELETE FROM kv_lists
-			WHERE key = $1 AND idx = (
-				SELECT idx FROM kv_lists WHERE key = $1 ORDER BY idx DESC LIMIT 1 FOR UPDATE SKIP LOCKED
-			)
-			RETURNING value
-		)
-		SELECT value FROM deleted`,
-		key,
-	).Scan(&value)
-
-	if err == pgx.ErrNoRows {
-		return "", false, nil
+	m, ok, err := o.listMeta(ctx, q, key, true)
+	if err != nil || !ok {
+		return "", false, err
 	}
+
+	value, err := o.listPopEnd(ctx, q, m, false)
 	if err != nil {
 		return "", false, err
 	}
 
-	return string(value), true, nil
+	o.notify(ctx, q, notifyList, "rpop", key)
+	return value, true, nil
 }
 
 func (o queryOps) lLen(ctx context.Context, q Querier, key string) (int64, error) {
-	// Check if key exists but is wrong type
-	keyType, err := o.getKeyType(ctx, q, key)
-	if err != nil {
-		return 0, err
-	}
-	if keyType != TypeNone && keyType != TypeList {
-		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-
-	var count int64
-	err = q.QueryRow(ctx,
-		"SELECT COUNT(*) FROM kv_lists WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
-	).Scan(&count)
-	return count, err
+	m, _, err := o.listMeta(ctx, q, key, false)
+	return m.length, err
 }
 
 func (o queryOps) lRange(ctx context.Context, q Querier, key string, start, stop int64) ([]string, error) {
-	// Check if key exists but is wrong type
-	keyType, err := o.getKeyType(ctx, q, key)
+	m, ok, err := o.listMeta(ctx, q, key, false)
 	if err != nil {
 		return nil, err
 	}
-	if keyType != TypeNone && keyType != TypeList {
-		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-
-	// Get total count
-	var total int64
-	if err := q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_lists WHERE key = $1", key).Scan(&total); err != nil {
-		return nil, fmt.Errorf("failed to get list count: %w", err)
+	if !ok {
+		return []string{}, nil
 	}
+	total := m.length
 
 	// Convert negative indices
 	if start < 0 {
@@ -1520,10 +1431,19 @@ func (o queryOps) lRange(ctx context.Context, q Querier, key string, start, stop
 		return []string{}, nil
 	}
 
+	// Seek to the first element, then read on by position
+	first, err := o.listIdx(ctx, q, m, start)
+	if err == pgx.ErrNoRows {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	rows, err := q.Query(ctx,
-		`SELECT value FROM kv_lists WHERE key = $1 
-		 ORDER BY idx ASC LIMIT $2 OFFSET $3`,
-		key, stop-start+1, start,
+		`SELECT value FROM kv_lists WHERE key_id = $1 AND idx >= $2
+		 ORDER BY idx ASC LIMIT $3`,
+		m.id, first, stop-start+1,
 	)
 	if err != nil {
 		return nil, err
@@ -1542,25 +1462,31 @@ func (o queryOps) lRange(ctx context.Context, q Querier, key string, start, stop
 }
 
 func (o queryOps) lIndex(ctx context.Context, q Querier, key string, index int64) (string, bool, error) {
-	// Get total count
-	var total int64
-	if err := q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_lists WHERE key = $1", key).Scan(&total); err != nil {
-		return "", false, fmt.Errorf("failed to get list count: %w", err)
+	m, ok, err := o.listMeta(ctx, q, key, false)
+	if err != nil || !ok {
+		return "", false, err
 	}
 
 	// Convert negative index
 	if index < 0 {
-		index = total + index
+		index = m.length + index
+	}
+	if index < 0 || index >= m.length {
+		return "", false, nil
 	}
-	if index < 0 || index >= total {
+
+	idx, err := o.listIdx(ctx, q, m, index)
+	if err == pgx.ErrNoRows {
 		return "", false, nil
 	}
+	if err != nil {
+		return "", false, err
+	}
 
 	var value []byte
-	err := q.QueryRow(ctx,
-		`SELECT value FROM kv_lists WHERE key = $1 
-		 ORDER BY idx ASC LIMIT 1 OFFSET $2`,
-		key, index,
+	err = q.QueryRow(ctx,
+		"SELECT value FROM kv_lists WHERE key_id = $1 AND idx = $2",
+		m.id, idx,
 	).Scan(&value)
 
 	if err == pgx.ErrNoRows {
@@ -1579,13 +1505,10 @@ func (o queryOps) sAdd(ctx context.Context, q Querier, key string, members []str
 		return 0, nil
 	}
 
-	keyType, err := o.getKeyType(ctx, q, key)
+	id, err := o.keyID(ctx, q, key, TypeSet)
 	if err != nil {
 		return 0, err
 	}
-	if keyType != TypeNone && keyType != TypeSet {
-		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
 
 	// Convert members to bytes for batch insert
 	memberBytes := make([][]byte, len(members))
@@ -1597,22 +1520,21 @@ func (o queryOps) sAdd(ctx context.Context, q Querier, key string, members []str
 	var added int64
 	err = q.QueryRow(ctx,
 		`WITH inserted AS (
-			INSERT INTO kv_sets (key, member)
+			INSERT INTO kv_sets (key_id, member)
 			SELECT $1, unnest($2::bytea[])
-			ON CONFLICT (key, member) DO NOTHING
+			ON CONFLICT (key_id, member) DO NOTHING
 			RETURNING 1
 		)
 		SELECT COUNT(*) FROM inserted`,
-		key, memberBytes,
+		id, memberBytes,
 	).Scan(&added)
 	if err != nil {
 		return 0, err
 	}
 
-	if err := o.setMeta(ctx, q, key, TypeSet, nil); err != nil {
-		return 0, err
+	if added > 0 {
+		o.notify(ctx, q, notifySet, "sadd", key)
 	}
-
 	return added, nil
 }
 
@@ -1623,12 +1545,15 @@ func (o queryOps) sRem(ctx context.Context, q Querier, key string, members []str
 	}
 
 	result, err := q.Exec(ctx,
-		"DELETE FROM kv_sets WHERE key = $1 AND member = ANY($2)",
-		key, memberBytes,
+		"DELETE FROM kv_sets WHERE key_id = postkeys_key_id($3, $1) AND member = ANY($2)",
+		key, memberBytes, o.db,
 	)
 	if err != nil {
 		return 0, err
 	}
+	if result.RowsAffected() > 0 {
+		o.notify(ctx, q, notifySet, "srem", key)
+	}
 	return result.RowsAffected(), nil
 }
 
@@ -1643,8 +1568,8 @@ func (o queryOps) sMembers(ctx context.Context, q Querier, key string) ([]string
 	}
 
 	rows, err := q.Query(ctx,
-		"SELECT member FROM kv_sets WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT member FROM kv_sets WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -1666,8 +1591,8 @@ func (o queryOps) sIsMember(ctx context.Context, q Querier, key, member string)
 	var count int64
 	err := q.QueryRow(ctx,
 		`SELECT COUNT(*) FROM kv_sets 
-		 WHERE key = $1 AND member = $2 AND (expires_at IS NULL OR expires_at > NOW())`,
-		key, []byte(member),
+		 WHERE key_id = postkeys_key_id($3, $1) AND member = $2`,
+		key, []byte(member), o.db,
 	).Scan(&count)
 	return count > 0, err
 }
@@ -1684,8 +1609,8 @@ func (o queryOps) sCard(ctx context.Context, q Querier, key string) (int64, erro
 
 	var count int64
 	err = q.QueryRow(ctx,
-		"SELECT COUNT(*) FROM kv_sets WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT COUNT(*) FROM kv_sets WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&count)
 	return count, err
 }
@@ -1693,20 +1618,17 @@ func (o queryOps) sCard(ctx context.Context, q Querier, key string) (int64, erro
 // ============== Sorted Set Commands ==============
 
 func (o queryOps) zAdd(ctx context.Context, q Querier, key string, members []ZMember) (int64, error) {
-	keyType, err := o.getKeyType(ctx, q, key)
+	id, err := o.keyID(ctx, q, key, TypeZSet)
 	if err != nil {
 		return 0, err
 	}
-	if keyType != TypeNone && keyType != TypeZSet {
-		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
 
 	var added int64
 	for _, m := range members {
 		result, err := q.Exec(ctx,
-			`INSERT INTO kv_zsets (key, member, score) VALUES ($1, $2, $3)
-			 ON CONFLICT (key, member) DO UPDATE SET score = $3`,
-			key, []byte(m.Member), m.Score,
+			`INSERT INTO kv_zsets (key_id, member, score) VALUES ($1, $2, $3)
+			 ON CONFLICT (key_id, member) DO UPDATE SET score = $3`,
+			id, []byte(m.Member), m.Score,
 		)
 		if err != nil {
 			return 0, err
@@ -1714,10 +1636,7 @@ func (o queryOps) zAdd(ctx context.Context, q Querier, key string, members []ZMe
 		added += result.RowsAffected()
 	}
 
-	if err := o.setMeta(ctx, q, key, TypeZSet, nil); err != nil {
-		return 0, err
-	}
-
+	o.notify(ctx, q, notifyZSet, "zadd", key)
 	return added, nil
 }
 
@@ -1725,8 +1644,8 @@ func (o queryOps) zRange(ctx context.Context, q Querier, key string, start, stop
 	// Get total count first to handle negative indices
 	var count int64
 	err := q.QueryRow(ctx,
-		"SELECT COUNT(*) FROM kv_zsets WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT COUNT(*) FROM kv_zsets WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&count)
 	if err != nil {
 		return nil, err
@@ -1758,10 +1677,10 @@ func (o queryOps) zRange(ctx context.Context, q Querier, key string, start, stop
 	limit := stop - start + 1
 	rows, err := q.Query(ctx,
 		`SELECT member, score FROM kv_zsets 
-		 WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		 WHERE key_id = postkeys_key_id($4, $1)
 		 ORDER BY score ASC, member ASC
 		 LIMIT $2 OFFSET $3`,
-		key, limit, start,
+		key, limit, start, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -1784,8 +1703,8 @@ func (o queryOps) zScore(ctx context.Context, q Querier, key, member string) (fl
 	var score float64
 	err := q.QueryRow(ctx,
 		`SELECT score FROM kv_zsets 
-		 WHERE key = $1 AND member = $2 AND (expires_at IS NULL OR expires_at > NOW())`,
-		key, []byte(member),
+		 WHERE key_id = postkeys_key_id($3, $1) AND member = $2`,
+		key, []byte(member), o.db,
 	).Scan(&score)
 
 	if err == pgx.ErrNoRows {
@@ -1804,20 +1723,23 @@ func (o queryOps) zRem(ctx context.Context, q Querier, key string, members []str
 	}
 
 	result, err := q.Exec(ctx,
-		"DELETE FROM kv_zsets WHERE key = $1 AND member = ANY($2)",
-		key, memberBytes,
+		"DELETE FROM kv_zsets WHERE key_id = postkeys_key_id($3, $1) AND member = ANY($2)",
+		key, memberBytes, o.db,
 	)
 	if err != nil {
 		return 0, err
 	}
+	if result.RowsAffected() > 0 {
+		o.notify(ctx, q, notifyZSet, "zrem", key)
+	}
 	return result.RowsAffected(), nil
 }
 
 func (o queryOps) zCard(ctx context.Context, q Querier, key string) (int64, error) {
 	var count int64
 	err := q.QueryRow(ctx,
-		"SELECT COUNT(*) FROM kv_zsets WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT COUNT(*) FROM kv_zsets WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&count)
 	return count, err
 }
@@ -1828,15 +1750,15 @@ func (o queryOps) zRangeByScore(ctx context.Context, q Querier, key string, min,
 
 	if count > 0 {
 		query = `SELECT member, score FROM kv_zsets 
-			 WHERE key = $1 AND score >= $2 AND score <= $3 AND (expires_at IS NULL OR expires_at > NOW())
+			 WHERE key_id = postkeys_key_id($6, $1) AND score >= $2 AND score <= $3
 			 ORDER BY score ASC, member ASC
 			 LIMIT $4 OFFSET $5`
-		args = []interface{}{key, min, max, count, offset}
+		args = []interface{}{key, min, max, count, offset, o.db}
 	} else {
 		query = `SELECT member, score FROM kv_zsets 
-			 WHERE key = $1 AND score >= $2 AND score <= $3 AND (expires_at IS NULL OR expires_at > NOW())
+			 WHERE key_id = postkeys_key_id($4, $1) AND score >= $2 AND score <= $3
 			 ORDER BY score ASC, member ASC`
-		args = []interface{}{key, min, max}
+		args = []interface{}{key, min, max, o.db}
 	}
 
 	rows, err := q.Query(ctx, query, args...)
@@ -1859,12 +1781,15 @@ func (o queryOps) zRangeByScore(ctx context.Context, q Querier, key string, min,
 
 func (o queryOps) zRemRangeByScore(ctx context.Context, q Querier, key string, min, max float64) (int64, error) {
 	result, err := q.Exec(ctx,
-		"DELETE FROM kv_zsets WHERE key = $1 AND score >= $2 AND score <= $3",
-		key, min, max,
+		"DELETE FROM kv_zsets WHERE key_id = postkeys_key_id($4, $1) AND score >= $2 AND score <= $3",
+		key, min, max, o.db,
 	)
 	if err != nil {
 		return 0, err
 	}
+	if result.RowsAffected() > 0 {
+		o.notify(ctx, q, notifyZSet, "zremrangebyscore", key)
+	}
 	return result.RowsAffected(), nil
 }
 
@@ -1872,8 +1797,8 @@ func (o queryOps) zRemRangeByRank(ctx context.Context, q Querier, key string, st
 	// Get total count first to handle negative indices
 	var count int64
 	err := q.QueryRow(ctx,
-		"SELECT COUNT(*) FROM kv_zsets WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT COUNT(*) FROM kv_zsets WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&count)
 	if err != nil {
 		return 0, err
@@ -1898,48 +1823,51 @@ func (o queryOps) zRemRangeByRank(ctx context.Context, q Querier, key string, st
 
 	// Delete members within the rank range
 	result, err := q.Exec(ctx,
-		`DELETE FROM kv_zsets WHERE key = $1 AND member IN (
+		`DELETE FROM kv_zsets WHERE key_id = postkeys_key_id($4, $1) AND member IN (
 			SELECT member FROM kv_zsets 
-			WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())
+			WHERE key_id = postkeys_key_id($4, $1)
 			ORDER BY score ASC, member ASC
 			LIMIT $3 OFFSET $2
 		)`,
-		key, start, stop-start+1,
+		key, start, stop-start+1, o.db,
 	)
 	if err != nil {
 		return 0, err
 	}
+	if result.RowsAffected() > 0 {
+		o.notify(ctx, q, notifyZSet, "zremrangebyrank", key)
+	}
 	return result.RowsAffected(), nil
 }
 
 func (o queryOps) zIncrBy(ctx context.Context, q Querier, key string, increment float64, member string) (float64, error) {
-	// Ensure meta entry exists
-	_, err := q.Exec(ctx,
-		`INSERT INTO kv_meta (key, key_type) VALUES ($1, 'zset') ON CONFLICT (key) DO NOTHING`,
-		key,
-	)
+	id, err := o.keyID(ctx, q, key, TypeZSet)
 	if err != nil {
 		return 0, err
 	}
 
 	var newScore float64
 	err = q.QueryRow(ctx,
-		`INSERT INTO kv_zsets (key, member, score) VALUES ($1, $2, $3)
-		 ON CONFLICT (key, member) DO UPDATE SET score = kv_zsets.score + EXCLUDED.score
+		`INSERT INTO kv_zsets (key_id, member, score) VALUES ($1, $2, $3)
+		 ON CONFLICT (key_id, member) DO UPDATE SET score = kv_zsets.score + EXCLUDED.score
 		 RETURNING score`,
-		key, []byte(member), increment,
+		id, []byte(member), increment,
 	).Scan(&newScore)
-	return newScore, err
+	if err != nil {
+		return 0, err
+	}
+	o.notify(ctx, q, notifyZSet, "zincr", key)
+	return newScore, nil
 }
 
 func (o queryOps) zPopMin(ctx context.Context, q Querier, key string, count int64) ([]ZMember, error) {
 	// Get the lowest-scored members
 	rows, err := q.Query(ctx,
 		`SELECT member, score FROM kv_zsets 
-		 WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		 WHERE key_id = postkeys_key_id($3, $1)
 		 ORDER BY score ASC, member ASC
 		 LIMIT $2`,
-		key, count,
+		key, count, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -1963,12 +1891,13 @@ func (o queryOps) zPopMin(ctx context.Context, q Querier, key string, count int6
 			memberBytes[i] = []byte(m.Member)
 		}
 		_, err = q.Exec(ctx,
-			"DELETE FROM kv_zsets WHERE key = $1 AND member = ANY($2)",
-			key, memberBytes,
+			"DELETE FROM kv_zsets WHERE key_id = postkeys_key_id($3, $1) AND member = ANY($2)",
+			key, memberBytes, o.db,
 		)
 		if err != nil {
 			return nil, err
 		}
+		o.notify(ctx, q, notifyZSet, "zpopmin", key)
 	}
 
 	return members, nil
@@ -1979,112 +1908,106 @@ func (o queryOps) lRem(ctx context.Context, q Querier, key string, count int64,
 	// count < 0: Remove -count elements from tail
 	// count = 0: Remove all elements
 
-	var result int64
+	m, ok, err := o.listMeta(ctx, q, key, true)
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	var res pgconn.CommandTag
 	if count == 0 {
 		// Remove all matching elements
-		res, err := q.Exec(ctx,
-			"DELETE FROM kv_lists WHERE key = $1 AND value = $2",
-			key, []byte(element),
+		res, err = q.Exec(ctx,
+			"DELETE FROM kv_lists WHERE key_id = $1 AND value = $2",
+			m.id, []byte(element),
 		)
-		if err != nil {
-			return 0, err
+	} else {
+		absCount := count
+		if count < 0 {
+			absCount = -count
 		}
-		return res.RowsAffected(), nil
-	}
 
-	absCount := count
-	if count < 0 {
-		absCount = -count
+		var order string
+		if count > 0 {
+			order = "ASC"
+		} else {
+			order = "DESC"
+		}
+
+		// Delete specific number of elements from head or tail
+		res, err = q.Exec(ctx,
+			fmt.Sprintf(`DELETE FROM kv_lists WHERE ctid IN (
+				SELECT ctid FROM kv_lists 
+				WHERE key_id = $1 AND value = $2
+				ORDER BY idx %s
+				LIMIT $3
+			)`, order),
+			m.id, []byte(element), absCount,
+		)
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := res.RowsAffected()
+	if removed == 0 {
+		return 0, nil
 	}
 
-	var order string
-	if count > 0 {
-		order = "ASC"
-	} else {
-		order = "DESC"
-	}
-
-	// Delete specific number of elements from head or tail
-	res, err := q.Exec(ctx,
-		fmt.Sprintf(`DELETE FROM kv_lists WHERE ctid IN (
-			SELECT ctid FROM kv_lists 
-			WHERE key = $1 AND value = $2
-			ORDER BY idx %s
-			LIMIT $3
-		)`, order),
-		key, []byte(element), absCount,
+	// The elements left of a dense list are still listGap apart, so it
+	// stays dense unless they have gaps between its new head and tail.
+	// Removing the last elements deleted the key and this row with it.
+	_, err = q.Exec(ctx,
+		`UPDATE kv_list_meta m SET head = e.head, tail = e.tail, length = m.length - $2,
+			dense = m.dense AND e.tail - e.head = (m.length - $2 - 1) * $3
+		 FROM (SELECT min(idx) AS head, max(idx) AS tail FROM kv_lists WHERE key_id = $1) e
+		 WHERE m.key_id = $1`,
+		m.id, removed, int64(listGap),
 	)
 	if err != nil {
 		return 0, err
 	}
-	result = res.RowsAffected()
 
-	return result, nil
+	o.notify(ctx, q, notifyList, "lrem", key)
+	return removed, nil
 }
 
 func (o queryOps) rPopLPush(ctx context.Context, q Querier, source, destination string) (string, bool, error) {
 	// Pop from source (right)
-	// Use FOR UPDATE SKIP LOCKED to prevent deadlocks when multiple clients pop concurrently
-	var value []byte
-	var idx int64
-
-	err := q.QueryRow(ctx,
-		`SELECT value, idx FROM kv_lists 
-		 WHERE key = $1 
-		 ORDER BY idx DESC 
-		 LIMIT 1 FOR UPDATE SKIP LOCKED`,
-		source,
-	).Scan(&value, &idx)
-
-	if err == pgx.ErrNoRows {
-		return "", false, nil
-	}
-	if err != nil {
-		return "", false, err
-	}
-
-	// Delete from source
-	_, err = q.Exec(ctx,
-		"DELETE FROM kv_lists WHERE key = $1 AND idx = $2",
-		source, idx,
-	)
-	if err != nil {
+	m, ok, err := o.listMeta(ctx, q, source, true)
+	if err != nil || !ok {
 		return "", false, err
-	}
-
-	// Ensure meta entry exists for destination
-	_, err = q.Exec(ctx,
-		`INSERT INTO kv_meta (key, key_type) VALUES ($1, 'list') ON CONFLICT (key) DO NOTHING`,
-		destination,
-	)
+	}
+	value, err := o.listPopEnd(ctx, q, m, false)
 	if err != nil {
 		return "", false, err
 	}
 
-	// Push to destination (left) using atomic subquery
-	_, err = q.Exec(ctx,
-		`INSERT INTO kv_lists (key, idx, value) 
-		 VALUES ($1, COALESCE((SELECT MIN(idx) FROM kv_lists WHERE key = $1), 0) - 1, $2)`,
-		destination, value,
-	)
-	if err != nil {
+	// A list rotated onto itself is already locked by the pop. Otherwise
+	// the destination is resolved after the pop, which drops the source key
+	// if it was its last element.
+	destID := m.id
+	if source != destination || m.length == 1 {
+		destID, err = o.keyID(ctx, q, destination, TypeList)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	// Push to destination (left)
+	if _, err := o.listPush(ctx, q, destID, []string{value}, true); err != nil {
 		return "", false, err
 	}
 
-	return string(value), true, nil
+	o.notify(ctx, q, notifyList, "rpop", source)
+	o.notify(ctx, q, notifyList, "lpush", destination)
+	return value, true, nil
 }
 
 func (o queryOps) lTrim(ctx context.Context, q Querier, key string, start, stop int64) error {
-	// Get total length
-	var length int64
-	err := q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_lists WHERE key = $1", key).Scan(&length)
-	if err != nil {
+	m, ok, err := o.listMeta(ctx, q, key, true)
+	if err != nil || !ok {
 		return err
 	}
-
-	if length == 0 {
-		return nil
-	}
+	length := m.length
 
 	// Normalize negative indices
 	if start < 0 {
@@ -2104,27 +2027,42 @@ func (o queryOps) lTrim(ctx context.Context, q Querier, key string, start, stop
 
 	// If start > stop, delete entire list
 	if start > stop {
-		_, err := q.Exec(ctx, "DELETE FROM kv_lists WHERE key = $1", key)
+		// Deleting the last elements deletes the key
+		_, err := q.Exec(ctx, "DELETE FROM kv_lists WHERE key_id = $1", m.id)
 		if err != nil {
 			return err
 		}
-		_, err = q.Exec(ctx, "DELETE FROM kv_meta WHERE key = $1", key)
-		return err
+		o.notify(ctx, q, notifyList, "ltrim", key)
+		o.notify(ctx, q, notifyGeneric, "del", key)
+		return nil
 	}
 
-	// Delete elements outside the range using ROW_NUMBER
+	// Delete the elements outside the positions of the new head and tail
+	head, err := o.listIdx(ctx, q, m, start)
+	if err != nil {
+		return err
+	}
+	tail, err := o.listIdx(ctx, q, m, stop)
+	if err != nil {
+		return err
+	}
 	_, err = q.Exec(ctx,
-		`DELETE FROM kv_lists WHERE ctid IN (
-			SELECT ctid FROM (
-				SELECT ctid, ROW_NUMBER() OVER (ORDER BY idx) - 1 AS pos
-				FROM kv_lists WHERE key = $1
-			) sub
-			WHERE pos < $2 OR pos > $3
-		)`,
-		key, start, stop,
+		"DELETE FROM kv_lists WHERE key_id = $1 AND (idx < $2 OR idx > $3)",
+		m.id, head, tail,
 	)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx,
+		"UPDATE kv_list_meta SET head = $2, tail = $3, length = $4 WHERE key_id = $1",
+		m.id, head, tail, stop-start+1,
+	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	o.notify(ctx, q, notifyList, "ltrim", key)
+	return nil
 }
 
 // LPos finds the position of an element in a list
@@ -2132,9 +2070,9 @@ func (o queryOps) lPos(ctx context.Context, q Querier, key, element string, rank
 	// Get all elements in order
 	rows, err := q.Query(ctx,
 		`SELECT ROW_NUMBER() OVER (ORDER BY idx) - 1 AS pos, value 
-		 FROM kv_lists WHERE key = $1 
+		 FROM kv_lists WHERE key_id = postkeys_key_id($2, $1) 
 		 ORDER BY idx`,
-		key,
+		key, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -2178,49 +2116,56 @@ func (o queryOps) lPos(ctx context.Context, q Querier, key, element string, rank
 
 // LSet sets an element at a specific index
 func (o queryOps) lSet(ctx context.Context, q Querier, key string, index int64, element string) error {
-	// Get total count
-	var total int64
-	if err := q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_lists WHERE key = $1", key).Scan(&total); err != nil {
+	m, ok, err := o.listMeta(ctx, q, key, true)
+	if err != nil {
 		return err
 	}
-
-	if total == 0 {
+	if !ok {
 		return fmt.Errorf("ERR no such key")
 	}
 
 	// Convert negative index
 	if index < 0 {
-		index = total + index
+		index = m.length + index
 	}
-	if index < 0 || index >= total {
+	if index < 0 || index >= m.length {
 		return fmt.Errorf("ERR index out of range")
 	}
 
-	// Get the idx value at the position
-	var idx int64
-	err := q.QueryRow(ctx,
-		`SELECT idx FROM kv_lists WHERE key = $1 ORDER BY idx LIMIT 1 OFFSET $2`,
-		key, index,
-	).Scan(&idx)
+	// Get the position of the element at the index
+	idx, err := o.listIdx(ctx, q, m, index)
 	if err != nil {
 		return err
 	}
 
 	// Update the value
 	_, err = q.Exec(ctx,
-		"UPDATE kv_lists SET value = $3 WHERE key = $1 AND idx = $2",
-		key, idx, []byte(element),
+		"UPDATE kv_lists SET value = $3 WHERE key_id = $1 AND idx = $2",
+		m.id, idx, []byte(element),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	o.notify(ctx, q, notifyList, "lset", key)
+	return nil
 }
 
 // LInsert inserts an element before or after a pivot element
 func (o queryOps) lInsert(ctx context.Context, q Querier, key, pivot, element string, before bool) (int64, error) {
+	// Lock the list to serialize list operations
+	m, ok, err := o.listMeta(ctx, q, key, true)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, nil
+	}
+
 	// Find the pivot element
 	var pivotIdx int64
-	err := q.QueryRow(ctx,
-		`SELECT idx FROM kv_lists WHERE key = $1 AND value = $2 ORDER BY idx LIMIT 1`,
-		key, []byte(pivot),
+	err = q.QueryRow(ctx,
+		`SELECT idx FROM kv_lists WHERE key_id = $1 AND value = $2 ORDER BY idx LIMIT 1`,
+		m.id, []byte(pivot),
 	).Scan(&pivotIdx)
 	if err == pgx.ErrNoRows {
 		return -1, nil // Pivot not found
@@ -2229,54 +2174,82 @@ func (o queryOps) lInsert(ctx context.Context, q Querier, key, pivot, element st
 		return 0, err
 	}
 
-	// Use advisory lock to serialize list operations
-	_, err = q.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1)::bigint)", key)
-	if err != nil {
+	// Find the pivot's neighbour on the side the element goes
+	neighbourSQL := "SELECT max(idx) FROM kv_lists WHERE key_id = $1 AND idx < $2"
+	step := int64(-listGap)
+	if !before {
+		neighbourSQL = "SELECT min(idx) FROM kv_lists WHERE key_id = $1 AND idx > $2"
+		step = listGap
+	}
+	var neighbour *int64
+	if err := q.QueryRow(ctx, neighbourSQL, m.id, pivotIdx).Scan(&neighbour); err != nil {
 		return 0, err
 	}
 
-	// Calculate insertion index - shift elements to make room
-	if before {
-		// BEFORE: Insert before the pivot
-		// Shift pivot and all elements after it UP by 1 to make room
-		_, err = q.Exec(ctx,
-			"UPDATE kv_lists SET idx = idx + 1 WHERE key = $1 AND idx >= $2",
-			key, pivotIdx,
-		)
-		if err != nil {
-			return 0, err
-		}
-		// Insert at the original pivot position (pivot has moved up)
-		_, err = q.Exec(ctx,
-			"INSERT INTO kv_lists (key, idx, value) VALUES ($1, $2, $3)",
-			key, pivotIdx, []byte(element),
-		)
-	} else {
-		// AFTER: Insert after the pivot
-		// Shift all elements after pivot UP by 1 to make room
-		_, err = q.Exec(ctx,
-			"UPDATE kv_lists SET idx = idx + 1 WHERE key = $1 AND idx > $2",
-			key, pivotIdx,
-		)
+	// A new head or tail goes a full step out, which keeps a dense list
+	// dense. Between two elements it takes the middle position, and once
+	// there is none left the list is renumbered to make room.
+	var idx int64
+	switch {
+	case neighbour == nil:
+		idx = pivotIdx + step
+	case *neighbour-pivotIdx > 1 || pivotIdx-*neighbour > 1:
+		idx = pivotIdx + (*neighbour-pivotIdx)/2
+	default:
+		pivotIdx, err = o.renumberList(ctx, q, m, pivotIdx)
 		if err != nil {
 			return 0, err
 		}
-		// Insert right after the pivot
-		_, err = q.Exec(ctx,
-			"INSERT INTO kv_lists (key, idx, value) VALUES ($1, $2, $3)",
-			key, pivotIdx+1, []byte(element),
-		)
+		idx = pivotIdx + step/2
+	}
+
+	_, err = q.Exec(ctx,
+		"INSERT INTO kv_lists (key_id, idx, value) VALUES ($1, $2, $3)",
+		m.id, idx, []byte(element),
+	)
+	if err != nil {
+		return 0, err
 	}
+	_, err = q.Exec(ctx,
+		`UPDATE kv_list_meta SET head = LEAST(head, $2), tail = GREATEST(tail, $2),
+			length = length + 1, dense = dense AND $3
+		 WHERE key_id = $1`,
+		m.id, idx, neighbour == nil,
+	)
 	if err != nil {
 		return 0, err
 	}
 
-	// Return new length
-	var length int64
-	if err := q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_lists WHERE key = $1", key).Scan(&length); err != nil {
+	o.notify(ctx, q, notifyList, "linsert", key)
+	return m.length + 1, nil
+}
+
+// renumberList spreads the elements of list m, which must be locked by
+// listMeta, listGap positions apart again and returns the new position of
+// the element at position idx. The new positions start past the tail, so no
+// two elements ever share one midway.
+func (o queryOps) renumberList(ctx context.Context, q Querier, m listMeta, idx int64) (int64, error) {
+	head := m.tail + listGap
+	var moved int64
+	err := q.QueryRow(ctx,
+		`WITH moved AS (
+			UPDATE kv_lists l SET idx = $2 + (r.n - 1) * $3
+			FROM (SELECT idx, row_number() OVER (ORDER BY idx) AS n FROM kv_lists WHERE key_id = $1) r
+			WHERE l.key_id = $1 AND l.idx = r.idx
+			RETURNING r.idx AS old_idx, l.idx AS new_idx
+		)
+		SELECT new_idx FROM moved WHERE old_idx = $4`,
+		m.id, head, int64(listGap), idx,
+	).Scan(&moved)
+	if err != nil {
 		return 0, err
 	}
-	return length, nil
+
+	_, err = q.Exec(ctx,
+		"UPDATE kv_list_meta SET head = $2, tail = $3, dense = true WHERE key_id = $1",
+		m.id, head, head+(m.length-1)*listGap,
+	)
+	return moved, err
 }
 
 // ============== Set Operation Extensions ==============
@@ -2287,8 +2260,8 @@ func (o queryOps) sMIsMember(ctx context.Context, q Querier, key string, members
 	// Build a set of existing members for O(1) lookup
 	existing := make(map[string]bool)
 	rows, err := q.Query(ctx,
-		"SELECT member FROM kv_sets WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT member FROM kv_sets WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -2360,7 +2333,7 @@ func (o queryOps) sInterStore(ctx context.Context, q Querier, destination string
 	}
 
 	// Delete destination key
-	if err := o.deleteKeyFromAllTables(ctx, q, destination); err != nil {
+	if err := o.deleteKeys(ctx, q, []string{destination}); err != nil {
 		return 0, err
 	}
 
@@ -2369,7 +2342,12 @@ func (o queryOps) sInterStore(ctx context.Context, q Querier, destination string
 	}
 
 	// Add members to destination
-	return o.sAdd(ctx, q, destination, members)
+	added, err := o.quiet().sAdd(ctx, q, destination, members)
+	if err != nil {
+		return 0, err
+	}
+	o.notify(ctx, q, notifySet, "sinterstore", destination)
+	return added, nil
 }
 
 func (o queryOps) sUnion(ctx context.Context, q Querier, keys []string) ([]string, error) {
@@ -2402,7 +2380,7 @@ func (o queryOps) sUnionStore(ctx context.Context, q Querier, destination string
 	}
 
 	// Delete destination key
-	if err := o.deleteKeyFromAllTables(ctx, q, destination); err != nil {
+	if err := o.deleteKeys(ctx, q, []string{destination}); err != nil {
 		return 0, err
 	}
 
@@ -2411,7 +2389,12 @@ func (o queryOps) sUnionStore(ctx context.Context, q Querier, destination string
 	}
 
 	// Add members to destination
-	return o.sAdd(ctx, q, destination, members)
+	added, err := o.quiet().sAdd(ctx, q, destination, members)
+	if err != nil {
+		return 0, err
+	}
+	o.notify(ctx, q, notifySet, "sunionstore", destination)
+	return added, nil
 }
 
 func (o queryOps) sDiff(ctx context.Context, q Querier, keys []string) ([]string, error) {
@@ -2458,7 +2441,7 @@ func (o queryOps) sDiffStore(ctx context.Context, q Querier, destination string,
 	}
 
 	// Delete destination key
-	if err := o.deleteKeyFromAllTables(ctx, q, destination); err != nil {
+	if err := o.deleteKeys(ctx, q, []string{destination}); err != nil {
 		return 0, err
 	}
 
@@ -2467,7 +2450,12 @@ func (o queryOps) sDiffStore(ctx context.Context, q Querier, destination string,
 	}
 
 	// Add members to destination
-	return o.sAdd(ctx, q, destination, members)
+	added, err := o.quiet().sAdd(ctx, q, destination, members)
+	if err != nil {
+		return 0, err
+	}
+	o.notify(ctx, q, notifySet, "sdiffstore", destination)
+	return added, nil
 }
 
 // ============== Sorted Set Extensions ==============
@@ -2476,10 +2464,10 @@ func (o queryOps) zPopMax(ctx context.Context, q Querier, key string, count int6
 	// Get the highest-scored members
 	rows, err := q.Query(ctx,
 		`SELECT member, score FROM kv_zsets 
-		 WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		 WHERE key_id = postkeys_key_id($3, $1)
 		 ORDER BY score DESC, member DESC
 		 LIMIT $2`,
-		key, count,
+		key, count, o.db,
 	)
 	if err != nil {
 		return nil, err
@@ -2503,12 +2491,13 @@ func (o queryOps) zPopMax(ctx context.Context, q Querier, key string, count int6
 			memberBytes[i] = []byte(m.Member)
 		}
 		_, err = q.Exec(ctx,
-			"DELETE FROM kv_zsets WHERE key = $1 AND member = ANY($2)",
-			key, memberBytes,
+			"DELETE FROM kv_zsets WHERE key_id = postkeys_key_id($3, $1) AND member = ANY($2)",
+			key, memberBytes, o.db,
 		)
 		if err != nil {
 			return nil, err
 		}
+		o.notify(ctx, q, notifyZSet, "zpopmax", key)
 	}
 
 	return members, nil
@@ -2519,9 +2508,9 @@ func (o queryOps) zRank(ctx context.Context, q Querier, key, member string) (int
 	err := q.QueryRow(ctx,
 		`SELECT rank FROM (
 			SELECT member, ROW_NUMBER() OVER (ORDER BY score ASC, member ASC) - 1 AS rank
-			FROM kv_zsets WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())
+			FROM kv_zsets WHERE key_id = postkeys_key_id($3, $1)
 		) sub WHERE member = $2`,
-		key, []byte(member),
+		key, []byte(member), o.db,
 	).Scan(&rank)
 
 	if err == pgx.ErrNoRows {
@@ -2538,9 +2527,9 @@ func (o queryOps) zRevRank(ctx context.Context, q Querier, key, member string) (
 	err := q.QueryRow(ctx,
 		`SELECT rank FROM (
 			SELECT member, ROW_NUMBER() OVER (ORDER BY score DESC, member DESC) - 1 AS rank
-			FROM kv_zsets WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())
+			FROM kv_zsets WHERE key_id = postkeys_key_id($3, $1)
 		) sub WHERE member = $2`,
-		key, []byte(member),
+		key, []byte(member), o.db,
 	).Scan(&rank)
 
 	if err == pgx.ErrNoRows {
@@ -2556,93 +2545,12 @@ func (o queryOps) zCount(ctx context.Context, q Querier, key string, min, max fl
 	var count int64
 	err := q.QueryRow(ctx,
 		`SELECT COUNT(*) FROM kv_zsets 
-		 WHERE key = $1 AND score >= $2 AND score <= $3 AND (expires_at IS NULL OR expires_at > NOW())`,
-		key, min, max,
+		 WHERE key_id = postkeys_key_id($4, $1) AND score >= $2 AND score <= $3`,
+		key, min, max, o.db,
 	).Scan(&count)
 	return count, err
 }
 
-func (o queryOps) zScan(ctx context.Context, q Querier, key string, cursor int64, pattern string, count int64) (int64, []ZMember, error) {
-	// Get all members
-	rows, err := q.Query(ctx,
-		`SELECT member, score FROM kv_zsets 
-		 WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())
-		 ORDER BY score ASC, member ASC`,
-		key,
-	)
-	if err != nil {
-		return 0, nil, err
-	}
-	defer rows.Close()
-
-	var allMembers []ZMember
-	for rows.Next() {
-		var member []byte
-		var score float64
-		if err := rows.Scan(&member, &score); err != nil {
-			return 0, nil, err
-		}
-		// Apply pattern matching
-		if pattern != "" && pattern != "*" {
-			matched, _ := matchGlob(pattern, string(member))
-			if !matched {
-				continue
-			}
-		}
-		allMembers = append(allMembers, ZMember{Member: string(member), Score: score})
-	}
-
-	// Simulate cursor-based pagination
-	start := int(cursor)
-	if start >= len(allMembers) {
-		return 0, []ZMember{}, nil
-	}
-
-	end := start + int(count)
-	if end > len(allMembers) {
-		end = len(allMembers)
-	}
-
-	result := allMembers[start:end]
-
-	var nextCursor int64
-	if end >= len(allMembers) {
-		nextCursor = 0
-	} else {
-		nextCursor = int64(end)
-	}
-
-	return nextCursor, result, nil
-}
-
-func matchGlob(pattern, s string) (bool, error) {
-	pi, si := 0, 0
-	starIdx, matchIdx := -1, 0
-
-	for si < len(s) {
-		if pi < len(pattern) && (pattern[pi] == '?' || pattern[pi] == s[si]) {
-			pi++
-			si++
-		} else if pi < len(pattern) && pattern[pi] == '*' {
-			starIdx = pi
-			matchIdx = si
-			pi++
-		} else if starIdx != -1 {
-			pi = starIdx + 1
-			matchIdx++
-			si = matchIdx
-		} else {
-			return false, nil
-		}
-	}
-
-	for pi < len(pattern) && pattern[pi] == '*' {
-		pi++
-	}
-
-	return pi == len(pattern), nil
-}
-
 func (o queryOps) zUnionStore(ctx context.Context, q Querier, destination string, keys []string, weights []float64, aggregate string) (int64, error) {
 	if len(weights) == 0 {
 		weights = make([]float64, len(keys))
@@ -2665,7 +2573,7 @@ func (o queryOps) zUnionStore(ctx context.Context, q Querier, destination string
 	}
 
 	// Delete destination
-	if err := o.deleteKeyFromAllTables(ctx, q, destination); err != nil {
+	if err := o.deleteKeys(ctx, q, []string{destination}); err != nil {
 		return 0, err
 	}
 
@@ -2700,7 +2608,12 @@ func (o queryOps) zUnionStore(ctx context.Context, q Querier, destination string
 		members = append(members, ZMember{Member: member, Score: finalScore})
 	}
 
-	return o.zAdd(ctx, q, destination, members)
+	added, err := o.quiet().zAdd(ctx, q, destination, members)
+	if err != nil {
+		return 0, err
+	}
+	o.notify(ctx, q, notifyZSet, "zunionstore", destination)
+	return added, nil
 }
 
 func (o queryOps) zInterStore(ctx context.Context, q Querier, destination string, keys []string, weights []float64, aggregate string) (int64, error) {
@@ -2749,7 +2662,7 @@ func (o queryOps) zInterStore(ctx context.Context, q Querier, destination string
 	}
 
 	// Delete destination
-	if err := o.deleteKeyFromAllTables(ctx, q, destination); err != nil {
+	if err := o.deleteKeys(ctx, q, []string{destination}); err != nil {
 		return 0, err
 	}
 
@@ -2784,16 +2697,21 @@ func (o queryOps) zInterStore(ctx context.Context, q Querier, destination string
 		members = append(members, ZMember{Member: member, Score: finalScore})
 	}
 
-	return o.zAdd(ctx, q, destination, members)
+	added, err := o.quiet().zAdd(ctx, q, destination, members)
+	if err != nil {
+		return 0, err
+	}
+	o.notify(ctx, q, notifyZSet, "zinterstore", destination)
+	return added, nil
 }
 
 // ============== Key Extensions ==============
 
 func (o queryOps) expireAt(ctx context.Context, q Querier, key string, timestamp time.Time) (bool, error) {
 	result, err := q.Exec(ctx,
-		`UPDATE kv_meta SET expires_at = $2 
-		 WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())`,
-		key, timestamp,
+		`UPDATE kv_keys SET expires_at = $2 
+		 WHERE db = $3 AND key = $1 AND (expires_at IS NULL OR expires_at > NOW())`,
+		key, timestamp, o.db,
 	)
 	if err != nil {
 		return false, err
@@ -2802,42 +2720,46 @@ func (o queryOps) expireAt(ctx context.Context, q Querier, key string, timestamp
 	if result.RowsAffected() == 0 {
 		return false, nil
 	}
+	o.notify(ctx, q, notifyGeneric, "expire", key)
+	return true, nil
+}
 
-	// Update expires_at in the data table
-	keyType, err := o.getKeyType(ctx, q, key)
-	if err != nil {
-		return false, err
-	}
-
-	var table string
-	switch keyType {
-	case TypeString:
-		table = "kv_strings"
-	case TypeHash:
-		table = "kv_hashes"
-	case TypeList:
-		table = "kv_lists"
-	case TypeSet:
-		table = "kv_sets"
-	case TypeZSet:
-		table = "kv_zsets"
-	default:
-		return true, nil
-	}
-
-	_, err = q.Exec(ctx, fmt.Sprintf("UPDATE %s SET expires_at = $2 WHERE key = $1", table), key, timestamp)
-	return err == nil, err
+// copyColumns lists the tables holding each type's data with the columns
+// COPY carries over to the new key
+var copyColumns = map[KeyType][][2]string{
+	TypeString: {{"kv_strings", "value"}},
+	TypeHash:   {{"kv_hashes", "field, value"}},
+	TypeList:   {{"kv_lists", "idx, value"}, {"kv_list_meta", "head, tail, length, dense"}},
+	TypeSet:    {{"kv_sets", "member"}},
+	TypeZSet:   {{"kv_zsets", "member, score"}},
+	TypeStream: {
+		{"kv_streams", "ms, seq, fields"},
+		{"kv_stream_meta", "last_ms, last_seq, max_deleted_ms, max_deleted_seq, entries_added"},
+		// Consumer groups are copied along with the entries
+		{"kv_stream_groups", "group_name, last_ms, last_seq, entries_read"},
+		{"kv_stream_consumers", "group_name, consumer, seen_time, active_time"},
+		{"kv_stream_pending", "group_name, ms, seq, consumer, delivered_at, delivery_count"},
+	},
+	TypeJSON:      {{"kv_json", "value"}},
+	"hyperloglog": {{"kv_hyperloglog", "registers"}},
 }
 
 func (o queryOps) copyKey(ctx context.Context, q Querier, source, destination string, replace bool) (bool, error) {
 	// Get source key type
-	keyType, err := o.getKeyType(ctx, q, source)
+	var sourceID int64
+	var keyType string
+	var expiresAt *time.Time
+	err := q.QueryRow(ctx,
+		`SELECT id, key_type, expires_at FROM kv_keys
+		 WHERE db = $2 AND key = $1 AND (expires_at IS NULL OR expires_at > NOW())`,
+		source, o.db,
+	).Scan(&sourceID, &keyType, &expiresAt)
+	if err == pgx.ErrNoRows {
+		return false, nil // Source doesn't exist
+	}
 	if err != nil {
 		return false, err
 	}
-	if keyType == TypeNone {
-		return false, nil // Source doesn't exist
-	}
 
 	// Check if destination exists
 	destType, err := o.getKeyType(ctx, q, destination)
@@ -2848,160 +2770,36 @@ func (o queryOps) copyKey(ctx context.Context, q Querier, source, destination st
 		return false, nil // Destination exists and replace not set
 	}
 
-	// Delete destination if it exists
-	if destType != TypeNone {
-		if err := o.deleteKeyFromAllTables(ctx, q, destination); err != nil {
-			return false, err
-		}
+	// The copy replaces the destination and keeps the source's TTL
+	destID, err := o.newKey(ctx, q, destination, KeyType(keyType), expiresAt)
+	if err != nil {
+		return false, err
 	}
-
-	// Copy based on type
-	switch keyType {
-	case TypeString:
-		var value []byte
-		var expiresAt *time.Time
-		err := q.QueryRow(ctx,
-			"SELECT value, expires_at FROM kv_strings WHERE key = $1",
-			source,
-		).Scan(&value, &expiresAt)
-		if err != nil {
-			return false, err
-		}
-		_, err = q.Exec(ctx,
-			"INSERT INTO kv_strings (key, value, expires_at) VALUES ($1, $2, $3)",
-			destination, value, expiresAt,
-		)
-		if err != nil {
-			return false, err
-		}
-		if err := o.setMeta(ctx, q, destination, TypeString, expiresAt); err != nil {
-			return false, err
-		}
-
-	case TypeHash:
-		rows, err := q.Query(ctx,
-			"SELECT field, value, expires_at FROM kv_hashes WHERE key = $1",
-			source,
-		)
-		if err != nil {
-			return false, err
-		}
-		defer rows.Close()
-		for rows.Next() {
-			var field string
-			var value []byte
-			var expiresAt *time.Time
-			if err := rows.Scan(&field, &value, &expiresAt); err != nil {
-				return false, err
-			}
-			_, err = q.Exec(ctx,
-				"INSERT INTO kv_hashes (key, field, value, expires_at) VALUES ($1, $2, $3, $4)",
-				destination, field, value, expiresAt,
-			)
-			if err != nil {
-				return false, err
-			}
-		}
-		if err := o.setMeta(ctx, q, destination, TypeHash, nil); err != nil {
-			return false, err
-		}
-
-	case TypeList:
-		rows, err := q.Query(ctx,
-			"SELECT idx, value, expires_at FROM kv_lists WHERE key = $1",
-			source,
-		)
-		if err != nil {
-			return false, err
-		}
-		defer rows.Close()
-		for rows.Next() {
-			var idx int64
-			var value []byte
-			var expiresAt *time.Time
-			if err := rows.Scan(&idx, &value, &expiresAt); err != nil {
-				return false, err
-			}
-			_, err = q.Exec(ctx,
-				"INSERT INTO kv_lists (key, idx, value, expires_at) VALUES ($1, $2, $3, $4)",
-				destination, idx, value, expiresAt,
-			)
-			if err != nil {
-				return false, err
-			}
-		}
-		if err := o.setMeta(ctx, q, destination, TypeList, nil); err != nil {
-			return false, err
-		}
-
-	case TypeSet:
-		rows, err := q.Query(ctx,
-			"SELECT member, expires_at FROM kv_sets WHERE key = $1",
-			source,
-		)
-		if err != nil {
-			return false, err
-		}
-		defer rows.Close()
-		for rows.Next() {
-			var member []byte
-			var expiresAt *time.Time
-			if err := rows.Scan(&member, &expiresAt); err != nil {
-				return false, err
-			}
-			_, err = q.Exec(ctx,
-				"INSERT INTO kv_sets (key, member, expires_at) VALUES ($1, $2, $3)",
-				destination, member, expiresAt,
-			)
-			if err != nil {
-				return false, err
-			}
-		}
-		if err := o.setMeta(ctx, q, destination, TypeSet, nil); err != nil {
-			return false, err
-		}
-
-	case TypeZSet:
-		rows, err := q.Query(ctx,
-			"SELECT member, score, expires_at FROM kv_zsets WHERE key = $1",
-			source,
+	for _, tc := range copyColumns[KeyType(keyType)] {
+		_, err := q.Exec(ctx,
+			fmt.Sprintf("INSERT INTO %[1]s (key_id, %[2]s) SELECT $2, %[2]s FROM %[1]s WHERE key_id = $1", tc[0], tc[1]),
+			sourceID, destID,
 		)
 		if err != nil {
 			return false, err
 		}
-		defer rows.Close()
-		for rows.Next() {
-			var member []byte
-			var score float64
-			var expiresAt *time.Time
-			if err := rows.Scan(&member, &score, &expiresAt); err != nil {
-				return false, err
-			}
-			_, err = q.Exec(ctx,
-				"INSERT INTO kv_zsets (key, member, score, expires_at) VALUES ($1, $2, $3, $4)",
-				destination, member, score, expiresAt,
-			)
-			if err != nil {
-				return false, err
-			}
-		}
-		if err := o.setMeta(ctx, q, destination, TypeZSet, nil); err != nil {
-			return false, err
-		}
 	}
 
+	o.notify(ctx, q, notifyGeneric, "copy_to", destination)
 	return true, nil
 }
 
 // ============== Bitmap Commands ==============
 
 func (o queryOps) setBit(ctx context.Context, q Querier, key string, offset int64, value int) (int64, error) {
+	id, err := o.keyID(ctx, q, key, TypeString)
+	if err != nil {
+		return 0, err
+	}
+
 	// Get existing value or create empty
 	var data []byte
-	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
-	).Scan(&data)
+	err = q.QueryRow(ctx, "SELECT value FROM kv_strings WHERE key_id = $1", id).Scan(&data)
 	if err == pgx.ErrNoRows {
 		data = []byte{}
 	} else if err != nil {
@@ -3028,27 +2826,19 @@ func (o queryOps) setBit(ctx context.Context, q Querier, key string, offset int6
 	}
 
 	// Save back
-	_, err = q.Exec(ctx,
-		`INSERT INTO kv_strings (key, value) VALUES ($1, $2)
-		 ON CONFLICT (key) DO UPDATE SET value = $2`,
-		key, data,
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	if err := o.setMeta(ctx, q, key, TypeString, nil); err != nil {
+	if err := o.putString(ctx, q, id, data); err != nil {
 		return 0, err
 	}
 
+	o.notify(ctx, q, notifyString, "setbit", key)
 	return oldBit, nil
 }
 
 func (o queryOps) getBit(ctx context.Context, q Querier, key string, offset int64) (int64, error) {
 	var data []byte
 	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&data)
 	if err == pgx.ErrNoRows {
 		return 0, nil
@@ -3069,8 +2859,8 @@ func (o queryOps) getBit(ctx context.Context, q Querier, key string, offset int6
 func (o queryOps) bitCount(ctx context.Context, q Querier, key string, start, end int64, useBit bool) (int64, error) {
 	var data []byte
 	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&data)
 	if err == pgx.ErrNoRows {
 		return 0, nil
@@ -3155,8 +2945,8 @@ func (o queryOps) bitOp(ctx context.Context, q Querier, operation, destKey strin
 	for i, key := range keys {
 		var data []byte
 		err := q.QueryRow(ctx,
-			"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-			key,
+			"SELECT value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+			key, o.db,
 		).Scan(&data)
 		if err == pgx.ErrNoRows {
 			values[i] = []byte{}
@@ -3214,32 +3004,24 @@ func (o queryOps) bitOp(ctx context.Context, q Querier, operation, destKey strin
 		return 0, fmt.Errorf("ERR BITOP: unsupported operation '%s'", operation)
 	}
 
-	// Delete destination and save result
-	if err := o.deleteKeyFromAllTables(ctx, q, destKey); err != nil {
-		return 0, err
-	}
-
-	_, err := q.Exec(ctx,
-		`INSERT INTO kv_strings (key, value) VALUES ($1, $2)
-		 ON CONFLICT (key) DO UPDATE SET value = $2`,
-		destKey, result,
-	)
+	// Replace destination with the result
+	id, err := o.newKey(ctx, q, destKey, TypeString, nil)
 	if err != nil {
 		return 0, err
 	}
-
-	if err := o.setMeta(ctx, q, destKey, TypeString, nil); err != nil {
+	if err := o.putString(ctx, q, id, result); err != nil {
 		return 0, err
 	}
 
+	o.notify(ctx, q, notifyString, "set", destKey)
 	return int64(maxLen), nil
 }
 
 func (o queryOps) bitPos(ctx context.Context, q Querier, key string, bit int, start, end int64, useBit bool) (int64, error) {
 	var data []byte
 	err := q.QueryRow(ctx,
-		"SELECT value FROM kv_strings WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
+		"SELECT value FROM kv_strings WHERE key_id = postkeys_key_id($2, $1)",
+		key, o.db,
 	).Scan(&data)
 	if err == pgx.ErrNoRows {
 		if bit == 0 {
@@ -3320,23 +3102,26 @@ func (o queryOps) bitPos(ctx context.Context, q Querier, key string, bit int, st
 
 // ============== HyperLogLog Commands ==============
 
+// putRegisters stores registers as the HyperLogLog of the key with id
+func (o queryOps) putRegisters(ctx context.Context, q Querier, id int64, registers []byte) error {
+	_, err := q.Exec(ctx,
+		`INSERT INTO kv_hyperloglog (key_id, registers) VALUES ($1, $2)
+		 ON CONFLICT (key_id) DO UPDATE SET registers = $2`,
+		id, registers,
+	)
+	return err
+}
+
 func (o queryOps) pfAdd(ctx context.Context, q Querier, key string, elements []string) (int64, error) {
-	// Check key type if exists
-	keyType, err := o.getKeyType(ctx, q, key)
+	id, err := o.keyID(ctx, q, key, "hyperloglog")
 	if err != nil {
 		return 0, err
 	}
-	if keyType != TypeNone && keyType != "hyperloglog" {
-		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
 
 	// Get existing HLL or create new one
 	var hll *HyperLogLog
 	var registers []byte
-	err = q.QueryRow(ctx,
-		"SELECT registers FROM kv_hyperloglog WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		key,
-	).Scan(&registers)
+	err = q.QueryRow(ctx, "SELECT registers FROM kv_hyperloglog WHERE key_id = $1", id).Scan(&registers)
 	if err == pgx.ErrNoRows {
 		hll = NewHyperLogLog()
 	} else if err != nil {
@@ -3354,22 +3139,12 @@ func (o queryOps) pfAdd(ctx context.Context, q Querier, key string, elements []s
 	}
 
 	// Save updated HLL
-	_, err = q.Exec(ctx,
-		`INSERT INTO kv_hyperloglog (key, registers) VALUES ($1, $2)
-		 ON CONFLICT (key) DO UPDATE SET registers = $2`,
-		key, hll.ToBytes(),
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	// Update metadata
-	err = o.setMeta(ctx, q, key, "hyperloglog", nil)
-	if err != nil {
+	if err := o.putRegisters(ctx, q, id, hll.ToBytes()); err != nil {
 		return 0, err
 	}
 
 	if changed {
+		o.notify(ctx, q, notifyString, "pfadd", key)
 		return 1, nil
 	}
 	return 0, nil
@@ -3384,8 +3159,8 @@ func (o queryOps) pfCount(ctx context.Context, q Querier, keys []string) (int64,
 		// Single key - just count
 		var registers []byte
 		err := q.QueryRow(ctx,
-			"SELECT registers FROM kv_hyperloglog WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-			keys[0],
+			"SELECT registers FROM kv_hyperloglog WHERE key_id = postkeys_key_id($2, $1)",
+			keys[0], o.db,
 		).Scan(&registers)
 		if err == pgx.ErrNoRows {
 			return 0, nil
@@ -3402,8 +3177,8 @@ func (o queryOps) pfCount(ctx context.Context, q Querier, keys []string) (int64,
 	for _, key := range keys {
 		var registers []byte
 		err := q.QueryRow(ctx,
-			"SELECT registers FROM kv_hyperloglog WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-			key,
+			"SELECT registers FROM kv_hyperloglog WHERE key_id = postkeys_key_id($2, $1)",
+			key, o.db,
 		).Scan(&registers)
 		if err == pgx.ErrNoRows {
 			continue // Skip non-existent keys
@@ -3419,22 +3194,15 @@ func (o queryOps) pfCount(ctx context.Context, q Querier, keys []string) (int64,
 }
 
 func (o queryOps) pfMerge(ctx context.Context, q Querier, destKey string, sourceKeys []string) error {
-	// Check dest key type if exists
-	keyType, err := o.getKeyType(ctx, q, destKey)
+	id, err := o.keyID(ctx, q, destKey, "hyperloglog")
 	if err != nil {
 		return err
 	}
-	if keyType != TypeNone && keyType != "hyperloglog" {
-		return fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
 
 	// Start with dest key's existing HLL (if any)
 	merged := NewHyperLogLog()
 	var registers []byte
-	err = q.QueryRow(ctx,
-		"SELECT registers FROM kv_hyperloglog WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-		destKey,
-	).Scan(&registers)
+	err = q.QueryRow(ctx, "SELECT registers FROM kv_hyperloglog WHERE key_id = $1", id).Scan(&registers)
 	if err == nil {
 		merged = HyperLogLogFromBytes(registers)
 	} else if err != pgx.ErrNoRows {
@@ -3444,8 +3212,8 @@ func (o queryOps) pfMerge(ctx context.Context, q Querier, destKey string, source
 	// Merge all source keys
 	for _, key := range sourceKeys {
 		err := q.QueryRow(ctx,
-			"SELECT registers FROM kv_hyperloglog WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())",
-			key,
+			"SELECT registers FROM kv_hyperloglog WHERE key_id = postkeys_key_id($2, $1)",
+			key, o.db,
 		).Scan(&registers)
 		if err == pgx.ErrNoRows {
 			continue
@@ -3458,17 +3226,11 @@ func (o queryOps) pfMerge(ctx context.Context, q Querier, destKey string, source
 	}
 
 	// Save merged HLL to dest
-	_, err = q.Exec(ctx,
-		`INSERT INTO kv_hyperloglog (key, registers) VALUES ($1, $2)
-		 ON CONFLICT (key) DO UPDATE SET registers = $2`,
-		destKey, merged.ToBytes(),
-	)
-	if err != nil {
+	if err := o.putRegisters(ctx, q, id, merged.ToBytes()); err != nil {
 		return err
 	}
-
-	// Update metadata
-	return o.setMeta(ctx, q, destKey, "hyperloglog", nil)
+	o.notify(ctx, q, notifyString, "pfadd", destKey)
+	return nil
 }
 
 // ============== Server Commands ==============
@@ -3476,7 +3238,8 @@ func (o queryOps) pfMerge(ctx context.Context, q Querier, destKey string, source
 func (o queryOps) dbSize(ctx context.Context, q Querier) (int64, error) {
 	var count int64
 	err := q.QueryRow(ctx,
-		"SELECT COUNT(*) FROM kv_meta WHERE expires_at IS NULL OR expires_at > NOW()",
+		"SELECT COUNT(*) FROM kv_keys WHERE db = $1 AND (expires_at IS NULL OR expires_at > NOW())",
+		o.db,
 	).Scan(&count)
 	return count, err
 }
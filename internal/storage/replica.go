@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mnorrsken/postkeys/internal/metrics"
+)
+
+// With Config.ReplicaDSN set, read-only operations run with a context from
+// WithReplicaReads are sent to a streaming replica, as long as its replication
+// lag stays within Config.ReplicaMaxLag; otherwise, and for everything else,
+// the primary is used. Reads from the replica may miss the most recent writes,
+// even the caller's own, so they are only allowed for standalone commands,
+// never in MULTI or scripts.
+
+const (
+	// replicaLagCheckInterval is how often the replica's lag is measured
+	replicaLagCheckInterval = 1 * time.Second
+
+	// defaultReplicaMaxLag is the lag bound used when Config doesn't set one
+	defaultReplicaMaxLag = 1 * time.Second
+)
+
+// replicaLagSamples bounds the samples of the primary's WAL position kept
+// while the replica catches up: one per check, so a replica further behind
+// than this many checks has an infinite lag
+const replicaLagSamples = 3600
+
+// replicaLag measures a replica's lag by sampling the primary's WAL position
+// at every check: the lag is how long ago the primary wrote the WAL the
+// replica has replayed up to, interpolated between the samples around it.
+// Comparing with the primary rather than with what the replica received
+// matters: a replica whose WAL receiver stopped has replayed everything it
+// received, yet falls further behind with every write to the primary.
+type replicaLag struct {
+	samples []lsnSample // oldest first, with increasing positions
+}
+
+// lsnSample is the primary's WAL position, and when it was last seen there
+type lsnSample struct {
+	at  time.Time
+	lsn uint64
+}
+
+// check reads the primary's WAL position at now, then the replica's replay
+// position, and returns the lag in seconds; it is infinite while the replica
+// hasn't replayed the oldest sample. A server that isn't a replica has no
+// lag.
+func (l *replicaLag) check(ctx context.Context, primary, replica Querier, now time.Time) (float64, error) {
+	var written string
+	if err := primary.QueryRow(ctx, "SELECT pg_current_wal_lsn()::text").Scan(&written); err != nil {
+		return 0, fmt.Errorf("failed to read the primary's WAL position: %w", err)
+	}
+	target, err := parseLSN(written)
+	if err != nil {
+		return 0, err
+	}
+	l.sample(now, target)
+
+	var recovering bool
+	var replayed string
+	err = replica.QueryRow(ctx,
+		"SELECT pg_is_in_recovery(), COALESCE(pg_last_wal_replay_lsn()::text, '0/0')",
+	).Scan(&recovering, &replayed)
+	if err != nil {
+		return 0, err
+	}
+	if !recovering {
+		return 0, nil
+	}
+	position, err := parseLSN(replayed)
+	if err != nil {
+		return 0, err
+	}
+
+	// Samples before the newest one the replica replayed no longer matter
+	i := sort.Search(len(l.samples), func(i int) bool { return l.samples[i].lsn > position }) - 1
+	if i < 0 {
+		return math.Inf(1), nil
+	}
+	l.samples = l.samples[i:]
+	replayedAt := l.samples[0].at
+	if len(l.samples) > 1 {
+		// The primary wrote position between the two samples around it
+		a, b := l.samples[0], l.samples[1]
+		fraction := float64(position-a.lsn) / float64(b.lsn-a.lsn)
+		replayedAt = a.at.Add(time.Duration(fraction * float64(b.at.Sub(a.at))))
+	}
+	return now.Sub(replayedAt).Seconds(), nil
+}
+
+// sample records that the primary was at WAL position lsn at now
+func (l *replicaLag) sample(now time.Time, lsn uint64) {
+	n := len(l.samples)
+	switch {
+	case n > 0 && l.samples[n-1].lsn == lsn:
+		// The primary was idle: what it wrote was still all of it at now
+		l.samples[n-1].at = now
+		return
+	case n > 0 && l.samples[n-1].lsn > lsn:
+		// Positions went back, so this is a different primary
+		l.samples = l.samples[:0]
+	case n == replicaLagSamples:
+		l.samples = append(l.samples[:0], l.samples[1:]...)
+	}
+	l.samples = append(l.samples, lsnSample{at: now, lsn: lsn})
+}
+
+// parseLSN parses a pg_lsn in its text form, such as 16/B374D848
+func parseLSN(s string) (uint64, error) {
+	var hi, lo uint32
+	if _, err := fmt.Sscanf(s, "%X/%X", &hi, &lo); err != nil {
+		return 0, fmt.Errorf("invalid WAL position %q", s)
+	}
+	return uint64(hi)<<32 | uint64(lo), nil
+}
+
+type replicaReadsContextKey struct{}
+
+// WithReplicaReads returns a context allowing the read-only operations run
+// with it to read from the replica, if one is configured
+func WithReplicaReads(ctx context.Context, allowed bool) context.Context {
+	return context.WithValue(ctx, replicaReadsContextKey{}, allowed)
+}
+
+// replicaReadsAllowed reports whether ctx allows replica reads
+func replicaReadsAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(replicaReadsContextKey{}).(bool)
+	return allowed
+}
+
+// newReplicaPool connects to the replica in cfg, with the same schema,
+// PgBouncer mode and connection settings as the primary
+func newReplicaPool(ctx context.Context, cfg Config) (*pgxpool.Pool, error) {
+	poolCfg, err := cfg.poolConfig(cfg.ReplicaDSN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid replica DSN: %w", err)
+	}
+	if cfg.PgBouncer {
+		poolCfg.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	} else if cfg.Schema != "" {
+		poolCfg.ConnConfig.RuntimeParams["search_path"] = cfg.Schema
+	}
+	return pgxpool.NewWithConfig(ctx, poolCfg)
+}
+
+// reader returns a Querier for a read-only operation: the replica if ctx
+// allows it and it is within the lag bound, else the primary
+func (s *Store) reader(ctx context.Context) Querier {
+	pool := s.readerPool(ctx)
+	if s.sqlTraceLevel > 0 {
+		return NewTracingQuerier(pool, s.sqlTraceLevel)
+	}
+	return pool
+}
+
+// readerPool returns the pool reader queries
+func (s *Store) readerPool(ctx context.Context) *pgxpool.Pool {
+	if s.replicaPool == nil || !s.replicaUsable.Load() || !replicaReadsAllowed(ctx) {
+		return s.poolFor(ctx)
+	}
+	return s.replicaPool
+}
+
+// keyMissReader is reader for operations that may send key miss
+// notifications, which a replica can't send
+func (s *Store) keyMissReader(ctx context.Context) Querier {
+	if s.events.enabled(notifyKeyMiss) {
+		return s.querier(ctx)
+	}
+	return s.reader(ctx)
+}
+
+// monitorReplica measures the replica's lag until ctx is cancelled, allowing
+// replica reads while it is within maxLag
+func (s *Store) monitorReplica(ctx context.Context, maxLag time.Duration) {
+	defer close(s.replicaMonitorDone)
+
+	var tracker replicaLag
+	first := true
+	for {
+		lag, err := tracker.check(ctx, s.pool, s.replicaPool, time.Now())
+		if ctx.Err() != nil {
+			return
+		}
+		usable := err == nil && lag <= maxLag.Seconds()
+		if usable != s.replicaUsable.Load() || (first && !usable) {
+			switch {
+			case err != nil:
+				log.Printf("Replica lag check failed, reading from the primary: %v", err)
+			case !usable:
+				log.Printf("Replica lag %.1fs exceeds %v, reading from the primary", lag, maxLag)
+			default:
+				log.Printf("Replica lag %.1fs is within %v, reading from the replica", lag, maxLag)
+			}
+		}
+		s.replicaUsable.Store(usable)
+		first = false
+
+		if err == nil {
+			metrics.ReplicaLag.Set(lag)
+		}
+		metrics.ReplicaReads.Set(boolGauge(usable))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(replicaLagCheckInterval):
+		}
+	}
+}
+
+// boolGauge converts a bool to a gauge value
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
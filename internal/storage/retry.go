@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mnorrsken/postkeys/internal/metrics"
+)
+
+// Transactions contending for hot keys can fail with errors that say nothing
+// about the transaction itself and go away when it runs again: deadlocks,
+// serialization failures and lock timeouts. withTx, and EXEC through RetryTx,
+// retry them with jittered exponential backoff.
+
+const (
+	// MaxTxAttempts is how many times a transaction runs before its
+	// retryable error is returned
+	MaxTxAttempts = 5
+
+	// The backoff before the nth retry is a random duration between half and
+	// all of retryBaseDelay*2^(n-1), capped at retryMaxDelay
+	retryBaseDelay = 5 * time.Millisecond
+	retryMaxDelay  = 200 * time.Millisecond
+)
+
+// retryableCodes are the SQLSTATEs of the errors worth retrying
+var retryableCodes = []string{
+	"40001", // serialization_failure
+	"40P01", // deadlock_detected
+	"55P03", // lock_not_available
+}
+
+// retryableCode returns the SQLSTATE of err if it is retryable, else "".
+// Errors flattened to text, like command replies, are recognised by the
+// "(SQLSTATE code)" suffix of pgx's error messages.
+func retryableCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		for _, code := range retryableCodes {
+			if pgErr.Code == code {
+				return code
+			}
+		}
+		return ""
+	}
+	msg := err.Error()
+	for _, code := range retryableCodes {
+		if strings.Contains(msg, "(SQLSTATE "+code+")") {
+			return code
+		}
+	}
+	return ""
+}
+
+// IsRetryable reports whether err is a deadlock, serialization failure or
+// lock timeout, which running the transaction again may not hit
+func IsRetryable(err error) bool {
+	return retryableCode(err) != ""
+}
+
+// statementErrKey is the context key of the *statementErrors a command's
+// failed statements are recorded in
+type statementErrKey struct{}
+
+// statementErrors holds the error of the last statement that failed
+type statementErrors struct {
+	mu  sync.Mutex
+	err error
+}
+
+// WithStatementErrors returns a context that records the error of the last
+// statement run with it that failed, for StatementError. Command replies
+// carry storage errors as text; this keeps the error itself, so the handler
+// can tell whether a failed command hit a deadlock or serialization failure.
+func WithStatementErrors(ctx context.Context) context.Context {
+	return context.WithValue(ctx, statementErrKey{}, &statementErrors{})
+}
+
+// StatementError returns the error of the last statement that failed under
+// ctx, since WithStatementErrors or the start of the last RetryTx attempt
+func StatementError(ctx context.Context) error {
+	if e, ok := ctx.Value(statementErrKey{}).(*statementErrors); ok {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.err
+	}
+	return nil
+}
+
+// setStatementError records err as the last statement error under ctx
+func setStatementError(ctx context.Context, err error) {
+	if e, ok := ctx.Value(statementErrKey{}).(*statementErrors); ok {
+		e.mu.Lock()
+		e.err = err
+		e.mu.Unlock()
+	}
+}
+
+// statementTracer is the pgx query tracer of every pool, recording failed
+// statements for StatementError
+type statementTracer struct{}
+
+func (statementTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (statementTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if data.Err != nil {
+		setStatementError(ctx, data.Err)
+	}
+}
+
+// RetryTx runs fn, which must run a whole transaction, until it succeeds,
+// fails with an error that isn't retryable, or has run MaxTxAttempts times.
+// scope labels the retry metrics.
+func RetryTx(ctx context.Context, scope string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		// Only the last attempt's failures explain the error returned
+		setStatementError(ctx, nil)
+		err := fn()
+		code := retryableCode(err)
+		if code == "" {
+			return err
+		}
+		if attempt == MaxTxAttempts {
+			metrics.TxRetriesExhausted.WithLabelValues(scope).Inc()
+			return err
+		}
+		metrics.TxRetries.WithLabelValues(scope, code).Inc()
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(retryDelay(attempt)):
+		}
+	}
+}
+
+// retryDelay returns the jittered backoff before the nth retry
+func retryDelay(n int) time.Duration {
+	d := min(retryBaseDelay<<(n-1), retryMaxDelay)
+	return d/2 + rand.N(d/2+1)
+}
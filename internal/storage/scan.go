@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mnorrsken/postkeys/internal/stringmatch"
+)
+
+// The SCAN family pages through its table in order of a 64-bit hash of each
+// key (or field, or member), computed by postkeys_scan_hash and indexed. The
+// cursor is the hash the next page starts at, so cursors carry no state on
+// the server, never expire and work on any pod or replica. Cursor 0 starts a
+// scan and is returned once it is complete. A page stops short of the rows
+// sharing the hash of the row after it, so everything present for the whole
+// scan is returned exactly once however much the table changes in between.
+//
+// MATCH patterns are translated to LIKE for filtering in SQL; when LIKE can
+// only narrow the rows down, each one is checked against the pattern as well.
+// Rows filtered out that way still move the cursor on, so a page may return
+// fewer than COUNT elements, like in Redis.
+
+// scanQuery describes the rows a SCAN-family command pages through
+type scanQuery struct {
+	table   string
+	columns string // selected columns
+	order   string // column whose hash the scan is ordered by
+	where   []string
+	args    []interface{}
+}
+
+// filter adds a condition on arg, with %d standing for its parameter number
+func (sq *scanQuery) filter(cond string, arg interface{}) {
+	sq.args = append(sq.args, arg)
+	sq.where = append(sq.where, fmt.Sprintf(cond, len(sq.args)))
+}
+
+// hash returns the expression the rows of sq are ordered by
+func (sq *scanQuery) hash() string {
+	return "postkeys_scan_hash(" + sq.order + ")"
+}
+
+// Cursors map hashes to uint64 in order, so the smallest hash is cursor 0:
+// a page boundary is never at that hash, as a page ends at a row with a
+// greater hash than its first one
+func scanCursor(hash int64) uint64 { return uint64(hash) ^ 1<<63 }
+func scanHash(cursor uint64) int64 { return int64(cursor ^ 1<<63) }
+
+// scanPage reads a page of up to count rows of sq from q, starting at
+// cursor. Rows are scanned into dest, and emit is called for each one on the
+// page. It returns the cursor of the next page, or 0 if this was the last.
+func (o queryOps) scanPage(ctx context.Context, q Querier, cursor uint64, count int64, sq scanQuery, dest []interface{}, emit func()) (uint64, error) {
+	if cursor != 0 {
+		sq.filter(sq.hash()+" >= $%d", scanHash(cursor))
+	}
+
+	// Fetch one row past the page; its hash is the next cursor
+	var next uint64
+	var shared *int64
+	err := o.scanRows(ctx, q, sq, min(count, math.MaxInt64-1)+1, dest, func(hash, n, first, last int64) {
+		switch {
+		case n <= count:
+			emit()
+		case first == last:
+			// More than count rows share one hash
+			shared = &last
+		case hash < last:
+			next = scanCursor(last)
+			emit()
+		}
+	})
+	if err != nil || shared == nil {
+		return next, err
+	}
+
+	// Return every row with that hash, to move on past it
+	group := sq
+	group.where = append([]string(nil), sq.where...)
+	group.args = append([]interface{}(nil), sq.args...)
+	group.filter(group.hash()+" = $%d", *shared)
+	if err := o.scanRows(ctx, q, group, 0, dest, func(int64, int64, int64, int64) { emit() }); err != nil {
+		return 0, err
+	}
+	sq.filter(sq.hash()+" > $%d", *shared)
+	var hash int64
+	err = q.QueryRow(ctx,
+		fmt.Sprintf("SELECT %s AS h FROM %s WHERE %s ORDER BY h LIMIT 1",
+			sq.hash(), sq.table, strings.Join(sq.where, " AND ")),
+		sq.args...,
+	).Scan(&hash)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	return scanCursor(hash), err
+}
+
+// scanRows reads up to limit rows of sq (all of them if limit is 0) in hash
+// order, scanning each into dest and passing visit its hash, along with the
+// number of rows read and the first and last hash among them
+func (o queryOps) scanRows(ctx context.Context, q Querier, sq scanQuery, limit int64, dest []interface{}, visit func(hash, n, first, last int64)) error {
+	var limitArg interface{}
+	if limit > 0 {
+		limitArg = limit
+	}
+	sq.args = append(sq.args, limitArg)
+	rows, err := q.Query(ctx,
+		fmt.Sprintf(`SELECT %[1]s, h, count(*) OVER (), min(h) OVER (), max(h) OVER ()
+			FROM (SELECT %[1]s, %[2]s AS h FROM %[3]s WHERE %[4]s ORDER BY h LIMIT $%[5]d) page
+			ORDER BY h`,
+			sq.columns, sq.hash(), sq.table, strings.Join(sq.where, " AND "), len(sq.args)),
+		sq.args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var hash, n, first, last int64
+	dest = append(dest[:len(dest):len(dest)], &hash, &n, &first, &last)
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		visit(hash, n, first, last)
+	}
+	return rows.Err()
+}
+
+func (o queryOps) scan(ctx context.Context, q Querier, cursor uint64, pattern string, count int64, keyType KeyType) (uint64, []string, error) {
+	sq := scanQuery{table: "kv_keys", columns: "key", order: "key"}
+	sq.filter("db = $%d", o.db)
+	sq.where = append(sq.where, "(expires_at IS NULL OR expires_at > NOW())")
+	like, exact := stringmatch.ToLike(pattern)
+	if like != "%" {
+		sq.filter("key LIKE $%d", like)
+	}
+	if keyType != "" {
+		// Type names are case-insensitive ("ReJSON-RL")
+		sq.filter("lower(key_type) = $%d", strings.ToLower(string(keyType)))
+	}
+
+	var keys []string
+	var key string
+	next, err := o.scanPage(ctx, q, cursor, count, sq, []interface{}{&key}, func() {
+		if exact || stringmatch.Match(pattern, key, false) {
+			keys = append(keys, key)
+		}
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return next, keys, nil
+}
+
+// memberScan returns the scanQuery over the rows of key in table, after
+// checking that key holds keyType
+func (o queryOps) memberScan(ctx context.Context, q Querier, key string, keyType KeyType, table, columns, order string) (scanQuery, error) {
+	existing, err := o.getKeyType(ctx, q, key)
+	if err != nil {
+		return scanQuery{}, err
+	}
+	if existing != TypeNone && existing != keyType {
+		return scanQuery{}, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	sq := scanQuery{table: table, columns: columns, order: order}
+	sq.args = append(sq.args, o.db, key)
+	sq.where = append(sq.where, "key_id = postkeys_key_id($1, $2)")
+	return sq, nil
+}
+
+func (o queryOps) hScan(ctx context.Context, q Querier, key string, cursor uint64, pattern string, count int64) (uint64, []HashField, error) {
+	sq, err := o.memberScan(ctx, q, key, TypeHash, "kv_hashes", "field, value", "field")
+	if err != nil {
+		return 0, nil, err
+	}
+	like, exact := stringmatch.ToLike(pattern)
+	if like != "%" {
+		// Binary field names are stored base64-encoded, so they are checked
+		// in Go once decoded
+		sq.filter("field LIKE ANY($%d)", []string{like, stringmatch.EscapeLike(binaryFieldPrefix) + "%"})
+	}
+
+	var fields []HashField
+	var field string
+	var value []byte
+	next, err := o.scanPage(ctx, q, cursor, count, sq, []interface{}{&field, &value}, func() {
+		decoded := decodeField(field)
+		if (exact && decoded == field) || stringmatch.Match(pattern, decoded, false) {
+			fields = append(fields, HashField{Field: decoded, Value: string(value)})
+		}
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return next, fields, nil
+}
+
+func (o queryOps) sScan(ctx context.Context, q Querier, key string, cursor uint64, pattern string, count int64) (uint64, []string, error) {
+	sq, err := o.memberScan(ctx, q, key, TypeSet, "kv_sets", "member", "member")
+	if err != nil {
+		return 0, nil, err
+	}
+	like, exact := stringmatch.ToLike(pattern)
+	if like != "%" {
+		sq.filter("member LIKE $%d", []byte(like))
+	}
+
+	var members []string
+	var member []byte
+	next, err := o.scanPage(ctx, q, cursor, count, sq, []interface{}{&member}, func() {
+		if exact || stringmatch.Match(pattern, string(member), false) {
+			members = append(members, string(member))
+		}
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return next, members, nil
+}
+
+func (o queryOps) zScan(ctx context.Context, q Querier, key string, cursor uint64, pattern string, count int64) (uint64, []ZMember, error) {
+	sq, err := o.memberScan(ctx, q, key, TypeZSet, "kv_zsets", "member, score", "member")
+	if err != nil {
+		return 0, nil, err
+	}
+	like, exact := stringmatch.ToLike(pattern)
+	if like != "%" {
+		sq.filter("member LIKE $%d", []byte(like))
+	}
+
+	var members []ZMember
+	var member []byte
+	var score float64
+	next, err := o.scanPage(ctx, q, cursor, count, sq, []interface{}{&member, &score}, func() {
+		if exact || stringmatch.Match(pattern, string(member), false) {
+			members = append(members, ZMember{Member: string(member), Score: score})
+		}
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return next, members, nil
+}
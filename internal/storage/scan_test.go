@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScanCursorOrder(t *testing.T) {
+	hashes := []int64{math.MinInt64, math.MinInt64 + 1, -1, 0, 1, math.MaxInt64}
+	for i, hash := range hashes {
+		if got := scanHash(scanCursor(hash)); got != hash {
+			t.Errorf("scanHash(scanCursor(%d)) = %d", hash, got)
+		}
+		if i > 0 && scanCursor(hashes[i-1]) >= scanCursor(hash) {
+			t.Errorf("Expected cursor of %d to be below cursor of %d", hashes[i-1], hash)
+		}
+	}
+	if scanCursor(math.MinInt64) != 0 {
+		t.Errorf("Expected the smallest hash to map to cursor 0")
+	}
+}
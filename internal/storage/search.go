@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	errFTUnknownIndex = fmt.Errorf("ERR Unknown index name")
+	errFTIndexExists  = fmt.Errorf("ERR Index already exists")
+)
+
+// attr returns the name an attribute is referred to by in queries
+func (f FTField) attr() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// field returns the schema attribute with the given name
+func (idx FTIndex) field(attr string) (FTField, bool) {
+	for _, f := range idx.Fields {
+		if f.attr() == attr {
+			return f, true
+		}
+	}
+	return FTField{}, false
+}
+
+// quoteLiteral quotes s as a SQL string literal. Field names are inlined rather
+// than bound so the planner can match the partial indexes' WHERE field = '...'.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// ftFieldPredicate restricts kv_hashes rows to the hash field behind an attribute
+func ftFieldPredicate(f FTField) string {
+	return "field = " + quoteLiteral(encodeField(f.Name))
+}
+
+// ftFieldExpr returns the indexed expression for an attribute's value
+func ftFieldExpr(f FTField, value string) string {
+	switch f.Type {
+	case "NUMERIC":
+		return fmt.Sprintf("postkeys_ft_numeric(%s)", value)
+	case "TAG":
+		return fmt.Sprintf("postkeys_ft_tags(%s, %s)", value, quoteLiteral(f.Separator))
+	default:
+		return fmt.Sprintf("to_tsvector('simple', postkeys_ft_text(%s))", value)
+	}
+}
+
+// ftIndexNames returns the names of the partial indexes backing each attribute
+// of an index in database db
+func ftIndexNames(db int, idx FTIndex) []string {
+	name := idx.Name
+	if db != 0 {
+		name = strconv.Itoa(db) + ":" + name
+	}
+	sum := sha1.Sum([]byte(name))
+	names := make([]string, len(idx.Fields))
+	for i := range idx.Fields {
+		names[i] = fmt.Sprintf("kv_ft_%s_%d", hex.EncodeToString(sum[:8]), i)
+	}
+	return names
+}
+
+// ftCreateIndexes builds a partial expression index on kv_hashes for every attribute.
+// CONCURRENTLY can't be used inside a transaction, so each index is built with its own statement.
+func (o queryOps) ftCreateIndexes(ctx context.Context, q Querier, idx FTIndex, concurrently bool) error {
+	mode := ""
+	if concurrently {
+		mode = "CONCURRENTLY "
+	}
+	for i, name := range ftIndexNames(o.db, idx) {
+		f := idx.Fields[i]
+		method := "gin"
+		if f.Type == "NUMERIC" {
+			method = "btree"
+		}
+		ddl := fmt.Sprintf("CREATE INDEX %sIF NOT EXISTS %s ON kv_hashes USING %s ((%s)) WHERE %s",
+			mode, name, method, ftFieldExpr(f, "value"), ftFieldPredicate(f))
+		if _, err := q.Exec(ctx, ddl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ftDropIndexes drops the partial indexes built by ftCreateIndexes
+func (o queryOps) ftDropIndexes(ctx context.Context, q Querier, idx FTIndex, concurrently bool) error {
+	mode := ""
+	if concurrently {
+		mode = "CONCURRENTLY "
+	}
+	for _, name := range ftIndexNames(o.db, idx) {
+		if _, err := q.Exec(ctx, fmt.Sprintf("DROP INDEX %sIF EXISTS %s", mode, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ftCreate records an index definition
+func (o queryOps) ftCreate(ctx context.Context, q Querier, idx FTIndex) error {
+	fields, err := json.Marshal(idx.Fields)
+	if err != nil {
+		return err
+	}
+	tag, err := q.Exec(ctx,
+		`INSERT INTO kv_ft_indexes (db, name, prefixes, fields) VALUES ($4, $1, $2, $3)
+		 ON CONFLICT (db, name) DO NOTHING`,
+		idx.Name, idx.Prefixes, fields, o.db,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errFTIndexExists
+	}
+	return nil
+}
+
+func (o queryOps) ftLoadIndex(ctx context.Context, q Querier, name string) (FTIndex, error) {
+	idx := FTIndex{Name: name}
+	var fields []byte
+	err := q.QueryRow(ctx, "SELECT prefixes, fields FROM kv_ft_indexes WHERE db = $2 AND name = $1", name, o.db).Scan(&idx.Prefixes, &fields)
+	if err == pgx.ErrNoRows {
+		return idx, errFTUnknownIndex
+	}
+	if err != nil {
+		return idx, err
+	}
+	return idx, json.Unmarshal(fields, &idx.Fields)
+}
+
+// ftDocKeysSQL selects the live hashes in database $2 covered by an index's prefixes ($1)
+const ftDocKeysSQL = `
+	SELECT m.key FROM kv_keys m
+	WHERE m.db = $2 AND m.key_type = 'hash' AND (m.expires_at IS NULL OR m.expires_at > NOW())
+	  AND EXISTS (SELECT 1 FROM unnest($1::text[]) p WHERE starts_with(m.key, p))`
+
+// ftDropIndex removes an index definition, and the hashes it covers when deleteDocs is set
+func (o queryOps) ftDropIndex(ctx context.Context, q Querier, name string, deleteDocs bool) (FTIndex, error) {
+	idx, err := o.ftLoadIndex(ctx, q, name)
+	if err != nil {
+		return idx, err
+	}
+	if _, err := q.Exec(ctx, "DELETE FROM kv_ft_indexes WHERE db = $2 AND name = $1", name, o.db); err != nil {
+		return idx, err
+	}
+	if !deleteDocs {
+		return idx, nil
+	}
+
+	rows, err := q.Query(ctx, ftDocKeysSQL, idx.Prefixes, o.db)
+	if err != nil {
+		return idx, err
+	}
+	keys, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return idx, err
+	}
+	if len(keys) == 0 {
+		return idx, nil
+	}
+	return idx, o.deleteKeys(ctx, q, keys)
+}
+
+func (o queryOps) ftInfo(ctx context.Context, q Querier, name string) (FTIndex, int64, error) {
+	idx, err := o.ftLoadIndex(ctx, q, name)
+	if err != nil {
+		return idx, 0, err
+	}
+	var numDocs int64
+	err = q.QueryRow(ctx, "SELECT count(*) FROM ("+ftDocKeysSQL+") d", idx.Prefixes, o.db).Scan(&numDocs)
+	return idx, numDocs, err
+}
+
+func (o queryOps) ftList(ctx context.Context, q Querier) ([]string, error) {
+	rows, err := q.Query(ctx, "SELECT name FROM kv_ft_indexes WHERE db = $1 ORDER BY name", o.db)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// ftCompiler turns a parsed query into a SQL set expression yielding the ids of
+// matching keys. The database is an integer, so it is inlined rather than bound.
+type ftCompiler struct {
+	index FTIndex
+	db    int
+	args  []any
+}
+
+func (c *ftCompiler) arg(v any) string {
+	c.args = append(c.args, v)
+	return fmt.Sprintf("$%d", len(c.args))
+}
+
+func (c *ftCompiler) attribute(name, want string) (FTField, error) {
+	f, ok := c.index.field(name)
+	if !ok {
+		return f, fmt.Errorf("ERR Unknown field '%s'", name)
+	}
+	if f.Type != want {
+		return f, fmt.Errorf("ERR Field '%s' is not a %s field", name, want)
+	}
+	return f, nil
+}
+
+// leaf selects the keys whose attribute f satisfies cond, an expression over the indexed value.
+// Key ids are unique across databases, and ftSearch keeps those of the index's database.
+func (c *ftCompiler) leaf(f FTField, cond string) string {
+	return fmt.Sprintf("SELECT key_id FROM kv_hashes WHERE %s AND %s %s", ftFieldPredicate(f), ftFieldExpr(f, "value"), cond)
+}
+
+func (c *ftCompiler) compile(n *ftNode) (string, error) {
+	switch n.kind {
+	case ftAll:
+		return fmt.Sprintf("SELECT id FROM kv_keys WHERE db = %d AND key_type = 'hash'", c.db), nil
+
+	case ftText:
+		var fields []FTField
+		if n.field != "" {
+			f, err := c.attribute(n.field, "TEXT")
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, f)
+		} else {
+			for _, f := range c.index.Fields {
+				if f.Type == "TEXT" {
+					fields = append(fields, f)
+				}
+			}
+			if len(fields) == 0 {
+				return "", fmt.Errorf("ERR No text fields in index")
+			}
+		}
+		cond := "@@ to_tsquery('simple', " + c.arg(n.tsquery) + ")"
+		parts := make([]string, len(fields))
+		for i, f := range fields {
+			parts[i] = c.leaf(f, cond)
+		}
+		return strings.Join(parts, " UNION "), nil
+
+	case ftNumeric:
+		f, err := c.attribute(n.field, "NUMERIC")
+		if err != nil {
+			return "", err
+		}
+		cond := "IS NOT NULL"
+		if !math.IsInf(n.min, -1) {
+			op := ">="
+			if n.minExcl {
+				op = ">"
+			}
+			cond += fmt.Sprintf(" AND %s %s %s::numeric", ftFieldExpr(f, "value"), op, c.arg(n.min))
+		}
+		if !math.IsInf(n.max, 1) {
+			op := "<="
+			if n.maxExcl {
+				op = "<"
+			}
+			cond += fmt.Sprintf(" AND %s %s %s::numeric", ftFieldExpr(f, "value"), op, c.arg(n.max))
+		}
+		return c.leaf(f, cond), nil
+
+	case ftTag:
+		f, err := c.attribute(n.field, "TAG")
+		if err != nil {
+			return "", err
+		}
+		return c.leaf(f, "&& "+c.arg(n.tags)+"::text[]"), nil
+
+	case ftOr:
+		parts := make([]string, len(n.children))
+		for i, child := range n.children {
+			sql, err := c.compile(child)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = "(" + sql + ")"
+		}
+		return strings.Join(parts, " UNION "), nil
+
+	case ftNot:
+		return c.compile(&ftNode{kind: ftAnd, children: []*ftNode{n}})
+
+	case ftAnd:
+		var include, exclude []string
+		for _, child := range n.children {
+			negated := child.kind == ftNot
+			if negated {
+				child = child.children[0]
+			}
+			sql, err := c.compile(child)
+			if err != nil {
+				return "", err
+			}
+			if negated {
+				exclude = append(exclude, "("+sql+")")
+			} else {
+				include = append(include, "("+sql+")")
+			}
+		}
+		if len(include) == 0 {
+			all, _ := c.compile(&ftNode{kind: ftAll})
+			include = append(include, "("+all+")")
+		}
+		sql := strings.Join(include, " INTERSECT ")
+		if len(exclude) > 0 {
+			sql = "(" + sql + ") EXCEPT " + strings.Join(exclude, " EXCEPT ")
+		}
+		return sql, nil
+	}
+	return "", fmt.Errorf("ERR unsupported query node")
+}
+
+// ftSearch returns the total number of matching hashes and the requested page of them
+func (o queryOps) ftSearch(ctx context.Context, q Querier, name, query string, opts FTSearchOptions) (int64, []FTDocument, error) {
+	idx, err := o.ftLoadIndex(ctx, q, name)
+	if err != nil {
+		return 0, nil, err
+	}
+	root, err := parseFTQuery(query)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	c := &ftCompiler{index: idx, db: o.db}
+	set, err := c.compile(root)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	order := "k.key"
+	if opts.SortBy != "" {
+		f, ok := idx.field(opts.SortBy)
+		if !ok {
+			return 0, nil, fmt.Errorf("ERR Property `%s` not loaded nor in schema", opts.SortBy)
+		}
+		expr := "postkeys_ft_text(s.value)"
+		if f.Type == "NUMERIC" {
+			expr = "postkeys_ft_numeric(s.value)"
+		}
+		dir := "ASC"
+		if opts.SortDesc {
+			dir = "DESC"
+		}
+		order = fmt.Sprintf("(SELECT %s FROM kv_hashes s WHERE s.key_id = k.id AND s.%s) %s NULLS LAST, k.key",
+			expr, ftFieldPredicate(f), dir)
+	}
+
+	matchSQL := fmt.Sprintf(`
+		SELECT k.key FROM (%s) AS m(id) JOIN kv_keys k ON k.id = m.id
+		WHERE k.db = %d AND k.key_type = 'hash' AND (k.expires_at IS NULL OR k.expires_at > NOW())
+		  AND EXISTS (SELECT 1 FROM unnest(%s::text[]) p WHERE starts_with(k.key, p))`,
+		set, o.db, c.arg(idx.Prefixes))
+
+	var total int64
+	if err := q.QueryRow(ctx, "SELECT count(*) FROM ("+matchSQL+") t", c.args...).Scan(&total); err != nil {
+		return 0, nil, err
+	}
+	if total == 0 || opts.Limit == 0 || opts.Offset >= total {
+		return total, nil, nil
+	}
+
+	pageSQL := fmt.Sprintf("%s ORDER BY %s LIMIT %s OFFSET %s", matchSQL, order, c.arg(opts.Limit), c.arg(opts.Offset))
+	rows, err := q.Query(ctx, pageSQL, c.args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	keys, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	docs := make([]FTDocument, len(keys))
+	for i, key := range keys {
+		docs[i].Key = key
+	}
+	if opts.NoContent {
+		return total, docs, nil
+	}
+
+	// Only fetch the requested attributes' fields when RETURN is given
+	var fields []string
+	if opts.Return != nil {
+		fields = []string{}
+		for _, attr := range opts.Return {
+			name := attr
+			if f, ok := idx.field(attr); ok {
+				name = f.Name
+			}
+			fields = append(fields, encodeField(name))
+		}
+	}
+
+	rows, err = q.Query(ctx,
+		`SELECT k.key, h.field, h.value FROM kv_keys k JOIN kv_hashes h ON h.key_id = k.id
+		 WHERE k.db = $3 AND k.key = ANY($1) AND ($2::text[] IS NULL OR h.field = ANY($2))
+		   AND (k.expires_at IS NULL OR k.expires_at > NOW())`,
+		keys, fields, o.db,
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	content := make(map[string][][2]string, len(keys))
+	for rows.Next() {
+		var key, field string
+		var value []byte
+		if err := rows.Scan(&key, &field, &value); err != nil {
+			return 0, nil, err
+		}
+		content[key] = append(content[key], [2]string{decodeField(field), string(value)})
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	for i := range docs {
+		pairs := content[docs[i].Key]
+		sort.Slice(pairs, func(a, b int) bool { return pairs[a][0] < pairs[b][0] })
+		for _, p := range pairs {
+			docs[i].Fields = append(docs[i].Fields, p[0], p[1])
+		}
+	}
+	return total, docs, nil
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ftNodeKind is the kind of a node in a parsed FT.SEARCH query
+type ftNodeKind int
+
+const (
+	ftAll     ftNodeKind = iota // *
+	ftAnd                       // clauses separated by spaces
+	ftOr                        // clauses separated by |
+	ftNot                       // -clause
+	ftText                      // word, prefix* or "phrase"
+	ftNumeric                   // @field:[min max]
+	ftTag                       // @field:{a | b}
+)
+
+// ftNode is a node of a parsed FT.SEARCH query
+type ftNode struct {
+	kind     ftNodeKind
+	field    string // attribute name; empty for text matching any TEXT field
+	children []*ftNode
+
+	tsquery string // ftText: PostgreSQL tsquery using the 'simple' configuration
+
+	min, max         float64 // ftNumeric bounds, possibly infinite
+	minExcl, maxExcl bool
+
+	tags []string // ftTag values, lower-cased
+}
+
+// ftQueryParser parses the supported subset of the RediSearch query syntax:
+// terms, prefix*, "phrases", -negation, a|b, (groups), @text:term,
+// @num:[min max] and @tag:{a|b}
+type ftQueryParser struct {
+	s   string
+	pos int
+}
+
+func ftSyntaxError(query string, pos int) error {
+	return fmt.Errorf("ERR Syntax error at offset %d near '%s'", pos, query)
+}
+
+// parseFTQuery parses a FT.SEARCH query string
+func parseFTQuery(query string) (*ftNode, error) {
+	if strings.TrimSpace(query) == "*" {
+		return &ftNode{kind: ftAll}, nil
+	}
+
+	p := &ftQueryParser{s: query}
+
+	node, err := p.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.s) {
+		return nil, ftSyntaxError(query, p.pos)
+	}
+	if node == nil {
+		return nil, ftSyntaxError(query, 0)
+	}
+	return node, nil
+}
+
+func (p *ftQueryParser) skipSpaces() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *ftQueryParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+// parseOr parses clauses separated by "|". field applies to bare terms inside @field:( ... ).
+func (p *ftQueryParser) parseOr(field string) (*ftNode, error) {
+	var alternatives []*ftNode
+	for {
+		node, err := p.parseAnd(field)
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			alternatives = append(alternatives, node)
+		}
+		p.skipSpaces()
+		if p.peek() != '|' {
+			break
+		}
+		p.pos++
+	}
+
+	switch len(alternatives) {
+	case 0:
+		return nil, nil
+	case 1:
+		return alternatives[0], nil
+	}
+	return &ftNode{kind: ftOr, children: alternatives}, nil
+}
+
+// parseAnd parses a sequence of clauses up to "|", ")" or the end of the query
+func (p *ftQueryParser) parseAnd(field string) (*ftNode, error) {
+	var clauses []*ftNode
+	for {
+		p.skipSpaces()
+		if c := p.peek(); c == 0 || c == '|' || c == ')' {
+			break
+		}
+		clause, err := p.parseClause(field)
+		if err != nil {
+			return nil, err
+		}
+		if clause != nil {
+			clauses = append(clauses, clause)
+		}
+	}
+
+	switch len(clauses) {
+	case 0:
+		return nil, nil
+	case 1:
+		return clauses[0], nil
+	}
+	return &ftNode{kind: ftAnd, children: clauses}, nil
+}
+
+func (p *ftQueryParser) parseClause(field string) (*ftNode, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		clause, err := p.parseClause(field)
+		if err != nil || clause == nil {
+			return nil, err
+		}
+		return &ftNode{kind: ftNot, children: []*ftNode{clause}}, nil
+
+	case '(':
+		p.pos++
+		node, err := p.parseOr(field)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.peek() != ')' {
+			return nil, ftSyntaxError(p.s, p.pos)
+		}
+		p.pos++
+		return node, nil
+
+	case '@':
+		return p.parseFieldClause()
+
+	case '"':
+		start := p.pos
+		end := strings.IndexByte(p.s[p.pos+1:], '"')
+		if end < 0 {
+			return nil, ftSyntaxError(p.s, start)
+		}
+		phrase := p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return ftTextNode(field, ftWords(phrase), false, " <-> "), nil
+	}
+
+	// Bare term, possibly with a trailing * for prefix matching
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" \t\r\n()|@{}[]\"", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, ftSyntaxError(p.s, start)
+	}
+	term := p.s[start:p.pos]
+	prefix := strings.HasSuffix(term, "*")
+	return ftTextNode(field, ftWords(strings.TrimSuffix(term, "*")), prefix, " & "), nil
+}
+
+// parseFieldClause parses @field:term, @field:(...), @field:[min max] or @field:{tags}
+func (p *ftQueryParser) parseFieldClause() (*ftNode, error) {
+	start := p.pos
+	p.pos++
+	colon := strings.IndexByte(p.s[p.pos:], ':')
+	if colon <= 0 {
+		return nil, ftSyntaxError(p.s, start)
+	}
+	field := p.s[p.pos : p.pos+colon]
+	p.pos += colon + 1
+	p.skipSpaces()
+
+	switch p.peek() {
+	case '[':
+		end := strings.IndexByte(p.s[p.pos:], ']')
+		if end < 0 {
+			return nil, ftSyntaxError(p.s, start)
+		}
+		bounds := strings.Fields(p.s[p.pos+1 : p.pos+end])
+		p.pos += end + 1
+		if len(bounds) != 2 {
+			return nil, ftSyntaxError(p.s, start)
+		}
+		node := &ftNode{kind: ftNumeric, field: field}
+		var err error
+		if node.min, node.minExcl, err = parseFTNumericBound(bounds[0]); err != nil {
+			return nil, err
+		}
+		if node.max, node.maxExcl, err = parseFTNumericBound(bounds[1]); err != nil {
+			return nil, err
+		}
+		return node, nil
+
+	case '{':
+		end := strings.IndexByte(p.s[p.pos:], '}')
+		if end < 0 {
+			return nil, ftSyntaxError(p.s, start)
+		}
+		node := &ftNode{kind: ftTag, field: field}
+		for _, tag := range strings.Split(p.s[p.pos+1:p.pos+end], "|") {
+			// Tags may escape spaces and punctuation with backslashes
+			tag = strings.TrimSpace(strings.ReplaceAll(tag, "\\", ""))
+			if tag != "" {
+				node.tags = append(node.tags, strings.ToLower(tag))
+			}
+		}
+		p.pos += end + 1
+		if len(node.tags) == 0 {
+			return nil, ftSyntaxError(p.s, start)
+		}
+		return node, nil
+	}
+
+	return p.parseClause(field)
+}
+
+// parseFTNumericBound parses a numeric range bound: a number, -inf, +inf, or "(" for exclusive
+func parseFTNumericBound(s string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+	switch strings.ToLower(s) {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "inf", "+inf":
+		return math.Inf(1), exclusive, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("ERR Expecting numeric range bound, got '%s'", s)
+	}
+	return v, exclusive, nil
+}
+
+// ftWords splits text into lower-cased words the way the 'simple' text search configuration does
+func ftWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// ftTextNode builds a text node joining words with op; words are letters
+// and digits only, so they are safe to embed in a tsquery
+func ftTextNode(field string, words []string, prefix bool, op string) *ftNode {
+	if len(words) == 0 {
+		return nil
+	}
+	if prefix {
+		words[len(words)-1] += ":*"
+	}
+	return &ftNode{kind: ftText, field: field, tsquery: strings.Join(words, op)}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// describeFTNode renders a parsed query compactly for comparison
+func describeFTNode(n *ftNode) string {
+	switch n.kind {
+	case ftAll:
+		return "*"
+	case ftText:
+		return n.field + "'" + n.tsquery + "'"
+	case ftNumeric:
+		lo, hi := "[", "]"
+		if n.minExcl {
+			lo = "("
+		}
+		if n.maxExcl {
+			hi = ")"
+		}
+		return n.field + lo + strconv.FormatFloat(n.min, 'g', -1, 64) + "," + strconv.FormatFloat(n.max, 'g', -1, 64) + hi
+	case ftTag:
+		return n.field + "{" + strings.Join(n.tags, "|") + "}"
+	case ftNot:
+		return "-" + describeFTNode(n.children[0])
+	}
+
+	parts := make([]string, len(n.children))
+	for i, c := range n.children {
+		parts[i] = describeFTNode(c)
+	}
+	if n.kind == ftOr {
+		return "OR(" + strings.Join(parts, " ") + ")"
+	}
+	return "AND(" + strings.Join(parts, " ") + ")"
+}
+
+func TestParseFTQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"*", "*"},
+		{"  *  ", "*"},
+		{"hello", "'hello'"},
+		{"Hello World", "AND('hello' 'world')"},
+		{"hel*", "'hel:*'"},
+		{`"quick brown"`, "'quick <-> brown'"},
+		{"foo-bar", "'foo & bar'"},
+		{"hello|world", "OR('hello' 'world')"},
+		{"a b | c", "OR(AND('a' 'b') 'c')"},
+		{"-hello", "-'hello'"},
+		{"hello -world", "AND('hello' -'world')"},
+		{"@title:hello", "title'hello'"},
+		{"@title:(hello|world)", "OR(title'hello' title'world')"},
+		{"@price:[10 20]", "price[10,20]"},
+		{"@price:[(10 +inf]", "price(10,+Inf]"},
+		{"@price:[-inf (5.5]", "price[-Inf,5.5)"},
+		{"@tags:{Red | blue}", "tags{red|blue}"},
+		{`@tags:{new\ york}`, "tags{new york}"},
+		{"@title:hi @price:[1 2] -@tags:{x}", "AND(title'hi' price[1,2] -tags{x})"},
+		{"(a | b) c", "AND(OR('a' 'b') 'c')"},
+	}
+
+	for _, tt := range tests {
+		node, err := parseFTQuery(tt.query)
+		if err != nil {
+			t.Errorf("parseFTQuery(%q) failed: %v", tt.query, err)
+			continue
+		}
+		if got := describeFTNode(node); got != tt.want {
+			t.Errorf("parseFTQuery(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "(a", `"open`, "@price:[1]", "@price:[a b]", "@tags:{}", "@tags:{a", "@:x"} {
+		if _, err := parseFTQuery(bad); err == nil {
+			t.Errorf("parseFTQuery(%q) should fail", bad)
+		}
+	}
+}
+
+func TestFTCompileSetOperations(t *testing.T) {
+	idx := FTIndex{Name: "idx", Prefixes: []string{"doc:"}, Fields: []FTField{
+		{Name: "title", Type: "TEXT"},
+		{Name: "body", Type: "TEXT"},
+		{Name: "price", Type: "NUMERIC"},
+		{Name: "tags", Type: "TAG", Separator: ","},
+		{Name: "it's", Alias: "q", Type: "TEXT"},
+	}}
+
+	compile := func(query string) (string, []any, error) {
+		node, err := parseFTQuery(query)
+		if err != nil {
+			return "", nil, err
+		}
+		c := &ftCompiler{index: idx}
+		sql, err := c.compile(node)
+		return sql, c.args, err
+	}
+
+	sql, args, err := compile("hello")
+	if err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	if strings.Count(sql, "UNION") != 2 || len(args) != 1 || args[0] != "hello" {
+		t.Errorf("Bare terms should search every TEXT field with one bound tsquery: %s %v", sql, args)
+	}
+
+	sql, _, _ = compile("@q:x")
+	if !strings.Contains(sql, "field = 'it''s'") {
+		t.Errorf("Field names should be quoted as literals: %s", sql)
+	}
+
+	sql, args, _ = compile("@price:[(1 +inf] -@tags:{a}")
+	if !strings.Contains(sql, "> $1::numeric") || strings.Contains(sql, "<") || !strings.Contains(sql, "EXCEPT") || len(args) != 2 {
+		t.Errorf("Unexpected numeric/negation SQL: %s %v", sql, args)
+	}
+
+	sql, _, _ = compile("-hello")
+	if !strings.HasPrefix(sql, "((SELECT id FROM kv_keys") {
+		t.Errorf("A purely negative query should subtract from every hash: %s", sql)
+	}
+
+	for _, bad := range []string{"@nope:x", "@price:x", "@title:[1 2]", "@title:{a}"} {
+		if _, _, err := compile(bad); err == nil {
+			t.Errorf("compile(%q) should fail", bad)
+		}
+	}
+}
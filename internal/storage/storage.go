@@ -4,18 +4,40 @@ package storage
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mnorrsken/postkeys/internal/metrics"
 )
 
 // Store provides PostgreSQL-backed storage for Redis operations
 type Store struct {
 	pool          *pgxpool.Pool
 	connStr       string
-	ops           queryOps
+	connConfig    *pgx.ConnConfig // for connections needing their own session
+	namespace     string
 	sqlTraceLevel int // 0=off, 1=important, 2=most queries, 3=everything
+	events        *keyspaceEvents
+	functions     bool // run the busiest writes as pk_* functions (see functions.go)
+
+	// volatilePool commits asynchronously, for keys matching volatilePatterns
+	// (see durability.go); nil if there are none
+	volatilePool     *pgxpool.Pool
+	volatilePatterns []string
+
+	// replicaPool serves read-only operations while replicaUsable (see
+	// replica.go); nil if there is no replica
+	replicaPool        *pgxpool.Pool
+	replicaUsable      atomic.Bool
+	stopReplicaMonitor context.CancelFunc
+	replicaMonitorDone chan struct{}
+
+	stopSweeper context.CancelFunc
+	sweeperDone chan struct{}
 }
 
 // Config holds PostgreSQL connection configuration
@@ -27,34 +49,235 @@ type Config struct {
 	Database      string
 	SSLMode       string
 	SQLTraceLevel int // 0=off, 1=important, 2=most queries, 3=everything
+	Migrate       MigrateMode
+
+	// DSN is a connection string, in keyword/value or URL form, used instead
+	// of Host, Port, User, Password, Database and SSLMode. It can set any
+	// option pgx supports, like sslrootcert, sslcert and target_session_attrs,
+	// and the libpq keepalives settings.
+	DSN string
+
+	// Pool sizing; zero keeps the pgxpool defaults
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+
+	// Connection settings for the pools and the session connections; zero
+	// keeps the DSN's or the server's settings (see connconfig.go)
+	ConnectTimeout   time.Duration
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+	TCPKeepAlive     time.Duration // idle time before keepalive probes
+	ApplicationName  string
+
+	// Schema is the PostgreSQL schema holding the tables, so several postkeys
+	// deployments can share a database with isolated keyspaces. It is created
+	// if needed; empty uses the database's default search_path.
+	Schema string
+
+	// VolatileKeyPatterns are glob patterns of keys written with asynchronous
+	// commit, trading the last moments of writes on a crash for throughput
+	VolatileKeyPatterns []string
+
+	// ReplicaDSN is the connection string of a streaming replica to serve
+	// read-only operations, with ReplicaMaxLag as the most replication lag
+	// tolerated before falling back to the primary (default 1s)
+	ReplicaDSN    string
+	ReplicaMaxLag time.Duration
+
+	// PgBouncer adapts the pools to PgBouncer in transaction pooling mode
+	// (see pgbouncer.go). ListenDSN is the connection string for connections
+	// that need a session of their own, like the LISTEN connections, which
+	// should bypass PgBouncer; empty uses the pools' connection string.
+	PgBouncer bool
+	ListenDSN string
+
+	// DisableFunctions runs every command as individual queries instead of
+	// calling the pk_* functions installed by the migrations for the busiest
+	// writes (see functions.go)
+	DisableFunctions bool
+}
+
+// schemaName matches the schema names Config accepts, which need no quoting
+var schemaName = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// validate checks cfg's settings that New can't leave to PostgreSQL
+func (cfg Config) validate() error {
+	if cfg.Schema != "" && !schemaName.MatchString(cfg.Schema) {
+		return fmt.Errorf("invalid schema name %q (expected lowercase letters, digits and underscores)", cfg.Schema)
+	}
+	if cfg.PgBouncer && len(cfg.VolatileKeyPatterns) > 0 {
+		return fmt.Errorf("volatile key patterns need session parameters, which PgBouncer transaction pooling doesn't support")
+	}
+	if cfg.PgBouncer && (cfg.StatementTimeout > 0 || cfg.LockTimeout > 0) {
+		return fmt.Errorf("statement and lock timeouts are session parameters, which PgBouncer transaction pooling doesn't support")
+	}
+	if cfg.MinConns > 0 && cfg.MaxConns > 0 && cfg.MinConns > cfg.MaxConns {
+		return fmt.Errorf("min conns %d exceeds max conns %d", cfg.MinConns, cfg.MaxConns)
+	}
+	return nil
+}
+
+// connString returns the libpq connection string for cfg: its DSN, or one
+// built from its fields. The schema is set as the search_path of every
+// connection, so queries find its tables unqualified.
+func (cfg Config) connString() string {
+	connStr := cfg.DSN
+	if connStr == "" {
+		connStr = fmt.Sprintf(
+			"user=%s password=%s host=%s port=%d dbname=%s sslmode=%s",
+			cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Database, cfg.SSLMode,
+		)
+	}
+	if cfg.PgBouncer {
+		// PgBouncer doesn't pass search_path on, see checkSearchPath
+		return withParam(connStr, "default_query_exec_mode", "simple_protocol")
+	}
+	return withParam(connStr, "search_path", cfg.Schema)
+}
+
+// sessionConnString returns the connection string for cfg's connections
+// that need a session of their own
+func (cfg Config) sessionConnString() string {
+	if cfg.ListenDSN == "" {
+		return cfg.connString()
+	}
+	return withParam(cfg.ListenDSN, "search_path", cfg.Schema)
+}
+
+// namespace returns the name that scopes cfg's LISTEN/NOTIFY channels and
+// advisory locks to its schema. The public schema shares them with
+// deployments that don't set one.
+func (cfg Config) namespace() string {
+	if cfg.Schema == "public" {
+		return ""
+	}
+	return cfg.Schema
+}
+
+// advisoryLockID returns the advisory lock id for base in namespace, so
+// tenants sharing a database don't contend for each other's locks
+func advisoryLockID(base int64, namespace string) int64 {
+	if namespace == "" {
+		return base
+	}
+	h := fnv.New64a()
+	h.Write([]byte(namespace))
+	return base ^ int64(h.Sum64())
 }
 
 // New creates a new Store with the given configuration
 func New(ctx context.Context, cfg Config) (*Store, error) {
-	connStr := fmt.Sprintf(
-		"user=%s password=%s host=%s port=%d dbname=%s sslmode=%s",
-		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Database, cfg.SSLMode,
-	)
-
-	pool, err := pgxpool.New(ctx, connStr)
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	poolCfg, err := cfg.poolConfig(cfg.connString())
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection settings: %w", err)
+	}
+	connCfg, err := cfg.sessionConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection settings: %w", err)
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create pool: %w", err)
 	}
 
-	store := &Store{pool: pool, connStr: connStr, sqlTraceLevel: cfg.SQLTraceLevel}
-	if err := store.initSchema(ctx); err != nil {
+	store := &Store{
+		pool:          pool,
+		connStr:       cfg.sessionConnString(),
+		connConfig:    connCfg,
+		namespace:     cfg.namespace(),
+		sqlTraceLevel: cfg.SQLTraceLevel,
+		events:        &keyspaceEvents{namespace: cfg.namespace()},
+		functions:     !cfg.DisableFunctions,
+	}
+	if cfg.PgBouncer {
+		if err := store.checkListen(ctx); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("LISTEN connections must bypass PgBouncer: %w", err)
+		}
+	}
+	if err := store.migrateSchema(ctx, cfg); err != nil {
 		pool.Close()
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
+	if cfg.PgBouncer && cfg.Schema != "" {
+		if err := store.checkSearchPath(ctx, cfg.Schema); err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+
+	if len(cfg.VolatileKeyPatterns) > 0 {
+		volatileCfg := poolCfg.Copy()
+		volatileCfg.ConnConfig.RuntimeParams["synchronous_commit"] = "off"
+		store.volatilePool, err = pgxpool.NewWithConfig(ctx, volatileCfg)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to create volatile pool: %w", err)
+		}
+		store.volatilePatterns = cfg.VolatileKeyPatterns
+	}
+
+	if cfg.ReplicaDSN != "" {
+		store.replicaPool, err = newReplicaPool(ctx, cfg)
+		if err != nil {
+			store.closePools()
+			return nil, fmt.Errorf("failed to create replica pool: %w", err)
+		}
+		monitorCtx, stopReplicaMonitor := context.WithCancel(ctx)
+		store.stopReplicaMonitor = stopReplicaMonitor
+		store.replicaMonitorDone = make(chan struct{})
+		maxLag := cfg.ReplicaMaxLag
+		if maxLag <= 0 {
+			maxLag = defaultReplicaMaxLag
+		}
+		go store.monitorReplica(monitorCtx, maxLag)
+	}
+
+	metrics.RegisterPool("primary", store.pool)
+	if store.volatilePool != nil {
+		metrics.RegisterPool("volatile", store.volatilePool)
+	}
+	if store.replicaPool != nil {
+		metrics.RegisterPool("replica", store.replicaPool)
+	}
 
 	// Start background goroutine to clean expired keys
-	go store.cleanupExpiredKeys(ctx)
+	sweepCtx, stopSweeper := context.WithCancel(ctx)
+	store.stopSweeper = stopSweeper
+	store.sweeperDone = make(chan struct{})
+	go store.runSweeper(sweepCtx)
 
 	return store, nil
 }
 
-// Close closes the database connection pool
+// Close stops the background goroutines and closes the database connection
+// pools
 func (s *Store) Close() {
+	s.stopSweeper()
+	<-s.sweeperDone
+	if s.replicaPool != nil {
+		s.stopReplicaMonitor()
+		<-s.replicaMonitorDone
+	}
+	s.closePools()
+}
+
+// closePools closes every connection pool of the Store
+func (s *Store) closePools() {
+	if s.replicaPool != nil {
+		metrics.UnregisterPool("replica", s.replicaPool)
+		s.replicaPool.Close()
+	}
+	if s.volatilePool != nil {
+		metrics.UnregisterPool("volatile", s.volatilePool)
+		s.volatilePool.Close()
+	}
+	metrics.UnregisterPool("primary", s.pool)
 	s.pool.Close()
 }
 
@@ -63,133 +286,65 @@ func (s *Store) Pool() *pgxpool.Pool {
 	return s.pool
 }
 
-// ConnString returns the connection string
+// ConnString returns the connection string for connections that need a
+// session of their own, like LISTEN
 func (s *Store) ConnString() string {
 	return s.connStr
 }
 
-func (s *Store) initSchema(ctx context.Context) error {
-	schema := `
-		-- Main key-value store for string types (BYTEA for binary-safe storage)
-		CREATE TABLE IF NOT EXISTS kv_strings (
-			key TEXT PRIMARY KEY,
-			value BYTEA NOT NULL,
-			expires_at TIMESTAMPTZ
-		);
-		CREATE INDEX IF NOT EXISTS idx_kv_strings_expires ON kv_strings(expires_at) WHERE expires_at IS NOT NULL;
-
-		-- Hash type storage
-		CREATE TABLE IF NOT EXISTS kv_hashes (
-			key TEXT NOT NULL,
-			field TEXT NOT NULL,
-			value BYTEA NOT NULL,
-			expires_at TIMESTAMPTZ,
-			PRIMARY KEY (key, field)
-		);
-		CREATE INDEX IF NOT EXISTS idx_kv_hashes_expires ON kv_hashes(expires_at) WHERE expires_at IS NOT NULL;
-		CREATE INDEX IF NOT EXISTS idx_kv_hashes_key ON kv_hashes(key);
-
-		-- List type storage
-		CREATE TABLE IF NOT EXISTS kv_lists (
-			key TEXT NOT NULL,
-			idx BIGINT NOT NULL,
-			value BYTEA NOT NULL,
-			expires_at TIMESTAMPTZ,
-			PRIMARY KEY (key, idx)
-		);
-		CREATE INDEX IF NOT EXISTS idx_kv_lists_expires ON kv_lists(expires_at) WHERE expires_at IS NOT NULL;
-		CREATE INDEX IF NOT EXISTS idx_kv_lists_key ON kv_lists(key);
-
-		-- Set type storage
-		CREATE TABLE IF NOT EXISTS kv_sets (
-			key TEXT NOT NULL,
-			member BYTEA NOT NULL,
-			expires_at TIMESTAMPTZ,
-			PRIMARY KEY (key, member)
-		);
-		CREATE INDEX IF NOT EXISTS idx_kv_sets_expires ON kv_sets(expires_at) WHERE expires_at IS NOT NULL;
-		CREATE INDEX IF NOT EXISTS idx_kv_sets_key ON kv_sets(key);
-
-		-- Sorted set type storage
-		CREATE TABLE IF NOT EXISTS kv_zsets (
-			key TEXT NOT NULL,
-			member BYTEA NOT NULL,
-			score DOUBLE PRECISION NOT NULL,
-			expires_at TIMESTAMPTZ,
-			PRIMARY KEY (key, member)
-		);
-		CREATE INDEX IF NOT EXISTS idx_kv_zsets_expires ON kv_zsets(expires_at) WHERE expires_at IS NOT NULL;
-		CREATE INDEX IF NOT EXISTS idx_kv_zsets_key ON kv_zsets(key);
-		CREATE INDEX IF NOT EXISTS idx_kv_zsets_score ON kv_zsets(key, score);
-
-		-- Key metadata for tracking types and TTL
-		CREATE TABLE IF NOT EXISTS kv_meta (
-			key TEXT PRIMARY KEY,
-			key_type TEXT NOT NULL,
-			expires_at TIMESTAMPTZ
-		);
-		CREATE INDEX IF NOT EXISTS idx_kv_meta_expires ON kv_meta(expires_at) WHERE expires_at IS NOT NULL;
-
-		-- HyperLogLog storage (stores serialized HLL registers)
-		CREATE TABLE IF NOT EXISTS kv_hyperloglog (
-			key TEXT PRIMARY KEY,
-			registers BYTEA NOT NULL,
-			expires_at TIMESTAMPTZ
-		);
-		CREATE INDEX IF NOT EXISTS idx_kv_hyperloglog_expires ON kv_hyperloglog(expires_at) WHERE expires_at IS NOT NULL;
-	`
-	_, err := s.pool.Exec(ctx, schema)
-	return err
+// ConnConfig returns the configuration for connections that need a session
+// of their own, like LISTEN
+func (s *Store) ConnConfig() *pgx.ConnConfig {
+	return s.connConfig.Copy()
 }
 
-func (s *Store) cleanupExpiredKeys(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			s.deleteExpiredKeys(context.Background())
-		}
-	}
+// Namespace returns the name that scopes the Store's LISTEN/NOTIFY channels
+// to its schema, for the pub/sub hub, list notifier and cache invalidator
+func (s *Store) Namespace() string {
+	return s.namespace
 }
 
-func (s *Store) deleteExpiredKeys(ctx context.Context) {
-	now := time.Now()
-	queries := []string{
-		"DELETE FROM kv_strings WHERE expires_at IS NOT NULL AND expires_at <= $1",
-		"DELETE FROM kv_hashes WHERE expires_at IS NOT NULL AND expires_at <= $1",
-		"DELETE FROM kv_lists WHERE expires_at IS NOT NULL AND expires_at <= $1",
-		"DELETE FROM kv_sets WHERE expires_at IS NOT NULL AND expires_at <= $1",
-		"DELETE FROM kv_meta WHERE expires_at IS NOT NULL AND expires_at <= $1",
-	}
-	for _, q := range queries {
-		s.pool.Exec(ctx, q, now)
+// poolFor returns the pool for the durability class of the keys declared in ctx
+func (s *Store) poolFor(ctx context.Context) *pgxpool.Pool {
+	if s.volatile(ctx) {
+		return s.volatilePool
 	}
+	return s.pool
 }
 
-// withTx wraps an operation in a transaction
+// withTx wraps an operation in a transaction, running it again after a
+// deadlock or serialization failure (see retry.go)
 func (s *Store) withTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
-	tx, err := s.pool.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
+	return RetryTx(ctx, "store", func() error {
+		tx, err := s.poolFor(ctx).Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
 
-	if err := fn(tx); err != nil {
-		return err
-	}
-	return tx.Commit(ctx)
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
 }
 
-// querier returns a Querier, optionally wrapped with tracing
-func (s *Store) querier() Querier {
+// withFunc runs fn, which makes a single pk_* function call, straight on the
+// pool with the retries of withTx
+func (s *Store) withFunc(ctx context.Context, fn func(q Querier) error) error {
+	return RetryTx(ctx, "store", func() error {
+		return fn(s.querier(ctx))
+	})
+}
+
+// querier returns a Querier for the keys declared in ctx, optionally wrapped
+// with tracing
+func (s *Store) querier(ctx context.Context) Querier {
+	pool := s.poolFor(ctx)
 	if s.sqlTraceLevel > 0 {
-		return NewTracingQuerier(s.pool, s.sqlTraceLevel)
+		return NewTracingQuerier(pool, s.sqlTraceLevel)
 	}
-	return s.pool
+	return pool
 }
 
 // txQuerier returns a Querier for a transaction, optionally wrapped with tracing
@@ -202,44 +357,63 @@ func (s *Store) txQuerier(tx pgx.Tx) Querier {
 
 // BeginTx starts a new transaction
 func (s *Store) BeginTx(ctx context.Context) (Transaction, error) {
-	tx, err := s.pool.Begin(ctx)
+	tx, err := s.poolFor(ctx).Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &TxStore{tx: tx, sqlTraceLevel: s.sqlTraceLevel}, nil
+	return &TxStore{tx: tx, sqlTraceLevel: s.sqlTraceLevel, events: s.events}, nil
 }
 
 // ============== String Commands ==============
 
 func (s *Store) Get(ctx context.Context, key string) (string, bool, error) {
-	return s.ops.get(ctx, s.querier(), key)
+	return s.ops(ctx).get(ctx, s.keyMissReader(ctx), key)
 }
 
 func (s *Store) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if s.functions {
+		return s.withFunc(ctx, func(q Querier) error {
+			return s.ops(ctx).setFunc(ctx, q, key, value, ttl)
+		})
+	}
 	return s.withTx(ctx, func(tx pgx.Tx) error {
-		return s.ops.set(ctx, s.txQuerier(tx), key, value, ttl)
+		return s.ops(ctx).set(ctx, s.txQuerier(tx), key, value, ttl)
 	})
 }
 
 func (s *Store) SetNX(ctx context.Context, key, value string) (bool, error) {
-	return s.ops.setNX(ctx, s.querier(), key, value)
+	var result bool
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).setNX(ctx, s.txQuerier(tx), key, value)
+		return err
+	})
+	return result, err
 }
 
 func (s *Store) MGet(ctx context.Context, keys []string) ([]interface{}, error) {
-	return s.ops.mGet(ctx, s.querier(), keys)
+	return s.ops(ctx).mGet(ctx, s.keyMissReader(ctx), keys)
 }
 
 func (s *Store) MSet(ctx context.Context, pairs map[string]string) error {
 	return s.withTx(ctx, func(tx pgx.Tx) error {
-		return s.ops.mSet(ctx, s.txQuerier(tx), pairs)
+		return s.ops(ctx).mSet(ctx, s.txQuerier(tx), pairs)
 	})
 }
 
 func (s *Store) Incr(ctx context.Context, key string, delta int64) (int64, error) {
 	var result int64
+	if s.functions {
+		err := s.withFunc(ctx, func(q Querier) error {
+			var err error
+			result, err = s.ops(ctx).incrFunc(ctx, q, key, delta)
+			return err
+		})
+		return result, err
+	}
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.incr(ctx, s.txQuerier(tx), key, delta)
+		result, err = s.ops(ctx).incr(ctx, s.txQuerier(tx), key, delta)
 		return err
 	})
 	return result, err
@@ -249,21 +423,21 @@ func (s *Store) Append(ctx context.Context, key, value string) (int64, error) {
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.appendStr(ctx, s.txQuerier(tx), key, value)
+		result, err = s.ops(ctx).appendStr(ctx, s.txQuerier(tx), key, value)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) GetRange(ctx context.Context, key string, start, end int64) (string, error) {
-	return s.ops.getRange(ctx, s.querier(), key, start, end)
+	return s.ops(ctx).getRange(ctx, s.reader(ctx), key, start, end)
 }
 
 func (s *Store) SetRange(ctx context.Context, key string, offset int64, value string) (int64, error) {
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.setRange(ctx, s.txQuerier(tx), key, offset, value)
+		result, err = s.ops(ctx).setRange(ctx, s.txQuerier(tx), key, offset, value)
 		return err
 	})
 	return result, err
@@ -273,14 +447,14 @@ func (s *Store) BitField(ctx context.Context, key string, ops []BitFieldOp) ([]i
 	var result []int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.bitField(ctx, s.txQuerier(tx), key, ops)
+		result, err = s.ops(ctx).bitField(ctx, s.txQuerier(tx), key, ops)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) StrLen(ctx context.Context, key string) (int64, error) {
-	return s.ops.strLen(ctx, s.querier(), key)
+	return s.ops(ctx).strLen(ctx, s.reader(ctx), key)
 }
 
 func (s *Store) GetEx(ctx context.Context, key string, ttl time.Duration, persist bool) (string, bool, error) {
@@ -288,7 +462,7 @@ func (s *Store) GetEx(ctx context.Context, key string, ttl time.Duration, persis
 	var exists bool
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, exists, err = s.ops.getEx(ctx, s.txQuerier(tx), key, ttl, persist)
+		result, exists, err = s.ops(ctx).getEx(ctx, s.txQuerier(tx), key, ttl, persist)
 		return err
 	})
 	return result, exists, err
@@ -299,7 +473,7 @@ func (s *Store) GetDel(ctx context.Context, key string) (string, bool, error) {
 	var exists bool
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, exists, err = s.ops.getDel(ctx, s.txQuerier(tx), key)
+		result, exists, err = s.ops(ctx).getDel(ctx, s.txQuerier(tx), key)
 		return err
 	})
 	return result, exists, err
@@ -310,7 +484,7 @@ func (s *Store) GetSet(ctx context.Context, key, value string) (string, bool, er
 	var exists bool
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, exists, err = s.ops.getSet(ctx, s.txQuerier(tx), key, value)
+		result, exists, err = s.ops(ctx).getSet(ctx, s.txQuerier(tx), key, value)
 		return err
 	})
 	return result, exists, err
@@ -320,7 +494,7 @@ func (s *Store) IncrByFloat(ctx context.Context, key string, delta float64) (flo
 	var result float64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.incrByFloat(ctx, s.txQuerier(tx), key, delta)
+		result, err = s.ops(ctx).incrByFloat(ctx, s.txQuerier(tx), key, delta)
 		return err
 	})
 	return result, err
@@ -332,55 +506,69 @@ func (s *Store) Del(ctx context.Context, keys []string) (int64, error) {
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.del(ctx, s.txQuerier(tx), keys)
+		result, err = s.ops(ctx).del(ctx, s.txQuerier(tx), keys)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) Exists(ctx context.Context, keys []string) (int64, error) {
-	return s.ops.exists(ctx, s.querier(), keys)
+	return s.ops(ctx).exists(ctx, s.reader(ctx), keys)
 }
 
 func (s *Store) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
-	return s.ops.expire(ctx, s.querier(), key, ttl)
+	return s.ops(ctx).expire(ctx, s.querier(ctx), key, ttl)
 }
 
 func (s *Store) TTL(ctx context.Context, key string) (int64, error) {
-	return s.ops.ttl(ctx, s.querier(), key)
+	return s.ops(ctx).ttl(ctx, s.reader(ctx), key)
 }
 
 func (s *Store) PTTL(ctx context.Context, key string) (int64, error) {
-	return s.ops.pttl(ctx, s.querier(), key)
+	return s.ops(ctx).pttl(ctx, s.reader(ctx), key)
 }
 
 func (s *Store) Persist(ctx context.Context, key string) (bool, error) {
-	return s.ops.persist(ctx, s.querier(), key)
+	return s.ops(ctx).persist(ctx, s.querier(ctx), key)
 }
 
 func (s *Store) Keys(ctx context.Context, pattern string) ([]string, error) {
-	return s.ops.keys(ctx, s.querier(), pattern)
+	return s.ops(ctx).keys(ctx, s.reader(ctx), pattern)
+}
+
+func (s *Store) Scan(ctx context.Context, cursor uint64, pattern string, count int64, keyType KeyType) (uint64, []string, error) {
+	return s.ops(ctx).scan(ctx, s.reader(ctx), cursor, pattern, count, keyType)
 }
 
 func (s *Store) Type(ctx context.Context, key string) (KeyType, error) {
-	return s.ops.keyType(ctx, s.querier(), key)
+	return s.ops(ctx).keyType(ctx, s.reader(ctx), key)
 }
 
 func (s *Store) Rename(ctx context.Context, oldKey, newKey string) error {
 	return s.withTx(ctx, func(tx pgx.Tx) error {
-		return s.ops.rename(ctx, s.txQuerier(tx), oldKey, newKey)
+		return s.ops(ctx).rename(ctx, s.txQuerier(tx), oldKey, newKey)
 	})
 }
 
 func (s *Store) ExpireAt(ctx context.Context, key string, timestamp time.Time) (bool, error) {
-	return s.ops.expireAt(ctx, s.querier(), key, timestamp)
+	return s.ops(ctx).expireAt(ctx, s.querier(ctx), key, timestamp)
 }
 
 func (s *Store) Copy(ctx context.Context, source, destination string, replace bool) (bool, error) {
 	var result bool
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.copyKey(ctx, s.txQuerier(tx), source, destination, replace)
+		result, err = s.ops(ctx).copyKey(ctx, s.txQuerier(tx), source, destination, replace)
+		return err
+	})
+	return result, err
+}
+
+func (s *Store) Move(ctx context.Context, key string, db int) (bool, error) {
+	var result bool
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).move(ctx, s.txQuerier(tx), key, db)
 		return err
 	})
 	return result, err
@@ -392,83 +580,95 @@ func (s *Store) SetBit(ctx context.Context, key string, offset int64, value int)
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.setBit(ctx, s.txQuerier(tx), key, offset, value)
+		result, err = s.ops(ctx).setBit(ctx, s.txQuerier(tx), key, offset, value)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) GetBit(ctx context.Context, key string, offset int64) (int64, error) {
-	return s.ops.getBit(ctx, s.querier(), key, offset)
+	return s.ops(ctx).getBit(ctx, s.reader(ctx), key, offset)
 }
 
 func (s *Store) BitCount(ctx context.Context, key string, start, end int64, useBit bool) (int64, error) {
-	return s.ops.bitCount(ctx, s.querier(), key, start, end, useBit)
+	return s.ops(ctx).bitCount(ctx, s.reader(ctx), key, start, end, useBit)
 }
 
 func (s *Store) BitOp(ctx context.Context, operation, destKey string, keys []string) (int64, error) {
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.bitOp(ctx, s.txQuerier(tx), operation, destKey, keys)
+		result, err = s.ops(ctx).bitOp(ctx, s.txQuerier(tx), operation, destKey, keys)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) BitPos(ctx context.Context, key string, bit int, start, end int64, useBit bool) (int64, error) {
-	return s.ops.bitPos(ctx, s.querier(), key, bit, start, end, useBit)
+	return s.ops(ctx).bitPos(ctx, s.reader(ctx), key, bit, start, end, useBit)
 }
 
 // ============== Hash Commands ==============
 
 func (s *Store) HGet(ctx context.Context, key, field string) (string, bool, error) {
-	return s.ops.hGet(ctx, s.querier(), key, field)
+	return s.ops(ctx).hGet(ctx, s.reader(ctx), key, field)
 }
 
 func (s *Store) HSet(ctx context.Context, key string, fields map[string]string) (int64, error) {
 	var result int64
+	if s.functions {
+		err := s.withFunc(ctx, func(q Querier) error {
+			var err error
+			result, err = s.ops(ctx).hSetFunc(ctx, q, key, fields)
+			return err
+		})
+		return result, err
+	}
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.hSet(ctx, s.txQuerier(tx), key, fields)
+		result, err = s.ops(ctx).hSet(ctx, s.txQuerier(tx), key, fields)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) HDel(ctx context.Context, key string, fields []string) (int64, error) {
-	return s.ops.hDel(ctx, s.querier(), key, fields)
+	return s.ops(ctx).hDel(ctx, s.querier(ctx), key, fields)
 }
 
 func (s *Store) HGetAll(ctx context.Context, key string) (map[string]string, error) {
-	return s.ops.hGetAll(ctx, s.querier(), key)
+	return s.ops(ctx).hGetAll(ctx, s.reader(ctx), key)
+}
+
+func (s *Store) HScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []HashField, error) {
+	return s.ops(ctx).hScan(ctx, s.reader(ctx), key, cursor, pattern, count)
 }
 
 func (s *Store) HMGet(ctx context.Context, key string, fields []string) ([]interface{}, error) {
-	return s.ops.hMGet(ctx, s.querier(), key, fields)
+	return s.ops(ctx).hMGet(ctx, s.reader(ctx), key, fields)
 }
 
 func (s *Store) HExists(ctx context.Context, key, field string) (bool, error) {
-	return s.ops.hExists(ctx, s.querier(), key, field)
+	return s.ops(ctx).hExists(ctx, s.reader(ctx), key, field)
 }
 
 func (s *Store) HKeys(ctx context.Context, key string) ([]string, error) {
-	return s.ops.hKeys(ctx, s.querier(), key)
+	return s.ops(ctx).hKeys(ctx, s.reader(ctx), key)
 }
 
 func (s *Store) HVals(ctx context.Context, key string) ([]string, error) {
-	return s.ops.hVals(ctx, s.querier(), key)
+	return s.ops(ctx).hVals(ctx, s.reader(ctx), key)
 }
 
 func (s *Store) HLen(ctx context.Context, key string) (int64, error) {
-	return s.ops.hLen(ctx, s.querier(), key)
+	return s.ops(ctx).hLen(ctx, s.reader(ctx), key)
 }
 
 func (s *Store) HIncrBy(ctx context.Context, key, field string, increment int64) (int64, error) {
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.hIncrBy(ctx, s.txQuerier(tx), key, field, increment)
+		result, err = s.ops(ctx).hIncrBy(ctx, s.txQuerier(tx), key, field, increment)
 		return err
 	})
 	return result, err
@@ -478,7 +678,7 @@ func (s *Store) HIncrByFloat(ctx context.Context, key, field string, increment f
 	var result float64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.hIncrByFloat(ctx, s.txQuerier(tx), key, field, increment)
+		result, err = s.ops(ctx).hIncrByFloat(ctx, s.txQuerier(tx), key, field, increment)
 		return err
 	})
 	return result, err
@@ -488,7 +688,7 @@ func (s *Store) HSetNX(ctx context.Context, key, field, value string) (bool, err
 	var result bool
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.hSetNX(ctx, s.txQuerier(tx), key, field, value)
+		result, err = s.ops(ctx).hSetNX(ctx, s.txQuerier(tx), key, field, value)
 		return err
 	})
 	return result, err
@@ -498,9 +698,17 @@ func (s *Store) HSetNX(ctx context.Context, key, field, value string) (bool, err
 
 func (s *Store) LPush(ctx context.Context, key string, values []string) (int64, error) {
 	var result int64
+	if s.functions {
+		err := s.withFunc(ctx, func(q Querier) error {
+			var err error
+			result, err = s.ops(ctx).pushFunc(ctx, q, "lpush", key, values)
+			return err
+		})
+		return result, err
+	}
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.lPush(ctx, s.txQuerier(tx), key, values)
+		result, err = s.ops(ctx).lPush(ctx, s.txQuerier(tx), key, values)
 		return err
 	})
 	return result, err
@@ -508,9 +716,17 @@ func (s *Store) LPush(ctx context.Context, key string, values []string) (int64,
 
 func (s *Store) RPush(ctx context.Context, key string, values []string) (int64, error) {
 	var result int64
+	if s.functions {
+		err := s.withFunc(ctx, func(q Querier) error {
+			var err error
+			result, err = s.ops(ctx).pushFunc(ctx, q, "rpush", key, values)
+			return err
+		})
+		return result, err
+	}
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.rPush(ctx, s.txQuerier(tx), key, values)
+		result, err = s.ops(ctx).rPush(ctx, s.txQuerier(tx), key, values)
 		return err
 	})
 	return result, err
@@ -521,7 +737,7 @@ func (s *Store) LPop(ctx context.Context, key string) (string, bool, error) {
 	var found bool
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		value, found, err = s.ops.lPop(ctx, s.txQuerier(tx), key)
+		value, found, err = s.ops(ctx).lPop(ctx, s.txQuerier(tx), key)
 		return err
 	})
 	return value, found, err
@@ -532,93 +748,105 @@ func (s *Store) RPop(ctx context.Context, key string) (string, bool, error) {
 	var found bool
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		value, found, err = s.ops.rPop(ctx, s.txQuerier(tx), key)
+		value, found, err = s.ops(ctx).rPop(ctx, s.txQuerier(tx), key)
 		return err
 	})
 	return value, found, err
 }
 
 func (s *Store) LLen(ctx context.Context, key string) (int64, error) {
-	return s.ops.lLen(ctx, s.querier(), key)
+	return s.ops(ctx).lLen(ctx, s.reader(ctx), key)
 }
 
 func (s *Store) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
-	return s.ops.lRange(ctx, s.querier(), key, start, stop)
+	return s.ops(ctx).lRange(ctx, s.reader(ctx), key, start, stop)
 }
 
 func (s *Store) LIndex(ctx context.Context, key string, index int64) (string, bool, error) {
-	return s.ops.lIndex(ctx, s.querier(), key, index)
+	return s.ops(ctx).lIndex(ctx, s.reader(ctx), key, index)
 }
 
 // ============== Set Commands ==============
 
 func (s *Store) SAdd(ctx context.Context, key string, members []string) (int64, error) {
 	var result int64
+	if s.functions {
+		err := s.withFunc(ctx, func(q Querier) error {
+			var err error
+			result, err = s.ops(ctx).sAddFunc(ctx, q, key, members)
+			return err
+		})
+		return result, err
+	}
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.sAdd(ctx, s.txQuerier(tx), key, members)
+		result, err = s.ops(ctx).sAdd(ctx, s.txQuerier(tx), key, members)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) SRem(ctx context.Context, key string, members []string) (int64, error) {
-	return s.ops.sRem(ctx, s.querier(), key, members)
+	return s.ops(ctx).sRem(ctx, s.querier(ctx), key, members)
 }
 
 func (s *Store) SMembers(ctx context.Context, key string) ([]string, error) {
-	return s.ops.sMembers(ctx, s.querier(), key)
+	return s.ops(ctx).sMembers(ctx, s.reader(ctx), key)
+}
+
+func (s *Store) SScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []string, error) {
+	return s.ops(ctx).sScan(ctx, s.reader(ctx), key, cursor, pattern, count)
 }
 
 func (s *Store) SIsMember(ctx context.Context, key, member string) (bool, error) {
-	return s.ops.sIsMember(ctx, s.querier(), key, member)
+	return s.ops(ctx).sIsMember(ctx, s.reader(ctx), key, member)
 }
 
 func (s *Store) SCard(ctx context.Context, key string) (int64, error) {
-	return s.ops.sCard(ctx, s.querier(), key)
+	return s.ops(ctx).sCard(ctx, s.reader(ctx), key)
 }
 
 func (s *Store) SMIsMember(ctx context.Context, key string, members []string) ([]bool, error) {
-	return s.ops.sMIsMember(ctx, s.querier(), key, members)
+	return s.ops(ctx).sMIsMember(ctx, s.reader(ctx), key, members)
 }
 
 func (s *Store) SInter(ctx context.Context, keys []string) ([]string, error) {
-	return s.ops.sInter(ctx, s.querier(), keys)
+	return s.ops(ctx).sInter(ctx, s.reader(ctx), keys)
 }
 
 func (s *Store) SInterStore(ctx context.Context, destination string, keys []string) (int64, error) {
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.sInterStore(ctx, s.txQuerier(tx), destination, keys)
+		result, err = s.ops(ctx).sInterStore(ctx, s.txQuerier(tx), destination, keys)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) SUnion(ctx context.Context, keys []string) ([]string, error) {
-	return s.ops.sUnion(ctx, s.querier(), keys)
+	return s.ops(ctx).sUnion(ctx, s.reader(ctx), keys)
 }
 
 func (s *Store) SUnionStore(ctx context.Context, destination string, keys []string) (int64, error) {
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.sUnionStore(ctx, s.txQuerier(tx), destination, keys)
+		result, err = s.ops(ctx).sUnionStore(ctx, s.txQuerier(tx), destination, keys)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) SDiff(ctx context.Context, keys []string) ([]string, error) {
-	return s.ops.sDiff(ctx, s.querier(), keys)
+	return s.ops(ctx).sDiff(ctx, s.reader(ctx), keys)
 }
 
 func (s *Store) SDiffStore(ctx context.Context, destination string, keys []string) (int64, error) {
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.sDiffStore(ctx, s.txQuerier(tx), destination, keys)
+		result, err = s.ops(ctx).sDiffStore(ctx, s.txQuerier(tx), destination, keys)
 		return err
 	})
 	return result, err
@@ -630,45 +858,45 @@ func (s *Store) ZAdd(ctx context.Context, key string, members []ZMember) (int64,
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.zAdd(ctx, s.txQuerier(tx), key, members)
+		result, err = s.ops(ctx).zAdd(ctx, s.txQuerier(tx), key, members)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) ZRange(ctx context.Context, key string, start, stop int64, withScores bool) ([]ZMember, error) {
-	return s.ops.zRange(ctx, s.querier(), key, start, stop, withScores)
+	return s.ops(ctx).zRange(ctx, s.reader(ctx), key, start, stop, withScores)
 }
 
 func (s *Store) ZScore(ctx context.Context, key, member string) (float64, bool, error) {
-	return s.ops.zScore(ctx, s.querier(), key, member)
+	return s.ops(ctx).zScore(ctx, s.reader(ctx), key, member)
 }
 
 func (s *Store) ZRem(ctx context.Context, key string, members []string) (int64, error) {
-	return s.ops.zRem(ctx, s.querier(), key, members)
+	return s.ops(ctx).zRem(ctx, s.querier(ctx), key, members)
 }
 
 func (s *Store) ZCard(ctx context.Context, key string) (int64, error) {
-	return s.ops.zCard(ctx, s.querier(), key)
+	return s.ops(ctx).zCard(ctx, s.reader(ctx), key)
 }
 
 func (s *Store) ZRangeByScore(ctx context.Context, key string, min, max float64, withScores bool, offset, count int64) ([]ZMember, error) {
-	return s.ops.zRangeByScore(ctx, s.querier(), key, min, max, withScores, offset, count)
+	return s.ops(ctx).zRangeByScore(ctx, s.reader(ctx), key, min, max, withScores, offset, count)
 }
 
 func (s *Store) ZRemRangeByScore(ctx context.Context, key string, min, max float64) (int64, error) {
-	return s.ops.zRemRangeByScore(ctx, s.querier(), key, min, max)
+	return s.ops(ctx).zRemRangeByScore(ctx, s.querier(ctx), key, min, max)
 }
 
 func (s *Store) ZRemRangeByRank(ctx context.Context, key string, start, stop int64) (int64, error) {
-	return s.ops.zRemRangeByRank(ctx, s.querier(), key, start, stop)
+	return s.ops(ctx).zRemRangeByRank(ctx, s.querier(ctx), key, start, stop)
 }
 
 func (s *Store) ZIncrBy(ctx context.Context, key string, increment float64, member string) (float64, error) {
 	var result float64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.zIncrBy(ctx, s.txQuerier(tx), key, increment, member)
+		result, err = s.ops(ctx).zIncrBy(ctx, s.txQuerier(tx), key, increment, member)
 		return err
 	})
 	return result, err
@@ -678,7 +906,7 @@ func (s *Store) ZPopMin(ctx context.Context, key string, count int64) ([]ZMember
 	var result []ZMember
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.zPopMin(ctx, s.txQuerier(tx), key, count)
+		result, err = s.ops(ctx).zPopMin(ctx, s.txQuerier(tx), key, count)
 		return err
 	})
 	return result, err
@@ -688,33 +916,33 @@ func (s *Store) ZPopMax(ctx context.Context, key string, count int64) ([]ZMember
 	var result []ZMember
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.zPopMax(ctx, s.txQuerier(tx), key, count)
+		result, err = s.ops(ctx).zPopMax(ctx, s.txQuerier(tx), key, count)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) ZRank(ctx context.Context, key, member string) (int64, bool, error) {
-	return s.ops.zRank(ctx, s.querier(), key, member)
+	return s.ops(ctx).zRank(ctx, s.reader(ctx), key, member)
 }
 
 func (s *Store) ZRevRank(ctx context.Context, key, member string) (int64, bool, error) {
-	return s.ops.zRevRank(ctx, s.querier(), key, member)
+	return s.ops(ctx).zRevRank(ctx, s.reader(ctx), key, member)
 }
 
 func (s *Store) ZCount(ctx context.Context, key string, min, max float64) (int64, error) {
-	return s.ops.zCount(ctx, s.querier(), key, min, max)
+	return s.ops(ctx).zCount(ctx, s.reader(ctx), key, min, max)
 }
 
-func (s *Store) ZScan(ctx context.Context, key string, cursor int64, pattern string, count int64) (int64, []ZMember, error) {
-	return s.ops.zScan(ctx, s.querier(), key, cursor, pattern, count)
+func (s *Store) ZScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []ZMember, error) {
+	return s.ops(ctx).zScan(ctx, s.reader(ctx), key, cursor, pattern, count)
 }
 
 func (s *Store) ZUnionStore(ctx context.Context, destination string, keys []string, weights []float64, aggregate string) (int64, error) {
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.zUnionStore(ctx, s.txQuerier(tx), destination, keys, weights, aggregate)
+		result, err = s.ops(ctx).zUnionStore(ctx, s.txQuerier(tx), destination, keys, weights, aggregate)
 		return err
 	})
 	return result, err
@@ -724,18 +952,26 @@ func (s *Store) ZInterStore(ctx context.Context, destination string, keys []stri
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.zInterStore(ctx, s.txQuerier(tx), destination, keys, weights, aggregate)
+		result, err = s.ops(ctx).zInterStore(ctx, s.txQuerier(tx), destination, keys, weights, aggregate)
 		return err
 	})
 	return result, err
 }
 
 func (s *Store) LRem(ctx context.Context, key string, count int64, element string) (int64, error) {
-	return s.ops.lRem(ctx, s.querier(), key, count, element)
+	var result int64
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).lRem(ctx, s.txQuerier(tx), key, count, element)
+		return err
+	})
+	return result, err
 }
 
 func (s *Store) LTrim(ctx context.Context, key string, start, stop int64) error {
-	return s.ops.lTrim(ctx, s.querier(), key, start, stop)
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		return s.ops(ctx).lTrim(ctx, s.txQuerier(tx), key, start, stop)
+	})
 }
 
 func (s *Store) RPopLPush(ctx context.Context, source, destination string) (string, bool, error) {
@@ -743,18 +979,18 @@ func (s *Store) RPopLPush(ctx context.Context, source, destination string) (stri
 	var found bool
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, found, err = s.ops.rPopLPush(ctx, s.txQuerier(tx), source, destination)
+		result, found, err = s.ops(ctx).rPopLPush(ctx, s.txQuerier(tx), source, destination)
 		return err
 	})
 	return result, found, err
 }
 func (s *Store) LPos(ctx context.Context, key, element string, rank, count, maxlen int64) ([]int64, error) {
-	return s.ops.lPos(ctx, s.querier(), key, element, rank, count, maxlen)
+	return s.ops(ctx).lPos(ctx, s.reader(ctx), key, element, rank, count, maxlen)
 }
 
 func (s *Store) LSet(ctx context.Context, key string, index int64, element string) error {
 	return s.withTx(ctx, func(tx pgx.Tx) error {
-		return s.ops.lSet(ctx, s.txQuerier(tx), key, index, element)
+		return s.ops(ctx).lSet(ctx, s.txQuerier(tx), key, index, element)
 	})
 }
 
@@ -762,7 +998,7 @@ func (s *Store) LInsert(ctx context.Context, key, pivot, element string, before
 	var result int64
 	err := s.withTx(ctx, func(tx pgx.Tx) error {
 		var err error
-		result, err = s.ops.lInsert(ctx, s.txQuerier(tx), key, pivot, element, before)
+		result, err = s.ops(ctx).lInsert(ctx, s.txQuerier(tx), key, pivot, element, before)
 		return err
 	})
 	return result, err
@@ -770,37 +1006,302 @@ func (s *Store) LInsert(ctx context.Context, key, pivot, element string, before
 // ============== HyperLogLog Commands ==============
 
 func (s *Store) PFAdd(ctx context.Context, key string, elements []string) (int64, error) {
-	return s.ops.pfAdd(ctx, s.querier(), key, elements)
+	var result int64
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).pfAdd(ctx, s.txQuerier(tx), key, elements)
+		return err
+	})
+	return result, err
 }
 
 func (s *Store) PFCount(ctx context.Context, keys []string) (int64, error) {
-	return s.ops.pfCount(ctx, s.querier(), keys)
+	return s.ops(ctx).pfCount(ctx, s.reader(ctx), keys)
 }
 
 func (s *Store) PFMerge(ctx context.Context, destKey string, sourceKeys []string) error {
-	return s.ops.pfMerge(ctx, s.querier(), destKey, sourceKeys)
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		return s.ops(ctx).pfMerge(ctx, s.txQuerier(tx), destKey, sourceKeys)
+	})
+}
+
+// ============== Stream Commands ==============
+
+func (s *Store) XAdd(ctx context.Context, key, id string, fields []string, noMkStream bool, trim StreamTrim) (string, bool, error) {
+	var result string
+	var added bool
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, added, err = s.ops(ctx).xAdd(ctx, s.txQuerier(tx), key, id, fields, noMkStream, trim)
+		return err
+	})
+	return result, added, err
+}
+
+func (s *Store) XRange(ctx context.Context, key string, start, end StreamID, count int64) ([]StreamEntry, error) {
+	return s.ops(ctx).xRange(ctx, s.reader(ctx), key, start, end, count)
+}
+
+func (s *Store) XRevRange(ctx context.Context, key string, end, start StreamID, count int64) ([]StreamEntry, error) {
+	return s.ops(ctx).xRevRange(ctx, s.reader(ctx), key, end, start, count)
+}
+
+func (s *Store) XLen(ctx context.Context, key string) (int64, error) {
+	return s.ops(ctx).xLen(ctx, s.reader(ctx), key)
+}
+
+func (s *Store) XDel(ctx context.Context, key string, ids []StreamID) (int64, error) {
+	var result int64
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).xDel(ctx, s.txQuerier(tx), key, ids)
+		return err
+	})
+	return result, err
+}
+
+func (s *Store) XTrim(ctx context.Context, key string, trim StreamTrim) (int64, error) {
+	var result int64
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).xTrim(ctx, s.txQuerier(tx), key, trim)
+		return err
+	})
+	return result, err
+}
+
+// ============== Stream Consumer Group Commands ==============
+
+func (s *Store) XGroupCreate(ctx context.Context, key, group, id string, mkStream bool, entriesRead *int64) error {
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		return s.ops(ctx).xGroupCreate(ctx, s.txQuerier(tx), key, group, id, mkStream, entriesRead)
+	})
+	return err
+}
+
+func (s *Store) XGroupSetID(ctx context.Context, key, group, id string, entriesRead *int64) error {
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		return s.ops(ctx).xGroupSetID(ctx, s.txQuerier(tx), key, group, id, entriesRead)
+	})
+	return err
+}
+
+func (s *Store) XGroupDestroy(ctx context.Context, key, group string) (bool, error) {
+	var result bool
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).xGroupDestroy(ctx, s.txQuerier(tx), key, group)
+		return err
+	})
+	return result, err
+}
+
+func (s *Store) XGroupCreateConsumer(ctx context.Context, key, group, consumer string) (bool, error) {
+	var result bool
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).xGroupCreateConsumer(ctx, s.txQuerier(tx), key, group, consumer)
+		return err
+	})
+	return result, err
+}
+
+func (s *Store) XGroupDelConsumer(ctx context.Context, key, group, consumer string) (int64, error) {
+	var result int64
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).xGroupDelConsumer(ctx, s.txQuerier(tx), key, group, consumer)
+		return err
+	})
+	return result, err
+}
+
+func (s *Store) XReadGroup(ctx context.Context, group, consumer string, keys, ids []string, count int64, noAck bool) ([]StreamReadResult, error) {
+	var result []StreamReadResult
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).xReadGroup(ctx, s.txQuerier(tx), group, consumer, keys, ids, count, noAck)
+		return err
+	})
+	return result, err
+}
+
+func (s *Store) XAck(ctx context.Context, key, group string, ids []StreamID) (int64, error) {
+	var result int64
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).xAck(ctx, s.txQuerier(tx), key, group, ids)
+		return err
+	})
+	return result, err
+}
+
+func (s *Store) XPendingSummary(ctx context.Context, key, group string) (StreamPendingSummary, error) {
+	return s.ops(ctx).xPendingSummary(ctx, s.reader(ctx), key, group)
+}
+
+func (s *Store) XPending(ctx context.Context, key, group string, start, end StreamID, count int64, consumer string, minIdle int64) ([]StreamPendingEntry, error) {
+	return s.ops(ctx).xPending(ctx, s.reader(ctx), key, group, start, end, count, consumer, minIdle)
+}
+
+func (s *Store) XClaim(ctx context.Context, key, group, consumer string, minIdle int64, ids []StreamID, opts StreamClaimOptions) ([]StreamEntry, error) {
+	var result []StreamEntry
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		result, err = s.ops(ctx).xClaim(ctx, s.txQuerier(tx), key, group, consumer, minIdle, ids, opts)
+		return err
+	})
+	return result, err
+}
+
+func (s *Store) XAutoClaim(ctx context.Context, key, group, consumer string, minIdle int64, start StreamID, count int64, justID bool) (StreamID, []StreamEntry, []StreamID, error) {
+	var next StreamID
+	var claimed []StreamEntry
+	var deleted []StreamID
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		next, claimed, deleted, err = s.ops(ctx).xAutoClaim(ctx, s.txQuerier(tx), key, group, consumer, minIdle, start, count, justID)
+		return err
+	})
+	return next, claimed, deleted, err
+}
+
+func (s *Store) XInfoStream(ctx context.Context, key string) (StreamInfo, error) {
+	return s.ops(ctx).xInfoStream(ctx, s.reader(ctx), key)
+}
+
+func (s *Store) XInfoGroups(ctx context.Context, key string) ([]StreamGroupInfo, error) {
+	return s.ops(ctx).xInfoGroups(ctx, s.reader(ctx), key)
+}
+
+func (s *Store) XInfoConsumers(ctx context.Context, key, group string) ([]StreamConsumerInfo, error) {
+	return s.ops(ctx).xInfoConsumers(ctx, s.reader(ctx), key, group)
+}
+
+// ============== JSON Commands ==============
+
+func (s *Store) JSONSet(ctx context.Context, key, path, value string, nx, xx bool) (bool, error) {
+	var set bool
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		set, err = s.ops(ctx).jsonSet(ctx, s.txQuerier(tx), key, path, value, nx, xx)
+		return err
+	})
+	return set, err
+}
+
+func (s *Store) JSONGet(ctx context.Context, key string, paths []string) ([][]JSONMatch, bool, error) {
+	return s.ops(ctx).jsonGet(ctx, s.reader(ctx), key, paths)
+}
+
+func (s *Store) JSONDel(ctx context.Context, key, path string) (int64, error) {
+	var deleted int64
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		deleted, err = s.ops(ctx).jsonDel(ctx, s.txQuerier(tx), key, path)
+		return err
+	})
+	return deleted, err
+}
+
+func (s *Store) JSONNumIncrBy(ctx context.Context, key, path, increment string) ([]JSONMatch, bool, error) {
+	var matches []JSONMatch
+	var exists bool
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		matches, exists, err = s.ops(ctx).jsonNumIncrBy(ctx, s.txQuerier(tx), key, path, increment)
+		return err
+	})
+	return matches, exists, err
+}
+
+func (s *Store) JSONArrAppend(ctx context.Context, key, path string, values []string) ([]JSONMatch, bool, error) {
+	var matches []JSONMatch
+	var exists bool
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		matches, exists, err = s.ops(ctx).jsonArrAppend(ctx, s.txQuerier(tx), key, path, values)
+		return err
+	})
+	return matches, exists, err
+}
+
+func (s *Store) JSONDescribe(ctx context.Context, key, path string, withKeys bool) ([]JSONMatch, bool, error) {
+	return s.ops(ctx).jsonDescribe(ctx, s.reader(ctx), key, path, withKeys)
+}
+
+// ============== Search Commands ==============
+
+func (s *Store) FTCreate(ctx context.Context, index FTIndex) error {
+	if err := s.ops(ctx).ftCreate(ctx, s.querier(ctx), index); err != nil {
+		return err
+	}
+	// Build the indexes outside a transaction so writers to kv_hashes aren't blocked
+	if err := s.ops(ctx).ftCreateIndexes(ctx, s.querier(ctx), index, true); err != nil {
+		s.ops(ctx).ftDropIndex(ctx, s.querier(ctx), index.Name, false)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) FTSearch(ctx context.Context, name, query string, opts FTSearchOptions) (int64, []FTDocument, error) {
+	return s.ops(ctx).ftSearch(ctx, s.reader(ctx), name, query, opts)
+}
+
+func (s *Store) FTInfo(ctx context.Context, name string) (FTIndex, int64, error) {
+	return s.ops(ctx).ftInfo(ctx, s.reader(ctx), name)
+}
+
+func (s *Store) FTDropIndex(ctx context.Context, name string, deleteDocs bool) error {
+	var index FTIndex
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		index, err = s.ops(ctx).ftDropIndex(ctx, s.txQuerier(tx), name, deleteDocs)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return s.ops(ctx).ftDropIndexes(ctx, s.querier(ctx), index, true)
+}
+
+func (s *Store) FTList(ctx context.Context) ([]string, error) {
+	return s.ops(ctx).ftList(ctx, s.reader(ctx))
 }
 
 // ============== Server Commands ==============
 
 func (s *Store) DBSize(ctx context.Context) (int64, error) {
-	return s.ops.dbSize(ctx, s.pool)
+	return s.ops(ctx).dbSize(ctx, s.pool)
 }
 
+func (s *Store) SwapDB(ctx context.Context, db1, db2 int) error {
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		return s.ops(ctx).swapDB(ctx, s.txQuerier(tx), db1, db2)
+	})
+}
+
+func (s *Store) Keyspace(ctx context.Context) ([]KeyspaceInfo, error) {
+	return s.ops(ctx).keyspace(ctx, s.querier(ctx))
+}
+
+func (s *Store) KeyVersions(ctx context.Context, keys []string) ([]int64, error) {
+	return s.ops(ctx).keyVersions(ctx, s.querier(ctx), keys, false)
+}
+
+// FlushDB deletes the keys of the database selected in ctx
 func (s *Store) FlushDB(ctx context.Context) error {
-	queries := []string{
-		"TRUNCATE kv_strings",
-		"TRUNCATE kv_hashes",
-		"TRUNCATE kv_lists",
-		"TRUNCATE kv_sets",
-		"TRUNCATE kv_zsets",
-		"TRUNCATE kv_hyperloglog",
-		"TRUNCATE kv_meta",
-	}
-	for _, q := range queries {
-		if _, err := s.pool.Exec(ctx, q); err != nil {
-			return err
-		}
-	}
-	return nil
+	return s.withTx(ctx, func(tx pgx.Tx) error {
+		return s.ops(ctx).flushDB(ctx, s.txQuerier(tx))
+	})
+}
+
+// FlushAll deletes the keys of every database
+func (s *Store) FlushAll(ctx context.Context) error {
+	// Every type table references kv_keys, so they go in one statement
+	_, err := s.pool.Exec(ctx,
+		`TRUNCATE kv_keys, kv_strings, kv_hashes, kv_lists, kv_list_meta, kv_list_blocks, kv_sets, kv_zsets, kv_hyperloglog,
+		 kv_streams, kv_stream_meta, kv_stream_groups, kv_stream_consumers, kv_stream_pending, kv_json`,
+	)
+	return err
 }
@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestConfigSchema(t *testing.T) {
+	for _, schema := range []string{"", "public", "tenant_1", "_x"} {
+		if err := (Config{Schema: schema}).validate(); err != nil {
+			t.Errorf("Schema %q: unexpected error %v", schema, err)
+		}
+	}
+	for _, schema := range []string{"Tenant", "1tenant", "a-b", "a b", "x;drop", strings.Repeat("a", 64)} {
+		if err := (Config{Schema: schema}).validate(); err == nil {
+			t.Errorf("Schema %q: expected an error", schema)
+		}
+	}
+
+	if cs := (Config{Schema: "tenant_1"}).connString(); !strings.HasSuffix(cs, " search_path=tenant_1") {
+		t.Errorf("Expected search_path in %q", cs)
+	}
+	if cs := (Config{}).connString(); strings.Contains(cs, "search_path") {
+		t.Errorf("Expected no search_path in %q", cs)
+	}
+
+	if ns := (Config{Schema: "public"}).namespace(); ns != "" {
+		t.Errorf("Expected the public schema to share the default namespace, got %q", ns)
+	}
+	if advisoryLockID(sweeperLockID, "") != sweeperLockID {
+		t.Error("Expected the default namespace to keep the lock id")
+	}
+	if advisoryLockID(sweeperLockID, "a") == advisoryLockID(sweeperLockID, "b") {
+		t.Error("Expected namespaces to get different lock ids")
+	}
+}
+
+func TestVolatile(t *testing.T) {
+	s := &Store{volatilePool: &pgxpool.Pool{}, volatilePatterns: []string{"cache:*", "session:?"}}
+
+	tests := []struct {
+		keys     []string
+		declared bool
+		volatile bool
+	}{
+		{nil, false, false},
+		{[]string{}, true, false},
+		{[]string{"cache:a"}, true, true},
+		{[]string{"cache:a", "session:1"}, true, true},
+		{[]string{"cache:a", "user:1"}, true, false},
+		{[]string{"session:10"}, true, false},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.declared {
+			ctx = WithKeys(ctx, tt.keys)
+		}
+		if got := s.volatile(ctx); got != tt.volatile {
+			t.Errorf("volatile(%v) = %v, want %v", tt.keys, got, tt.volatile)
+		}
+	}
+
+	if (&Store{}).volatile(WithKeys(context.Background(), []string{"cache:a"})) {
+		t.Error("Expected keys to be durable without volatile patterns")
+	}
+}
+
+func TestReader(t *testing.T) {
+	primary, replica := &pgxpool.Pool{}, &pgxpool.Pool{}
+	s := &Store{pool: primary, replicaPool: replica, events: &keyspaceEvents{}}
+	ctx := context.Background()
+	replicaCtx := WithReplicaReads(ctx, true)
+
+	if s.reader(replicaCtx) != primary {
+		t.Error("Expected reads from the primary while the replica lags")
+	}
+	s.replicaUsable.Store(true)
+	if s.reader(replicaCtx) != replica {
+		t.Error("Expected reads from the replica")
+	}
+	if s.reader(ctx) != primary || s.reader(WithReplicaReads(ctx, false)) != primary {
+		t.Error("Expected reads from the primary unless the context allows the replica")
+	}
+	if s.querier(replicaCtx) != primary {
+		t.Error("Expected writes to go to the primary")
+	}
+
+	s.events.flags.Store(notifyKeyMiss | notifyKeyspace)
+	if s.keyMissReader(replicaCtx) != primary {
+		t.Error("Expected reads that send key miss notifications to go to the primary")
+	}
+}
+
+func TestConfigPgBouncer(t *testing.T) {
+	cfg := Config{Schema: "tenant_1", PgBouncer: true}
+	if cs := cfg.connString(); !strings.HasSuffix(cs, " default_query_exec_mode=simple_protocol") || strings.Contains(cs, "search_path") {
+		t.Errorf("Expected the simple protocol and no search_path in %q", cs)
+	}
+	if cs := cfg.sessionConnString(); cs != cfg.connString() {
+		t.Errorf("Expected the pool's connection string without ListenDSN, got %q", cs)
+	}
+
+	cfg.ListenDSN = "host=db port=5432"
+	if cs := cfg.sessionConnString(); cs != "host=db port=5432 search_path=tenant_1" {
+		t.Errorf("Unexpected session connection string %q", cs)
+	}
+	cfg.ListenDSN = "postgres://u:p@db:5432/postkeys?sslmode=disable"
+	if cs := cfg.sessionConnString(); cs != "postgres://u:p@db:5432/postkeys?search_path=tenant_1&sslmode=disable" {
+		t.Errorf("Unexpected session connection string %q", cs)
+	}
+
+	cfg.VolatileKeyPatterns = []string{"cache:*"}
+	if err := cfg.validate(); err == nil {
+		t.Error("Expected an error for volatile key patterns through PgBouncer")
+	}
+}
+
+func TestConfigConnSettings(t *testing.T) {
+	cfg := Config{
+		DSN:              "postgres://u:p@db:5432/postkeys?sslmode=require&target_session_attrs=read-write&keepalives_idle=30&keepalives_count=3",
+		Schema:           "tenant_1",
+		MaxConns:         20,
+		MinConns:         2,
+		MaxConnIdleTime:  time.Minute,
+		ConnectTimeout:   3 * time.Second,
+		StatementTimeout: 1500 * time.Millisecond,
+		LockTimeout:      time.Second,
+	}
+	if cs := cfg.connString(); !strings.HasPrefix(cs, "postgres://u:p@db:5432/postkeys?") || !strings.Contains(cs, "search_path=tenant_1") {
+		t.Errorf("Expected the DSN with a search_path, got %q", cs)
+	}
+
+	poolCfg, err := cfg.poolConfig(cfg.connString())
+	if err != nil {
+		t.Fatalf("poolConfig failed: %v", err)
+	}
+	if poolCfg.MaxConns != 20 || poolCfg.MinConns != 2 || poolCfg.MaxConnIdleTime != time.Minute {
+		t.Errorf("Unexpected pool sizing %d/%d/%v", poolCfg.MaxConns, poolCfg.MinConns, poolCfg.MaxConnIdleTime)
+	}
+	connCfg := poolCfg.ConnConfig
+	if connCfg.ConnectTimeout != 3*time.Second {
+		t.Errorf("Expected a 3s connect timeout, got %v", connCfg.ConnectTimeout)
+	}
+	want := map[string]string{
+		"statement_timeout": "1500",
+		"lock_timeout":      "1000",
+		"application_name":  "postkeys",
+		"search_path":       "tenant_1",
+	}
+	for k, v := range want {
+		if connCfg.RuntimeParams[k] != v {
+			t.Errorf("Expected %s=%s, got %q", k, v, connCfg.RuntimeParams[k])
+		}
+	}
+	if _, ok := connCfg.RuntimeParams["keepalives_idle"]; ok {
+		t.Error("Expected the keepalives settings to go to the dialer, not the server")
+	}
+	if connCfg.TLSConfig == nil {
+		t.Error("Expected TLS from sslmode=require")
+	}
+
+	// An explicit application name wins over the DSN's, which wins over the default
+	if err := (Config{ApplicationName: "web"}).applyConnSettings(connCfg); err != nil || connCfg.RuntimeParams["application_name"] != "web" {
+		t.Errorf("Expected application_name=web, got %q, %v", connCfg.RuntimeParams["application_name"], err)
+	}
+	if err := (Config{}).applyConnSettings(connCfg); err != nil || connCfg.RuntimeParams["application_name"] != "web" {
+		t.Errorf("Expected application_name to stay web, got %q, %v", connCfg.RuntimeParams["application_name"], err)
+	}
+
+	if err := (Config{MinConns: 5, MaxConns: 2}).validate(); err == nil {
+		t.Error("Expected an error for min conns above max conns")
+	}
+	if err := (Config{PgBouncer: true, StatementTimeout: time.Second}).validate(); err == nil {
+		t.Error("Expected an error for a statement timeout through PgBouncer")
+	}
+}
+
+func TestKeepAliveConfig(t *testing.T) {
+	params := map[string]string{"keepalives_idle": "30", "keepalives_interval": "5", "keepalives_count": "3", "other": "x"}
+	cfg, set, err := keepAliveConfig(params)
+	if err != nil || !set {
+		t.Fatalf("keepAliveConfig failed: %v, %v", set, err)
+	}
+	if !cfg.Enable || cfg.Idle != 30*time.Second || cfg.Interval != 5*time.Second || cfg.Count != 3 {
+		t.Errorf("Unexpected keepalive config %+v", cfg)
+	}
+	if len(params) != 1 {
+		t.Errorf("Expected only the other setting to remain, got %v", params)
+	}
+
+	if cfg, _, _ := keepAliveConfig(map[string]string{"keepalives": "0"}); cfg.Enable {
+		t.Error("Expected keepalives=0 to disable keepalives")
+	}
+	if _, set, _ := keepAliveConfig(map[string]string{}); set {
+		t.Error("Expected no keepalive config without keepalives settings")
+	}
+	if _, _, err := keepAliveConfig(map[string]string{"keepalives_idle": "soon"}); err == nil {
+		t.Error("Expected an error for an invalid keepalives_idle")
+	}
+}
+
+func TestRetryTx(t *testing.T) {
+	deadlock := &pgconn.PgError{Code: "40P01", Message: "deadlock detected"}
+	if !IsRetryable(deadlock) || !IsRetryable(fmt.Errorf("incr: %w", deadlock)) {
+		t.Error("Expected deadlocks to be retryable")
+	}
+	if !IsRetryable(errors.New("ERR ERROR: could not serialize access (SQLSTATE 40001)")) {
+		t.Error("Expected serialization failures flattened to text to be retryable")
+	}
+	if IsRetryable(&pgconn.PgError{Code: "23505"}) || IsRetryable(errors.New("ERR value is not an integer")) || IsRetryable(nil) {
+		t.Error("Expected other errors not to be retryable")
+	}
+
+	attempts := 0
+	err := RetryTx(context.Background(), "test", func() error {
+		attempts++
+		if attempts < 3 {
+			return deadlock
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("Expected success on the third attempt, got %v after %d", err, attempts)
+	}
+
+	attempts = 0
+	err = RetryTx(context.Background(), "test", func() error {
+		attempts++
+		return deadlock
+	})
+	if err != deadlock || attempts != MaxTxAttempts {
+		t.Errorf("Expected the deadlock after %d attempts, got %v after %d", MaxTxAttempts, err, attempts)
+	}
+
+	attempts = 0
+	notRetryable := errors.New("boom")
+	if err := RetryTx(context.Background(), "test", func() error { attempts++; return notRetryable }); err != notRetryable || attempts != 1 {
+		t.Errorf("Expected one attempt for other errors, got %v after %d", err, attempts)
+	}
+
+	for n := 1; n <= 10; n++ {
+		d := retryDelay(n)
+		if d <= 0 || d > retryMaxDelay {
+			t.Errorf("retryDelay(%d) = %v out of range", n, d)
+		}
+	}
+}
+
+func TestFuncError(t *testing.T) {
+	wrongType := &pgconn.PgError{Code: "P0001", Message: "WRONGTYPE Operation against a key holding the wrong kind of value"}
+	if err := funcError(fmt.Errorf("hset: %w", wrongType)); err == nil || err.Error() != wrongType.Message {
+		t.Errorf("Expected the raised message as the error, got %v", err)
+	}
+	deadlock := &pgconn.PgError{Code: "40P01"}
+	if err := funcError(deadlock); err != deadlock || !IsRetryable(err) {
+		t.Errorf("Expected other errors unchanged, got %v", err)
+	}
+}
+
+// lsnServer answers the WAL position queries of replicaLag.check
+type lsnServer struct {
+	Querier
+	recovering bool
+	lsn        string
+}
+
+func (s *lsnServer) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return lsnRow{s}
+}
+
+type lsnRow struct{ s *lsnServer }
+
+func (r lsnRow) Scan(dest ...any) error {
+	if len(dest) == 1 {
+		*dest[0].(*string) = r.s.lsn
+		return nil
+	}
+	*dest[0].(*bool) = r.s.recovering
+	*dest[1].(*string) = r.s.lsn
+	return nil
+}
+
+// lsn formats a WAL position like PostgreSQL
+func lsn(v uint64) string {
+	return fmt.Sprintf("%X/%X", v>>32, uint32(v))
+}
+
+func TestReplicaLag(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	primary := &lsnServer{lsn: "0/3000000"}
+	replica := &lsnServer{recovering: true, lsn: "0/2FFFF00"}
+
+	var tracker replicaLag
+	if lag, err := tracker.check(ctx, primary, replica, start); err != nil || !math.IsInf(lag, 1) {
+		t.Errorf("Expected infinite lag before the replica replayed any sample, got %v, %v", lag, err)
+	}
+	replica.lsn = "0/3000000"
+	if lag, err := tracker.check(ctx, primary, replica, start.Add(time.Second)); err != nil || lag != 0 {
+		t.Errorf("Expected no lag once caught up with an idle primary, got %v, %v", lag, err)
+	}
+
+	// Under steady writes the primary is always ahead of the replica, which
+	// replays what was written half a second ago
+	const base, rate = 0x3000000, 0x1000 // WAL written per second
+	at := func(s float64) time.Time { return start.Add(time.Duration(s * float64(time.Second))) }
+	for k := 1; k <= 10; k++ {
+		primary.lsn = lsn(base + uint64(k)*rate)
+		replica.lsn = lsn(base + uint64(k)*rate - rate/2)
+		lag, err := tracker.check(ctx, primary, replica, at(1+float64(k)))
+		if err != nil || math.Abs(lag-0.5) > 1e-6 {
+			t.Fatalf("Expected 0.5s of lag under steady writes at check %d, got %v, %v", k, lag, err)
+		}
+	}
+
+	// The WAL receiver stops: the replica has replayed all it received, but
+	// the primary moves on without it
+	var last float64
+	for k := 11; k <= 15; k++ {
+		primary.lsn = lsn(base + uint64(k)*rate)
+		lag, err := tracker.check(ctx, primary, replica, at(1+float64(k)))
+		if err != nil || lag <= last {
+			t.Fatalf("Expected the lag to keep growing with a stopped receiver, got %v after %v, %v", lag, last, err)
+		}
+		last = lag
+	}
+	if math.Abs(last-5.5) > 1e-6 {
+		t.Errorf("Expected 5.5s of lag 5s after the receiver stopped, got %v", last)
+	}
+	if len(tracker.samples) > 7 {
+		t.Errorf("Expected samples before the replayed position to be dropped, got %d", len(tracker.samples))
+	}
+
+	primary.lsn = "1/0"
+	replica.lsn = "1/10"
+	if lag, err := tracker.check(ctx, primary, replica, at(20)); err != nil || lag != 0 {
+		t.Errorf("Expected no lag past a WAL segment boundary, got %v, %v", lag, err)
+	}
+	if lag, err := tracker.check(ctx, primary, &lsnServer{lsn: "0/0"}, at(21)); err != nil || lag != 0 {
+		t.Errorf("Expected no lag for a server not in recovery, got %v, %v", lag, err)
+	}
+	if _, err := tracker.check(ctx, primary, &lsnServer{recovering: true, lsn: "bogus"}, at(22)); err == nil {
+		t.Error("Expected an error for an invalid WAL position")
+	}
+}
+
+func TestStatementError(t *testing.T) {
+	deadlock := &pgconn.PgError{Code: "40P01"}
+	var tracer statementTracer
+
+	tracer.TraceQueryEnd(context.Background(), nil, pgx.TraceQueryEndData{Err: deadlock})
+	ctx := WithStatementErrors(context.Background())
+	if err := StatementError(ctx); err != nil {
+		t.Errorf("Expected no statement error yet, got %v", err)
+	}
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: deadlock})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+	if err := StatementError(ctx); err != deadlock || !IsRetryable(err) {
+		t.Errorf("Expected the deadlock to be kept after a later statement succeeded, got %v", err)
+	}
+
+	// A retried transaction's error is explained by its last attempt only
+	attempts := 0
+	err := RetryTx(ctx, "test", func() error {
+		attempts++
+		if attempts == 1 {
+			tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: deadlock})
+			return deadlock
+		}
+		return errors.New("ERR value is not an integer")
+	})
+	if err == nil || StatementError(ctx) != nil {
+		t.Errorf("Expected the first attempt's deadlock forgotten, got %v", StatementError(ctx))
+	}
+	if StatementError(context.Background()) != nil {
+		t.Error("Expected no statement error without WithStatementErrors")
+	}
+}
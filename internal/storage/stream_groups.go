@@ -0,0 +1,955 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var errXGroupNoKey = fmt.Errorf("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+
+// streamMeta is the per-stream state kept in kv_stream_meta
+type streamMeta struct {
+	last         StreamID
+	maxDeleted   StreamID
+	entriesAdded int64
+}
+
+func noGroupError(key, group string) error {
+	return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+func noConsumerGroupError(key, group string) error {
+	return fmt.Errorf("NOGROUP No such consumer group '%s' for key name '%s'", group, key)
+}
+
+// streamKeyExists reports whether key holds a stream, failing with WRONGTYPE for other types
+func (o queryOps) streamKeyExists(ctx context.Context, q Querier, key string) (bool, error) {
+	keyType, err := o.getKeyType(ctx, q, key)
+	if err != nil {
+		return false, err
+	}
+	if keyType == TypeNone {
+		return false, nil
+	}
+	if keyType != TypeStream {
+		return false, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return true, nil
+}
+
+func (o queryOps) loadStreamMeta(ctx context.Context, q Querier, key string) (streamMeta, error) {
+	var lastMs, lastSeq, delMs, delSeq, added int64
+	err := q.QueryRow(ctx,
+		`SELECT last_ms, last_seq, max_deleted_ms, max_deleted_seq, entries_added
+		 FROM kv_stream_meta WHERE key_id = postkeys_key_id($2, $1)`,
+		key, o.db,
+	).Scan(&lastMs, &lastSeq, &delMs, &delSeq, &added)
+	if err == pgx.ErrNoRows {
+		return streamMeta{}, nil
+	}
+	if err != nil {
+		return streamMeta{}, err
+	}
+	return streamMeta{
+		last:         StreamID{Ms: uint64(lastMs), Seq: uint64(lastSeq)},
+		maxDeleted:   StreamID{Ms: uint64(delMs), Seq: uint64(delSeq)},
+		entriesAdded: added,
+	}, nil
+}
+
+func (o queryOps) streamGroupExists(ctx context.Context, q Querier, key, group string) (bool, error) {
+	var exists bool
+	err := q.QueryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM kv_stream_groups WHERE key_id = postkeys_key_id($3, $1) AND group_name = $2)",
+		key, group, o.db,
+	).Scan(&exists)
+	return exists, err
+}
+
+// lockStreamGroup locks a consumer group row so concurrent readers of the group
+// can't be handed the same new entries. It returns the last delivered ID and entries-read counter.
+func (o queryOps) lockStreamGroup(ctx context.Context, q Querier, key, group string) (StreamID, *int64, bool, error) {
+	var ms, seq int64
+	var entriesRead *int64
+	err := q.QueryRow(ctx,
+		"SELECT last_ms, last_seq, entries_read FROM kv_stream_groups WHERE key_id = postkeys_key_id($3, $1) AND group_name = $2 FOR UPDATE",
+		key, group, o.db,
+	).Scan(&ms, &seq, &entriesRead)
+	if err == pgx.ErrNoRows {
+		return StreamID{}, nil, false, nil
+	}
+	if err != nil {
+		return StreamID{}, nil, false, err
+	}
+	return StreamID{Ms: uint64(ms), Seq: uint64(seq)}, entriesRead, true, nil
+}
+
+// touchStreamConsumer creates the consumer if needed and updates its seen time,
+// and its active time when it actually read or claimed entries
+func (o queryOps) touchStreamConsumer(ctx context.Context, q Querier, key, group, consumer string, active bool) error {
+	_, err := q.Exec(ctx,
+		`INSERT INTO kv_stream_consumers (key_id, group_name, consumer, seen_time, active_time)
+		 VALUES (postkeys_key_id($5, $1), $2, $3, NOW(), CASE WHEN $4::boolean THEN NOW() END)
+		 ON CONFLICT (key_id, group_name, consumer) DO UPDATE
+		 SET seen_time = NOW(), active_time = COALESCE(EXCLUDED.active_time, kv_stream_consumers.active_time)`,
+		key, group, consumer, active, o.db,
+	)
+	return err
+}
+
+// estimateEntriesRead returns the entries-read counter of a group that has delivered
+// everything up to id, or nil when deleted entries after id make it unknown
+func (o queryOps) estimateEntriesRead(ctx context.Context, q Querier, key string, meta streamMeta, id StreamID) (*int64, error) {
+	if meta.entriesAdded == 0 || !id.Less(meta.last) {
+		n := meta.entriesAdded
+		return &n, nil
+	}
+	if id.Less(meta.maxDeleted) {
+		return nil, nil
+	}
+	var after int64
+	err := q.QueryRow(ctx,
+		"SELECT COUNT(*) FROM kv_streams WHERE key_id = postkeys_key_id($4, $1) AND (ms, seq) > ($2, $3)",
+		key, int64(id.Ms), int64(id.Seq), o.db,
+	).Scan(&after)
+	if err != nil {
+		return nil, err
+	}
+	n := meta.entriesAdded - after
+	return &n, nil
+}
+
+// groupStartID resolves the ID argument of XGROUP CREATE/SETID, where "$" is the last entry ID.
+// Unless given explicitly, the entries-read counter is only known for "$".
+func groupStartID(id string, meta streamMeta, entriesRead *int64) (StreamID, *int64, error) {
+	if id == "$" {
+		if entriesRead == nil {
+			n := meta.entriesAdded
+			entriesRead = &n
+		}
+		return meta.last, entriesRead, nil
+	}
+	start, err := ParseStreamID(id, 0)
+	if err != nil {
+		return StreamID{}, nil, err
+	}
+	if entriesRead != nil && *entriesRead < 0 {
+		entriesRead = nil
+	}
+	return start, entriesRead, nil
+}
+
+// ============== Stream Consumer Group Commands ==============
+
+func (o queryOps) xGroupCreate(ctx context.Context, q Querier, key, group, id string, mkStream bool, entriesRead *int64) error {
+	exists, err := o.streamKeyExists(ctx, q, key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if !mkStream {
+			return errXGroupNoKey
+		}
+		// Create an empty stream, replacing an expired key
+		streamID, err := o.newKey(ctx, q, key, TypeStream, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := q.Exec(ctx, "INSERT INTO kv_stream_meta (key_id) VALUES ($1)", streamID); err != nil {
+			return err
+		}
+	}
+
+	meta, err := o.loadStreamMeta(ctx, q, key)
+	if err != nil {
+		return err
+	}
+	start, entriesRead, err := groupStartID(id, meta, entriesRead)
+	if err != nil {
+		return err
+	}
+
+	tag, err := q.Exec(ctx,
+		`INSERT INTO kv_stream_groups (key_id, group_name, last_ms, last_seq, entries_read)
+		 VALUES (postkeys_key_id($6, $1), $2, $3, $4, $5)
+		 ON CONFLICT (key_id, group_name) DO NOTHING`,
+		key, group, int64(start.Ms), int64(start.Seq), entriesRead, o.db,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("BUSYGROUP Consumer Group name already exists")
+	}
+	o.notify(ctx, q, notifyStream, "xgroup-create", key)
+	return nil
+}
+
+func (o queryOps) xGroupSetID(ctx context.Context, q Querier, key, group, id string, entriesRead *int64) error {
+	exists, err := o.streamKeyExists(ctx, q, key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errXGroupNoKey
+	}
+
+	meta, err := o.loadStreamMeta(ctx, q, key)
+	if err != nil {
+		return err
+	}
+	start, entriesRead, err := groupStartID(id, meta, entriesRead)
+	if err != nil {
+		return err
+	}
+
+	tag, err := q.Exec(ctx,
+		`UPDATE kv_stream_groups SET last_ms = $3, last_seq = $4, entries_read = $5
+		 WHERE key_id = postkeys_key_id($6, $1) AND group_name = $2`,
+		key, group, int64(start.Ms), int64(start.Seq), entriesRead, o.db,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return noConsumerGroupError(key, group)
+	}
+	o.notify(ctx, q, notifyStream, "xgroup-setid", key)
+	return nil
+}
+
+func (o queryOps) xGroupDestroy(ctx context.Context, q Querier, key, group string) (bool, error) {
+	exists, err := o.streamKeyExists(ctx, q, key)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, errXGroupNoKey
+	}
+
+	for _, query := range []string{
+		"DELETE FROM kv_stream_pending WHERE key_id = postkeys_key_id($3, $1) AND group_name = $2",
+		"DELETE FROM kv_stream_consumers WHERE key_id = postkeys_key_id($3, $1) AND group_name = $2",
+	} {
+		if _, err := q.Exec(ctx, query, key, group, o.db); err != nil {
+			return false, err
+		}
+	}
+	tag, err := q.Exec(ctx, "DELETE FROM kv_stream_groups WHERE key_id = postkeys_key_id($3, $1) AND group_name = $2", key, group, o.db)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() > 0 {
+		o.notify(ctx, q, notifyStream, "xgroup-destroy", key)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (o queryOps) xGroupCreateConsumer(ctx context.Context, q Querier, key, group, consumer string) (bool, error) {
+	exists, err := o.streamKeyExists(ctx, q, key)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, errXGroupNoKey
+	}
+	found, err := o.streamGroupExists(ctx, q, key, group)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, noConsumerGroupError(key, group)
+	}
+
+	tag, err := q.Exec(ctx,
+		`INSERT INTO kv_stream_consumers (key_id, group_name, consumer) VALUES (postkeys_key_id($4, $1), $2, $3)
+		 ON CONFLICT (key_id, group_name, consumer) DO NOTHING`,
+		key, group, consumer, o.db,
+	)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() > 0 {
+		o.notify(ctx, q, notifyStream, "xgroup-createconsumer", key)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (o queryOps) xGroupDelConsumer(ctx context.Context, q Querier, key, group, consumer string) (int64, error) {
+	exists, err := o.streamKeyExists(ctx, q, key)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, errXGroupNoKey
+	}
+	found, err := o.streamGroupExists(ctx, q, key, group)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, noConsumerGroupError(key, group)
+	}
+
+	// The consumer's pending entries are dropped with it
+	tag, err := q.Exec(ctx,
+		"DELETE FROM kv_stream_pending WHERE key_id = postkeys_key_id($4, $1) AND group_name = $2 AND consumer = $3",
+		key, group, consumer, o.db,
+	)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := q.Exec(ctx,
+		"DELETE FROM kv_stream_consumers WHERE key_id = postkeys_key_id($4, $1) AND group_name = $2 AND consumer = $3",
+		key, group, consumer, o.db,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if deleted.RowsAffected() > 0 {
+		o.notify(ctx, q, notifyStream, "xgroup-delconsumer", key)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (o queryOps) xReadGroup(ctx context.Context, q Querier, group, consumer string, keys, ids []string, count int64, noAck bool) ([]StreamReadResult, error) {
+	var results []StreamReadResult
+	for i, key := range keys {
+		exists, err := o.streamKeyExists(ctx, q, key)
+		if err != nil {
+			return nil, err
+		}
+		var last StreamID
+		var entriesRead *int64
+		found := false
+		if exists {
+			last, entriesRead, found, err = o.lockStreamGroup(ctx, q, key, group)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, group)
+		}
+
+		var entries []StreamEntry
+		if ids[i] == ">" {
+			entries, err = o.readNewGroupEntries(ctx, q, key, group, consumer, last, entriesRead, count, noAck)
+		} else {
+			var start StreamID
+			start, err = ParseStreamID(ids[i], 0)
+			if err != nil {
+				return nil, err
+			}
+			entries, err = o.readPendingGroupEntries(ctx, q, key, group, consumer, start, count)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := o.touchStreamConsumer(ctx, q, key, group, consumer, len(entries) > 0); err != nil {
+			return nil, err
+		}
+
+		// Streams without new entries are left out; history reads always reply
+		if ids[i] == ">" && len(entries) == 0 {
+			continue
+		}
+		results = append(results, StreamReadResult{Key: key, Entries: entries})
+	}
+	return results, nil
+}
+
+// readNewGroupEntries delivers entries after the group's last delivered ID to consumer,
+// adding them to the pending entries list unless noAck is set
+func (o queryOps) readNewGroupEntries(ctx context.Context, q Querier, key, group, consumer string, last StreamID, entriesRead *int64, count int64, noAck bool) ([]StreamEntry, error) {
+	var limit *int64
+	if count > 0 {
+		limit = &count
+	}
+	rows, err := q.Query(ctx,
+		`SELECT ms, seq, fields FROM kv_streams
+		 WHERE key_id = postkeys_key_id($5, $1) AND (ms, seq) > ($2, $3)
+		 ORDER BY ms, seq
+		 LIMIT $4`,
+		key, int64(last.Ms), int64(last.Seq), limit, o.db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := scanStreamEntries(rows)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	if !noAck {
+		ids := make([]StreamID, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.ID
+		}
+		msList, seqList := streamIDArrays(ids)
+		// An entry can already be pending if XGROUP SETID moved the group backwards
+		_, err = q.Exec(ctx,
+			`INSERT INTO kv_stream_pending (key_id, group_name, ms, seq, consumer)
+			 SELECT postkeys_key_id($6, $1), $2, unnest($3::bigint[]), unnest($4::bigint[]), $5
+			 ON CONFLICT (key_id, group_name, ms, seq) DO UPDATE
+			 SET consumer = EXCLUDED.consumer, delivered_at = NOW(), delivery_count = 1`,
+			key, group, msList, seqList, consumer, o.db,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	meta, err := o.loadStreamMeta(ctx, q, key)
+	if err != nil {
+		return nil, err
+	}
+	newLast := entries[len(entries)-1].ID
+	if entriesRead != nil && !last.Less(meta.maxDeleted) {
+		n := *entriesRead + int64(len(entries))
+		entriesRead = &n
+	} else if entriesRead, err = o.estimateEntriesRead(ctx, q, key, meta, newLast); err != nil {
+		return nil, err
+	}
+
+	_, err = q.Exec(ctx,
+		`UPDATE kv_stream_groups SET last_ms = $3, last_seq = $4, entries_read = $5
+		 WHERE key_id = postkeys_key_id($6, $1) AND group_name = $2`,
+		key, group, int64(newLast.Ms), int64(newLast.Seq), entriesRead, o.db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// readPendingGroupEntries re-delivers consumer's pending entries after start.
+// Entries deleted from the stream are returned with nil fields.
+func (o queryOps) readPendingGroupEntries(ctx context.Context, q Querier, key, group, consumer string, start StreamID, count int64) ([]StreamEntry, error) {
+	var limit *int64
+	if count > 0 {
+		limit = &count
+	}
+	rows, err := q.Query(ctx,
+		`SELECT p.ms, p.seq, s.fields FROM kv_stream_pending p
+		 LEFT JOIN kv_streams s ON s.key_id = p.key_id AND s.ms = p.ms AND s.seq = p.seq
+		 WHERE p.key_id = postkeys_key_id($7, $1) AND p.group_name = $2 AND p.consumer = $3 AND (p.ms, p.seq) > ($4, $5)
+		 ORDER BY p.ms, p.seq
+		 LIMIT $6`,
+		key, group, consumer, int64(start.Ms), int64(start.Seq), limit, o.db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := scanStreamEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var delivered []StreamID
+	for _, entry := range entries {
+		if entry.Fields != nil {
+			delivered = append(delivered, entry.ID)
+		}
+	}
+	if len(delivered) > 0 {
+		msList, seqList := streamIDArrays(delivered)
+		_, err = q.Exec(ctx,
+			`UPDATE kv_stream_pending SET delivered_at = NOW(), delivery_count = delivery_count + 1
+			 WHERE key_id = postkeys_key_id($5, $1) AND group_name = $2 AND (ms, seq) IN (SELECT unnest($3::bigint[]), unnest($4::bigint[]))`,
+			key, group, msList, seqList, o.db,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if entries == nil {
+		entries = []StreamEntry{}
+	}
+	return entries, nil
+}
+
+func (o queryOps) xAck(ctx context.Context, q Querier, key, group string, ids []StreamID) (int64, error) {
+	exists, err := o.streamKeyExists(ctx, q, key)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	msList, seqList := streamIDArrays(ids)
+	tag, err := q.Exec(ctx,
+		`DELETE FROM kv_stream_pending
+		 WHERE key_id = postkeys_key_id($5, $1) AND group_name = $2 AND (ms, seq) IN (SELECT unnest($3::bigint[]), unnest($4::bigint[]))`,
+		key, group, msList, seqList, o.db,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// requireStreamGroup fails with NOGROUP unless key is a stream with the given group
+func (o queryOps) requireStreamGroup(ctx context.Context, q Querier, key, group string) error {
+	exists, err := o.streamKeyExists(ctx, q, key)
+	if err != nil {
+		return err
+	}
+	found := false
+	if exists {
+		if found, err = o.streamGroupExists(ctx, q, key, group); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return noGroupError(key, group)
+	}
+	return nil
+}
+
+func (o queryOps) xPendingSummary(ctx context.Context, q Querier, key, group string) (StreamPendingSummary, error) {
+	var summary StreamPendingSummary
+	if err := o.requireStreamGroup(ctx, q, key, group); err != nil {
+		return summary, err
+	}
+
+	rows, err := q.Query(ctx,
+		`SELECT consumer, COUNT(*) FROM kv_stream_pending
+		 WHERE key_id = postkeys_key_id($3, $1) AND group_name = $2
+		 GROUP BY consumer ORDER BY consumer`,
+		key, group, o.db,
+	)
+	if err != nil {
+		return summary, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c StreamConsumerPending
+		if err := rows.Scan(&c.Name, &c.Pending); err != nil {
+			return summary, err
+		}
+		summary.Consumers = append(summary.Consumers, c)
+		summary.Count += c.Pending
+	}
+	if err := rows.Err(); err != nil {
+		return summary, err
+	}
+	if summary.Count == 0 {
+		return summary, nil
+	}
+
+	for _, order := range []string{"ASC", "DESC"} {
+		var ms, seq int64
+		err := q.QueryRow(ctx,
+			fmt.Sprintf(`SELECT ms, seq FROM kv_stream_pending
+			 WHERE key_id = postkeys_key_id($3, $1) AND group_name = $2
+			 ORDER BY ms %s, seq %s LIMIT 1`, order, order),
+			key, group, o.db,
+		).Scan(&ms, &seq)
+		if err != nil {
+			return summary, err
+		}
+		if order == "ASC" {
+			summary.Lowest = StreamID{Ms: uint64(ms), Seq: uint64(seq)}
+		} else {
+			summary.Highest = StreamID{Ms: uint64(ms), Seq: uint64(seq)}
+		}
+	}
+	return summary, nil
+}
+
+func (o queryOps) xPending(ctx context.Context, q Querier, key, group string, start, end StreamID, count int64, consumer string, minIdle int64) ([]StreamPendingEntry, error) {
+	if err := o.requireStreamGroup(ctx, q, key, group); err != nil {
+		return nil, err
+	}
+	if end.Less(start) || count <= 0 {
+		return nil, nil
+	}
+
+	rows, err := q.Query(ctx,
+		`SELECT ms, seq, consumer, (EXTRACT(EPOCH FROM NOW() - delivered_at) * 1000)::BIGINT, delivery_count
+		 FROM kv_stream_pending
+		 WHERE key_id = postkeys_key_id($10, $1) AND group_name = $2 AND (ms, seq) >= ($3, $4) AND (ms, seq) <= ($5, $6)
+		   AND ($7::text = '' OR consumer = $7)
+		   AND delivered_at <= NOW() - $8::bigint * INTERVAL '1 millisecond'
+		 ORDER BY ms, seq
+		 LIMIT $9`,
+		key, group, int64(start.Ms), int64(start.Seq), int64(end.Ms), int64(end.Seq), consumer, minIdle, count, o.db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []StreamPendingEntry
+	for rows.Next() {
+		var ms, seq int64
+		var p StreamPendingEntry
+		if err := rows.Scan(&ms, &seq, &p.Consumer, &p.Idle, &p.DeliveryCount); err != nil {
+			return nil, err
+		}
+		p.ID = StreamID{Ms: uint64(ms), Seq: uint64(seq)}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+func (o queryOps) xClaim(ctx context.Context, q Querier, key, group, consumer string, minIdle int64, ids []StreamID, opts StreamClaimOptions) ([]StreamEntry, error) {
+	if err := o.requireStreamGroup(ctx, q, key, group); err != nil {
+		return nil, err
+	}
+
+	if opts.LastID != nil {
+		_, err := q.Exec(ctx,
+			`UPDATE kv_stream_groups SET last_ms = $3, last_seq = $4
+			 WHERE key_id = postkeys_key_id($5, $1) AND group_name = $2 AND (last_ms, last_seq) < ($3, $4)`,
+			key, group, int64(opts.LastID.Ms), int64(opts.LastID.Seq), o.db,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	msList, seqList := streamIDArrays(ids)
+	forced := make(map[StreamID]bool)
+	if opts.Force {
+		// Forced entries start at one delivery once claimed, like Redis
+		rows, err := q.Query(ctx,
+			`INSERT INTO kv_stream_pending (key_id, group_name, ms, seq, consumer, delivery_count)
+			 SELECT key_id, $2, ms, seq, $3, CASE WHEN $6::boolean THEN 1 ELSE 0 END FROM kv_streams
+			 WHERE key_id = postkeys_key_id($7, $1) AND (ms, seq) IN (SELECT unnest($4::bigint[]), unnest($5::bigint[]))
+			 ON CONFLICT (key_id, group_name, ms, seq) DO NOTHING
+			 RETURNING ms, seq`,
+			key, group, consumer, msList, seqList, opts.JustID, o.db,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var ms, seq int64
+			if err := rows.Scan(&ms, &seq); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			forced[StreamID{Ms: uint64(ms), Seq: uint64(seq)}] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// SKIP LOCKED leaves entries being claimed by another client to that client
+	rows, err := q.Query(ctx,
+		`SELECT p.ms, p.seq, (EXTRACT(EPOCH FROM NOW() - p.delivered_at) * 1000)::BIGINT, s.fields
+		 FROM kv_stream_pending p
+		 LEFT JOIN kv_streams s ON s.key_id = p.key_id AND s.ms = p.ms AND s.seq = p.seq
+		 WHERE p.key_id = postkeys_key_id($5, $1) AND p.group_name = $2 AND (p.ms, p.seq) IN (SELECT unnest($3::bigint[]), unnest($4::bigint[]))
+		 FOR UPDATE OF p SKIP LOCKED`,
+		key, group, msList, seqList, o.db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[StreamID]StreamEntry)
+	idle := make(map[StreamID]int64)
+	for rows.Next() {
+		var ms, seq, idleMs int64
+		var fields [][]byte
+		if err := rows.Scan(&ms, &seq, &idleMs, &fields); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entry := StreamEntry{ID: StreamID{Ms: uint64(ms), Seq: uint64(seq)}, Fields: decodeStreamFields(fields)}
+		pending[entry.ID] = entry
+		idle[entry.ID] = idleMs
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []StreamEntry
+	var claimedIDs, deletedIDs []StreamID
+	for _, id := range ids {
+		entry, ok := pending[id]
+		if !ok {
+			continue
+		}
+		delete(pending, id) // IDs may be repeated
+		if entry.Fields == nil {
+			deletedIDs = append(deletedIDs, id)
+			continue
+		}
+		if !forced[id] && idle[id] < minIdle {
+			continue
+		}
+		claimed = append(claimed, entry)
+		claimedIDs = append(claimedIDs, id)
+	}
+
+	if err := o.deletePendingEntries(ctx, q, key, group, deletedIDs); err != nil {
+		return nil, err
+	}
+	if len(claimedIDs) == 0 {
+		return nil, nil
+	}
+
+	msList, seqList = streamIDArrays(claimedIDs)
+	_, err = q.Exec(ctx,
+		`UPDATE kv_stream_pending SET consumer = $3,
+		   delivered_at = COALESCE(to_timestamp($6::bigint / 1000.0), NOW() - COALESCE($7::bigint, 0) * INTERVAL '1 millisecond'),
+		   delivery_count = CASE WHEN $8::bigint IS NOT NULL THEN $8::bigint
+		                         WHEN $9::boolean THEN delivery_count
+		                         ELSE delivery_count + 1 END
+		 WHERE key_id = postkeys_key_id($10, $1) AND group_name = $2 AND (ms, seq) IN (SELECT unnest($4::bigint[]), unnest($5::bigint[]))`,
+		key, group, consumer, msList, seqList, opts.Time, opts.Idle, opts.RetryCount, opts.JustID, o.db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.touchStreamConsumer(ctx, q, key, group, consumer, true); err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+func (o queryOps) xAutoClaim(ctx context.Context, q Querier, key, group, consumer string, minIdle int64, start StreamID, count int64, justID bool) (StreamID, []StreamEntry, []StreamID, error) {
+	if err := o.requireStreamGroup(ctx, q, key, group); err != nil {
+		return StreamID{}, nil, nil, err
+	}
+
+	// Fetch one extra entry to find where the next scan should start.
+	// SKIP LOCKED keeps competing clients from claiming the same entries.
+	rows, err := q.Query(ctx,
+		`SELECT p.ms, p.seq, s.fields FROM kv_stream_pending p
+		 LEFT JOIN kv_streams s ON s.key_id = p.key_id AND s.ms = p.ms AND s.seq = p.seq
+		 WHERE p.key_id = postkeys_key_id($7, $1) AND p.group_name = $2 AND (p.ms, p.seq) >= ($3, $4)
+		   AND p.delivered_at <= NOW() - $5::bigint * INTERVAL '1 millisecond'
+		 ORDER BY p.ms, p.seq
+		 LIMIT $6
+		 FOR UPDATE OF p SKIP LOCKED`,
+		key, group, int64(start.Ms), int64(start.Seq), minIdle, count+1, o.db,
+	)
+	if err != nil {
+		return StreamID{}, nil, nil, err
+	}
+	entries, err := scanStreamEntries(rows)
+	if err != nil {
+		return StreamID{}, nil, nil, err
+	}
+
+	var next StreamID
+	if int64(len(entries)) > count {
+		next = entries[count].ID
+		entries = entries[:count]
+	}
+
+	claimed := []StreamEntry{}
+	var claimedIDs, deletedIDs []StreamID
+	for _, entry := range entries {
+		if entry.Fields == nil {
+			deletedIDs = append(deletedIDs, entry.ID)
+			continue
+		}
+		claimed = append(claimed, entry)
+		claimedIDs = append(claimedIDs, entry.ID)
+	}
+
+	if err := o.deletePendingEntries(ctx, q, key, group, deletedIDs); err != nil {
+		return StreamID{}, nil, nil, err
+	}
+	if len(claimedIDs) > 0 {
+		msList, seqList := streamIDArrays(claimedIDs)
+		_, err = q.Exec(ctx,
+			`UPDATE kv_stream_pending SET consumer = $3, delivered_at = NOW(),
+			   delivery_count = CASE WHEN $6::boolean THEN delivery_count ELSE delivery_count + 1 END
+			 WHERE key_id = postkeys_key_id($7, $1) AND group_name = $2 AND (ms, seq) IN (SELECT unnest($4::bigint[]), unnest($5::bigint[]))`,
+			key, group, consumer, msList, seqList, justID, o.db,
+		)
+		if err != nil {
+			return StreamID{}, nil, nil, err
+		}
+	}
+	if err := o.touchStreamConsumer(ctx, q, key, group, consumer, len(claimedIDs) > 0); err != nil {
+		return StreamID{}, nil, nil, err
+	}
+	return next, claimed, deletedIDs, nil
+}
+
+// deletePendingEntries drops PEL entries whose stream entries no longer exist
+func (o queryOps) deletePendingEntries(ctx context.Context, q Querier, key, group string, ids []StreamID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	msList, seqList := streamIDArrays(ids)
+	_, err := q.Exec(ctx,
+		`DELETE FROM kv_stream_pending
+		 WHERE key_id = postkeys_key_id($5, $1) AND group_name = $2 AND (ms, seq) IN (SELECT unnest($3::bigint[]), unnest($4::bigint[]))`,
+		key, group, msList, seqList, o.db,
+	)
+	return err
+}
+
+// ============== Stream Introspection Commands ==============
+
+func (o queryOps) xInfoStream(ctx context.Context, q Querier, key string) (StreamInfo, error) {
+	var info StreamInfo
+	exists, err := o.streamKeyExists(ctx, q, key)
+	if err != nil {
+		return info, err
+	}
+	if !exists {
+		return info, fmt.Errorf("ERR no such key")
+	}
+
+	meta, err := o.loadStreamMeta(ctx, q, key)
+	if err != nil {
+		return info, err
+	}
+	info.LastGeneratedID = meta.last
+	info.MaxDeletedEntryID = meta.maxDeleted
+	info.EntriesAdded = meta.entriesAdded
+
+	err = q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_streams WHERE key_id = postkeys_key_id($2, $1)", key, o.db).Scan(&info.Length)
+	if err != nil {
+		return info, err
+	}
+	err = q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_stream_groups WHERE key_id = postkeys_key_id($2, $1)", key, o.db).Scan(&info.Groups)
+	if err != nil {
+		return info, err
+	}
+
+	first, err := o.streamRange(ctx, q, key, StreamID{}, MaxStreamID, 1, false)
+	if err != nil {
+		return info, err
+	}
+	last, err := o.streamRange(ctx, q, key, StreamID{}, MaxStreamID, 1, true)
+	if err != nil {
+		return info, err
+	}
+	if len(first) > 0 {
+		info.FirstEntry = &first[0]
+		info.RecordedFirstEntryID = first[0].ID
+	}
+	if len(last) > 0 {
+		info.LastEntry = &last[0]
+	}
+	return info, nil
+}
+
+func (o queryOps) xInfoGroups(ctx context.Context, q Querier, key string) ([]StreamGroupInfo, error) {
+	exists, err := o.streamKeyExists(ctx, q, key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("ERR no such key")
+	}
+
+	rows, err := q.Query(ctx,
+		`SELECT g.group_name, g.last_ms, g.last_seq, g.entries_read,
+		   (SELECT COUNT(*) FROM kv_stream_consumers c WHERE c.key_id = g.key_id AND c.group_name = g.group_name),
+		   (SELECT COUNT(*) FROM kv_stream_pending p WHERE p.key_id = g.key_id AND p.group_name = g.group_name)
+		 FROM kv_stream_groups g
+		 WHERE g.key_id = postkeys_key_id($2, $1)
+		 ORDER BY g.group_name`,
+		key, o.db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var groups []StreamGroupInfo
+	for rows.Next() {
+		var g StreamGroupInfo
+		var ms, seq int64
+		if err := rows.Scan(&g.Name, &ms, &seq, &g.EntriesRead, &g.Consumers, &g.Pending); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		g.LastDeliveredID = StreamID{Ms: uint64(ms), Seq: uint64(seq)}
+		groups = append(groups, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	meta, err := o.loadStreamMeta(ctx, q, key)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		if groups[i].Lag, err = o.streamGroupLag(ctx, q, key, meta, groups[i]); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// streamGroupLag returns the number of entries not yet delivered to a group,
+// or nil when deleted entries after its last delivered ID make it unknown
+func (o queryOps) streamGroupLag(ctx context.Context, q Querier, key string, meta streamMeta, g StreamGroupInfo) (*int64, error) {
+	var lag int64
+	if meta.entriesAdded == 0 || !g.LastDeliveredID.Less(meta.last) {
+		return &lag, nil
+	}
+	if g.LastDeliveredID.Less(meta.maxDeleted) {
+		return nil, nil
+	}
+	if g.EntriesRead != nil {
+		lag = meta.entriesAdded - *g.EntriesRead
+		return &lag, nil
+	}
+	err := q.QueryRow(ctx,
+		"SELECT COUNT(*) FROM kv_streams WHERE key_id = postkeys_key_id($4, $1) AND (ms, seq) > ($2, $3)",
+		key, int64(g.LastDeliveredID.Ms), int64(g.LastDeliveredID.Seq), o.db,
+	).Scan(&lag)
+	if err != nil {
+		return nil, err
+	}
+	return &lag, nil
+}
+
+func (o queryOps) xInfoConsumers(ctx context.Context, q Querier, key, group string) ([]StreamConsumerInfo, error) {
+	exists, err := o.streamKeyExists(ctx, q, key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("ERR no such key")
+	}
+	found, err := o.streamGroupExists(ctx, q, key, group)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, noConsumerGroupError(key, group)
+	}
+
+	rows, err := q.Query(ctx,
+		`SELECT c.consumer,
+		   (SELECT COUNT(*) FROM kv_stream_pending p
+		    WHERE p.key_id = c.key_id AND p.group_name = c.group_name AND p.consumer = c.consumer),
+		   (EXTRACT(EPOCH FROM NOW() - c.seen_time) * 1000)::BIGINT,
+		   COALESCE((EXTRACT(EPOCH FROM NOW() - c.active_time) * 1000)::BIGINT, -1)
+		 FROM kv_stream_consumers c
+		 WHERE c.key_id = postkeys_key_id($3, $1) AND c.group_name = $2
+		 ORDER BY c.consumer`,
+		key, group, o.db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var consumers []StreamConsumerInfo
+	for rows.Next() {
+		var c StreamConsumerInfo
+		if err := rows.Scan(&c.Name, &c.Pending, &c.Idle, &c.Inactive); err != nil {
+			return nil, err
+		}
+		consumers = append(consumers, c)
+	}
+	return consumers, rows.Err()
+}
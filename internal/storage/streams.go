@@ -0,0 +1,409 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// streamNodeMaxEntries mirrors Redis' stream-node-max-entries. Approximate ("~")
+// trimming only evicts whole nodes, so it never removes fewer than this many entries.
+const streamNodeMaxEntries = 100
+
+// MaxStreamID is the largest ID that can be stored (IDs are kept in BIGINT columns)
+var MaxStreamID = StreamID{Ms: math.MaxInt64, Seq: math.MaxInt64}
+
+var errInvalidStreamID = fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
+
+// String formats the ID as <ms>-<seq>
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less reports whether id sorts before other
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// ParseStreamID parses an ID in the form <ms>-<seq> or <ms>.
+// When the sequence part is missing, defaultSeq is used.
+func ParseStreamID(s string, defaultSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 63)
+	if err != nil {
+		return StreamID{}, errInvalidStreamID
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: defaultSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 63)
+	if err != nil {
+		return StreamID{}, errInvalidStreamID
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// nextStreamID resolves an XADD ID argument ("*", "<ms>-*" or an explicit ID)
+// against the last ID generated for the stream.
+func nextStreamID(spec string, last StreamID) (StreamID, error) {
+	if spec == "*" {
+		ms := uint64(time.Now().UnixMilli())
+		if ms > last.Ms {
+			return StreamID{Ms: ms}, nil
+		}
+		if last.Seq == MaxStreamID.Seq {
+			if last.Ms == MaxStreamID.Ms {
+				return StreamID{}, fmt.Errorf("ERR The stream has exhausted the last possible ID, unable to add more items")
+			}
+			return StreamID{Ms: last.Ms + 1}, nil
+		}
+		return StreamID{Ms: last.Ms, Seq: last.Seq + 1}, nil
+	}
+
+	if msPart, ok := strings.CutSuffix(spec, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 63)
+		if err != nil {
+			return StreamID{}, errInvalidStreamID
+		}
+		switch {
+		case ms > last.Ms:
+			return StreamID{Ms: ms}, nil
+		case ms == last.Ms && last.Seq < MaxStreamID.Seq:
+			return StreamID{Ms: ms, Seq: last.Seq + 1}, nil
+		default:
+			return StreamID{}, fmt.Errorf("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+	}
+
+	id, err := ParseStreamID(spec, 0)
+	if err != nil {
+		return StreamID{}, err
+	}
+	if id == (StreamID{}) {
+		return StreamID{}, fmt.Errorf("ERR The ID specified in XADD must be greater than 0-0")
+	}
+	if !last.Less(id) {
+		return StreamID{}, fmt.Errorf("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	return id, nil
+}
+
+// streamIDArrays splits IDs into parallel ms and seq arrays for unnest()
+func streamIDArrays(ids []StreamID) ([]int64, []int64) {
+	msList := make([]int64, len(ids))
+	seqList := make([]int64, len(ids))
+	for i, id := range ids {
+		msList[i] = int64(id.Ms)
+		seqList[i] = int64(id.Seq)
+	}
+	return msList, seqList
+}
+
+// decodeStreamFields converts a fields column to strings. A NULL column, as produced
+// by joining pending entries that no longer exist in the stream, stays nil.
+func decodeStreamFields(fields [][]byte) []string {
+	if fields == nil {
+		return nil
+	}
+	result := make([]string, len(fields))
+	for i, f := range fields {
+		result[i] = string(f)
+	}
+	return result
+}
+
+// scanStreamEntries reads (ms, seq, fields) rows into stream entries
+func scanStreamEntries(rows pgx.Rows) ([]StreamEntry, error) {
+	defer rows.Close()
+
+	var entries []StreamEntry
+	for rows.Next() {
+		var ms, seq int64
+		var fields [][]byte
+		if err := rows.Scan(&ms, &seq, &fields); err != nil {
+			return nil, err
+		}
+		entries = append(entries, StreamEntry{
+			ID:     StreamID{Ms: uint64(ms), Seq: uint64(seq)},
+			Fields: decodeStreamFields(fields),
+		})
+	}
+	return entries, rows.Err()
+}
+
+// ============== Stream Commands ==============
+
+func (o queryOps) xAdd(ctx context.Context, q Querier, key, id string, fields []string, noMkStream bool, trim StreamTrim) (string, bool, error) {
+	keyType, err := o.getKeyType(ctx, q, key)
+	if err != nil {
+		return "", false, err
+	}
+	if keyType != TypeNone && keyType != TypeStream {
+		return "", false, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if keyType == TypeNone && noMkStream {
+		return "", false, nil
+	}
+
+	// The key's row serialises concurrent XADDs on the same key. An expired
+	// key is replaced, so the new stream starts from 0-0.
+	streamID, err := o.keyID(ctx, q, key, TypeStream)
+	if err != nil {
+		return "", false, err
+	}
+	_, err = q.Exec(ctx, "INSERT INTO kv_stream_meta (key_id) VALUES ($1) ON CONFLICT (key_id) DO NOTHING", streamID)
+	if err != nil {
+		return "", false, err
+	}
+	var lastMs, lastSeq int64
+	err = q.QueryRow(ctx,
+		"SELECT last_ms, last_seq FROM kv_stream_meta WHERE key_id = $1",
+		streamID,
+	).Scan(&lastMs, &lastSeq)
+	if err != nil {
+		return "", false, err
+	}
+
+	newID, err := nextStreamID(id, StreamID{Ms: uint64(lastMs), Seq: uint64(lastSeq)})
+	if err != nil {
+		return "", false, err
+	}
+
+	fieldBytes := make([][]byte, len(fields))
+	for i, f := range fields {
+		fieldBytes[i] = []byte(f)
+	}
+	_, err = q.Exec(ctx,
+		"INSERT INTO kv_streams (key_id, ms, seq, fields) VALUES ($1, $2, $3, $4)",
+		streamID, int64(newID.Ms), int64(newID.Seq), fieldBytes,
+	)
+	if err != nil {
+		return "", false, err
+	}
+
+	_, err = q.Exec(ctx,
+		`UPDATE kv_stream_meta SET last_ms = $2, last_seq = $3, entries_added = entries_added + 1
+		 WHERE key_id = $1`,
+		streamID, int64(newID.Ms), int64(newID.Seq),
+	)
+	if err != nil {
+		return "", false, err
+	}
+
+	o.notify(ctx, q, notifyStream, "xadd", key)
+	trimmed, err := o.trimStream(ctx, q, key, trim)
+	if err != nil {
+		return "", false, err
+	}
+	if trimmed > 0 {
+		o.notify(ctx, q, notifyStream, "xtrim", key)
+	}
+
+	return newID.String(), true, nil
+}
+
+func (o queryOps) xRange(ctx context.Context, q Querier, key string, start, end StreamID, count int64) ([]StreamEntry, error) {
+	return o.streamRange(ctx, q, key, start, end, count, false)
+}
+
+func (o queryOps) xRevRange(ctx context.Context, q Querier, key string, end, start StreamID, count int64) ([]StreamEntry, error) {
+	return o.streamRange(ctx, q, key, start, end, count, true)
+}
+
+// streamRange returns entries with start <= ID <= end, newest first when reverse is set.
+// A negative count means no limit.
+func (o queryOps) streamRange(ctx context.Context, q Querier, key string, start, end StreamID, count int64, reverse bool) ([]StreamEntry, error) {
+	keyType, err := o.getKeyType(ctx, q, key)
+	if err != nil {
+		return nil, err
+	}
+	if keyType == TypeNone {
+		return nil, nil
+	}
+	if keyType != TypeStream {
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if end.Less(start) || count == 0 {
+		return nil, nil
+	}
+
+	order := "ASC"
+	if reverse {
+		order = "DESC"
+	}
+	var limit *int64
+	if count > 0 {
+		limit = &count
+	}
+
+	rows, err := q.Query(ctx,
+		fmt.Sprintf(`SELECT ms, seq, fields FROM kv_streams
+		 WHERE key_id = postkeys_key_id($7, $1) AND (ms, seq) >= ($2, $3) AND (ms, seq) <= ($4, $5)
+		 ORDER BY ms %s, seq %s
+		 LIMIT $6`, order, order),
+		key, int64(start.Ms), int64(start.Seq), int64(end.Ms), int64(end.Seq), limit, o.db,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanStreamEntries(rows)
+}
+
+func (o queryOps) xLen(ctx context.Context, q Querier, key string) (int64, error) {
+	keyType, err := o.getKeyType(ctx, q, key)
+	if err != nil {
+		return 0, err
+	}
+	if keyType == TypeNone {
+		return 0, nil
+	}
+	if keyType != TypeStream {
+		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	var length int64
+	err = q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_streams WHERE key_id = postkeys_key_id($2, $1)", key, o.db).Scan(&length)
+	return length, err
+}
+
+func (o queryOps) xDel(ctx context.Context, q Querier, key string, ids []StreamID) (int64, error) {
+	keyType, err := o.getKeyType(ctx, q, key)
+	if err != nil {
+		return 0, err
+	}
+	if keyType == TypeNone {
+		return 0, nil
+	}
+	if keyType != TypeStream {
+		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	msList, seqList := streamIDArrays(ids)
+	rows, err := q.Query(ctx,
+		`DELETE FROM kv_streams
+		 WHERE key_id = postkeys_key_id($4, $1) AND (ms, seq) IN (SELECT unnest($2::bigint[]), unnest($3::bigint[]))
+		 RETURNING ms, seq`,
+		key, msList, seqList, o.db,
+	)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := o.recordStreamDeletes(ctx, q, key, rows)
+	if err != nil {
+		return 0, err
+	}
+	if deleted > 0 {
+		o.notify(ctx, q, notifyStream, "xdel", key)
+	}
+	return deleted, nil
+}
+
+func (o queryOps) xTrim(ctx context.Context, q Querier, key string, trim StreamTrim) (int64, error) {
+	keyType, err := o.getKeyType(ctx, q, key)
+	if err != nil {
+		return 0, err
+	}
+	if keyType == TypeNone {
+		return 0, nil
+	}
+	if keyType != TypeStream {
+		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	trimmed, err := o.trimStream(ctx, q, key, trim)
+	if err != nil {
+		return 0, err
+	}
+	if trimmed > 0 {
+		o.notify(ctx, q, notifyStream, "xtrim", key)
+	}
+	return trimmed, nil
+}
+
+// trimStream evicts the oldest entries according to a MAXLEN or MINID strategy
+func (o queryOps) trimStream(ctx context.Context, q Querier, key string, trim StreamTrim) (int64, error) {
+	var candidates int64
+	switch trim.Strategy {
+	case "MAXLEN":
+		var length int64
+		if err := q.QueryRow(ctx, "SELECT COUNT(*) FROM kv_streams WHERE key_id = postkeys_key_id($2, $1)", key, o.db).Scan(&length); err != nil {
+			return 0, err
+		}
+		candidates = length - trim.MaxLen
+	case "MINID":
+		err := q.QueryRow(ctx,
+			"SELECT COUNT(*) FROM kv_streams WHERE key_id = postkeys_key_id($4, $1) AND (ms, seq) < ($2, $3)",
+			key, int64(trim.MinID.Ms), int64(trim.MinID.Seq), o.db,
+		).Scan(&candidates)
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, nil
+	}
+
+	if trim.Approx {
+		limit := trim.Limit
+		if limit == 0 {
+			limit = 100 * streamNodeMaxEntries
+		}
+		if limit > 0 && candidates > limit {
+			candidates = limit
+		}
+		candidates -= candidates % streamNodeMaxEntries
+	}
+	if candidates <= 0 {
+		return 0, nil
+	}
+
+	rows, err := q.Query(ctx,
+		`DELETE FROM kv_streams WHERE ctid IN (
+			SELECT ctid FROM kv_streams
+			WHERE key_id = postkeys_key_id($3, $1)
+			ORDER BY ms, seq
+			LIMIT $2
+		) RETURNING ms, seq`,
+		key, candidates, o.db,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return o.recordStreamDeletes(ctx, q, key, rows)
+}
+
+// recordStreamDeletes consumes the (ms, seq) rows returned by a DELETE and
+// advances the stream's max-deleted-entry-id. It returns the number of deleted entries.
+func (o queryOps) recordStreamDeletes(ctx context.Context, q Querier, key string, rows pgx.Rows) (int64, error) {
+	var deleted int64
+	var maxDeleted StreamID
+	for rows.Next() {
+		var ms, seq int64
+		if err := rows.Scan(&ms, &seq); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		id := StreamID{Ms: uint64(ms), Seq: uint64(seq)}
+		if maxDeleted.Less(id) {
+			maxDeleted = id
+		}
+		deleted++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	_, err := q.Exec(ctx,
+		`UPDATE kv_stream_meta SET max_deleted_ms = $2, max_deleted_seq = $3
+		 WHERE key_id = postkeys_key_id($4, $1) AND (max_deleted_ms, max_deleted_seq) < ($2, $3)`,
+		key, int64(maxDeleted.Ms), int64(maxDeleted.Seq), o.db,
+	)
+	return deleted, err
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mnorrsken/postkeys/internal/metrics"
+)
+
+// sweeperLockID is the advisory lock held by the pod that runs the expiry
+// sweeper ("pkSweep" in ASCII)
+const sweeperLockID int64 = 0x706b5377656570
+
+const (
+	// sweepBatchSize is the most keys deleted by one sweep transaction
+	sweepBatchSize = 500
+
+	// Sweeps run every sweepInterval while they find expired keys, right
+	// away (after sweepMinInterval) while a full batch is waiting, and back off
+	// to sweepMaxInterval while there is nothing to delete
+	sweepMinInterval = 50 * time.Millisecond
+	sweepInterval    = 1 * time.Second
+	sweepMaxInterval = 5 * time.Second
+
+	// sweepLeaderRetry is how often pods that aren't running the sweeper
+	// try to take over
+	sweepLeaderRetry = 10 * time.Second
+)
+
+// runSweeper deletes expired keys until ctx is cancelled. Only one pod per
+// PostgreSQL database and schema sweeps at a time: the one holding the sweeper advisory
+// lock on its dedicated connection. The others retry periodically, so another
+// pod takes over when the leader's connection goes away.
+func (s *Store) runSweeper(ctx context.Context) {
+	defer close(s.sweeperDone)
+
+	for {
+		if conn, err := pgx.ConnectConfig(ctx, s.connConfig); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Expiry sweeper failed to connect: %v", err)
+			}
+		} else {
+			var leader bool
+			err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", advisoryLockID(sweeperLockID, s.namespace)).Scan(&leader)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Expiry sweeper failed to take the sweeper lock: %v", err)
+			}
+			if leader {
+				metrics.ExpirySweeperLeader.Set(1)
+				s.sweepWhileLeader(ctx, conn)
+				metrics.ExpirySweeperLeader.Set(0)
+			}
+			// Closing the connection releases the lock
+			conn.Close(context.Background())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sweepLeaderRetry):
+		}
+	}
+}
+
+// sweepWhileLeader sweeps at an adaptive cadence until ctx is cancelled or
+// the connection holding the sweeper lock fails
+func (s *Store) sweepWhileLeader(ctx context.Context, lockConn *pgx.Conn) {
+	interval := sweepInterval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		if err := lockConn.Ping(ctx); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Expiry sweeper lost its lock connection: %v", err)
+			}
+			return
+		}
+
+		swept, err := s.sweepExpiredKeys(ctx)
+		switch {
+		case err != nil:
+			if ctx.Err() == nil {
+				log.Printf("Expiry sweep failed: %v", err)
+			}
+			interval = sweepInterval
+		case swept == sweepBatchSize:
+			interval = sweepMinInterval
+		case swept > 0:
+			interval = sweepInterval
+		default:
+			interval = min(interval*2, sweepMaxInterval)
+		}
+	}
+}
+
+// sweepExpiredKeys deletes one batch of expired keys and returns how many
+// keys it deleted. kv_keys holds the expiry of every key and deleting its
+// rows deletes the keys' data, so the batch is picked and deleted there,
+// skipping keys that a command is writing right now.
+func (s *Store) sweepExpiredKeys(ctx context.Context) (int, error) {
+	start := time.Now()
+	var swept int
+	err := s.withTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx,
+			`DELETE FROM kv_keys WHERE id IN (
+				SELECT id FROM kv_keys
+				WHERE expires_at IS NOT NULL AND expires_at <= NOW()
+				ORDER BY expires_at LIMIT $1 FOR UPDATE SKIP LOCKED
+			 )
+			 RETURNING db, key`,
+			sweepBatchSize,
+		)
+		if err != nil {
+			return err
+		}
+		var count int
+		expired := make(map[int][]string)
+		for rows.Next() {
+			var db int32
+			var key string
+			if err := rows.Scan(&db, &key); err != nil {
+				rows.Close()
+				return err
+			}
+			expired[int(db)] = append(expired[int(db)], key)
+			count++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		ops := queryOps{events: s.events}
+		for db, dbKeys := range expired {
+			ops.notifyDB(ctx, tx, db, notifyExpired, "expired", dbKeys...)
+		}
+		swept = count
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	metrics.ExpiredKeys.Add(float64(swept))
+	metrics.ExpirySweepDuration.Observe(time.Since(start).Seconds())
+	return swept, nil
+}
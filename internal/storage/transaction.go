@@ -12,9 +12,9 @@ import (
 // TxStore wraps a PostgreSQL transaction and implements the Transaction interface
 type TxStore struct {
 	tx            pgx.Tx
-	ops           queryOps
 	done          bool
 	sqlTraceLevel int // 0=off, 1=important, 2=most queries, 3=everything
+	events        *keyspaceEvents
 }
 
 // querier returns a Querier for the transaction, optionally wrapped with tracing
@@ -43,378 +43,565 @@ func (t *TxStore) Rollback(ctx context.Context) error {
 	return t.tx.Rollback(ctx)
 }
 
+// Savepoint starts a savepoint for the next command
+func (t *TxStore) Savepoint(ctx context.Context) error {
+	_, err := t.querier().Exec(ctx, "SAVEPOINT postkeys_cmd")
+	return err
+}
+
+// ReleaseSavepoint keeps the changes made since Savepoint
+func (t *TxStore) ReleaseSavepoint(ctx context.Context) error {
+	_, err := t.querier().Exec(ctx, "RELEASE SAVEPOINT postkeys_cmd")
+	return err
+}
+
+// RollbackToSavepoint undoes the changes made since Savepoint and releases it
+func (t *TxStore) RollbackToSavepoint(ctx context.Context) error {
+	if _, err := t.querier().Exec(ctx, "ROLLBACK TO SAVEPOINT postkeys_cmd"); err != nil {
+		return err
+	}
+	return t.ReleaseSavepoint(ctx)
+}
+
 // ============== String Commands ==============
 
 func (t *TxStore) Get(ctx context.Context, key string) (string, bool, error) {
-	return t.ops.get(ctx, t.querier(), key)
+	return t.ops(ctx).get(ctx, t.querier(), key)
 }
 
 func (t *TxStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
-	return t.ops.set(ctx, t.querier(), key, value, ttl)
+	return t.ops(ctx).set(ctx, t.querier(), key, value, ttl)
 }
 
 func (t *TxStore) SetNX(ctx context.Context, key, value string) (bool, error) {
-	return t.ops.setNX(ctx, t.querier(), key, value)
+	return t.ops(ctx).setNX(ctx, t.querier(), key, value)
 }
 
 func (t *TxStore) MGet(ctx context.Context, keys []string) ([]interface{}, error) {
-	return t.ops.mGet(ctx, t.querier(), keys)
+	return t.ops(ctx).mGet(ctx, t.querier(), keys)
 }
 
 func (t *TxStore) MSet(ctx context.Context, pairs map[string]string) error {
-	return t.ops.mSet(ctx, t.querier(), pairs)
+	return t.ops(ctx).mSet(ctx, t.querier(), pairs)
 }
 
 func (t *TxStore) Incr(ctx context.Context, key string, delta int64) (int64, error) {
-	return t.ops.incr(ctx, t.querier(), key, delta)
+	return t.ops(ctx).incr(ctx, t.querier(), key, delta)
 }
 
 func (t *TxStore) Append(ctx context.Context, key, value string) (int64, error) {
-	return t.ops.appendStr(ctx, t.querier(), key, value)
+	return t.ops(ctx).appendStr(ctx, t.querier(), key, value)
 }
 
 func (t *TxStore) GetRange(ctx context.Context, key string, start, end int64) (string, error) {
-	return t.ops.getRange(ctx, t.querier(), key, start, end)
+	return t.ops(ctx).getRange(ctx, t.querier(), key, start, end)
 }
 
 func (t *TxStore) SetRange(ctx context.Context, key string, offset int64, value string) (int64, error) {
-	return t.ops.setRange(ctx, t.querier(), key, offset, value)
+	return t.ops(ctx).setRange(ctx, t.querier(), key, offset, value)
 }
 
 func (t *TxStore) BitField(ctx context.Context, key string, ops []BitFieldOp) ([]int64, error) {
-	return t.ops.bitField(ctx, t.querier(), key, ops)
+	return t.ops(ctx).bitField(ctx, t.querier(), key, ops)
 }
 
 func (t *TxStore) StrLen(ctx context.Context, key string) (int64, error) {
-	return t.ops.strLen(ctx, t.querier(), key)
+	return t.ops(ctx).strLen(ctx, t.querier(), key)
 }
 
 func (t *TxStore) GetEx(ctx context.Context, key string, ttl time.Duration, persist bool) (string, bool, error) {
-	return t.ops.getEx(ctx, t.querier(), key, ttl, persist)
+	return t.ops(ctx).getEx(ctx, t.querier(), key, ttl, persist)
 }
 
 func (t *TxStore) GetDel(ctx context.Context, key string) (string, bool, error) {
-	return t.ops.getDel(ctx, t.querier(), key)
+	return t.ops(ctx).getDel(ctx, t.querier(), key)
 }
 
 func (t *TxStore) GetSet(ctx context.Context, key, value string) (string, bool, error) {
-	return t.ops.getSet(ctx, t.querier(), key, value)
+	return t.ops(ctx).getSet(ctx, t.querier(), key, value)
 }
 
 func (t *TxStore) IncrByFloat(ctx context.Context, key string, delta float64) (float64, error) {
-	return t.ops.incrByFloat(ctx, t.querier(), key, delta)
+	return t.ops(ctx).incrByFloat(ctx, t.querier(), key, delta)
 }
 
 // ============== Key Commands ==============
 
 func (t *TxStore) Del(ctx context.Context, keys []string) (int64, error) {
-	return t.ops.del(ctx, t.querier(), keys)
+	return t.ops(ctx).del(ctx, t.querier(), keys)
 }
 
 func (t *TxStore) Exists(ctx context.Context, keys []string) (int64, error) {
-	return t.ops.exists(ctx, t.querier(), keys)
+	return t.ops(ctx).exists(ctx, t.querier(), keys)
 }
 
 func (t *TxStore) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
-	return t.ops.expire(ctx, t.querier(), key, ttl)
+	return t.ops(ctx).expire(ctx, t.querier(), key, ttl)
 }
 
 func (t *TxStore) TTL(ctx context.Context, key string) (int64, error) {
-	return t.ops.ttl(ctx, t.querier(), key)
+	return t.ops(ctx).ttl(ctx, t.querier(), key)
 }
 
 func (t *TxStore) PTTL(ctx context.Context, key string) (int64, error) {
-	return t.ops.pttl(ctx, t.querier(), key)
+	return t.ops(ctx).pttl(ctx, t.querier(), key)
 }
 
 func (t *TxStore) Persist(ctx context.Context, key string) (bool, error) {
-	return t.ops.persist(ctx, t.querier(), key)
+	return t.ops(ctx).persist(ctx, t.querier(), key)
 }
 
 func (t *TxStore) Keys(ctx context.Context, pattern string) ([]string, error) {
-	return t.ops.keys(ctx, t.querier(), pattern)
+	return t.ops(ctx).keys(ctx, t.querier(), pattern)
+}
+
+func (t *TxStore) Scan(ctx context.Context, cursor uint64, pattern string, count int64, keyType KeyType) (uint64, []string, error) {
+	return t.ops(ctx).scan(ctx, t.querier(), cursor, pattern, count, keyType)
 }
 
 func (t *TxStore) Type(ctx context.Context, key string) (KeyType, error) {
-	return t.ops.keyType(ctx, t.querier(), key)
+	return t.ops(ctx).keyType(ctx, t.querier(), key)
 }
 
 func (t *TxStore) Rename(ctx context.Context, oldKey, newKey string) error {
-	return t.ops.rename(ctx, t.querier(), oldKey, newKey)
+	return t.ops(ctx).rename(ctx, t.querier(), oldKey, newKey)
 }
 
 func (t *TxStore) ExpireAt(ctx context.Context, key string, timestamp time.Time) (bool, error) {
-	return t.ops.expireAt(ctx, t.querier(), key, timestamp)
+	return t.ops(ctx).expireAt(ctx, t.querier(), key, timestamp)
 }
 
 func (t *TxStore) Copy(ctx context.Context, source, destination string, replace bool) (bool, error) {
-	return t.ops.copyKey(ctx, t.querier(), source, destination, replace)
+	return t.ops(ctx).copyKey(ctx, t.querier(), source, destination, replace)
+}
+
+func (t *TxStore) Move(ctx context.Context, key string, db int) (bool, error) {
+	return t.ops(ctx).move(ctx, t.querier(), key, db)
 }
 
 // ============== Bitmap Commands ==============
 
 func (t *TxStore) SetBit(ctx context.Context, key string, offset int64, value int) (int64, error) {
-	return t.ops.setBit(ctx, t.querier(), key, offset, value)
+	return t.ops(ctx).setBit(ctx, t.querier(), key, offset, value)
 }
 
 func (t *TxStore) GetBit(ctx context.Context, key string, offset int64) (int64, error) {
-	return t.ops.getBit(ctx, t.querier(), key, offset)
+	return t.ops(ctx).getBit(ctx, t.querier(), key, offset)
 }
 
 func (t *TxStore) BitCount(ctx context.Context, key string, start, end int64, useBit bool) (int64, error) {
-	return t.ops.bitCount(ctx, t.querier(), key, start, end, useBit)
+	return t.ops(ctx).bitCount(ctx, t.querier(), key, start, end, useBit)
 }
 
 func (t *TxStore) BitOp(ctx context.Context, operation, destKey string, keys []string) (int64, error) {
-	return t.ops.bitOp(ctx, t.querier(), operation, destKey, keys)
+	return t.ops(ctx).bitOp(ctx, t.querier(), operation, destKey, keys)
 }
 
 func (t *TxStore) BitPos(ctx context.Context, key string, bit int, start, end int64, useBit bool) (int64, error) {
-	return t.ops.bitPos(ctx, t.querier(), key, bit, start, end, useBit)
+	return t.ops(ctx).bitPos(ctx, t.querier(), key, bit, start, end, useBit)
 }
 
 // ============== Hash Commands ==============
 
 func (t *TxStore) HGet(ctx context.Context, key, field string) (string, bool, error) {
-	return t.ops.hGet(ctx, t.querier(), key, field)
+	return t.ops(ctx).hGet(ctx, t.querier(), key, field)
 }
 
 func (t *TxStore) HSet(ctx context.Context, key string, fields map[string]string) (int64, error) {
-	return t.ops.hSet(ctx, t.querier(), key, fields)
+	return t.ops(ctx).hSet(ctx, t.querier(), key, fields)
 }
 
 func (t *TxStore) HDel(ctx context.Context, key string, fields []string) (int64, error) {
-	return t.ops.hDel(ctx, t.querier(), key, fields)
+	return t.ops(ctx).hDel(ctx, t.querier(), key, fields)
 }
 
 func (t *TxStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
-	return t.ops.hGetAll(ctx, t.querier(), key)
+	return t.ops(ctx).hGetAll(ctx, t.querier(), key)
+}
+
+func (t *TxStore) HScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []HashField, error) {
+	return t.ops(ctx).hScan(ctx, t.querier(), key, cursor, pattern, count)
 }
 
 func (t *TxStore) HMGet(ctx context.Context, key string, fields []string) ([]interface{}, error) {
-	return t.ops.hMGet(ctx, t.querier(), key, fields)
+	return t.ops(ctx).hMGet(ctx, t.querier(), key, fields)
 }
 
 func (t *TxStore) HExists(ctx context.Context, key, field string) (bool, error) {
-	return t.ops.hExists(ctx, t.querier(), key, field)
+	return t.ops(ctx).hExists(ctx, t.querier(), key, field)
 }
 
 func (t *TxStore) HKeys(ctx context.Context, key string) ([]string, error) {
-	return t.ops.hKeys(ctx, t.querier(), key)
+	return t.ops(ctx).hKeys(ctx, t.querier(), key)
 }
 
 func (t *TxStore) HVals(ctx context.Context, key string) ([]string, error) {
-	return t.ops.hVals(ctx, t.querier(), key)
+	return t.ops(ctx).hVals(ctx, t.querier(), key)
 }
 
 func (t *TxStore) HLen(ctx context.Context, key string) (int64, error) {
-	return t.ops.hLen(ctx, t.querier(), key)
+	return t.ops(ctx).hLen(ctx, t.querier(), key)
 }
 
 func (t *TxStore) HIncrBy(ctx context.Context, key, field string, increment int64) (int64, error) {
-	return t.ops.hIncrBy(ctx, t.querier(), key, field, increment)
+	return t.ops(ctx).hIncrBy(ctx, t.querier(), key, field, increment)
 }
 
 func (t *TxStore) HIncrByFloat(ctx context.Context, key, field string, increment float64) (float64, error) {
-	return t.ops.hIncrByFloat(ctx, t.querier(), key, field, increment)
+	return t.ops(ctx).hIncrByFloat(ctx, t.querier(), key, field, increment)
 }
 
 func (t *TxStore) HSetNX(ctx context.Context, key, field, value string) (bool, error) {
-	return t.ops.hSetNX(ctx, t.querier(), key, field, value)
+	return t.ops(ctx).hSetNX(ctx, t.querier(), key, field, value)
 }
 
 // ============== List Commands ==============
 
 func (t *TxStore) LPush(ctx context.Context, key string, values []string) (int64, error) {
-	return t.ops.lPush(ctx, t.querier(), key, values)
+	return t.ops(ctx).lPush(ctx, t.querier(), key, values)
 }
 
 func (t *TxStore) RPush(ctx context.Context, key string, values []string) (int64, error) {
-	return t.ops.rPush(ctx, t.querier(), key, values)
+	return t.ops(ctx).rPush(ctx, t.querier(), key, values)
 }
 
 func (t *TxStore) LPop(ctx context.Context, key string) (string, bool, error) {
-	return t.ops.lPop(ctx, t.querier(), key)
+	return t.ops(ctx).lPop(ctx, t.querier(), key)
 }
 
 func (t *TxStore) RPop(ctx context.Context, key string) (string, bool, error) {
-	return t.ops.rPop(ctx, t.querier(), key)
+	return t.ops(ctx).rPop(ctx, t.querier(), key)
 }
 
 func (t *TxStore) LLen(ctx context.Context, key string) (int64, error) {
-	return t.ops.lLen(ctx, t.querier(), key)
+	return t.ops(ctx).lLen(ctx, t.querier(), key)
 }
 
 func (t *TxStore) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
-	return t.ops.lRange(ctx, t.querier(), key, start, stop)
+	return t.ops(ctx).lRange(ctx, t.querier(), key, start, stop)
 }
 
 func (t *TxStore) LIndex(ctx context.Context, key string, index int64) (string, bool, error) {
-	return t.ops.lIndex(ctx, t.querier(), key, index)
+	return t.ops(ctx).lIndex(ctx, t.querier(), key, index)
 }
 
 func (t *TxStore) LRem(ctx context.Context, key string, count int64, element string) (int64, error) {
-	return t.ops.lRem(ctx, t.querier(), key, count, element)
+	return t.ops(ctx).lRem(ctx, t.querier(), key, count, element)
 }
 
 func (t *TxStore) LTrim(ctx context.Context, key string, start, stop int64) error {
-	return t.ops.lTrim(ctx, t.querier(), key, start, stop)
+	return t.ops(ctx).lTrim(ctx, t.querier(), key, start, stop)
 }
 
 func (t *TxStore) RPopLPush(ctx context.Context, source, destination string) (string, bool, error) {
-	return t.ops.rPopLPush(ctx, t.querier(), source, destination)
+	return t.ops(ctx).rPopLPush(ctx, t.querier(), source, destination)
 }
 
 func (t *TxStore) LPos(ctx context.Context, key, element string, rank, count, maxlen int64) ([]int64, error) {
-	return t.ops.lPos(ctx, t.querier(), key, element, rank, count, maxlen)
+	return t.ops(ctx).lPos(ctx, t.querier(), key, element, rank, count, maxlen)
 }
 
 func (t *TxStore) LSet(ctx context.Context, key string, index int64, element string) error {
-	return t.ops.lSet(ctx, t.querier(), key, index, element)
+	return t.ops(ctx).lSet(ctx, t.querier(), key, index, element)
 }
 
 func (t *TxStore) LInsert(ctx context.Context, key, pivot, element string, before bool) (int64, error) {
-	return t.ops.lInsert(ctx, t.querier(), key, pivot, element, before)
+	return t.ops(ctx).lInsert(ctx, t.querier(), key, pivot, element, before)
 }
 
 // ============== Set Commands ==============
 
 func (t *TxStore) SAdd(ctx context.Context, key string, members []string) (int64, error) {
-	return t.ops.sAdd(ctx, t.querier(), key, members)
+	return t.ops(ctx).sAdd(ctx, t.querier(), key, members)
 }
 
 func (t *TxStore) SRem(ctx context.Context, key string, members []string) (int64, error) {
-	return t.ops.sRem(ctx, t.querier(), key, members)
+	return t.ops(ctx).sRem(ctx, t.querier(), key, members)
 }
 
 func (t *TxStore) SMembers(ctx context.Context, key string) ([]string, error) {
-	return t.ops.sMembers(ctx, t.querier(), key)
+	return t.ops(ctx).sMembers(ctx, t.querier(), key)
+}
+
+func (t *TxStore) SScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []string, error) {
+	return t.ops(ctx).sScan(ctx, t.querier(), key, cursor, pattern, count)
 }
 
 func (t *TxStore) SIsMember(ctx context.Context, key, member string) (bool, error) {
-	return t.ops.sIsMember(ctx, t.querier(), key, member)
+	return t.ops(ctx).sIsMember(ctx, t.querier(), key, member)
 }
 
 func (t *TxStore) SCard(ctx context.Context, key string) (int64, error) {
-	return t.ops.sCard(ctx, t.querier(), key)
+	return t.ops(ctx).sCard(ctx, t.querier(), key)
 }
 
 func (t *TxStore) SMIsMember(ctx context.Context, key string, members []string) ([]bool, error) {
-	return t.ops.sMIsMember(ctx, t.querier(), key, members)
+	return t.ops(ctx).sMIsMember(ctx, t.querier(), key, members)
 }
 
 func (t *TxStore) SInter(ctx context.Context, keys []string) ([]string, error) {
-	return t.ops.sInter(ctx, t.querier(), keys)
+	return t.ops(ctx).sInter(ctx, t.querier(), keys)
 }
 
 func (t *TxStore) SInterStore(ctx context.Context, destination string, keys []string) (int64, error) {
-	return t.ops.sInterStore(ctx, t.querier(), destination, keys)
+	return t.ops(ctx).sInterStore(ctx, t.querier(), destination, keys)
 }
 
 func (t *TxStore) SUnion(ctx context.Context, keys []string) ([]string, error) {
-	return t.ops.sUnion(ctx, t.querier(), keys)
+	return t.ops(ctx).sUnion(ctx, t.querier(), keys)
 }
 
 func (t *TxStore) SUnionStore(ctx context.Context, destination string, keys []string) (int64, error) {
-	return t.ops.sUnionStore(ctx, t.querier(), destination, keys)
+	return t.ops(ctx).sUnionStore(ctx, t.querier(), destination, keys)
 }
 
 func (t *TxStore) SDiff(ctx context.Context, keys []string) ([]string, error) {
-	return t.ops.sDiff(ctx, t.querier(), keys)
+	return t.ops(ctx).sDiff(ctx, t.querier(), keys)
 }
 
 func (t *TxStore) SDiffStore(ctx context.Context, destination string, keys []string) (int64, error) {
-	return t.ops.sDiffStore(ctx, t.querier(), destination, keys)
+	return t.ops(ctx).sDiffStore(ctx, t.querier(), destination, keys)
 }
 
 // ============== Sorted Set Commands ==============
 
 func (t *TxStore) ZAdd(ctx context.Context, key string, members []ZMember) (int64, error) {
-	return t.ops.zAdd(ctx, t.querier(), key, members)
+	return t.ops(ctx).zAdd(ctx, t.querier(), key, members)
 }
 
 func (t *TxStore) ZRange(ctx context.Context, key string, start, stop int64, withScores bool) ([]ZMember, error) {
-	return t.ops.zRange(ctx, t.querier(), key, start, stop, withScores)
+	return t.ops(ctx).zRange(ctx, t.querier(), key, start, stop, withScores)
 }
 
 func (t *TxStore) ZScore(ctx context.Context, key, member string) (float64, bool, error) {
-	return t.ops.zScore(ctx, t.querier(), key, member)
+	return t.ops(ctx).zScore(ctx, t.querier(), key, member)
 }
 
 func (t *TxStore) ZRem(ctx context.Context, key string, members []string) (int64, error) {
-	return t.ops.zRem(ctx, t.querier(), key, members)
+	return t.ops(ctx).zRem(ctx, t.querier(), key, members)
 }
 
 func (t *TxStore) ZCard(ctx context.Context, key string) (int64, error) {
-	return t.ops.zCard(ctx, t.querier(), key)
+	return t.ops(ctx).zCard(ctx, t.querier(), key)
 }
 
 func (t *TxStore) ZRangeByScore(ctx context.Context, key string, min, max float64, withScores bool, offset, count int64) ([]ZMember, error) {
-	return t.ops.zRangeByScore(ctx, t.querier(), key, min, max, withScores, offset, count)
+	return t.ops(ctx).zRangeByScore(ctx, t.querier(), key, min, max, withScores, offset, count)
 }
 
 func (t *TxStore) ZRemRangeByScore(ctx context.Context, key string, min, max float64) (int64, error) {
-	return t.ops.zRemRangeByScore(ctx, t.querier(), key, min, max)
+	return t.ops(ctx).zRemRangeByScore(ctx, t.querier(), key, min, max)
 }
 
 func (t *TxStore) ZRemRangeByRank(ctx context.Context, key string, start, stop int64) (int64, error) {
-	return t.ops.zRemRangeByRank(ctx, t.querier(), key, start, stop)
+	return t.ops(ctx).zRemRangeByRank(ctx, t.querier(), key, start, stop)
 }
 
 func (t *TxStore) ZIncrBy(ctx context.Context, key string, increment float64, member string) (float64, error) {
-	return t.ops.zIncrBy(ctx, t.querier(), key, increment, member)
+	return t.ops(ctx).zIncrBy(ctx, t.querier(), key, increment, member)
 }
 
 func (t *TxStore) ZPopMin(ctx context.Context, key string, count int64) ([]ZMember, error) {
-	return t.ops.zPopMin(ctx, t.querier(), key, count)
+	return t.ops(ctx).zPopMin(ctx, t.querier(), key, count)
 }
 
 func (t *TxStore) ZPopMax(ctx context.Context, key string, count int64) ([]ZMember, error) {
-	return t.ops.zPopMax(ctx, t.querier(), key, count)
+	return t.ops(ctx).zPopMax(ctx, t.querier(), key, count)
 }
 
 func (t *TxStore) ZRank(ctx context.Context, key, member string) (int64, bool, error) {
-	return t.ops.zRank(ctx, t.querier(), key, member)
+	return t.ops(ctx).zRank(ctx, t.querier(), key, member)
 }
 
 func (t *TxStore) ZRevRank(ctx context.Context, key, member string) (int64, bool, error) {
-	return t.ops.zRevRank(ctx, t.querier(), key, member)
+	return t.ops(ctx).zRevRank(ctx, t.querier(), key, member)
 }
 
 func (t *TxStore) ZCount(ctx context.Context, key string, min, max float64) (int64, error) {
-	return t.ops.zCount(ctx, t.querier(), key, min, max)
+	return t.ops(ctx).zCount(ctx, t.querier(), key, min, max)
 }
 
-func (t *TxStore) ZScan(ctx context.Context, key string, cursor int64, pattern string, count int64) (int64, []ZMember, error) {
-	return t.ops.zScan(ctx, t.querier(), key, cursor, pattern, count)
+func (t *TxStore) ZScan(ctx context.Context, key string, cursor uint64, pattern string, count int64) (uint64, []ZMember, error) {
+	return t.ops(ctx).zScan(ctx, t.querier(), key, cursor, pattern, count)
 }
 
 func (t *TxStore) ZUnionStore(ctx context.Context, destination string, keys []string, weights []float64, aggregate string) (int64, error) {
-	return t.ops.zUnionStore(ctx, t.querier(), destination, keys, weights, aggregate)
+	return t.ops(ctx).zUnionStore(ctx, t.querier(), destination, keys, weights, aggregate)
 }
 
 func (t *TxStore) ZInterStore(ctx context.Context, destination string, keys []string, weights []float64, aggregate string) (int64, error) {
-	return t.ops.zInterStore(ctx, t.querier(), destination, keys, weights, aggregate)
+	return t.ops(ctx).zInterStore(ctx, t.querier(), destination, keys, weights, aggregate)
 }
 
 // ============== HyperLogLog Commands ==============
 
 func (t *TxStore) PFAdd(ctx context.Context, key string, elements []string) (int64, error) {
-	return t.ops.pfAdd(ctx, t.querier(), key, elements)
+	return t.ops(ctx).pfAdd(ctx, t.querier(), key, elements)
 }
 
 func (t *TxStore) PFCount(ctx context.Context, keys []string) (int64, error) {
-	return t.ops.pfCount(ctx, t.querier(), keys)
+	return t.ops(ctx).pfCount(ctx, t.querier(), keys)
 }
 
 func (t *TxStore) PFMerge(ctx context.Context, destKey string, sourceKeys []string) error {
-	return t.ops.pfMerge(ctx, t.querier(), destKey, sourceKeys)
+	return t.ops(ctx).pfMerge(ctx, t.querier(), destKey, sourceKeys)
+}
+
+// ============== Stream Commands ==============
+
+func (t *TxStore) XAdd(ctx context.Context, key, id string, fields []string, noMkStream bool, trim StreamTrim) (string, bool, error) {
+	return t.ops(ctx).xAdd(ctx, t.querier(), key, id, fields, noMkStream, trim)
+}
+
+func (t *TxStore) XRange(ctx context.Context, key string, start, end StreamID, count int64) ([]StreamEntry, error) {
+	return t.ops(ctx).xRange(ctx, t.querier(), key, start, end, count)
+}
+
+func (t *TxStore) XRevRange(ctx context.Context, key string, end, start StreamID, count int64) ([]StreamEntry, error) {
+	return t.ops(ctx).xRevRange(ctx, t.querier(), key, end, start, count)
+}
+
+func (t *TxStore) XLen(ctx context.Context, key string) (int64, error) {
+	return t.ops(ctx).xLen(ctx, t.querier(), key)
+}
+
+func (t *TxStore) XDel(ctx context.Context, key string, ids []StreamID) (int64, error) {
+	return t.ops(ctx).xDel(ctx, t.querier(), key, ids)
+}
+
+func (t *TxStore) XTrim(ctx context.Context, key string, trim StreamTrim) (int64, error) {
+	return t.ops(ctx).xTrim(ctx, t.querier(), key, trim)
+}
+
+// ============== Stream Consumer Group Commands ==============
+
+func (t *TxStore) XGroupCreate(ctx context.Context, key, group, id string, mkStream bool, entriesRead *int64) error {
+	return t.ops(ctx).xGroupCreate(ctx, t.querier(), key, group, id, mkStream, entriesRead)
+}
+
+func (t *TxStore) XGroupSetID(ctx context.Context, key, group, id string, entriesRead *int64) error {
+	return t.ops(ctx).xGroupSetID(ctx, t.querier(), key, group, id, entriesRead)
+}
+
+func (t *TxStore) XGroupDestroy(ctx context.Context, key, group string) (bool, error) {
+	return t.ops(ctx).xGroupDestroy(ctx, t.querier(), key, group)
+}
+
+func (t *TxStore) XGroupCreateConsumer(ctx context.Context, key, group, consumer string) (bool, error) {
+	return t.ops(ctx).xGroupCreateConsumer(ctx, t.querier(), key, group, consumer)
+}
+
+func (t *TxStore) XGroupDelConsumer(ctx context.Context, key, group, consumer string) (int64, error) {
+	return t.ops(ctx).xGroupDelConsumer(ctx, t.querier(), key, group, consumer)
+}
+
+func (t *TxStore) XReadGroup(ctx context.Context, group, consumer string, keys, ids []string, count int64, noAck bool) ([]StreamReadResult, error) {
+	return t.ops(ctx).xReadGroup(ctx, t.querier(), group, consumer, keys, ids, count, noAck)
+}
+
+func (t *TxStore) XAck(ctx context.Context, key, group string, ids []StreamID) (int64, error) {
+	return t.ops(ctx).xAck(ctx, t.querier(), key, group, ids)
+}
+
+func (t *TxStore) XPendingSummary(ctx context.Context, key, group string) (StreamPendingSummary, error) {
+	return t.ops(ctx).xPendingSummary(ctx, t.querier(), key, group)
+}
+
+func (t *TxStore) XPending(ctx context.Context, key, group string, start, end StreamID, count int64, consumer string, minIdle int64) ([]StreamPendingEntry, error) {
+	return t.ops(ctx).xPending(ctx, t.querier(), key, group, start, end, count, consumer, minIdle)
+}
+
+func (t *TxStore) XClaim(ctx context.Context, key, group, consumer string, minIdle int64, ids []StreamID, opts StreamClaimOptions) ([]StreamEntry, error) {
+	return t.ops(ctx).xClaim(ctx, t.querier(), key, group, consumer, minIdle, ids, opts)
+}
+
+func (t *TxStore) XAutoClaim(ctx context.Context, key, group, consumer string, minIdle int64, start StreamID, count int64, justID bool) (StreamID, []StreamEntry, []StreamID, error) {
+	return t.ops(ctx).xAutoClaim(ctx, t.querier(), key, group, consumer, minIdle, start, count, justID)
+}
+
+func (t *TxStore) XInfoStream(ctx context.Context, key string) (StreamInfo, error) {
+	return t.ops(ctx).xInfoStream(ctx, t.querier(), key)
+}
+
+func (t *TxStore) XInfoGroups(ctx context.Context, key string) ([]StreamGroupInfo, error) {
+	return t.ops(ctx).xInfoGroups(ctx, t.querier(), key)
+}
+
+func (t *TxStore) XInfoConsumers(ctx context.Context, key, group string) ([]StreamConsumerInfo, error) {
+	return t.ops(ctx).xInfoConsumers(ctx, t.querier(), key, group)
+}
+
+// ============== JSON Commands ==============
+
+func (t *TxStore) JSONSet(ctx context.Context, key, path, value string, nx, xx bool) (bool, error) {
+	return t.ops(ctx).jsonSet(ctx, t.querier(), key, path, value, nx, xx)
+}
+
+func (t *TxStore) JSONGet(ctx context.Context, key string, paths []string) ([][]JSONMatch, bool, error) {
+	return t.ops(ctx).jsonGet(ctx, t.querier(), key, paths)
+}
+
+func (t *TxStore) JSONDel(ctx context.Context, key, path string) (int64, error) {
+	return t.ops(ctx).jsonDel(ctx, t.querier(), key, path)
+}
+
+func (t *TxStore) JSONNumIncrBy(ctx context.Context, key, path, increment string) ([]JSONMatch, bool, error) {
+	return t.ops(ctx).jsonNumIncrBy(ctx, t.querier(), key, path, increment)
+}
+
+func (t *TxStore) JSONArrAppend(ctx context.Context, key, path string, values []string) ([]JSONMatch, bool, error) {
+	return t.ops(ctx).jsonArrAppend(ctx, t.querier(), key, path, values)
+}
+
+func (t *TxStore) JSONDescribe(ctx context.Context, key, path string, withKeys bool) ([]JSONMatch, bool, error) {
+	return t.ops(ctx).jsonDescribe(ctx, t.querier(), key, path, withKeys)
+}
+
+// ============== Search Commands ==============
+
+func (t *TxStore) FTCreate(ctx context.Context, index FTIndex) error {
+	if err := t.ops(ctx).ftCreate(ctx, t.querier(), index); err != nil {
+		return err
+	}
+	return t.ops(ctx).ftCreateIndexes(ctx, t.querier(), index, false)
+}
+
+func (t *TxStore) FTSearch(ctx context.Context, name, query string, opts FTSearchOptions) (int64, []FTDocument, error) {
+	return t.ops(ctx).ftSearch(ctx, t.querier(), name, query, opts)
+}
+
+func (t *TxStore) FTInfo(ctx context.Context, name string) (FTIndex, int64, error) {
+	return t.ops(ctx).ftInfo(ctx, t.querier(), name)
+}
+
+func (t *TxStore) FTDropIndex(ctx context.Context, name string, deleteDocs bool) error {
+	index, err := t.ops(ctx).ftDropIndex(ctx, t.querier(), name, deleteDocs)
+	if err != nil {
+		return err
+	}
+	return t.ops(ctx).ftDropIndexes(ctx, t.querier(), index, false)
+}
+
+func (t *TxStore) FTList(ctx context.Context) ([]string, error) {
+	return t.ops(ctx).ftList(ctx, t.querier())
 }
 
 // ============== Server Commands ==============
 
 func (t *TxStore) DBSize(ctx context.Context) (int64, error) {
-	return t.ops.dbSize(ctx, t.tx)
+	return t.ops(ctx).dbSize(ctx, t.tx)
+}
+
+func (t *TxStore) SwapDB(ctx context.Context, db1, db2 int) error {
+	return t.ops(ctx).swapDB(ctx, t.querier(), db1, db2)
+}
+
+func (t *TxStore) Keyspace(ctx context.Context) ([]KeyspaceInfo, error) {
+	return t.ops(ctx).keyspace(ctx, t.querier())
+}
+
+func (t *TxStore) KeyVersions(ctx context.Context, keys []string) ([]int64, error) {
+	return t.ops(ctx).keyVersions(ctx, t.querier(), keys, true)
 }
 
 // Ensure TxStore implements Transaction
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+)
+
+// keyVersions returns the current version of each key, or 0 for keys that
+// don't exist. When lock is set the kv_keys rows are locked until the end of
+// the transaction, so the keys can't change between the check and the commit.
+func (o queryOps) keyVersions(ctx context.Context, q Querier, keys []string, lock bool) ([]int64, error) {
+	query := `SELECT key, version FROM kv_keys
+		 WHERE db = $2 AND key = ANY($1) AND (expires_at IS NULL OR expires_at > NOW())`
+	if lock {
+		// Lock in key order so concurrent EXECs can't deadlock each other
+		query += " ORDER BY key FOR UPDATE"
+	}
+	rows, err := q.Query(ctx, query, keys, o.db)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]int64, len(keys))
+	for rows.Next() {
+		var key string
+		var version int64
+		if err := rows.Scan(&key, &version); err != nil {
+			return nil, err
+		}
+		found[key] = version
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	versions := make([]int64, len(keys))
+	for i, key := range keys {
+		versions[i] = found[key]
+	}
+	return versions, nil
+}
@@ -0,0 +1,197 @@
+// Package stringmatch implements Redis glob-style pattern matching, as used
+// by KEYS, SCAN, PSUBSCRIBE and CONFIG GET, and its translation to SQL LIKE
+// patterns for filtering in PostgreSQL.
+//
+// Patterns support * (any bytes), ? (one byte), [abc], [^abc] and [a-z]
+// character classes, and backslash escapes. Matching is done on bytes, like
+// Redis's stringmatchlen.
+package stringmatch
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxNesting bounds the recursion on * like Redis, so abusive patterns fail
+// to match instead of exhausting the stack
+const maxNesting = 1000
+
+// Match reports whether str matches the glob pattern, ignoring ASCII case if
+// nocase is set
+func Match(pattern, str string, nocase bool) bool {
+	skipLongerMatches := false
+	return match(pattern, str, nocase, &skipLongerMatches, 0)
+}
+
+// match is a port of Redis's stringmatchlen_impl. Once the rest of the
+// pattern after a * fails to match anywhere in the string, skipLongerMatches
+// stops the earlier *s from trying longer matches, which can't succeed
+// either; this keeps patterns like a*a*a*a*b linear.
+func match(pattern, str string, nocase bool, skipLongerMatches *bool, nesting int) bool {
+	if nesting > maxNesting {
+		return false
+	}
+
+	for len(pattern) > 0 && len(str) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for len(str) > 0 {
+				if match(pattern[1:], str, nocase, skipLongerMatches, nesting+1) {
+					return true
+				}
+				if *skipLongerMatches {
+					return false
+				}
+				str = str[1:]
+			}
+			*skipLongerMatches = true
+			return false
+
+		case '?':
+			pattern = pattern[1:]
+			str = str[1:]
+
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			matched := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						matched = true
+					}
+				case len(pattern) >= 3 && pattern[1] == '-':
+					start, end, c := pattern[0], pattern[2], str[0]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = lower(start), lower(end), lower(c)
+					}
+					pattern = pattern[2:]
+					if c >= start && c <= end {
+						matched = true
+					}
+				default:
+					if equal(pattern[0], str[0], nocase) {
+						matched = true
+					}
+				}
+				pattern = pattern[1:]
+			}
+			// Skip the closing ], if the class wasn't left unterminated
+			if len(pattern) > 0 {
+				pattern = pattern[1:]
+			}
+			if not {
+				matched = !matched
+			}
+			if !matched {
+				return false
+			}
+			str = str[1:]
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if !equal(pattern[0], str[0], nocase) {
+				return false
+			}
+			pattern = pattern[1:]
+			str = str[1:]
+		}
+
+		if len(str) == 0 {
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			break
+		}
+	}
+	return len(pattern) == 0 && len(str) == 0
+}
+
+// equal compares two bytes, ignoring ASCII case if nocase is set
+func equal(a, b byte, nocase bool) bool {
+	if nocase {
+		return lower(a) == lower(b)
+	}
+	return a == b
+}
+
+// lower lowercases an ASCII letter
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// ToLike translates a glob pattern to a SQL LIKE pattern using the default
+// backslash escape. LIKE can only express * and literals byte for byte, so
+// from the first ? or character class on the result is just %, and exact is
+// false: the LIKE pattern then matches a superset of the glob pattern, and
+// candidates must be checked with Match.
+func ToLike(pattern string) (like string, exact bool) {
+	if !utf8.ValidString(pattern) {
+		// PostgreSQL rejects invalid UTF-8 in text parameters
+		return "%", false
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			sb.WriteByte('%')
+		case '?', '[':
+			sb.WriteByte('%')
+			return sb.String(), false
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				c = pattern[i]
+			}
+			fallthrough
+		default:
+			if isLikeSpecial(c) {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), true
+}
+
+// EscapeLike escapes the LIKE wildcards and the escape character in s, so it
+// matches literally
+func EscapeLike(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if isLikeSpecial(s[i]) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// isLikeSpecial reports whether c needs escaping in a LIKE pattern
+func isLikeSpecial(c byte) bool {
+	return c == '%' || c == '_' || c == '\\'
+}
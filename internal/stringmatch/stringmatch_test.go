@@ -0,0 +1,136 @@
+package stringmatch
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Cases from the Redis KEYS and PSUBSCRIBE documentation and test suite,
+// plus the edge cases of stringmatchlen
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		nocase  bool
+		match   bool
+	}{
+		// KEYS documentation
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hallo", false, true},
+		{"h?llo", "hxllo", false, true},
+		{"h?llo", "hllo", false, false},
+		{"h*llo", "hllo", false, true},
+		{"h*llo", "heeeello", false, true},
+		{"h[ae]llo", "hello", false, true},
+		{"h[ae]llo", "hallo", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hbllo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hallo", false, true},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h[a-b]llo", "hcllo", false, false},
+
+		// KEYS with pattern
+		{"foo*", "foo_a", false, true},
+		{"foo*", "foo_b", false, true},
+		{"foo*", "key_x", false, false},
+		{"*", "key_x", false, true},
+
+		// PSUBSCRIBE
+		{"foo.*", "foo.1", false, true},
+		{"foo.*", "foo", false, false},
+		{"foo.*", "bar.1", false, false},
+		{"*", "foo", false, true},
+
+		// Literals are matched byte for byte
+		{"user_1*", "user_1:name", false, true},
+		{"user_1*", "userX1:name", false, false},
+		{"100%", "100%", false, true},
+		{"100%", "1000", false, false},
+		{"exact", "exact", false, true},
+		{"exact", "notexact", false, false},
+
+		// Escapes
+		{`h\*llo`, "h*llo", false, true},
+		{`h\*llo`, "hello", false, false},
+		{`h\?llo`, "h?llo", false, true},
+		{`h\?llo`, "hello", false, false},
+		{`\[a]`, "[a]", false, true},
+		{`a\\b`, `a\b`, false, true},
+		{`a\`, `a\`, false, true},
+
+		// Character classes
+		{"[b-a]", "a", false, true},
+		{`[\]]`, "]", false, true},
+		{`[\-]`, "-", false, true},
+		{"[^a-z]", "A", false, true},
+		{"[^a-z]", "q", false, false},
+		{"[abc", "b", false, true},
+		{"[abc", "bc", false, false},
+
+		// Multiple and trailing stars
+		{"a*b*c", "abc", false, true},
+		{"a*b*c", "axxbxxc", false, true},
+		{"a*b*c", "axxbxx", false, false},
+		{"a**", "a", false, true},
+		{"a*", "a", false, true},
+
+		// Case-insensitive matching
+		{"HELLO", "hello", true, true},
+		{"HELLO", "hello", false, false},
+		{"h[A-Z]llo", "hello", true, true},
+		{"h[^E]llo", "hello", true, false},
+		{"notify-*", "NOTIFY-keyspace-events", true, true},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.str, tt.nocase); got != tt.match {
+			t.Errorf("Match(%q, %q, %v) = %v, want %v", tt.pattern, tt.str, tt.nocase, got, tt.match)
+		}
+	}
+}
+
+// Regression tests for pattern matching long nested loops
+func TestMatchNestedStars(t *testing.T) {
+	start := time.Now()
+	if Match(strings.Repeat("a*", 30)+"b", strings.Repeat("a", 62), false) {
+		t.Error("Expected a*a*...b not to match a string of as")
+	}
+	if Match(strings.Repeat("*?", 50000), strings.Repeat("a", 62), false) {
+		t.Error("Expected *?*?... not to match")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Nested stars took %v", elapsed)
+	}
+}
+
+func TestToLike(t *testing.T) {
+	tests := []struct {
+		pattern string
+		like    string
+		exact   bool
+	}{
+		{"*", "%", true},
+		{"user:*", "user:%", true},
+		{"*:temp", "%:temp", true},
+		{"user_1*", `user\_1%`, true},
+		{"100%", `100\%`, true},
+		{`back\slash`, `backslash`, true},
+		{`a\\b`, `a\\b`, true},
+		{`a\*b`, "a*b", true},
+		{`a\`, `a\\`, true},
+		{"h?llo", "h%", false},
+		{"h[ae]llo", "h%", false},
+		{"*[0-9]", "%%", false},
+		{"caf\xc3", "%", false},
+	}
+
+	for _, tt := range tests {
+		like, exact := ToLike(tt.pattern)
+		if like != tt.like || exact != tt.exact {
+			t.Errorf("ToLike(%q) = %q, %v, want %q, %v", tt.pattern, like, exact, tt.like, tt.exact)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// ============== Connection Configuration Tests ==============
+
+func TestConnectionSettingsFromDSN(t *testing.T) {
+	ctx := context.Background()
+
+	base := testStorageConfig()
+	cfg := storage.Config{
+		DSN: fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s&keepalives_idle=30",
+			base.User, base.Password, base.Host, base.Port, base.Database, base.SSLMode),
+		MaxConns:         4,
+		StatementTimeout: 2 * time.Second,
+		ApplicationName:  "postkeys-test",
+	}
+	store, err := storage.New(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to connect with a DSN: %v", err)
+	}
+	defer store.Close()
+
+	var statementTimeout, applicationName string
+	err = store.Pool().QueryRow(ctx, "SELECT current_setting('statement_timeout'), current_setting('application_name')").
+		Scan(&statementTimeout, &applicationName)
+	if err != nil {
+		t.Fatalf("Failed to read settings: %v", err)
+	}
+	if statementTimeout != "2s" || applicationName != "postkeys-test" {
+		t.Errorf("Expected 2s and postkeys-test, got %q and %q", statementTimeout, applicationName)
+	}
+	if max := store.Pool().Stat().MaxConns(); max != 4 {
+		t.Errorf("Expected 4 max conns, got %d", max)
+	}
+
+	// The listener connections get the same settings
+	conn, err := pgx.ConnectConfig(ctx, store.ConnConfig())
+	if err != nil {
+		t.Fatalf("Failed to open a session connection: %v", err)
+	}
+	defer conn.Close(ctx)
+	if err := conn.QueryRow(ctx, "SELECT current_setting('application_name')").Scan(&applicationName); err != nil || applicationName != "postkeys-test" {
+		t.Errorf("Expected postkeys-test on the session connection, got %q, %v", applicationName, err)
+	}
+}
@@ -0,0 +1,234 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ============== Database Tests ==============
+
+// dbClient returns a client whose connections have database db selected
+func dbClient(t *testing.T, ts *testServer, db int) *redis.Client {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: ts.addr, DB: db})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestSelectIsolatesKeys(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	db1 := dbClient(t, ts, 1)
+
+	ts.client.Set(ctx, "shared", "zero", 0)
+	db1.Set(ctx, "shared", "one", 0)
+	db1.HSet(ctx, "hash", "f", "v")
+
+	if v := ts.client.Get(ctx, "shared").Val(); v != "zero" {
+		t.Errorf("Expected 'zero' in db0, got %q", v)
+	}
+	if v := db1.Get(ctx, "shared").Val(); v != "one" {
+		t.Errorf("Expected 'one' in db1, got %q", v)
+	}
+	if n := ts.client.Exists(ctx, "hash").Val(); n != 0 {
+		t.Errorf("db1 hash should not be visible in db0")
+	}
+	if n := db1.DBSize(ctx).Val(); n != 2 {
+		t.Errorf("Expected 2 keys in db1, got %d", n)
+	}
+
+	if err := ts.client.Do(ctx, "SELECT", "16").Err(); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("Expected out of range error, got %v", err)
+	}
+}
+
+func TestMove(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	db1 := dbClient(t, ts, 1)
+
+	ts.client.RPush(ctx, "list", "a", "b")
+	if ok := ts.client.Move(ctx, "list", 1).Val(); !ok {
+		t.Fatalf("MOVE should succeed")
+	}
+	if n := ts.client.Exists(ctx, "list").Val(); n != 0 {
+		t.Errorf("Moved key should be gone from db0")
+	}
+	if vals := db1.LRange(ctx, "list", 0, -1).Val(); len(vals) != 2 || vals[0] != "a" {
+		t.Errorf("Unexpected moved list: %v", vals)
+	}
+
+	// MOVE fails when the key exists in the target database
+	ts.client.Set(ctx, "list", "x", 0)
+	if ok := ts.client.Move(ctx, "list", 1).Val(); ok {
+		t.Errorf("MOVE onto an existing key should fail")
+	}
+	if ok := ts.client.Move(ctx, "missing", 1).Val(); ok {
+		t.Errorf("MOVE of a missing key should fail")
+	}
+	if err := ts.client.Move(ctx, "list", 0).Err(); err == nil {
+		t.Errorf("Expected error moving to the same database")
+	}
+}
+
+func TestSwapDB(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	db1 := dbClient(t, ts, 1)
+
+	ts.client.Set(ctx, "a", "zero", 0)
+	ts.client.Set(ctx, "only0", "x", 0)
+	db1.Set(ctx, "a", "one", 0)
+
+	if err := ts.client.SwapDB(ctx, 0, 1).Err(); err != nil {
+		t.Fatalf("SWAPDB failed: %v", err)
+	}
+	if v := ts.client.Get(ctx, "a").Val(); v != "one" {
+		t.Errorf("Expected 'one' in db0 after swap, got %q", v)
+	}
+	if v := db1.Get(ctx, "a").Val(); v != "zero" {
+		t.Errorf("Expected 'zero' in db1 after swap, got %q", v)
+	}
+	if n := db1.Exists(ctx, "only0").Val(); n != 1 {
+		t.Errorf("Expected only0 to move to db1")
+	}
+}
+
+func TestSwapDBConcurrentSet(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	dbs := []*redis.Client{ts.client, dbClient(t, ts, 1)}
+
+	// Writers keep setting the same keys in both databases, so a key created
+	// between the steps of a swap would collide with the one moved there
+	const keys = 20
+	for w, client := range dbs {
+		for i := 0; i < keys; i++ {
+			client.Set(ctx, fmt.Sprintf("k%d", i), w, 0)
+		}
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var setErrors atomic.Int64
+	for w, client := range dbs {
+		wg.Add(1)
+		go func(w int, client *redis.Client) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := client.Set(ctx, fmt.Sprintf("k%d", i%keys), w, 0).Err(); err != nil {
+					setErrors.Add(1)
+				}
+			}
+		}(w, client)
+	}
+
+	for i := 0; i < 50; i++ {
+		if err := ts.client.SwapDB(ctx, 0, 1).Err(); err != nil {
+			t.Errorf("SWAPDB %d failed: %v", i, err)
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if n := setErrors.Load(); n != 0 {
+		t.Errorf("Expected every SET to succeed, %d failed", n)
+	}
+	for db, client := range dbs {
+		if n := client.DBSize(ctx).Val(); n != keys {
+			t.Errorf("Expected %d keys in db%d, got %d", keys, db, n)
+		}
+	}
+}
+
+func TestFlushDBAndFlushAll(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	db1 := dbClient(t, ts, 1)
+
+	ts.client.Set(ctx, "k", "v", 0)
+	db1.Set(ctx, "k", "v", 0)
+
+	db1.FlushDB(ctx)
+	if n := db1.DBSize(ctx).Val(); n != 0 {
+		t.Errorf("FLUSHDB should empty db1, %d keys left", n)
+	}
+	if n := ts.client.DBSize(ctx).Val(); n != 1 {
+		t.Errorf("FLUSHDB in db1 should not touch db0, got %d keys", n)
+	}
+
+	db1.Set(ctx, "k", "v", 0)
+	ts.client.FlushAll(ctx)
+	if n := ts.client.DBSize(ctx).Val() + db1.DBSize(ctx).Val(); n != 0 {
+		t.Errorf("FLUSHALL should empty every database, %d keys left", n)
+	}
+}
+
+func TestInfoKeyspace(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	db3 := dbClient(t, ts, 3)
+
+	ts.client.Set(ctx, "a", "1", 0)
+	db3.Set(ctx, "b", "1", 0)
+	db3.Set(ctx, "c", "1", time.Hour)
+
+	info := ts.client.Info(ctx, "keyspace").Val()
+	if !strings.Contains(info, "db0:keys=1,expires=0") {
+		t.Errorf("Missing db0 keyspace line: %s", info)
+	}
+	if !strings.Contains(info, "db3:keys=2,expires=1") {
+		t.Errorf("Missing db3 keyspace line: %s", info)
+	}
+}
+
+func TestSelectInTransaction(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	db2 := dbClient(t, ts, 2)
+
+	conn := ts.client.Conn()
+	defer conn.Close()
+	_, err := conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Select(ctx, 2)
+		pipe.Set(ctx, "in-tx", "v", 0)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("MULTI/EXEC failed: %v", err)
+	}
+	if v := db2.Get(ctx, "in-tx").Val(); v != "v" {
+		t.Errorf("SET after SELECT in MULTI should write to db2, got %q", v)
+	}
+	if v := conn.Get(ctx, "in-tx").Val(); v != "v" {
+		t.Errorf("SELECT in MULTI should stay in effect after EXEC")
+	}
+}
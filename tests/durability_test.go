@@ -0,0 +1,74 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// ============== Durability Tier Tests ==============
+
+func TestVolatileKeysAcrossTiers(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := testStorageConfig()
+	cfg.VolatileKeyPatterns = []string{"cache:*"}
+	store, err := storage.New(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer store.Close()
+	if err := store.FlushAll(ctx); err != nil {
+		t.Fatalf("Failed to flush database: %v", err)
+	}
+
+	volatileCtx := storage.WithKeys(ctx, []string{"cache:page"})
+	if err := store.Set(volatileCtx, "cache:page", "html", 0); err != nil {
+		t.Fatalf("Volatile SET failed: %v", err)
+	}
+	if _, err := store.HSet(storage.WithKeys(ctx, []string{"cache:hash"}), "cache:hash", map[string]string{"f": "v"}); err != nil {
+		t.Fatalf("Volatile HSET failed: %v", err)
+	}
+
+	// Reads without declared keys see volatile writes
+	if val, found, err := store.Get(ctx, "cache:page"); err != nil || !found || val != "html" {
+		t.Errorf("Expected 'html', got %q, %v, %v", val, found, err)
+	}
+	if keyType, err := store.Type(ctx, "cache:hash"); err != nil || keyType != storage.TypeHash {
+		t.Errorf("Expected hash, got %v, %v", keyType, err)
+	}
+
+	// RENAME and COPY between a volatile and a durable key are durable
+	mixedCtx := storage.WithKeys(ctx, []string{"cache:page", "page"})
+	if err := store.Rename(mixedCtx, "cache:page", "page"); err != nil {
+		t.Fatalf("RENAME failed: %v", err)
+	}
+	if val, _, err := store.Get(ctx, "page"); err != nil || val != "html" {
+		t.Errorf("Expected renamed value 'html', got %q, %v", val, err)
+	}
+	if copied, err := store.Copy(storage.WithKeys(ctx, []string{"page", "cache:copy"}), "page", "cache:copy", false); err != nil || !copied {
+		t.Fatalf("COPY failed: %v, %v", copied, err)
+	}
+	if val, _, err := store.Get(ctx, "cache:copy"); err != nil || val != "html" {
+		t.Errorf("Expected copied value 'html', got %q, %v", val, err)
+	}
+
+	// MULTI transactions over volatile keys run on the volatile pool
+	tx, err := store.BeginTx(storage.WithKeys(ctx, []string{"cache:tx"}))
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+	if err := tx.Set(ctx, "cache:tx", "1", 0); err != nil {
+		tx.Rollback(ctx)
+		t.Fatalf("SET in transaction failed: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if val, _, err := store.Get(ctx, "cache:tx"); err != nil || val != "1" {
+		t.Errorf("Expected '1', got %q, %v", val, err)
+	}
+}
@@ -0,0 +1,95 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// ============== Command Function Tests ==============
+
+// TestCommandFunctions runs the commands backed by pk_* functions with and
+// without them, expecting the same replies and errors
+func TestCommandFunctions(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		t.Run(fmt.Sprintf("DisableFunctions=%v", disable), func(t *testing.T) {
+			ctx := context.Background()
+			cfg := testStorageConfig()
+			cfg.DisableFunctions = disable
+			store, err := storage.New(ctx, cfg)
+			if err != nil {
+				t.Fatalf("Failed to connect to PostgreSQL: %v", err)
+			}
+			defer store.Close()
+			if err := store.FlushAll(ctx); err != nil {
+				t.Fatalf("Failed to flush database: %v", err)
+			}
+
+			if err := store.Set(ctx, "fn:str", "10", 0); err != nil {
+				t.Fatalf("SET failed: %v", err)
+			}
+			if n, err := store.Incr(ctx, "fn:str", 5); err != nil || n != 15 {
+				t.Errorf("Expected INCRBY to return 15, got %d, %v", n, err)
+			}
+			if n, err := store.Incr(ctx, "fn:new", -2); err != nil || n != -2 {
+				t.Errorf("Expected INCRBY on a missing key to return -2, got %d, %v", n, err)
+			}
+			store.Set(ctx, "fn:text", " 1", 0)
+			if _, err := store.Incr(ctx, "fn:text", 1); err == nil || err.Error() != "value is not an integer" {
+				t.Errorf("Expected value is not an integer, got %v", err)
+			}
+			store.Set(ctx, "fn:max", fmt.Sprint(int64(math.MaxInt64)), 0)
+			if _, err := store.Incr(ctx, "fn:max", 1); err == nil || err.Error() != "increment or decrement would overflow" {
+				t.Errorf("Expected an overflow error, got %v", err)
+			}
+
+			if n, err := store.HSet(ctx, "fn:hash", map[string]string{"a": "1", "b": "2"}); err != nil || n != 2 {
+				t.Errorf("Expected HSET to add 2 fields, got %d, %v", n, err)
+			}
+			if n, err := store.HSet(ctx, "fn:hash", map[string]string{"b": "3", "c\x00": "4"}); err != nil || n != 1 {
+				t.Errorf("Expected HSET to add 1 field, got %d, %v", n, err)
+			}
+			if v, _, _ := store.HGet(ctx, "fn:hash", "c\x00"); v != "4" {
+				t.Errorf("Expected the binary field to round trip, got %q", v)
+			}
+
+			if n, err := store.RPush(ctx, "fn:list", []string{"b", "c"}); err != nil || n != 2 {
+				t.Errorf("Expected RPUSH to return 2, got %d, %v", n, err)
+			}
+			if n, err := store.LPush(ctx, "fn:list", []string{"a", "z"}); err != nil || n != 4 {
+				t.Errorf("Expected LPUSH to return 4, got %d, %v", n, err)
+			}
+			if list, _ := store.LRange(ctx, "fn:list", 0, -1); fmt.Sprint(list) != "[z a b c]" {
+				t.Errorf("Expected [z a b c], got %v", list)
+			}
+
+			if n, err := store.SAdd(ctx, "fn:set", []string{"x", "y", "x"}); err != nil || n != 2 {
+				t.Errorf("Expected SADD to add 2 members, got %d, %v", n, err)
+			}
+
+			// SET replaces a key of any type
+			if err := store.Set(ctx, "fn:hash", "v", 0); err != nil {
+				t.Fatalf("SET over a hash failed: %v", err)
+			}
+			if keyType, _ := store.Type(ctx, "fn:hash"); keyType != storage.TypeString {
+				t.Errorf("Expected SET to replace the hash, got %s", keyType)
+			}
+
+			const wrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+			if _, err := store.HSet(ctx, "fn:str", map[string]string{"a": "1"}); err == nil || err.Error() != wrongType {
+				t.Errorf("Expected WRONGTYPE from HSET, got %v", err)
+			}
+			if _, err := store.LPush(ctx, "fn:set", []string{"a"}); err == nil || err.Error() != wrongType {
+				t.Errorf("Expected WRONGTYPE from LPUSH, got %v", err)
+			}
+			if _, err := store.SAdd(ctx, "fn:list", []string{"a"}); err == nil || err.Error() != wrongType {
+				t.Errorf("Expected WRONGTYPE from SADD, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ============== Geospatial Tests ==============
+
+func addSicily(t *testing.T, ts *testServer) {
+	t.Helper()
+	added, err := ts.client.GeoAdd(context.Background(), "Sicily",
+		&redis.GeoLocation{Name: "Palermo", Longitude: 13.361389, Latitude: 38.115556},
+		&redis.GeoLocation{Name: "Catania", Longitude: 15.087269, Latitude: 37.502669},
+	).Result()
+	if err != nil {
+		t.Fatalf("GEOADD failed: %v", err)
+	}
+	if added != 2 {
+		t.Fatalf("Expected 2 added, got %d", added)
+	}
+}
+
+func TestGeoAddPosDistHash(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	addSicily(t, ts)
+
+	dist, err := ts.client.GeoDist(ctx, "Sicily", "Palermo", "Catania", "km").Result()
+	if err != nil {
+		t.Fatalf("GEODIST failed: %v", err)
+	}
+	if dist != 166.2742 {
+		t.Errorf("Expected 166.2742 km, got %v", dist)
+	}
+
+	pos, err := ts.client.GeoPos(ctx, "Sicily", "Palermo", "Nowhere").Result()
+	if err != nil {
+		t.Fatalf("GEOPOS failed: %v", err)
+	}
+	if len(pos) != 2 || pos[0] == nil || pos[1] != nil {
+		t.Fatalf("Unexpected GEOPOS result: %v", pos)
+	}
+	if pos[0].Longitude < 13.3613 || pos[0].Longitude > 13.3614 || pos[0].Latitude < 38.1155 || pos[0].Latitude > 38.1156 {
+		t.Errorf("Unexpected Palermo position: %+v", pos[0])
+	}
+
+	hashes, err := ts.client.GeoHash(ctx, "Sicily", "Palermo", "Catania").Result()
+	if err != nil {
+		t.Fatalf("GEOHASH failed: %v", err)
+	}
+	if hashes[0] != "sqc8b49rny0" || hashes[1] != "sqdtr74hyu0" {
+		t.Errorf("Unexpected GEOHASH result: %v", hashes)
+	}
+
+	// Geo keys are plain sorted sets
+	members, err := ts.client.ZRangeWithScores(ctx, "Sicily", 0, -1).Result()
+	if err != nil {
+		t.Fatalf("ZRANGE failed: %v", err)
+	}
+	if len(members) != 2 || members[0].Member != "Palermo" || members[0].Score != 3479099956230698 {
+		t.Errorf("Unexpected ZRANGE result: %v", members)
+	}
+
+	// NX/XX/CH
+	n, _ := ts.client.Do(ctx, "GEOADD", "Sicily", "XX", "CH", "13.5", "38.1", "Palermo", "14", "37", "Nowhere").Int64()
+	if n != 1 {
+		t.Errorf("Expected 1 changed with XX CH, got %d", n)
+	}
+	if ts.client.ZScore(ctx, "Sicily", "Nowhere").Err() != redis.Nil {
+		t.Errorf("XX should not add new members")
+	}
+
+	if err := ts.client.GeoAdd(ctx, "Sicily", &redis.GeoLocation{Name: "Pole", Longitude: 0, Latitude: 89}).Err(); err == nil {
+		t.Errorf("Expected error for invalid latitude")
+	}
+}
+
+func TestGeoSearch(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	addSicily(t, ts)
+
+	res, err := ts.client.GeoSearchLocation(ctx, "Sicily", &redis.GeoSearchLocationQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{Longitude: 15, Latitude: 37, Radius: 200, RadiusUnit: "km", Sort: "ASC"},
+		WithCoord:      true,
+		WithDist:       true,
+		WithHash:       true,
+	}).Result()
+	if err != nil {
+		t.Fatalf("GEOSEARCH failed: %v", err)
+	}
+	if len(res) != 2 || res[0].Name != "Catania" || res[1].Name != "Palermo" {
+		t.Fatalf("Unexpected GEOSEARCH result: %+v", res)
+	}
+	if res[0].Dist != 56.4413 || res[1].Dist != 190.4424 {
+		t.Errorf("Unexpected distances: %v %v", res[0].Dist, res[1].Dist)
+	}
+	if res[0].GeoHash != 3479447370796909 || res[0].Longitude == 0 {
+		t.Errorf("Unexpected hash/coords: %+v", res[0])
+	}
+
+	names, err := ts.client.GeoSearch(ctx, "Sicily", &redis.GeoSearchQuery{
+		Longitude: 15, Latitude: 37, BoxWidth: 400, BoxHeight: 400, BoxUnit: "km", Sort: "DESC",
+	}).Result()
+	if err != nil {
+		t.Fatalf("GEOSEARCH BYBOX failed: %v", err)
+	}
+	if len(names) != 2 || names[0] != "Palermo" {
+		t.Errorf("Unexpected GEOSEARCH BYBOX result: %v", names)
+	}
+
+	names, _ = ts.client.GeoSearch(ctx, "Sicily", &redis.GeoSearchQuery{
+		Member: "Palermo", Radius: 100, RadiusUnit: "km",
+	}).Result()
+	if len(names) != 1 || names[0] != "Palermo" {
+		t.Errorf("Unexpected FROMMEMBER result: %v", names)
+	}
+
+	names, _ = ts.client.GeoSearch(ctx, "Sicily", &redis.GeoSearchQuery{
+		Longitude: 15, Latitude: 37, Radius: 200, RadiusUnit: "km", Count: 1,
+	}).Result()
+	if len(names) != 1 || names[0] != "Catania" {
+		t.Errorf("COUNT should return the closest member, got %v", names)
+	}
+
+	names, _ = ts.client.GeoSearch(ctx, "Sicily", &redis.GeoSearchQuery{
+		Longitude: 15, Latitude: 37, Radius: 200, RadiusUnit: "km", Count: 1, CountAny: true,
+	}).Result()
+	if len(names) != 1 {
+		t.Errorf("COUNT ANY should return one member, got %v", names)
+	}
+
+	if names, _ := ts.client.GeoSearch(ctx, "missing", &redis.GeoSearchQuery{Member: "x", Radius: 1, RadiusUnit: "km"}).Result(); len(names) != 0 {
+		t.Errorf("Expected empty result for missing key, got %v", names)
+	}
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	addSicily(t, ts)
+
+	n, err := ts.client.GeoSearchStore(ctx, "Sicily", "near", &redis.GeoSearchStoreQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{Longitude: 15, Latitude: 37, Radius: 200, RadiusUnit: "km"},
+		StoreDist:      true,
+	}).Result()
+	if err != nil {
+		t.Fatalf("GEOSEARCHSTORE failed: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected 2 stored, got %d", n)
+	}
+
+	members, _ := ts.client.ZRangeWithScores(ctx, "near", 0, -1).Result()
+	if len(members) != 2 || members[0].Member != "Catania" || members[0].Score < 56.44 || members[0].Score > 56.45 {
+		t.Errorf("Unexpected stored distances: %v", members)
+	}
+
+	n, _ = ts.client.GeoSearchStore(ctx, "Sicily", "near", &redis.GeoSearchStoreQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{Longitude: 0, Latitude: 0, Radius: 1, RadiusUnit: "km"},
+	}).Result()
+	if n != 0 || ts.client.Exists(ctx, "near").Val() != 0 {
+		t.Errorf("Empty GEOSEARCHSTORE should delete the destination")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -34,14 +35,9 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
-// newTestServer creates a new test server with PostgreSQL storage
-func newTestServer(t *testing.T, password string) *testServer {
-	t.Helper()
-
-	ctx := context.Background()
-
-	// PostgreSQL connection config from environment
-	cfg := storage.Config{
+// testStorageConfig returns the PostgreSQL connection config from environment
+func testStorageConfig() storage.Config {
+	return storage.Config{
 		Host:     getEnvOrDefault("PG_HOST", "localhost"),
 		Port:     5789, // Use test port from docker-compose.test.yml
 		User:     getEnvOrDefault("PG_USER", "postgres"),
@@ -49,14 +45,21 @@ func newTestServer(t *testing.T, password string) *testServer {
 		Database: getEnvOrDefault("PG_DATABASE", "postgres"),
 		SSLMode:  getEnvOrDefault("PG_SSLMODE", "disable"),
 	}
+}
 
-	store, err := storage.New(ctx, cfg)
+// newTestServer creates a new test server with PostgreSQL storage
+func newTestServer(t *testing.T, password string) *testServer {
+	t.Helper()
+
+	ctx := context.Background()
+
+	store, err := storage.New(ctx, testStorageConfig())
 	if err != nil {
 		t.Fatalf("Failed to connect to PostgreSQL: %v", err)
 	}
 
 	// Clean up any existing data
-	if err := store.FlushDB(ctx); err != nil {
+	if err := store.FlushAll(ctx); err != nil {
 		store.Close()
 		t.Fatalf("Failed to flush database: %v", err)
 	}
@@ -412,6 +415,58 @@ func TestExpireTTL(t *testing.T) {
 	}
 }
 
+func TestExpirySweeperReclaimsAllTypes(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	ts.client.Set(ctx, "sweep:string", "v", 0)
+	ts.client.HSet(ctx, "sweep:hash", "f", "v")
+	ts.client.RPush(ctx, "sweep:list", "a", "b")
+	ts.client.SAdd(ctx, "sweep:set", "a")
+	ts.client.ZAdd(ctx, "sweep:zset", redis.Z{Score: 1, Member: "a"})
+	ts.client.PFAdd(ctx, "sweep:hll", "a", "b")
+	ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "sweep:stream", Values: []string{"f", "v"}})
+
+	keys := []string{"sweep:string", "sweep:hash", "sweep:list", "sweep:set", "sweep:zset", "sweep:hll", "sweep:stream"}
+	var ids []int64
+	err := ts.store.Pool().QueryRow(ctx, "SELECT array_agg(id) FROM kv_keys WHERE key = ANY($1)", keys).Scan(&ids)
+	if err != nil || len(ids) != len(keys) {
+		t.Fatalf("Expected %d key ids, got %v, %v", len(keys), ids, err)
+	}
+	for _, key := range keys {
+		if ok, err := ts.client.PExpire(ctx, key, 100*time.Millisecond).Result(); err != nil || !ok {
+			t.Fatalf("PEXPIRE %s failed: %v", key, err)
+		}
+	}
+
+	// The sweeper must delete the rows of every type, not just hide them
+	tables := []string{"kv_keys", "kv_strings", "kv_hashes", "kv_lists", "kv_list_meta", "kv_list_blocks", "kv_sets", "kv_zsets", "kv_hyperloglog", "kv_streams", "kv_stream_meta"}
+	deadline := time.Now().Add(10 * time.Second)
+	for _, table := range tables {
+		column := "key_id"
+		if table == "kv_keys" {
+			column = "id"
+		}
+		for {
+			var count int
+			err := ts.store.Pool().QueryRow(ctx,
+				"SELECT COUNT(*) FROM "+table+" WHERE "+column+" = ANY($1)", ids).Scan(&count)
+			if err != nil {
+				t.Fatalf("Counting %s rows failed: %v", table, err)
+			}
+			if count == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d expired rows left in %s", count, table)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
 func TestType(t *testing.T) {
 	ts := newTestServer(t, "")
 	defer ts.Close()
@@ -476,6 +531,72 @@ func TestKeys(t *testing.T) {
 	}
 }
 
+func TestKeysGlobPatterns(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	for _, key := range []string{"user_1:a", "userX1:b", "100%", "1000", "hallo", "hello", "hillo", "h*llo"} {
+		ts.client.Set(ctx, key, "v", 0)
+	}
+	ts.client.SAdd(ctx, "myset", "user_1", "userX1", "hello", "hallo")
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"user_1*", []string{"user_1:a"}},
+		{"100%", []string{"100%"}},
+		{"h[ae]llo", []string{"hallo", "hello"}},
+		{"h[^e]llo", []string{"hallo", "hillo"}},
+		{`h\*llo`, []string{"h*llo"}},
+		{"h?llo", []string{"h*llo", "hallo", "hello", "hillo"}},
+	}
+	for _, tt := range tests {
+		keys, err := ts.client.Keys(ctx, tt.pattern).Result()
+		if err != nil {
+			t.Fatalf("KEYS %s failed: %v", tt.pattern, err)
+		}
+		sort.Strings(keys)
+		if strings.Join(keys, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("KEYS %s = %v, want %v", tt.pattern, keys, tt.want)
+		}
+
+		var scanned []string
+		cursor := uint64(0)
+		for {
+			page, next, err := ts.client.Scan(ctx, cursor, tt.pattern, 2).Result()
+			if err != nil {
+				t.Fatalf("SCAN MATCH %s failed: %v", tt.pattern, err)
+			}
+			scanned = append(scanned, page...)
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+		sort.Strings(scanned)
+		if strings.Join(scanned, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("SCAN MATCH %s = %v, want %v", tt.pattern, scanned, tt.want)
+		}
+	}
+
+	members, _, err := ts.client.SScan(ctx, "myset", 0, "user_1", 10).Result()
+	if err != nil {
+		t.Fatalf("SSCAN failed: %v", err)
+	}
+	if len(members) != 1 || members[0] != "user_1" {
+		t.Errorf("SSCAN MATCH user_1 = %v, want [user_1]", members)
+	}
+	members, _, err = ts.client.SScan(ctx, "myset", 0, "h[a]llo", 10).Result()
+	if err != nil {
+		t.Fatalf("SSCAN failed: %v", err)
+	}
+	if len(members) != 1 || members[0] != "hallo" {
+		t.Errorf("SSCAN MATCH h[a]llo = %v, want [hallo]", members)
+	}
+}
+
 func TestRename(t *testing.T) {
 	ts := newTestServer(t, "")
 	defer ts.Close()
@@ -2582,6 +2703,73 @@ func TestDiscardTransaction(t *testing.T) {
 	}
 }
 
+func TestMultiExecAbortOnQueueError(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	conn := ts.client.Conn()
+	defer conn.Close()
+
+	conn.Do(ctx, "MULTI")
+	if res := conn.Do(ctx, "SET", "queued", "v").Val(); res != "QUEUED" {
+		t.Errorf("Expected QUEUED, got %v", res)
+	}
+	if err := conn.Do(ctx, "GET").Err(); err == nil || !strings.Contains(err.Error(), "wrong number of arguments") {
+		t.Errorf("Expected arity error while queuing, got %v", err)
+	}
+	if err := conn.Do(ctx, "NOSUCHCOMMAND", "x").Err(); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("Expected unknown command error while queuing, got %v", err)
+	}
+
+	err := conn.Do(ctx, "EXEC").Err()
+	if err == nil || !strings.HasPrefix(err.Error(), "EXECABORT") {
+		t.Fatalf("Expected EXECABORT, got %v", err)
+	}
+	if n := ts.client.Exists(ctx, "queued").Val(); n != 0 {
+		t.Errorf("Aborted transaction should not run queued commands")
+	}
+
+	// The connection is out of MULTI again
+	if err := conn.Set(ctx, "after", "v", 0).Err(); err != nil {
+		t.Errorf("SET after EXECABORT failed: %v", err)
+	}
+}
+
+func TestMultiExecRuntimeErrors(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	ts.client.LPush(ctx, "list", "a")
+
+	pipe := ts.client.TxPipeline()
+	before := pipe.Set(ctx, "before", "1", 0)
+	wrongType := pipe.Incr(ctx, "list")
+	notInt := pipe.Set(ctx, "str", "abc", 0)
+	incr := pipe.Incr(ctx, "str")
+	after := pipe.Set(ctx, "after", "2", 0)
+	pipe.Exec(ctx)
+
+	if before.Err() != nil || notInt.Err() != nil || after.Err() != nil {
+		t.Errorf("Commands around the failing ones should succeed: %v %v %v", before.Err(), notInt.Err(), after.Err())
+	}
+	if err := wrongType.Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Errorf("Expected WRONGTYPE, got %v", err)
+	}
+	if incr.Err() == nil {
+		t.Errorf("Expected INCR of a non-integer to fail")
+	}
+
+	// The failures don't roll back the other commands
+	if v := ts.client.Get(ctx, "before").Val(); v != "1" {
+		t.Errorf("Expected before=1, got %q", v)
+	}
+	if v := ts.client.Get(ctx, "after").Val(); v != "2" {
+		t.Errorf("Expected after=2, got %q", v)
+	}
+}
+
 // contains checks if s contains substr
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsHelper(s, substr))
@@ -3011,6 +3199,109 @@ func TestScanWithCount(t *testing.T) {
 	}
 }
 
+func TestScanReturnsStableKeysOnce(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		ts.client.Set(ctx, fmt.Sprintf("stable:%03d", i), "value", 0)
+	}
+	ts.client.HSet(ctx, "stable:hash", "field", "value")
+
+	// Keys added and removed between pages must not make the scan skip or
+	// repeat any key that exists throughout
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	for page := 0; ; page++ {
+		keys, nextCursor, err := ts.client.ScanType(ctx, cursor, "stable:*", 7, "string").Result()
+		if err != nil {
+			t.Fatalf("SCAN failed: %v", err)
+		}
+		for _, key := range keys {
+			seen[key]++
+		}
+		ts.client.Set(ctx, fmt.Sprintf("stable:%03d:new", page), "value", 0)
+		ts.client.Del(ctx, fmt.Sprintf("stable:%03d:new", page-1))
+		cursor = nextCursor
+		if cursor == 0 {
+			break
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("stable:%03d", i)
+		if seen[key] != 1 {
+			t.Errorf("Expected %s to be returned once, got %d", key, seen[key])
+		}
+	}
+	if seen["stable:hash"] != 0 {
+		t.Error("Expected SCAN TYPE string to skip the hash")
+	}
+}
+
+func TestScanCollections(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	for i := 0; i < 50; i++ {
+		ts.client.HSet(ctx, "myhash", fmt.Sprintf("field%02d", i), "value")
+		ts.client.SAdd(ctx, "myset", fmt.Sprintf("member%02d", i))
+		ts.client.ZAdd(ctx, "myzset", redis.Z{Score: float64(50 - i), Member: fmt.Sprintf("member%02d", i)})
+	}
+
+	scanAll := func(name string, scan func(cursor uint64) ([]string, uint64, error), step int) map[string]int {
+		seen := make(map[string]int)
+		cursor := uint64(0)
+		for {
+			items, nextCursor, err := scan(cursor)
+			if err != nil {
+				t.Fatalf("%s failed: %v", name, err)
+			}
+			for i := 0; i < len(items); i += step {
+				seen[items[i]]++
+			}
+			cursor = nextCursor
+			if cursor == 0 {
+				return seen
+			}
+		}
+	}
+
+	fields := scanAll("HSCAN", func(cursor uint64) ([]string, uint64, error) {
+		return ts.client.HScan(ctx, "myhash", cursor, "*", 8).Result()
+	}, 2)
+	members := scanAll("SSCAN", func(cursor uint64) ([]string, uint64, error) {
+		return ts.client.SScan(ctx, "myset", cursor, "*", 8).Result()
+	}, 1)
+	zmembers := scanAll("ZSCAN", func(cursor uint64) ([]string, uint64, error) {
+		return ts.client.ZScan(ctx, "myzset", cursor, "*", 8).Result()
+	}, 2)
+
+	for i := 0; i < 50; i++ {
+		if n := fields[fmt.Sprintf("field%02d", i)]; n != 1 {
+			t.Errorf("Expected HSCAN to return field%02d once, got %d", i, n)
+		}
+		if n := members[fmt.Sprintf("member%02d", i)]; n != 1 {
+			t.Errorf("Expected SSCAN to return member%02d once, got %d", i, n)
+		}
+		if n := zmembers[fmt.Sprintf("member%02d", i)]; n != 1 {
+			t.Errorf("Expected ZSCAN to return member%02d once, got %d", i, n)
+		}
+	}
+
+	if err := ts.client.SScan(ctx, "myhash", 0, "*", 10).Err(); err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Errorf("Expected WRONGTYPE for SSCAN on a hash, got %v", err)
+	}
+	// Cursors hold the position they resume at, so any cursor is valid
+	if err := ts.client.Scan(ctx, 123456789, "*", 10).Err(); err != nil {
+		t.Errorf("Expected any cursor to be accepted, got %v", err)
+	}
+}
+
 // ============== Lua Scripting Tests ==============
 
 func TestEvalSimple(t *testing.T) {
@@ -3791,7 +4082,7 @@ func TestWatch(t *testing.T) {
 
 	ctx := context.Background()
 
-	// WATCH should return OK (it's a no-op for PostgreSQL compatibility)
+	// WATCH with an empty callback should succeed
 	err := ts.client.Watch(ctx, func(tx *redis.Tx) error {
 		// Just test that Watch doesn't error
 		return nil
@@ -3821,6 +4112,128 @@ func TestWatchUnwatch(t *testing.T) {
 	}
 }
 
+func TestWatchAbortsOnChange(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	ts.client.Set(ctx, "counter", "1", 0)
+	ts.client.HSet(ctx, "hash", "f", "v")
+
+	// Another connection modifies each watched key between WATCH and EXEC
+	modify := map[string]func(){
+		"counter": func() { ts.client.Incr(ctx, "counter") },
+		"hash":    func() { ts.client.HSet(ctx, "hash", "g", "w") },
+		"missing": func() { ts.client.Set(ctx, "missing", "now", 0) },
+	}
+	for key, change := range modify {
+		err := ts.client.Watch(ctx, func(tx *redis.Tx) error {
+			change()
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, "result", key, 0)
+				return nil
+			})
+			return err
+		}, key)
+		if err != redis.TxFailedErr {
+			t.Errorf("Expected TxFailedErr after %s changed, got %v", key, err)
+		}
+	}
+	if n := ts.client.Exists(ctx, "result").Val(); n != 0 {
+		t.Errorf("Aborted transactions should not write")
+	}
+}
+
+func TestWatchCommitsWhenUnchanged(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	ts.client.Set(ctx, "counter", "1", 0)
+	ts.client.Set(ctx, "other", "1", 0)
+
+	err := ts.client.Watch(ctx, func(tx *redis.Tx) error {
+		n, err := tx.Get(ctx, "counter").Int()
+		if err != nil {
+			return err
+		}
+		// Writes to unwatched keys don't abort the transaction
+		ts.client.Incr(ctx, "other")
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, "counter", n+1, 0)
+			return nil
+		})
+		return err
+	}, "counter")
+	if err != nil {
+		t.Fatalf("Transaction should commit: %v", err)
+	}
+	if v := ts.client.Get(ctx, "counter").Val(); v != "2" {
+		t.Errorf("Expected counter 2, got %s", v)
+	}
+}
+
+func TestWatchDeleteAndRecreate(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	ts.client.Set(ctx, "key", "v", 0)
+
+	err := ts.client.Watch(ctx, func(tx *redis.Tx) error {
+		// Same value, but a new incarnation of the key
+		ts.client.Del(ctx, "key")
+		ts.client.Set(ctx, "key", "v", 0)
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, "key", "mine", 0)
+			return nil
+		})
+		return err
+	}, "key")
+	if err != redis.TxFailedErr {
+		t.Errorf("Expected TxFailedErr, got %v", err)
+	}
+}
+
+func TestWatchUnwatchAndDiscard(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	conn := ts.client.Conn()
+	defer conn.Close()
+
+	// UNWATCH forgets the key, so a later change doesn't abort EXEC
+	conn.Do(ctx, "WATCH", "key")
+	conn.Do(ctx, "UNWATCH")
+	ts.client.Set(ctx, "key", "changed", 0)
+	if _, err := conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Get(ctx, "key")
+		return nil
+	}); err != nil {
+		t.Errorf("EXEC after UNWATCH should succeed: %v", err)
+	}
+
+	// WATCH is not allowed inside MULTI
+	conn.Do(ctx, "MULTI")
+	if err := conn.Do(ctx, "WATCH", "key").Err(); err == nil || !strings.Contains(err.Error(), "WATCH inside MULTI") {
+		t.Errorf("Expected WATCH inside MULTI error, got %v", err)
+	}
+
+	// DISCARD unwatches too
+	conn.Do(ctx, "DISCARD")
+	conn.Do(ctx, "WATCH", "key")
+	conn.Do(ctx, "MULTI")
+	conn.Do(ctx, "DISCARD")
+	ts.client.Set(ctx, "key", "again", 0)
+	if _, err := conn.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Get(ctx, "key")
+		return nil
+	}); err != nil {
+		t.Errorf("EXEC after DISCARD should succeed: %v", err)
+	}
+}
+
 // ============== Sorted Set Negative Tests ==============
 
 func TestZAddWrongArgCount(t *testing.T) {
@@ -4326,6 +4739,178 @@ func TestLInsert(t *testing.T) {
 	}
 }
 
+func TestLInsertWithoutRoom(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	ts.client.RPush(ctx, "mylist", "a", "z")
+
+	// Each insert halves the room left before z, so the list must be
+	// renumbered along the way
+	expected := []string{"a"}
+	for i := 0; i < 40; i++ {
+		element := fmt.Sprintf("e%d", i)
+		pivot := "z"
+		if i > 0 {
+			pivot = fmt.Sprintf("e%d", i-1)
+		}
+		result, err := ts.client.LInsertBefore(ctx, "mylist", pivot, element).Result()
+		if err != nil {
+			t.Fatalf("LINSERT BEFORE failed: %v", err)
+		}
+		if result != int64(i+3) {
+			t.Fatalf("Expected %d, got %d", i+3, result)
+		}
+	}
+	for i := 39; i >= 0; i-- {
+		expected = append(expected, fmt.Sprintf("e%d", i))
+	}
+	expected = append(expected, "z")
+
+	vals, err := ts.client.LRange(ctx, "mylist", 0, -1).Result()
+	if err != nil {
+		t.Fatalf("LRANGE failed: %v", err)
+	}
+	if strings.Join(vals, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, vals)
+	}
+	for i, want := range expected {
+		got, err := ts.client.LIndex(ctx, "mylist", int64(i-len(expected))).Result()
+		if err != nil || got != want {
+			t.Errorf("LINDEX %d: expected %s, got %s, %v", i-len(expected), want, got, err)
+		}
+	}
+}
+
+func TestListIndexesAfterRemovals(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	// 0..9, with 10..14 pushed onto the head
+	for i := 0; i < 10; i++ {
+		ts.client.RPush(ctx, "mylist", fmt.Sprint(i))
+	}
+	ts.client.LPush(ctx, "mylist", "10", "11", "12", "13", "14")
+	// Leave gaps in the middle of the list
+	ts.client.LRem(ctx, "mylist", 0, "3")
+	ts.client.LRem(ctx, "mylist", 0, "5")
+	ts.client.LInsertAfter(ctx, "mylist", "6", "x")
+	ts.client.LPop(ctx, "mylist")
+	ts.client.RPop(ctx, "mylist")
+
+	expected := []string{"13", "12", "11", "10", "0", "1", "2", "4", "6", "x", "7", "8"}
+	if n, err := ts.client.LLen(ctx, "mylist").Result(); err != nil || n != int64(len(expected)) {
+		t.Fatalf("Expected length %d, got %d, %v", len(expected), n, err)
+	}
+	for i, want := range expected {
+		for _, index := range []int64{int64(i), int64(i - len(expected))} {
+			got, err := ts.client.LIndex(ctx, "mylist", index).Result()
+			if err != nil || got != want {
+				t.Errorf("LINDEX %d: expected %s, got %s, %v", index, want, got, err)
+			}
+		}
+	}
+
+	vals, err := ts.client.LRange(ctx, "mylist", -5, -2).Result()
+	if err != nil {
+		t.Fatalf("LRANGE failed: %v", err)
+	}
+	if strings.Join(vals, ",") != "4,6,x,7" {
+		t.Errorf("Expected [4 6 x 7], got %v", vals)
+	}
+
+	if err := ts.client.LSet(ctx, "mylist", -3, "X").Err(); err != nil {
+		t.Fatalf("LSET failed: %v", err)
+	}
+	if err := ts.client.LTrim(ctx, "mylist", 3, -2).Err(); err != nil {
+		t.Fatalf("LTRIM failed: %v", err)
+	}
+	vals, err = ts.client.LRange(ctx, "mylist", 0, -1).Result()
+	if err != nil {
+		t.Fatalf("LRANGE failed: %v", err)
+	}
+	if strings.Join(vals, ",") != "10,0,1,2,4,6,X,7" {
+		t.Errorf("Expected [10 0 1 2 4 6 X 7], got %v", vals)
+	}
+
+	// Pushes still go past the ends of the trimmed list
+	ts.client.RPush(ctx, "mylist", "y")
+	ts.client.LPush(ctx, "mylist", "w")
+	vals, err = ts.client.LRange(ctx, "mylist", 0, -1).Result()
+	if err != nil {
+		t.Fatalf("LRANGE failed: %v", err)
+	}
+	if strings.Join(vals, ",") != "w,10,0,1,2,4,6,X,7,y" {
+		t.Errorf("Expected [w 10 0 1 2 4 6 X 7 y], got %v", vals)
+	}
+
+	// kv_list_blocks still counts every element
+	var counted int64
+	err = ts.store.Pool().QueryRow(ctx,
+		"SELECT COALESCE(sum(b.length), 0) FROM kv_list_blocks b JOIN kv_keys k ON k.id = b.key_id WHERE k.key = 'mylist'",
+	).Scan(&counted)
+	if err != nil || counted != int64(len(vals)) {
+		t.Errorf("Expected kv_list_blocks to count %d elements, got %d, %v", len(vals), counted, err)
+	}
+}
+
+func TestListIndexesAcrossBlocks(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	// Over 1024 elements a dense list spans several blocks of kv_list_blocks
+	var expected []string
+	for i := 0; i < 5000; i += 500 {
+		batch := make([]interface{}, 500)
+		for j := range batch {
+			batch[j] = fmt.Sprint(i + j)
+		}
+		ts.client.RPush(ctx, "mylist", batch...)
+		for j := range batch {
+			expected = append(expected, fmt.Sprint(i+j))
+		}
+	}
+
+	// Leave the list sparse, with changes in different blocks
+	ts.client.LInsertBefore(ctx, "mylist", "2500", "a")
+	ts.client.LInsertAfter(ctx, "mylist", "4000", "b")
+	ts.client.LRem(ctx, "mylist", 0, "100")
+	ts.client.LPush(ctx, "mylist", "h")
+	ts.client.LPop(ctx, "mylist")
+	ts.client.LPop(ctx, "mylist")
+
+	var want []string
+	for _, v := range expected[1:] {
+		switch v {
+		case "100":
+			continue
+		case "2500":
+			want = append(want, "a")
+		}
+		want = append(want, v)
+		if v == "4000" {
+			want = append(want, "b")
+		}
+	}
+
+	for _, i := range []int{1, 98, 99, 1022, 1023, 1024, 2047, 2498, 2499, 2500, 3998, 3999, 4000, 4001, len(want) - 2} {
+		got, err := ts.client.LIndex(ctx, "mylist", int64(i)).Result()
+		if err != nil || got != want[i] {
+			t.Errorf("LINDEX %d: expected %s, got %s, %v", i, want[i], got, err)
+		}
+	}
+	vals, err := ts.client.LRange(ctx, "mylist", 2495, 2505).Result()
+	if err != nil || strings.Join(vals, ",") != strings.Join(want[2495:2506], ",") {
+		t.Errorf("Expected %v, got %v, %v", want[2495:2506], vals, err)
+	}
+}
+
 // ============== Set Extension Tests ==============
 
 func TestSMIsMember(t *testing.T) {
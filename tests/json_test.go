@@ -0,0 +1,162 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ============== JSON Tests ==============
+
+func TestJSONSetGet(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	if err := ts.client.Do(ctx, "JSON.SET", "doc", "$", `{"a":1,"b":{"c":"hi","d":[1,2,3]}}`).Err(); err != nil {
+		t.Fatalf("JSON.SET failed: %v", err)
+	}
+
+	if v, _ := ts.client.Do(ctx, "JSON.GET", "doc", "$.b.c").Text(); v != `["hi"]` {
+		t.Errorf("Expected [\"hi\"], got %s", v)
+	}
+	if v, _ := ts.client.Do(ctx, "JSON.GET", "doc", ".b.d").Text(); v != `[1,2,3]` {
+		t.Errorf("Expected legacy path value [1,2,3], got %s", v)
+	}
+	if v, _ := ts.client.Do(ctx, "JSON.GET", "doc", "$.b.d[-1]").Text(); v != `[3]` {
+		t.Errorf("Expected [3], got %s", v)
+	}
+	if v, _ := ts.client.Do(ctx, "JSON.GET", "doc", "$..c").Text(); v != `["hi"]` {
+		t.Errorf("Expected recursive match [\"hi\"], got %s", v)
+	}
+	if v, _ := ts.client.Do(ctx, "JSON.GET", "doc", "$.a", "$.b.c").Text(); v != `{"$.a":[1],"$.b.c":["hi"]}` {
+		t.Errorf("Unexpected multi-path result: %s", v)
+	}
+	if v, _ := ts.client.Do(ctx, "JSON.GET", "doc", "INDENT", "  ", "NEWLINE", "\n", "SPACE", " ", ".b.d").Text(); v != "[\n  1,\n  2,\n  3\n]" {
+		t.Errorf("Unexpected formatted result: %q", v)
+	}
+
+	// Update in place, add a new key, NX/XX
+	ts.client.Do(ctx, "JSON.SET", "doc", "$.b.c", `"bye"`)
+	ts.client.Do(ctx, "JSON.SET", "doc", "$.e", `true`)
+	if err := ts.client.Do(ctx, "JSON.SET", "doc", "$.a", `5`, "NX").Err(); err != redis.Nil {
+		t.Errorf("Expected nil for NX on existing path, got %v", err)
+	}
+	if err := ts.client.Do(ctx, "JSON.SET", "doc", "$.missing", `5`, "XX").Err(); err != redis.Nil {
+		t.Errorf("Expected nil for XX on missing path, got %v", err)
+	}
+	if v, _ := ts.client.Do(ctx, "JSON.GET", "doc", "$.b.c", "$.e").Text(); v != `{"$.b.c":["bye"],"$.e":[true]}` {
+		t.Errorf("Unexpected result after updates: %s", v)
+	}
+
+	if err := ts.client.Do(ctx, "JSON.SET", "newdoc", "$.a", `1`).Err(); err == nil {
+		t.Errorf("Expected error creating a new key at a non-root path")
+	}
+	if err := ts.client.Do(ctx, "JSON.GET", "doc", ".nope").Err(); err == nil {
+		t.Errorf("Expected error for missing legacy path")
+	}
+	if err := ts.client.Do(ctx, "JSON.GET", "missing").Err(); err != redis.Nil {
+		t.Errorf("Expected nil for missing key, got %v", err)
+	}
+
+	if typ, _ := ts.client.Type(ctx, "doc").Result(); typ != "ReJSON-RL" {
+		t.Errorf("Expected TYPE ReJSON-RL, got %s", typ)
+	}
+	ts.client.Set(ctx, "str", "x", 0)
+	if err := ts.client.Do(ctx, "JSON.GET", "str").Err(); err == nil {
+		t.Errorf("Expected WRONGTYPE for string key")
+	}
+}
+
+func TestJSONDelMGet(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	ts.client.Do(ctx, "JSON.SET", "a", "$", `{"x":[1,2,3,4],"y":{"x":1}}`)
+	ts.client.Do(ctx, "JSON.SET", "b", "$", `{"x":"b"}`)
+
+	n, err := ts.client.Do(ctx, "JSON.DEL", "a", "$.x[*]").Int64()
+	if err != nil || n != 4 {
+		t.Fatalf("Expected 4 deleted, got %d (%v)", n, err)
+	}
+	if v, _ := ts.client.Do(ctx, "JSON.GET", "a").Text(); v != `{"x":[],"y":{"x":1}}` {
+		t.Errorf("Unexpected document after delete: %s", v)
+	}
+
+	vals, err := ts.client.Do(ctx, "JSON.MGET", "a", "b", "missing", "$.x").Slice()
+	if err != nil {
+		t.Fatalf("JSON.MGET failed: %v", err)
+	}
+	if len(vals) != 3 || vals[0] != `[[]]` || vals[1] != `["b"]` || vals[2] != nil {
+		t.Errorf("Unexpected JSON.MGET result: %v", vals)
+	}
+
+	if n, _ := ts.client.Do(ctx, "JSON.DEL", "a").Int64(); n != 1 {
+		t.Errorf("Expected root delete to return 1, got %d", n)
+	}
+	if ts.client.Exists(ctx, "a").Val() != 0 {
+		t.Errorf("Root delete should remove the key")
+	}
+}
+
+func TestJSONNumbersArraysInspection(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	ts.client.Do(ctx, "JSON.SET", "doc", "$", `{"n":1,"f":1.5,"s":"hello","arr":[1],"obj":{"k1":1,"k2":2},"nested":{"n":10}}`)
+
+	if v, _ := ts.client.Do(ctx, "JSON.NUMINCRBY", "doc", "$.n", "2").Text(); v != `[3]` {
+		t.Errorf("Expected [3], got %s", v)
+	}
+	if v, _ := ts.client.Do(ctx, "JSON.NUMINCRBY", "doc", ".f", "1").Text(); v != `2.5` {
+		t.Errorf("Expected 2.5, got %s", v)
+	}
+	if v, _ := ts.client.Do(ctx, "JSON.NUMINCRBY", "doc", "$..n", "1").Text(); v != `[4,11]` {
+		t.Errorf("Expected [4,11], got %s", v)
+	}
+	if v, _ := ts.client.Do(ctx, "JSON.NUMINCRBY", "doc", "$.s", "1").Text(); v != `[null]` {
+		t.Errorf("Expected [null] for a string, got %s", v)
+	}
+
+	lens, err := ts.client.Do(ctx, "JSON.ARRAPPEND", "doc", "$.arr", `2`, `"three"`).Slice()
+	if err != nil || len(lens) != 1 || lens[0] != int64(3) {
+		t.Errorf("Unexpected JSON.ARRAPPEND result: %v (%v)", lens, err)
+	}
+	if n, _ := ts.client.Do(ctx, "JSON.ARRLEN", "doc", ".arr").Int64(); n != 3 {
+		t.Errorf("Expected ARRLEN 3, got %d", n)
+	}
+	if err := ts.client.Do(ctx, "JSON.ARRLEN", "doc", ".s").Err(); err == nil {
+		t.Errorf("Expected error for ARRLEN on a string with a legacy path")
+	}
+
+	if n, _ := ts.client.Do(ctx, "JSON.STRLEN", "doc", ".s").Int64(); n != 5 {
+		t.Errorf("Expected STRLEN 5, got %d", n)
+	}
+
+	keys, _ := ts.client.Do(ctx, "JSON.OBJKEYS", "doc", ".obj").StringSlice()
+	if len(keys) != 2 || keys[0] != "k1" || keys[1] != "k2" {
+		t.Errorf("Unexpected OBJKEYS: %v", keys)
+	}
+
+	if typ, _ := ts.client.Do(ctx, "JSON.TYPE", "doc").Text(); typ != "object" {
+		t.Errorf("Expected object, got %s", typ)
+	}
+	if typ, _ := ts.client.Do(ctx, "JSON.TYPE", "doc", ".n").Text(); typ != "integer" {
+		t.Errorf("Expected integer, got %s", typ)
+	}
+	if typ, _ := ts.client.Do(ctx, "JSON.TYPE", "doc", ".f").Text(); typ != "number" {
+		t.Errorf("Expected number, got %s", typ)
+	}
+
+	if err := ts.client.Do(ctx, "JSON.NUMINCRBY", "missing", "$.n", "1").Err(); err == nil {
+		t.Errorf("Expected error for missing key")
+	}
+}
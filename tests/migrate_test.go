@@ -0,0 +1,73 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// ============== Schema Migration Tests ==============
+
+func TestSchemaMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	// Stores starting together must not race applying migrations
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store, err := storage.New(ctx, testStorageConfig())
+			if err == nil {
+				store.Close()
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Concurrent startup failed: %v", err)
+		}
+	}
+
+	m, err := storage.NewMigrator(ctx, testStorageConfig())
+	if err != nil {
+		t.Fatalf("NewMigrator failed: %v", err)
+	}
+	defer m.Close()
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if len(statuses) == 0 {
+		t.Fatal("Expected migrations in status")
+	}
+	for _, st := range statuses {
+		if !st.Applied || st.Modified || st.Unknown {
+			t.Errorf("Expected %s to be applied, got %+v", st, st)
+		}
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected no pending migrations, applied %v", applied)
+	}
+
+	cfg := testStorageConfig()
+	cfg.Migrate = storage.MigrateCheck
+	store, err := storage.New(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Expected startup with PG_MIGRATE=check to succeed on an up to date schema: %v", err)
+	}
+	store.Close()
+}
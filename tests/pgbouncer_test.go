@@ -0,0 +1,171 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// ============== PgBouncer Mode Tests ==============
+
+// TestPgBouncerModeSimpleProtocol runs commands of every type with the
+// simple protocol PgBouncer mode uses
+func TestPgBouncerModeSimpleProtocol(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := testStorageConfig()
+	cfg.PgBouncer = true
+	store, err := storage.New(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create store in PgBouncer mode: %v", err)
+	}
+	defer store.Close()
+	if err := store.FlushAll(ctx); err != nil {
+		t.Fatalf("Failed to flush database: %v", err)
+	}
+
+	if err := store.Set(ctx, "pgb:str", "it's \x00 binary", 0); err != nil {
+		t.Fatalf("SET failed: %v", err)
+	}
+	if val, _, err := store.Get(ctx, "pgb:str"); err != nil || val != "it's \x00 binary" {
+		t.Errorf("Expected the value back unchanged, got %q, %v", val, err)
+	}
+	if vals, err := store.MGet(ctx, []string{"pgb:str", "pgb:missing"}); err != nil || len(vals) != 2 {
+		t.Errorf("MGET failed: %v, %v", vals, err)
+	}
+	if _, err := store.HSet(ctx, "pgb:hash", map[string]string{"a": "1", "b": "2"}); err != nil {
+		t.Fatalf("HSET failed: %v", err)
+	}
+	if all, err := store.HGetAll(ctx, "pgb:hash"); err != nil || all["b"] != "2" {
+		t.Errorf("Expected b=2, got %v, %v", all, err)
+	}
+	if _, err := store.RPush(ctx, "pgb:list", []string{"x", "y"}); err != nil {
+		t.Fatalf("RPUSH failed: %v", err)
+	}
+	if vals, err := store.LRange(ctx, "pgb:list", 0, -1); err != nil || len(vals) != 2 {
+		t.Errorf("Expected 2 list elements, got %v, %v", vals, err)
+	}
+	if _, err := store.SAdd(ctx, "pgb:set", []string{"m"}); err != nil {
+		t.Fatalf("SADD failed: %v", err)
+	}
+	if _, err := store.ZAdd(ctx, "pgb:zset", []storage.ZMember{{Member: "m", Score: 1.5}}); err != nil {
+		t.Fatalf("ZADD failed: %v", err)
+	}
+	if score, found, err := store.ZScore(ctx, "pgb:zset", "m"); err != nil || !found || score != 1.5 {
+		t.Errorf("Expected score 1.5, got %v, %v, %v", score, found, err)
+	}
+	if _, keys, err := store.Scan(ctx, 0, "pgb:*", 10, ""); err != nil || len(keys) != 5 {
+		t.Errorf("Expected SCAN to return 5 keys, got %v, %v", keys, err)
+	}
+}
+
+// TestPgBouncerModeScanPages follows SCAN cursors across pages in PgBouncer
+// mode, where cursor positions are sent as literals
+func TestPgBouncerModeScanPages(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := testStorageConfig()
+	cfg.PgBouncer = true
+	store, err := storage.New(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create store in PgBouncer mode: %v", err)
+	}
+	defer store.Close()
+	if err := store.FlushAll(ctx); err != nil {
+		t.Fatalf("Failed to flush database: %v", err)
+	}
+
+	const n = 50
+	fields := make(map[string]string, n)
+	members := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if err := store.Set(ctx, fmt.Sprintf("pgb:page:%d", i), "v", 0); err != nil {
+			t.Fatalf("SET failed: %v", err)
+		}
+		fields[fmt.Sprintf("f%d", i)] = "v"
+		members = append(members, fmt.Sprintf("m%d", i))
+	}
+	if _, err := store.HSet(ctx, "pgb:hash", fields); err != nil {
+		t.Fatalf("HSET failed: %v", err)
+	}
+	if _, err := store.SAdd(ctx, "pgb:set", members); err != nil {
+		t.Fatalf("SADD failed: %v", err)
+	}
+
+	seen := map[string]int{}
+	pages := 0
+	for cursor := uint64(0); ; {
+		next, keys, err := store.Scan(ctx, cursor, "pgb:page:*", 7, "")
+		if err != nil {
+			t.Fatalf("SCAN failed: %v", err)
+		}
+		for _, key := range keys {
+			seen[key]++
+		}
+		pages++
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != n || pages < 2 {
+		t.Errorf("Expected %d keys over several pages, got %d over %d", n, len(seen), pages)
+	}
+	for key, times := range seen {
+		if times != 1 {
+			t.Errorf("SCAN returned %s %d times", key, times)
+		}
+	}
+
+	seen = map[string]int{}
+	for cursor := uint64(0); ; {
+		next, page, err := store.HScan(ctx, "pgb:hash", cursor, "*", 7)
+		if err != nil {
+			t.Fatalf("HSCAN failed: %v", err)
+		}
+		for _, f := range page {
+			seen[f.Field]++
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != n {
+		t.Errorf("Expected %d fields from HSCAN, got %d", n, len(seen))
+	}
+	for field, times := range seen {
+		if times != 1 {
+			t.Errorf("HSCAN returned %s %d times", field, times)
+		}
+	}
+
+	seen = map[string]int{}
+	for cursor := uint64(0); ; {
+		next, page, err := store.SScan(ctx, "pgb:set", cursor, "m*", 7)
+		if err != nil {
+			t.Fatalf("SSCAN failed: %v", err)
+		}
+		for _, m := range page {
+			seen[m]++
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != n {
+		t.Errorf("Expected %d members from SSCAN, got %d", n, len(seen))
+	}
+}
+
+func TestPgBouncerModeRejectsVolatileKeys(t *testing.T) {
+	cfg := testStorageConfig()
+	cfg.PgBouncer = true
+	cfg.VolatileKeyPatterns = []string{"cache:*"}
+	if store, err := storage.New(context.Background(), cfg); err == nil {
+		store.Close()
+		t.Fatal("Expected an error for volatile key patterns in PgBouncer mode")
+	}
+}
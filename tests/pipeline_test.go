@@ -0,0 +1,184 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mnorrsken/postkeys/internal/cache"
+	"github.com/mnorrsken/postkeys/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// ============== Pipeline Tests ==============
+
+func TestPipelineBatch(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	pipe := ts.client.Pipeline()
+	set := pipe.Set(ctx, "pipe:str", "10", 0)
+	incr := pipe.IncrBy(ctx, "pipe:str", 5)
+	decr := pipe.Decr(ctx, "pipe:counter")
+	hset := pipe.HSet(ctx, "pipe:hash", "a", "1", "b", "2")
+	hget := pipe.HGet(ctx, "pipe:hash", "b")
+	rpush := pipe.RPush(ctx, "pipe:list", "x", "y")
+	lpush := pipe.LPush(ctx, "pipe:list", "w")
+	sadd := pipe.SAdd(ctx, "pipe:set", "m", "n", "m")
+	get := pipe.Get(ctx, "pipe:str")
+	missing := pipe.Get(ctx, "pipe:missing")
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+
+	if set.Val() != "OK" {
+		t.Errorf("Expected SET to return OK, got %q", set.Val())
+	}
+	if incr.Val() != 15 {
+		t.Errorf("Expected INCRBY to return 15, got %d", incr.Val())
+	}
+	if decr.Val() != -1 {
+		t.Errorf("Expected DECR to return -1, got %d", decr.Val())
+	}
+	if hset.Val() != 2 {
+		t.Errorf("Expected HSET to add 2 fields, got %d", hset.Val())
+	}
+	if hget.Val() != "2" {
+		t.Errorf("Expected HGET to return 2, got %q", hget.Val())
+	}
+	if rpush.Val() != 2 || lpush.Val() != 3 {
+		t.Errorf("Expected RPUSH and LPUSH to return 2 and 3, got %d and %d", rpush.Val(), lpush.Val())
+	}
+	if sadd.Val() != 2 {
+		t.Errorf("Expected SADD to add 2 members, got %d", sadd.Val())
+	}
+	if get.Val() != "15" {
+		t.Errorf("Expected GET to see the INCRBY, got %q", get.Val())
+	}
+	if missing.Err() != redis.Nil {
+		t.Errorf("Expected a nil reply for a missing key, got %v", missing.Err())
+	}
+	if list := ts.client.LRange(ctx, "pipe:list", 0, -1).Val(); fmt.Sprint(list) != "[w x y]" {
+		t.Errorf("Expected [w x y], got %v", list)
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+	ts.client.SAdd(ctx, "pipe:set", "a")
+
+	// The WRONGTYPE rolls back the batch, which runs again command by
+	// command: the commands around it must still take effect
+	pipe := ts.client.Pipeline()
+	before := pipe.Incr(ctx, "pipe:n")
+	wrong := pipe.LPush(ctx, "pipe:set", "x")
+	after := pipe.Set(ctx, "pipe:str", "v", 0)
+	notInt := pipe.Incr(ctx, "pipe:str")
+	pipe.Exec(ctx)
+
+	if before.Val() != 1 {
+		t.Errorf("Expected INCR to return 1, got %d", before.Val())
+	}
+	if err := wrong.Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Errorf("Expected WRONGTYPE, got %v", err)
+	}
+	if after.Val() != "OK" {
+		t.Errorf("Expected SET after the error to return OK, got %v", after.Err())
+	}
+	if err := notInt.Err(); err == nil || !strings.Contains(err.Error(), "not an integer") {
+		t.Errorf("Expected value is not an integer, got %v", err)
+	}
+	if n, _ := ts.client.Get(ctx, "pipe:n").Int(); n != 1 {
+		t.Errorf("Expected INCR to apply once, got %d", n)
+	}
+	if v := ts.client.Get(ctx, "pipe:str").Val(); v != "v" {
+		t.Errorf("Expected 'v', got %q", v)
+	}
+}
+
+func TestPipelineMixed(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	// Commands that can't be batched end a batch and run on their own
+	pipe := ts.client.Pipeline()
+	pipe.Set(ctx, "pipe:a", "1", 0)
+	set := pipe.Set(ctx, "pipe:b", "2", 0)
+	ttl := pipe.Expire(ctx, "pipe:b", 100*time.Second)
+	pipe.Incr(ctx, "pipe:a")
+	mget := pipe.MGet(ctx, "pipe:a", "pipe:b")
+	for i := 0; i < 300; i++ {
+		pipe.Incr(ctx, "pipe:many")
+	}
+	many := pipe.Get(ctx, "pipe:many")
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatalf("Pipeline failed: %v", err)
+	}
+
+	if set.Val() != "OK" || !ttl.Val() {
+		t.Errorf("Expected SET and EXPIRE to succeed, got %v, %v", set.Err(), ttl.Err())
+	}
+	if fmt.Sprint(mget.Val()) != "[2 2]" {
+		t.Errorf("Expected [2 2], got %v", mget.Val())
+	}
+	if many.Val() != "300" {
+		t.Errorf("Expected 300 increments, got %q", many.Val())
+	}
+}
+
+func TestPipelineCachedStore(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := storage.New(ctx, testStorageConfig())
+	if err != nil {
+		t.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	if err := store.FlushAll(ctx); err != nil {
+		store.Close()
+		t.Fatalf("Failed to flush database: %v", err)
+	}
+	cs := cache.NewCachedStore(store, cache.Config{TTL: time.Minute, MaxSize: 100})
+	defer cs.Close()
+
+	if err := store.Set(ctx, "pipe:cached", "v1", 0); err != nil {
+		t.Fatalf("SET failed: %v", err)
+	}
+
+	// A batched GET fills the cache
+	b := cs.NewBatch(ctx)
+	if b == nil {
+		t.Fatal("Expected the cached store to run batches")
+	}
+	var got string
+	b.Get("pipe:cached", func(value string, found bool) { got = value })
+	if err := cs.SendBatch(ctx, b); err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	if cached, found := cs.GetCache().Get("pipe:cached"); got != "v1" || !found || cached != "v1" {
+		t.Errorf("Expected v1 read and cached, got %q and %q, %v", got, cached, found)
+	}
+
+	// A batched write invalidates it
+	b = cs.NewBatch(ctx)
+	b.Set("pipe:cached", "v2", func() {})
+	if err := cs.SendBatch(ctx, b); err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	if _, found := cs.GetCache().Get("pipe:cached"); found {
+		t.Error("Expected the batched SET to invalidate the cached value")
+	}
+	if val, _, err := cs.Get(ctx, "pipe:cached"); err != nil || val != "v2" {
+		t.Errorf("Expected v2, got %q, %v", val, err)
+	}
+}
@@ -33,11 +33,9 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
-func newPgTestServer(t testing.TB) *pgTestServer {
-	ctx := context.Background()
-
-	// PostgreSQL connection config from environment
-	cfg := storage.Config{
+// pgBenchConfig returns the PostgreSQL connection config from environment
+func pgBenchConfig() storage.Config {
+	return storage.Config{
 		Host:     getEnvOrDefault("PG_HOST", "localhost"),
 		Port:     5789, // Use test port
 		User:     getEnvOrDefault("PG_USER", "postgres"),
@@ -45,8 +43,12 @@ func newPgTestServer(t testing.TB) *pgTestServer {
 		Database: getEnvOrDefault("PG_DATABASE", "postgres"),
 		SSLMode:  getEnvOrDefault("PG_SSLMODE", "disable"),
 	}
+}
 
-	store, err := storage.New(ctx, cfg)
+func newPgTestServer(t testing.TB) *pgTestServer {
+	ctx := context.Background()
+
+	store, err := storage.New(ctx, pgBenchConfig())
 	if err != nil {
 		t.Fatalf("Failed to connect to PostgreSQL: %v", err)
 	}
@@ -298,3 +300,165 @@ func BenchmarkPgPipeline(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkPgCommandFunctions compares the writes backed by pk_* functions,
+// called as one statement, with their individual queries in a transaction:
+// each command runs as <command>/functions and <command>/queries
+func BenchmarkPgCommandFunctions(b *testing.B) {
+	ctx := context.Background()
+
+	commands := []struct {
+		name string
+		run  func(store *storage.Store, i int) error
+	}{
+		{"Set", func(store *storage.Store, i int) error {
+			return store.Set(ctx, fmt.Sprintf("fn_key_%d", i%1000), "value", 0)
+		}},
+		{"Incr", func(store *storage.Store, i int) error {
+			_, err := store.Incr(ctx, "fn_counter", 1)
+			return err
+		}},
+		{"HSet", func(store *storage.Store, i int) error {
+			_, err := store.HSet(ctx, "fn_hash", map[string]string{fmt.Sprintf("field_%d", i%100): "value"})
+			return err
+		}},
+		{"LPush", func(store *storage.Store, i int) error {
+			_, err := store.LPush(ctx, fmt.Sprintf("fn_list_%d", i/100), []string{"value"})
+			return err
+		}},
+		{"RPush", func(store *storage.Store, i int) error {
+			_, err := store.RPush(ctx, fmt.Sprintf("fn_list_%d", i/100), []string{"value"})
+			return err
+		}},
+		{"SAdd", func(store *storage.Store, i int) error {
+			_, err := store.SAdd(ctx, "fn_set", []string{fmt.Sprintf("member_%d", i%1000)})
+			return err
+		}},
+	}
+
+	for _, mode := range []struct {
+		name    string
+		disable bool
+	}{{"functions", false}, {"queries", true}} {
+		cfg := pgBenchConfig()
+		cfg.DisableFunctions = mode.disable
+		store, err := storage.New(ctx, cfg)
+		if err != nil {
+			b.Fatalf("Failed to connect to PostgreSQL: %v", err)
+		}
+
+		for _, cmd := range commands {
+			b.Run(cmd.name+"/"+mode.name, func(b *testing.B) {
+				cleanupStore(ctx, store)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := cmd.run(store, i); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+
+		cleanupStore(ctx, store)
+		store.Close()
+	}
+}
+
+// BenchmarkPgLongList measures index-based access to a 1M-element list, first
+// while it is dense and then, after LINSERTs have put elements between
+// others, while its positions are sparse
+func BenchmarkPgLongList(b *testing.B) {
+	ctx := context.Background()
+
+	store, err := storage.New(ctx, pgBenchConfig())
+	if err != nil {
+		b.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer store.Close()
+	cleanupStore(ctx, store)
+	defer cleanupStore(ctx, store)
+
+	const length = 1_000_000
+	const key = "bench_long_list"
+	batch := make([]string, 0, 10_000)
+	for i := 0; i < length; i++ {
+		batch = append(batch, fmt.Sprintf("value_%d", i))
+		if len(batch) == cap(batch) {
+			if _, err := store.RPush(ctx, key, batch); err != nil {
+				b.Fatalf("RPUSH failed: %v", err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	reads := []struct {
+		name string
+		run  func(i int) error
+	}{
+		{"LIndex/head", func(i int) error {
+			_, _, err := store.LIndex(ctx, key, 0)
+			return err
+		}},
+		{"LIndex/middle", func(i int) error {
+			_, _, err := store.LIndex(ctx, key, length/2)
+			return err
+		}},
+		{"LIndex/tail", func(i int) error {
+			_, _, err := store.LIndex(ctx, key, -1)
+			return err
+		}},
+		{"LRange/tail", func(i int) error {
+			_, err := store.LRange(ctx, key, -100, -1)
+			return err
+		}},
+		{"LSet/middle", func(i int) error {
+			return store.LSet(ctx, key, length/2, "updated")
+		}},
+		{"LLen", func(i int) error {
+			_, err := store.LLen(ctx, key)
+			return err
+		}},
+	}
+	run := func(name string, fn func(i int) error) {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := fn(i); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	for _, r := range reads {
+		run(r.name+"/dense", r.run)
+	}
+
+	// A queue: the list keeps its length
+	run("RPushLPop", func(i int) error {
+		if _, err := store.RPush(ctx, key, []string{fmt.Sprintf("value_%d", length+i)}); err != nil {
+			return err
+		}
+		_, _, err := store.LPop(ctx, key)
+		return err
+	})
+
+	// A single LINSERT in the middle leaves the list sparse
+	if _, err := store.LInsert(ctx, key, fmt.Sprintf("value_%d", length/2), "inserted", true); err != nil {
+		b.Fatalf("LINSERT failed: %v", err)
+	}
+	for _, r := range reads {
+		run(r.name+"/linserted", r.run)
+	}
+
+	// LINSERT scans for its pivot, like in Redis; spreading the pivots out
+	// leaves room between each and its neighbour
+	run("LInsert", func(i int) error {
+		pivot := fmt.Sprintf("value_%d", length/2+(i*7919)%(length/4))
+		_, err := store.LInsert(ctx, key, pivot, "inserted", true)
+		return err
+	})
+
+	for _, r := range reads {
+		run(r.name+"/sparse", r.run)
+	}
+}
@@ -44,7 +44,7 @@ func newPubSubTestServer(t *testing.T) (*server.Server, *storage.Store, string)
 	srv := server.NewWithDebug(":0", h, false)
 
 	// Create and start pub/sub hub
-	hub := pubsub.NewHub(store.Pool(), store.ConnString())
+	hub := pubsub.NewHub(store.Pool(), store.ConnConfig())
 	if err := hub.Start(ctx); err != nil {
 		store.Close()
 		t.Fatalf("Failed to start pub/sub hub: %v", err)
@@ -326,6 +326,192 @@ func TestPubSubConcurrent(t *testing.T) {
 	})
 }
 
+func TestPubSubPatternAcrossServers(t *testing.T) {
+	srvA, storeA, addrA := newPubSubTestServer(t)
+	defer srvA.Stop()
+	defer storeA.Close()
+	srvB, storeB, addrB := newPubSubTestServer(t)
+	defer srvB.Stop()
+	defer storeB.Close()
+
+	// Pattern subscriber on server A, with no exact subscriber anywhere
+	subConn, err := net.Dial("tcp", addrA)
+	if err != nil {
+		t.Fatalf("Failed to connect subscriber: %v", err)
+	}
+	defer subConn.Close()
+	subReader := bufio.NewReader(subConn)
+
+	sendCommand(subConn, "PSUBSCRIBE", "orders.*")
+	readArrayPubSubWithReader(subReader, 3)
+	time.Sleep(200 * time.Millisecond)
+
+	// Publish from server B, including a channel long enough to be hashed
+	pubConn, err := net.Dial("tcp", addrB)
+	if err != nil {
+		t.Fatalf("Failed to connect publisher: %v", err)
+	}
+	defer pubConn.Close()
+	pubReader := bufio.NewReader(pubConn)
+
+	longChannel := "orders." + strings.Repeat("x", 80)
+	for _, channel := range []string{"orders.created", longChannel, "invoices.created"} {
+		sendCommand(pubConn, "PUBLISH", channel, "payload:"+channel)
+		pubReader.ReadString('\n')
+	}
+
+	subConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, channel := range []string{"orders.created", longChannel} {
+		msg := readArrayPubSubWithReader(subReader, 4)
+		if len(msg) != 4 || msg[0] != "pmessage" || msg[1] != "orders.*" || msg[2] != channel || msg[3] != "payload:"+channel {
+			t.Errorf("Got %v, want pmessage on %s", msg, channel)
+		}
+	}
+
+	// The non-matching channel must not be delivered
+	subConn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if msg := readArrayPubSubWithReader(subReader, 4); len(msg) != 0 {
+		t.Errorf("Unexpected message: %v", msg)
+	}
+}
+
+func TestPubSubPatternListeners(t *testing.T) {
+	srv, store, addr := newPubSubTestServer(t)
+	defer srv.Stop()
+	defer store.Close()
+	ctx := context.Background()
+
+	// Hubs with pattern subscriptions are registered, so publishers know to
+	// send the broadcast copy
+	listeners := func() int {
+		var n int
+		err := store.Pool().QueryRow(ctx,
+			"SELECT count(*) FROM kv_pattern_listeners WHERE pid IN (SELECT pid FROM pg_stat_activity)").Scan(&n)
+		if err != nil {
+			t.Fatalf("Failed to count pattern listeners: %v", err)
+		}
+		return n
+	}
+	waitFor := func(want func(int) bool, what string) int {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			n := listeners()
+			if want(n) {
+				return n
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s, %d registered", what, n)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	before := listeners()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	sendCommand(conn, "PSUBSCRIBE", "orders.*")
+	readArrayPubSubWithReader(reader, 3)
+	registered := waitFor(func(n int) bool { return n > before }, "the hub to register")
+
+	sendCommand(conn, "PUNSUBSCRIBE", "orders.*")
+	readArrayPubSubWithReader(reader, 3)
+	waitFor(func(n int) bool { return n < registered }, "the hub to unregister")
+}
+
+func TestPubSubMessageTooLarge(t *testing.T) {
+	srv, store, addr := newPubSubTestServer(t)
+	defer srv.Stop()
+	defer store.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// The second message fits a NOTIFY payload on its own, but not together
+	// with its channel name on the broadcast channel
+	for _, message := range []string{strings.Repeat("x", 8000), strings.Repeat("x", 7990)} {
+		sendCommand(conn, "PUBLISH", "orders.created", message)
+		reply, _ := reader.ReadString('\n')
+		if !strings.HasPrefix(reply, "-ERR message too large") {
+			t.Errorf("Expected a message too large error for %d bytes, got %q", len(message), reply)
+		}
+	}
+
+	sendCommand(conn, "PUBLISH", "orders.created", strings.Repeat("x", 7900))
+	if reply, _ := reader.ReadString('\n'); reply != ":0\r\n" {
+		t.Errorf("Expected :0 for a message that fits, got %q", reply)
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	srv, store, addr := newPubSubTestServer(t)
+	defer srv.Stop()
+	defer store.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	sendCommand(conn, "CONFIG", "SET", "notify-keyspace-events", "KEA")
+	if line, _ := reader.ReadString('\n'); strings.TrimSpace(line) != "+OK" {
+		t.Fatalf("CONFIG SET failed: %s", line)
+	}
+	defer store.SetNotifyKeyspaceEvents("")
+
+	sendCommand(conn, "CONFIG", "GET", "notify-keyspace-events")
+	if got := readArrayPubSubWithReader(reader, 2); len(got) != 2 || got[1] != "AKE" {
+		t.Errorf("CONFIG GET notify-keyspace-events = %v, want AKE", got)
+	}
+
+	sendCommand(conn, "CONFIG", "SET", "notify-keyspace-events", "KEQ")
+	if line, _ := reader.ReadString('\n'); !strings.HasPrefix(line, "-ERR") {
+		t.Errorf("Expected error for invalid flags, got: %s", line)
+	}
+
+	subConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect subscriber: %v", err)
+	}
+	defer subConn.Close()
+	subReader := bufio.NewReader(subConn)
+
+	sendCommand(subConn, "SUBSCRIBE", "__keyspace@0__:notify:key", "__keyevent@0__:expired")
+	readArrayPubSubWithReader(subReader, 3)
+	readArrayPubSubWithReader(subReader, 3)
+	time.Sleep(200 * time.Millisecond)
+
+	sendCommand(conn, "DEL", "notify:key")
+	reader.ReadString('\n')
+	sendCommand(conn, "SET", "notify:key", "v", "PX", "100")
+	reader.ReadString('\n')
+
+	// Expired events come from the sweeper, which may be backing off
+	subConn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	want := [][]string{
+		{"message", "__keyspace@0__:notify:key", "set"},
+		{"message", "__keyspace@0__:notify:key", "expire"},
+		{"message", "__keyspace@0__:notify:key", "expired"},
+		{"message", "__keyevent@0__:expired", "notify:key"},
+	}
+	for _, w := range want {
+		msg := readArrayPubSubWithReader(subReader, 3)
+		if len(msg) != 3 || msg[0] != w[0] || msg[1] != w[1] || msg[2] != w[2] {
+			t.Errorf("Got %v, want %v", msg, w)
+		}
+	}
+}
+
 // Helper functions for pub/sub tests
 
 func sendCommand(conn net.Conn, args ...string) {
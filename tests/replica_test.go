@@ -0,0 +1,59 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// ============== Read Replica Tests ==============
+
+func TestReplicaReads(t *testing.T) {
+	ctx := context.Background()
+
+	primary, err := storage.New(ctx, testStorageConfig())
+	if err != nil {
+		t.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer primary.Close()
+
+	// The primary stands in for a replica with no lag
+	cfg := testStorageConfig()
+	cfg.ReplicaDSN = primary.ConnString()
+	store, err := storage.New(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create store with a replica: %v", err)
+	}
+	defer store.Close()
+	if err := store.FlushAll(ctx); err != nil {
+		t.Fatalf("Failed to flush database: %v", err)
+	}
+
+	if err := store.Set(ctx, "replica:key", "value", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := store.HSet(ctx, "replica:hash", map[string]string{"f": "v"}); err != nil {
+		t.Fatalf("HSet failed: %v", err)
+	}
+
+	// Give the lag monitor time to allow replica reads
+	time.Sleep(100 * time.Millisecond)
+
+	replicaCtx := storage.WithReplicaReads(ctx, true)
+	if val, found, err := store.Get(replicaCtx, "replica:key"); err != nil || !found || val != "value" {
+		t.Errorf("Expected 'value', got %q, %v, %v", val, found, err)
+	}
+	if val, found, err := store.HGet(replicaCtx, "replica:hash", "f"); err != nil || !found || val != "v" {
+		t.Errorf("Expected 'v', got %q, %v, %v", val, found, err)
+	}
+	if keys, err := store.Keys(replicaCtx, "replica:*"); err != nil || len(keys) != 2 {
+		t.Errorf("Expected 2 keys, got %v, %v", keys, err)
+	}
+	if _, keys, err := store.Scan(replicaCtx, 0, "replica:*", 10, ""); err != nil || len(keys) != 2 {
+		t.Errorf("Expected SCAN to return 2 keys, got %v, %v", keys, err)
+	}
+}
@@ -0,0 +1,50 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// ============== Transaction Retry Tests ==============
+
+// TestRetryAfterLockTimeout holds a row lock past the store's lock_timeout,
+// so the first attempts of an INCR fail with 55P03 and a retry succeeds
+func TestRetryAfterLockTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := testStorageConfig()
+	cfg.LockTimeout = 50 * time.Millisecond
+	store, err := storage.New(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Failed to connect to PostgreSQL: %v", err)
+	}
+	defer store.Close()
+	if err := store.FlushAll(ctx); err != nil {
+		t.Fatalf("Failed to flush database: %v", err)
+	}
+	if err := store.Set(ctx, "retry:counter", "1", 0); err != nil {
+		t.Fatalf("SET failed: %v", err)
+	}
+
+	locker, err := store.Pool().Begin(ctx)
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if _, err := locker.Exec(ctx, "SELECT 1 FROM kv_keys WHERE key = 'retry:counter' FOR UPDATE"); err != nil {
+		t.Fatalf("Failed to lock the row: %v", err)
+	}
+	go func() {
+		time.Sleep(120 * time.Millisecond)
+		locker.Rollback(context.Background())
+	}()
+
+	val, err := store.Incr(ctx, "retry:counter", 1)
+	if err != nil || val != 2 {
+		t.Errorf("Expected INCR to succeed after retrying, got %d, %v", val, err)
+	}
+}
@@ -0,0 +1,169 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mnorrsken/postkeys/internal/pubsub"
+	"github.com/mnorrsken/postkeys/internal/storage"
+)
+
+// ============== Schema (Tenant) Tests ==============
+
+// newSchemaStore creates a store whose tables live in schema
+func newSchemaStore(t *testing.T, schema string) *storage.Store {
+	t.Helper()
+
+	cfg := testStorageConfig()
+	cfg.Schema = schema
+	store, err := storage.New(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Failed to create store in schema %s: %v", schema, err)
+	}
+	t.Cleanup(store.Close)
+	if err := store.FlushAll(context.Background()); err != nil {
+		t.Fatalf("Failed to flush schema %s: %v", schema, err)
+	}
+	return store
+}
+
+func TestSchemaIsolatesKeyspaces(t *testing.T) {
+	ctx := context.Background()
+	a := newSchemaStore(t, "postkeys_test_a")
+	b := newSchemaStore(t, "postkeys_test_b")
+
+	if err := a.Set(ctx, "shared", "a", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := b.Set(ctx, "shared", "b", 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	if val, _, err := a.Get(ctx, "shared"); err != nil || val != "a" {
+		t.Errorf("Expected 'a' in schema a, got %q, %v", val, err)
+	}
+	if err := b.FlushAll(ctx); err != nil {
+		t.Fatalf("FlushAll failed: %v", err)
+	}
+	if val, found, err := a.Get(ctx, "shared"); err != nil || !found || val != "a" {
+		t.Errorf("Expected FLUSHALL in schema b to leave schema a alone, got %q, %v, %v", val, found, err)
+	}
+	if _, found, _ := b.Get(ctx, "shared"); found {
+		t.Error("Expected schema b to be empty after FLUSHALL")
+	}
+}
+
+func TestSchemaRejectsInvalidName(t *testing.T) {
+	cfg := testStorageConfig()
+	cfg.Schema = "Robert'); DROP TABLE kv_meta;--"
+	if store, err := storage.New(context.Background(), cfg); err == nil {
+		store.Close()
+		t.Fatal("Expected an error for an invalid schema name")
+	}
+}
+
+// channelSubscriber collects the messages a hub delivers
+type channelSubscriber struct {
+	id       uint64
+	messages chan string
+}
+
+func (s *channelSubscriber) SendPubSubMessage(msgType, channel, payload string) error {
+	s.messages <- channel + " " + payload
+	return nil
+}
+
+func (s *channelSubscriber) GetID() uint64 { return s.id }
+
+func TestSchemaIsolatesPubSub(t *testing.T) {
+	ctx := context.Background()
+	stores := []*storage.Store{newSchemaStore(t, "postkeys_test_a"), newSchemaStore(t, "postkeys_test_b")}
+
+	var hubs []*pubsub.Hub
+	var subs []*channelSubscriber
+	for i, store := range stores {
+		hub := pubsub.NewHub(store.Pool(), store.ConnConfig())
+		hub.SetNamespace(store.Namespace())
+		if err := hub.Start(ctx); err != nil {
+			t.Fatalf("Failed to start hub: %v", err)
+		}
+		defer hub.Stop()
+
+		sub := &channelSubscriber{id: uint64(i + 1), messages: make(chan string, 10)}
+		hub.Subscribe(sub, "news")
+		hub.PSubscribe(sub, "n*")
+		hubs, subs = append(hubs, hub), append(subs, sub)
+	}
+	// Give the listeners time to LISTEN
+	time.Sleep(200 * time.Millisecond)
+
+	if _, err := hubs[0].Publish(ctx, "news", "hello"); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-subs[0].messages:
+			if msg != "news hello" {
+				t.Errorf("Expected 'news hello', got %q", msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for the message in the publisher's schema")
+		}
+	}
+	select {
+	case msg := <-subs[1].messages:
+		t.Errorf("Expected no message in the other schema, got %q", msg)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestSchemaIsolatesPubSubFromDefault(t *testing.T) {
+	ctx := context.Background()
+	stores := []*storage.Store{newSchemaStore(t, ""), newSchemaStore(t, "postkeys_test_a")}
+
+	var hubs []*pubsub.Hub
+	for _, store := range stores {
+		hub := pubsub.NewHub(store.Pool(), store.ConnConfig())
+		hub.SetNamespace(store.Namespace())
+		if err := hub.Start(ctx); err != nil {
+			t.Fatalf("Failed to start hub: %v", err)
+		}
+		defer hub.Stop()
+		hubs = append(hubs, hub)
+	}
+	sub := &channelSubscriber{id: 1, messages: make(chan string, 10)}
+	hubs[1].Subscribe(sub, "orders", "postkeys_broadcast")
+	hubs[1].PSubscribe(sub, "*")
+	// Give the listeners time to LISTEN
+	time.Sleep(200 * time.Millisecond)
+
+	// The default tenant's channels are namespaced too, so names spelling
+	// out another tenant's prefix stay its own
+	for _, channel := range []string{"postkeys_test_a:orders", "postkeys_test_a:postkeys_broadcast"} {
+		if _, err := hubs[0].Publish(ctx, channel, "forged"); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+	select {
+	case msg := <-sub.messages:
+		t.Errorf("Expected no message from the default schema, got %q", msg)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if _, err := hubs[1].Publish(ctx, "orders", "hello"); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	select {
+	case msg := <-sub.messages:
+		if !strings.HasSuffix(msg, "orders hello") {
+			t.Errorf("Expected 'orders hello', got %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the message in the tenant's own schema")
+	}
+}
@@ -0,0 +1,170 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"testing"
+)
+
+// ============== Search Tests ==============
+
+// createProductIndex creates an index over product:* hashes and drops it when the test ends,
+// since index definitions survive FLUSHDB
+func createProductIndex(t *testing.T, ts *testServer) {
+	t.Helper()
+	ctx := context.Background()
+
+	ts.client.Do(ctx, "FT.DROPINDEX", "products")
+	err := ts.client.Do(ctx, "FT.CREATE", "products", "ON", "HASH", "PREFIX", "1", "product:",
+		"SCHEMA", "name", "TEXT", "SORTABLE", "price", "NUMERIC", "SORTABLE", "tags", "TAG", "SEPARATOR", ",").Err()
+	if err != nil {
+		t.Fatalf("FT.CREATE failed: %v", err)
+	}
+	t.Cleanup(func() { ts.client.Do(context.Background(), "FT.DROPINDEX", "products") })
+
+	ts.client.HSet(ctx, "product:1", "name", "Red running shoes", "price", "80", "tags", "shoes,Sale")
+	ts.client.HSet(ctx, "product:2", "name", "Blue running shorts", "price", "35", "tags", "clothing")
+	ts.client.HSet(ctx, "product:3", "name", "Walking shoes", "price", "120", "tags", "shoes")
+	ts.client.HSet(ctx, "other:1", "name", "Red running shoes", "price", "1")
+}
+
+func searchKeys(t *testing.T, ts *testServer, args ...interface{}) (int64, []string) {
+	t.Helper()
+	res, err := ts.client.Do(context.Background(), append([]interface{}{"FT.SEARCH", "products"}, args...)...).Slice()
+	if err != nil {
+		t.Fatalf("FT.SEARCH %v failed: %v", args, err)
+	}
+	var keys []string
+	for _, v := range res[1:] {
+		if key, ok := v.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return res[0].(int64), keys
+}
+
+func TestFTSearchQueries(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+	createProductIndex(t, ts)
+
+	tests := []struct {
+		args []interface{}
+		want []string
+	}{
+		{[]interface{}{"*"}, []string{"product:1", "product:2", "product:3"}},
+		{[]interface{}{"running"}, []string{"product:1", "product:2"}},
+		{[]interface{}{"running shoes"}, []string{"product:1"}},
+		{[]interface{}{"shoe*"}, []string{"product:1", "product:3"}},
+		{[]interface{}{"running -red"}, []string{"product:2"}},
+		{[]interface{}{"walking|blue"}, []string{"product:2", "product:3"}},
+		{[]interface{}{`"running shoes"`}, []string{"product:1"}},
+		{[]interface{}{"@price:[50 200]"}, []string{"product:1", "product:3"}},
+		{[]interface{}{"@price:[(80 +inf]"}, []string{"product:3"}},
+		{[]interface{}{"@tags:{sale | clothing}"}, []string{"product:1", "product:2"}},
+		{[]interface{}{"@name:shoes @tags:{shoes} @price:[-inf 100]"}, []string{"product:1"}},
+		{[]interface{}{"*", "SORTBY", "price", "DESC"}, []string{"product:3", "product:1", "product:2"}},
+		{[]interface{}{"*", "SORTBY", "price", "LIMIT", "1", "1"}, []string{"product:1"}},
+	}
+
+	for _, tt := range tests {
+		_, keys := searchKeys(t, ts, tt.args...)
+		if len(keys) != len(tt.want) {
+			t.Errorf("FT.SEARCH %v = %v, want %v", tt.args, keys, tt.want)
+			continue
+		}
+		for i := range keys {
+			if keys[i] != tt.want[i] {
+				t.Errorf("FT.SEARCH %v = %v, want %v", tt.args, keys, tt.want)
+				break
+			}
+		}
+	}
+
+	if total, keys := searchKeys(t, ts, "*", "LIMIT", "0", "0"); total != 3 || len(keys) != 0 {
+		t.Errorf("LIMIT 0 0 should only count matches, got %d %v", total, keys)
+	}
+}
+
+func TestFTSearchReplyShape(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+	createProductIndex(t, ts)
+
+	ctx := context.Background()
+	res, err := ts.client.Do(ctx, "FT.SEARCH", "products", "@price:[30 40]", "RETURN", "1", "price").Slice()
+	if err != nil {
+		t.Fatalf("FT.SEARCH failed: %v", err)
+	}
+	if len(res) != 3 || res[0] != int64(1) || res[1] != "product:2" {
+		t.Fatalf("Unexpected reply: %v", res)
+	}
+	fields := res[2].([]interface{})
+	if len(fields) != 2 || fields[0] != "price" || fields[1] != "35" {
+		t.Errorf("Expected only the returned field, got %v", fields)
+	}
+
+	res, _ = ts.client.Do(ctx, "FT.SEARCH", "products", "walking", "NOCONTENT").Slice()
+	if len(res) != 2 || res[1] != "product:3" {
+		t.Errorf("Unexpected NOCONTENT reply: %v", res)
+	}
+
+	// Expired and non-hash keys under the prefix are not matched
+	ts.client.Set(ctx, "product:4", "shoes", 0)
+	ts.client.Del(ctx, "product:3")
+	if total, _ := searchKeys(t, ts, "shoes"); total != 1 {
+		t.Errorf("Expected 1 match after delete, got %d", total)
+	}
+
+	if err := ts.client.Do(ctx, "FT.SEARCH", "products", "@nope:x").Err(); err == nil {
+		t.Errorf("Expected error for an unknown field")
+	}
+	if err := ts.client.Do(ctx, "FT.SEARCH", "missing", "*").Err(); err == nil {
+		t.Errorf("Expected error for an unknown index")
+	}
+}
+
+func TestFTInfoDropIndex(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+	createProductIndex(t, ts)
+
+	ctx := context.Background()
+	if err := ts.client.Do(ctx, "FT.CREATE", "products", "SCHEMA", "name", "TEXT").Err(); err == nil {
+		t.Errorf("Expected error creating an existing index")
+	}
+
+	info, err := ts.client.Do(ctx, "FT.INFO", "products").Slice()
+	if err != nil {
+		t.Fatalf("FT.INFO failed: %v", err)
+	}
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(info); i += 2 {
+		fields[info[i].(string)] = info[i+1]
+	}
+	if fields["index_name"] != "products" || fields["num_docs"] != int64(3) {
+		t.Errorf("Unexpected FT.INFO: %v", info)
+	}
+	if attrs := fields["attributes"].([]interface{}); len(attrs) != 3 {
+		t.Errorf("Expected 3 attributes, got %v", attrs)
+	}
+
+	names, _ := ts.client.Do(ctx, "FT._LIST").StringSlice()
+	if len(names) != 1 || names[0] != "products" {
+		t.Errorf("Unexpected FT._LIST: %v", names)
+	}
+
+	if err := ts.client.Do(ctx, "FT.DROPINDEX", "products", "DD").Err(); err != nil {
+		t.Fatalf("FT.DROPINDEX failed: %v", err)
+	}
+	if n := ts.client.Exists(ctx, "product:1", "product:2", "product:3").Val(); n != 0 {
+		t.Errorf("DD should delete indexed hashes, %d left", n)
+	}
+	if ts.client.Exists(ctx, "other:1").Val() != 1 {
+		t.Errorf("Hashes outside the prefix should be kept")
+	}
+	if err := ts.client.Do(ctx, "FT.INFO", "products").Err(); err == nil {
+		t.Errorf("Expected error for a dropped index")
+	}
+}
@@ -0,0 +1,606 @@
+//go:build postgres
+
+package integration_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ============== Stream Tests ==============
+
+func TestXAddXRange(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	// Explicit IDs
+	id, err := ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "mystream", ID: "1-1", Values: []string{"a", "1"}}).Result()
+	if err != nil {
+		t.Fatalf("XADD failed: %v", err)
+	}
+	if id != "1-1" {
+		t.Errorf("Expected 1-1, got %s", id)
+	}
+	ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "mystream", ID: "1-2", Values: []string{"b", "2"}})
+	ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "mystream", ID: "2-0", Values: []string{"c", "3", "d", "4"}})
+
+	// IDs must be increasing
+	err = ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "mystream", ID: "1-5", Values: []string{"x", "y"}}).Err()
+	if err == nil || !strings.Contains(err.Error(), "equal or smaller") {
+		t.Errorf("Expected 'equal or smaller' error, got %v", err)
+	}
+
+	// Auto-sequence on an explicit millisecond
+	id, err = ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "mystream", ID: "2-*", Values: []string{"e", "5"}}).Result()
+	if err != nil {
+		t.Fatalf("XADD 2-* failed: %v", err)
+	}
+	if id != "2-1" {
+		t.Errorf("Expected 2-1, got %s", id)
+	}
+
+	msgs, err := ts.client.XRange(ctx, "mystream", "-", "+").Result()
+	if err != nil {
+		t.Fatalf("XRANGE failed: %v", err)
+	}
+	if len(msgs) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(msgs))
+	}
+	if msgs[2].ID != "2-0" || msgs[2].Values["c"] != "3" || msgs[2].Values["d"] != "4" {
+		t.Errorf("Unexpected entry: %v", msgs[2])
+	}
+
+	// Incomplete and exclusive bounds
+	msgs, err = ts.client.XRange(ctx, "mystream", "1", "1").Result()
+	if err != nil {
+		t.Fatalf("XRANGE failed: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Errorf("Expected 2 entries for ms 1, got %d", len(msgs))
+	}
+	msgs, err = ts.client.XRange(ctx, "mystream", "(1-1", "+").Result()
+	if err != nil {
+		t.Fatalf("XRANGE exclusive failed: %v", err)
+	}
+	if len(msgs) != 3 || msgs[0].ID != "1-2" {
+		t.Errorf("Expected 3 entries starting at 1-2, got %v", msgs)
+	}
+
+	// XREVRANGE with COUNT
+	msgs, err = ts.client.XRevRangeN(ctx, "mystream", "+", "-", 2).Result()
+	if err != nil {
+		t.Fatalf("XREVRANGE failed: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != "2-1" || msgs[1].ID != "2-0" {
+		t.Errorf("Expected [2-1 2-0], got %v", msgs)
+	}
+
+	// Auto-generated IDs are greater than the last one
+	id, err = ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "mystream", Values: []string{"f", "6"}}).Result()
+	if err != nil {
+		t.Fatalf("XADD * failed: %v", err)
+	}
+	if !strings.HasSuffix(id, "-0") {
+		t.Errorf("Expected <ms>-0 ID, got %s", id)
+	}
+
+	length, err := ts.client.XLen(ctx, "mystream").Result()
+	if err != nil {
+		t.Fatalf("XLEN failed: %v", err)
+	}
+	if length != 5 {
+		t.Errorf("Expected length 5, got %d", length)
+	}
+}
+
+func TestXAddNoMkStream(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	err := ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "nostream", NoMkStream: true, Values: []string{"a", "1"}}).Err()
+	if err != redis.Nil {
+		t.Errorf("Expected nil reply, got %v", err)
+	}
+
+	exists, _ := ts.client.Exists(ctx, "nostream").Result()
+	if exists != 0 {
+		t.Errorf("Expected stream not to be created")
+	}
+}
+
+func TestXDelXTrim(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	for i := 1; i <= 10; i++ {
+		ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "s", ID: "0-*", Values: []string{"n", "v"}})
+	}
+
+	deleted, err := ts.client.XDel(ctx, "s", "0-1", "0-2", "0-99").Result()
+	if err != nil {
+		t.Fatalf("XDEL failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected 2 deleted, got %d", deleted)
+	}
+
+	// Exact MAXLEN
+	trimmed, err := ts.client.XTrimMaxLen(ctx, "s", 5).Result()
+	if err != nil {
+		t.Fatalf("XTRIM MAXLEN failed: %v", err)
+	}
+	if trimmed != 3 {
+		t.Errorf("Expected 3 trimmed, got %d", trimmed)
+	}
+
+	// Approximate trimming never evicts less than a whole node
+	trimmed, err = ts.client.XTrimMaxLenApprox(ctx, "s", 1, 0).Result()
+	if err != nil {
+		t.Fatalf("XTRIM MAXLEN ~ failed: %v", err)
+	}
+	if trimmed != 0 {
+		t.Errorf("Expected 0 trimmed with ~, got %d", trimmed)
+	}
+
+	// MINID
+	trimmed, err = ts.client.XTrimMinID(ctx, "s", "0-8").Result()
+	if err != nil {
+		t.Fatalf("XTRIM MINID failed: %v", err)
+	}
+	if trimmed != 2 {
+		t.Errorf("Expected 2 trimmed, got %d", trimmed)
+	}
+
+	// XADD with MAXLEN trims as part of the insert
+	ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "s", MaxLen: 2, Values: []string{"n", "v"}})
+	length, _ := ts.client.XLen(ctx, "s").Result()
+	if length != 2 {
+		t.Errorf("Expected length 2, got %d", length)
+	}
+
+	// The stream still exists when emptied, and IDs keep increasing
+	ts.client.XTrimMaxLen(ctx, "s", 0)
+	keyType, _ := ts.client.Type(ctx, "s").Result()
+	if keyType != "stream" {
+		t.Errorf("Expected type stream, got %s", keyType)
+	}
+	err = ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "s", ID: "0-5", Values: []string{"n", "v"}}).Err()
+	if err == nil {
+		t.Errorf("Expected XADD with an old ID to fail after trimming")
+	}
+}
+
+func TestStreamKeyCommands(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "src", ID: "1-0", Values: []string{"a", "1"}})
+
+	keyType, err := ts.client.Type(ctx, "src").Result()
+	if err != nil {
+		t.Fatalf("TYPE failed: %v", err)
+	}
+	if keyType != "stream" {
+		t.Errorf("Expected stream, got %s", keyType)
+	}
+
+	// Wrong type errors
+	if err := ts.client.Get(ctx, "src").Err(); err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Errorf("Expected WRONGTYPE for GET on stream, got %v", err)
+	}
+	ts.client.Set(ctx, "str", "x", 0)
+	if err := ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "str", Values: []string{"a", "1"}}).Err(); err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Errorf("Expected WRONGTYPE for XADD on string, got %v", err)
+	}
+
+	// COPY
+	copied, err := ts.client.Copy(ctx, "src", "copy", 0, false).Result()
+	if err != nil || copied != 1 {
+		t.Fatalf("COPY failed: %v %d", err, copied)
+	}
+	msgs, _ := ts.client.XRange(ctx, "copy", "-", "+").Result()
+	if len(msgs) != 1 || msgs[0].ID != "1-0" {
+		t.Errorf("Unexpected copied stream: %v", msgs)
+	}
+
+	// RENAME
+	if err := ts.client.Rename(ctx, "copy", "renamed").Err(); err != nil {
+		t.Fatalf("RENAME failed: %v", err)
+	}
+	length, _ := ts.client.XLen(ctx, "renamed").Result()
+	if length != 1 {
+		t.Errorf("Expected renamed stream length 1, got %d", length)
+	}
+	if err := ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "renamed", ID: "1-0", Values: []string{"a", "1"}}).Err(); err == nil {
+		t.Errorf("Expected last ID to follow the renamed stream")
+	}
+
+	// EXPIRE
+	ok, err := ts.client.Expire(ctx, "src", 1*time.Second).Result()
+	if err != nil || !ok {
+		t.Fatalf("EXPIRE failed: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	length, _ = ts.client.XLen(ctx, "src").Result()
+	if length != 0 {
+		t.Errorf("Expected expired stream to be empty, got %d", length)
+	}
+
+	// DEL
+	deleted, err := ts.client.Del(ctx, "renamed").Result()
+	if err != nil || deleted != 1 {
+		t.Fatalf("DEL failed: %v %d", err, deleted)
+	}
+	keyType, _ = ts.client.Type(ctx, "renamed").Result()
+	if keyType != "none" {
+		t.Errorf("Expected none after DEL, got %s", keyType)
+	}
+}
+
+// ============== Stream Consumer Group Tests ==============
+
+func TestXGroupCreate(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	// Key must exist without MKSTREAM
+	err := ts.client.XGroupCreate(ctx, "gs", "g1", "$").Err()
+	if err == nil || !strings.Contains(err.Error(), "MKSTREAM") {
+		t.Errorf("Expected error mentioning MKSTREAM, got %v", err)
+	}
+
+	if err := ts.client.XGroupCreateMkStream(ctx, "gs", "g1", "$").Err(); err != nil {
+		t.Fatalf("XGROUP CREATE MKSTREAM failed: %v", err)
+	}
+	keyType, _ := ts.client.Type(ctx, "gs").Result()
+	if keyType != "stream" {
+		t.Errorf("Expected empty stream to be created, got type %s", keyType)
+	}
+
+	err = ts.client.XGroupCreate(ctx, "gs", "g1", "0").Err()
+	if err == nil || !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		t.Errorf("Expected BUSYGROUP, got %v", err)
+	}
+
+	created, err := ts.client.XGroupCreateConsumer(ctx, "gs", "g1", "c1").Result()
+	if err != nil || created != 1 {
+		t.Errorf("XGROUP CREATECONSUMER failed: %v %d", err, created)
+	}
+	created, _ = ts.client.XGroupCreateConsumer(ctx, "gs", "g1", "c1").Result()
+	if created != 0 {
+		t.Errorf("Expected existing consumer not to be created again")
+	}
+
+	err = ts.client.XGroupSetID(ctx, "gs", "nogroup", "0").Err()
+	if err == nil || !strings.HasPrefix(err.Error(), "NOGROUP") {
+		t.Errorf("Expected NOGROUP, got %v", err)
+	}
+
+	destroyed, err := ts.client.XGroupDestroy(ctx, "gs", "g1").Result()
+	if err != nil || destroyed != 1 {
+		t.Errorf("XGROUP DESTROY failed: %v %d", err, destroyed)
+	}
+}
+
+func TestXReadGroupXAck(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "jobs", ID: id, Values: []string{"job", id}})
+	}
+	if err := ts.client.XGroupCreate(ctx, "jobs", "workers", "0").Err(); err != nil {
+		t.Fatalf("XGROUP CREATE failed: %v", err)
+	}
+
+	// New entries are split between consumers
+	streams, err := ts.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "workers", Consumer: "alice", Streams: []string{"jobs", ">"}, Count: 2, Block: -1,
+	}).Result()
+	if err != nil {
+		t.Fatalf("XREADGROUP failed: %v", err)
+	}
+	if len(streams) != 1 || len(streams[0].Messages) != 2 || streams[0].Messages[0].ID != "1-0" {
+		t.Fatalf("Unexpected XREADGROUP result: %v", streams)
+	}
+
+	streams, err = ts.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "workers", Consumer: "bob", Streams: []string{"jobs", ">"}, Block: -1,
+	}).Result()
+	if err != nil {
+		t.Fatalf("XREADGROUP failed: %v", err)
+	}
+	if len(streams[0].Messages) != 1 || streams[0].Messages[0].ID != "3-0" {
+		t.Fatalf("Expected bob to get 3-0, got %v", streams)
+	}
+
+	// Nothing new left
+	err = ts.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "workers", Consumer: "bob", Streams: []string{"jobs", ">"}, Block: -1,
+	}).Err()
+	if err != redis.Nil {
+		t.Errorf("Expected nil reply, got %v", err)
+	}
+
+	// History read returns alice's pending entries
+	streams, err = ts.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "workers", Consumer: "alice", Streams: []string{"jobs", "0"}, Block: -1,
+	}).Result()
+	if err != nil {
+		t.Fatalf("XREADGROUP history failed: %v", err)
+	}
+	if len(streams[0].Messages) != 2 {
+		t.Errorf("Expected 2 pending entries for alice, got %v", streams)
+	}
+
+	acked, err := ts.client.XAck(ctx, "jobs", "workers", "1-0", "99-0").Result()
+	if err != nil || acked != 1 {
+		t.Errorf("XACK failed: %v %d", err, acked)
+	}
+
+	pending, err := ts.client.XPending(ctx, "jobs", "workers").Result()
+	if err != nil {
+		t.Fatalf("XPENDING failed: %v", err)
+	}
+	if pending.Count != 2 || pending.Lower != "2-0" || pending.Higher != "3-0" {
+		t.Errorf("Unexpected XPENDING summary: %+v", pending)
+	}
+	if pending.Consumers["alice"] != 1 || pending.Consumers["bob"] != 1 {
+		t.Errorf("Unexpected XPENDING consumers: %v", pending.Consumers)
+	}
+
+	// NOGROUP for unknown groups
+	err = ts.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "nope", Consumer: "c", Streams: []string{"jobs", ">"}, Block: -1,
+	}).Err()
+	if err == nil || !strings.HasPrefix(err.Error(), "NOGROUP") {
+		t.Errorf("Expected NOGROUP, got %v", err)
+	}
+}
+
+func TestXPendingIdle(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "q", ID: "1-0", Values: []string{"a", "1"}})
+	ts.client.XGroupCreate(ctx, "q", "g", "0")
+	ts.client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g", Consumer: "c1", Streams: []string{"q", ">"}, Block: -1})
+
+	entries, err := ts.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: "q", Group: "g", Idle: 10 * time.Second, Start: "-", End: "+", Count: 10,
+	}).Result()
+	if err != nil {
+		t.Fatalf("XPENDING IDLE failed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no entries idle for 10s, got %v", entries)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	entries, err = ts.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: "q", Group: "g", Idle: 100 * time.Millisecond, Start: "-", End: "+", Count: 10, Consumer: "c1",
+	}).Result()
+	if err != nil {
+		t.Fatalf("XPENDING IDLE failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != "1-0" || entries[0].Consumer != "c1" || entries[0].RetryCount != 1 {
+		t.Errorf("Unexpected pending entries: %+v", entries)
+	}
+}
+
+func TestXClaimXAutoClaim(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "tasks", ID: id, Values: []string{"n", id}})
+	}
+	ts.client.XGroupCreate(ctx, "tasks", "g", "0")
+	ts.client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g", Consumer: "dead", Streams: []string{"tasks", ">"}, Block: -1})
+
+	// Not idle long enough
+	msgs, err := ts.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream: "tasks", Group: "g", Consumer: "live", MinIdle: time.Hour, Messages: []string{"1-0"},
+	}).Result()
+	if err != nil {
+		t.Fatalf("XCLAIM failed: %v", err)
+	}
+	if len(msgs) != 0 {
+		t.Errorf("Expected nothing claimed, got %v", msgs)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	msgs, err = ts.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream: "tasks", Group: "g", Consumer: "live", MinIdle: 10 * time.Millisecond, Messages: []string{"1-0"},
+	}).Result()
+	if err != nil {
+		t.Fatalf("XCLAIM failed: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].ID != "1-0" || msgs[0].Values["n"] != "1-0" {
+		t.Errorf("Unexpected XCLAIM result: %v", msgs)
+	}
+
+	// Deleted entries are dropped from the PEL by XAUTOCLAIM
+	ts.client.XDel(ctx, "tasks", "3-0")
+	msgs, start, err := ts.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream: "tasks", Group: "g", Consumer: "live", MinIdle: 10 * time.Millisecond, Start: "0", Count: 10,
+	}).Result()
+	if err != nil {
+		t.Fatalf("XAUTOCLAIM failed: %v", err)
+	}
+	if start != "0-0" {
+		t.Errorf("Expected cursor 0-0, got %s", start)
+	}
+	if len(msgs) != 1 || msgs[0].ID != "2-0" {
+		t.Errorf("Expected to claim 2-0, got %v", msgs)
+	}
+
+	pending, _ := ts.client.XPending(ctx, "tasks", "g").Result()
+	if pending.Count != 2 || pending.Consumers["live"] != 2 {
+		t.Errorf("Unexpected pending after claims: %+v", pending)
+	}
+
+	consumers, err := ts.client.XInfoConsumers(ctx, "tasks", "g").Result()
+	if err != nil {
+		t.Fatalf("XINFO CONSUMERS failed: %v", err)
+	}
+	if len(consumers) != 2 || consumers[0].Name != "dead" || consumers[0].Pending != 0 || consumers[1].Pending != 2 {
+		t.Errorf("Unexpected consumers: %+v", consumers)
+	}
+
+	deleted, err := ts.client.XGroupDelConsumer(ctx, "tasks", "g", "live").Result()
+	if err != nil || deleted != 2 {
+		t.Errorf("XGROUP DELCONSUMER failed: %v %d", err, deleted)
+	}
+}
+
+func TestXInfo(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "info", ID: id, Values: []string{"k", "v"}})
+	}
+	ts.client.XGroupCreate(ctx, "info", "g", "0")
+	ts.client.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g", Consumer: "c", Streams: []string{"info", ">"}, Count: 1, Block: -1})
+
+	stream, err := ts.client.XInfoStream(ctx, "info").Result()
+	if err != nil {
+		t.Fatalf("XINFO STREAM failed: %v", err)
+	}
+	if stream.Length != 3 || stream.Groups != 1 || stream.LastGeneratedID != "3-0" || stream.EntriesAdded != 3 {
+		t.Errorf("Unexpected XINFO STREAM: %+v", stream)
+	}
+	if stream.FirstEntry.ID != "1-0" || stream.LastEntry.ID != "3-0" {
+		t.Errorf("Unexpected first/last entry: %v %v", stream.FirstEntry, stream.LastEntry)
+	}
+
+	groups, err := ts.client.XInfoGroups(ctx, "info").Result()
+	if err != nil {
+		t.Fatalf("XINFO GROUPS failed: %v", err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("Expected 1 group, got %d", len(groups))
+	}
+	g := groups[0]
+	if g.Name != "g" || g.Consumers != 1 || g.Pending != 1 || g.LastDeliveredID != "1-0" || g.EntriesRead != 1 || g.Lag != 2 {
+		t.Errorf("Unexpected XINFO GROUPS: %+v", g)
+	}
+
+	if err := ts.client.XInfoStream(ctx, "missing").Err(); err == nil {
+		t.Errorf("Expected error for missing key")
+	}
+}
+
+func TestXRead(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "a", ID: "1-0", Values: []string{"k", "1"}})
+	ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "a", ID: "2-0", Values: []string{"k", "2"}})
+	ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "b", ID: "5-0", Values: []string{"k", "5"}})
+
+	streams, err := ts.client.XRead(ctx, &redis.XReadArgs{Streams: []string{"a", "b", "missing", "1-0", "0", "0"}, Block: -1}).Result()
+	if err != nil {
+		t.Fatalf("XREAD failed: %v", err)
+	}
+	if len(streams) != 2 || streams[0].Stream != "a" || streams[1].Stream != "b" {
+		t.Fatalf("Unexpected XREAD result: %v", streams)
+	}
+	if len(streams[0].Messages) != 1 || streams[0].Messages[0].ID != "2-0" {
+		t.Errorf("Expected only 2-0 from a, got %v", streams[0].Messages)
+	}
+
+	err = ts.client.XRead(ctx, &redis.XReadArgs{Streams: []string{"a", "$"}, Block: -1}).Err()
+	if err != redis.Nil {
+		t.Errorf("Expected nil reply for $ without BLOCK, got %v", err)
+	}
+}
+
+func TestXReadBlock(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "events", ID: "1-0", Values: []string{"k", "old"}})
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "events", ID: "2-0", Values: []string{"k", "new"}})
+	}()
+
+	start := time.Now()
+	streams, err := ts.client.XRead(ctx, &redis.XReadArgs{Streams: []string{"events", "$"}, Block: 5 * time.Second}).Result()
+	if err != nil {
+		t.Fatalf("XREAD BLOCK failed: %v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("XREAD BLOCK was not woken up by XADD")
+	}
+	if len(streams) != 1 || len(streams[0].Messages) != 1 || streams[0].Messages[0].ID != "2-0" {
+		t.Errorf("Expected only the new entry, got %v", streams)
+	}
+
+	start = time.Now()
+	err = ts.client.XRead(ctx, &redis.XReadArgs{Streams: []string{"events", "$"}, Block: 300 * time.Millisecond}).Err()
+	if err != redis.Nil {
+		t.Errorf("Expected nil reply on timeout, got %v", err)
+	}
+	if time.Since(start) < 250*time.Millisecond {
+		t.Errorf("XREAD BLOCK returned before the timeout")
+	}
+}
+
+func TestXReadGroupBlock(t *testing.T) {
+	ts := newTestServer(t, "")
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	ts.client.XGroupCreateMkStream(ctx, "work", "g", "$")
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		ts.client.XAdd(ctx, &redis.XAddArgs{Stream: "work", ID: "1-0", Values: []string{"job", "1"}})
+	}()
+
+	streams, err := ts.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "g", Consumer: "c", Streams: []string{"work", ">"}, Block: 5 * time.Second,
+	}).Result()
+	if err != nil {
+		t.Fatalf("XREADGROUP BLOCK failed: %v", err)
+	}
+	if len(streams) != 1 || len(streams[0].Messages) != 1 || streams[0].Messages[0].ID != "1-0" {
+		t.Errorf("Unexpected XREADGROUP BLOCK result: %v", streams)
+	}
+
+	pending, _ := ts.client.XPending(ctx, "work", "g").Result()
+	if pending.Count != 1 {
+		t.Errorf("Expected 1 pending entry, got %d", pending.Count)
+	}
+}